@@ -0,0 +1,15 @@
+// Package api embeds the OpenAPI document that describes the public HTTP API.
+// It is the source of truth for request validation and the generated client.
+package api
+
+import (
+	_ "embed"
+
+	"github.com/irajwani/microservice-go/internal/openapi"
+)
+
+//go:embed openapi.json
+var raw []byte
+
+// Spec is the loaded api/openapi.json.
+var Spec = openapi.MustLoad(raw)
@@ -0,0 +1,455 @@
+// Code generated by cmd/openapi-gen from api/openapi.json. DO NOT EDIT.
+
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Balance is the Balance schema of the document.
+type Balance struct {
+	Currency Currency `json:"currency"`
+	Balance  float64  `json:"balance"`
+	// Reserved for unsettled jobs
+	Held float64 `json:"held"`
+	// Balance less held; what new jobs can use
+	Available float64 `json:"available"`
+}
+
+// Balances is the Balances schema of the document.
+type Balances struct {
+	UserID    string     `json:"user_id"`
+	Accounts  []Balance  `json:"accounts"`
+	Valuation *Valuation `json:"valuation,omitempty"`
+}
+
+// Batch is the Batch schema of the document.
+type Batch struct {
+	BatchID      string `json:"batch_id"`
+	ClientID     string `json:"client_id"`
+	AllOrNothing bool   `json:"all_or_nothing"`
+	Status       string `json:"status"`
+	// Legs per job status
+	Counts    map[string]int `json:"counts"`
+	CreatedAt time.Time      `json:"created_at"`
+	Legs      []BatchLeg     `json:"legs"`
+}
+
+// BatchItem is the BatchItem schema of the document.
+type BatchItem struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Job    Job    `json:"job"`
+}
+
+// BatchItemRequest is the BatchItemRequest schema of the document.
+type BatchItemRequest struct {
+	// Defaults to the batch's; must match it
+	ClientID       *string  `json:"client_id,omitempty"`
+	SourceCurrency Currency `json:"source_currency"`
+	TargetCurrency Currency `json:"target_currency"`
+	SourceAmount   float64  `json:"source_amount"`
+	IdempotencyKey *string  `json:"idempotency_key,omitempty"`
+}
+
+// BatchLeg is the BatchLeg schema of the document.
+type BatchLeg struct {
+	Index          int       `json:"index"`
+	JobID          string    `json:"job_id"`
+	SourceCurrency Currency  `json:"source_currency"`
+	TargetCurrency Currency  `json:"target_currency"`
+	SourceAmount   float64   `json:"source_amount"`
+	TargetAmount   *float64  `json:"target_amount,omitempty"`
+	Status         JobStatus `json:"status"`
+	// Failure reason
+	Error *string `json:"error,omitempty"`
+}
+
+// BatchRequest is the BatchRequest schema of the document.
+type BatchRequest struct {
+	ClientID string `json:"client_id"`
+	// Settle every leg together, or fail them all
+	AllOrNothing *bool              `json:"all_or_nothing,omitempty"`
+	Jobs         []BatchItemRequest `json:"jobs"`
+}
+
+// BatchResponse is the BatchResponse schema of the document.
+type BatchResponse struct {
+	// Empty when every item replays a job created on its own
+	BatchID       string      `json:"batch_id"`
+	ClientID      string      `json:"client_id"`
+	AllOrNothing  bool        `json:"all_or_nothing"`
+	CorrelationID *string     `json:"correlation_id,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	Items         []BatchItem `json:"items"`
+}
+
+// Candle is the Candle schema of the document.
+type Candle struct {
+	Start time.Time `json:"start"`
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+	// Rates recorded in the interval
+	Ticks int `json:"ticks"`
+}
+
+// CompletedJob is the CompletedJob schema of the document.
+type CompletedJob struct {
+	JobID          string   `json:"job_id"`
+	ClientID       string   `json:"client_id"`
+	SourceCurrency Currency `json:"source_currency"`
+	TargetCurrency Currency `json:"target_currency"`
+	SourceAmount   float64  `json:"source_amount"`
+	TargetAmount   float64  `json:"target_amount"`
+	// The rate the job executed at
+	Rate      float64  `json:"rate"`
+	MidRate   *float64 `json:"mid_rate,omitempty"`
+	SpreadBps *int     `json:"spread_bps,omitempty"`
+	// Spread revenue in target currency units, apart from the fee
+	Spread      *float64  `json:"spread,omitempty"`
+	Fee         float64   `json:"fee"`
+	Status      JobStatus `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Currency is the Currency schema of the document.
+type Currency string
+
+// ExchangeRequest is the ExchangeRequest schema of the document.
+type ExchangeRequest struct {
+	UserID         string   `json:"user_id"`
+	SourceCurrency Currency `json:"source_currency"`
+	TargetCurrency Currency `json:"target_currency"`
+	SourceAmount   float64  `json:"source_amount"`
+}
+
+// ExchangeResponse is the ExchangeResponse schema of the document.
+type ExchangeResponse struct {
+	JobID          string   `json:"job_id"`
+	UserID         string   `json:"user_id"`
+	SourceCurrency Currency `json:"source_currency"`
+	TargetCurrency Currency `json:"target_currency"`
+	SourceAmount   float64  `json:"source_amount"`
+	TargetAmount   float64  `json:"target_amount"`
+	// The rate the exchange executed at: the bid, after the client's spread
+	Rate      float64 `json:"rate"`
+	MidRate   float64 `json:"mid_rate"`
+	SpreadBps int     `json:"spread_bps"`
+	// Spread revenue in target currency units, apart from the fee
+	Spread             float64   `json:"spread"`
+	ClientTier         *string   `json:"client_tier,omitempty"`
+	Fee                float64   `json:"fee"`
+	FeeBps             int       `json:"fee_bps"`
+	FeeScheduleID      *string   `json:"fee_schedule_id,omitempty"`
+	FeeScheduleVersion *int      `json:"fee_schedule_version,omitempty"`
+	Status             JobStatus `json:"status"`
+}
+
+// FieldError is the FieldError schema of the document.
+type FieldError struct {
+	// A body field as a JSON path such as jobs[1].source_amount, or a parameter name
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// Job is the Job schema of the document.
+type Job struct {
+	JobID          string     `json:"job_id"`
+	Status         JobStatus  `json:"status"`
+	ClientID       string     `json:"client_id"`
+	SourceCurrency Currency   `json:"source_currency"`
+	TargetCurrency Currency   `json:"target_currency"`
+	SourceAmount   float64    `json:"source_amount"`
+	IdempotencyKey *string    `json:"idempotency_key,omitempty"`
+	CorrelationID  *string    `json:"correlation_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	BatchID        *string    `json:"batch_id,omitempty"`
+	AllOrNothing   *bool      `json:"all_or_nothing,omitempty"`
+	LimitRate      *float64   `json:"limit_rate,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// JobList is the JobList schema of the document.
+type JobList struct {
+	UserID string         `json:"user_id"`
+	Jobs   []CompletedJob `json:"jobs"`
+}
+
+// JobRequest is the JobRequest schema of the document.
+type JobRequest struct {
+	ClientID       string   `json:"client_id"`
+	SourceCurrency Currency `json:"source_currency"`
+	TargetCurrency Currency `json:"target_currency"`
+	SourceAmount   float64  `json:"source_amount"`
+	IdempotencyKey *string  `json:"idempotency_key,omitempty"`
+	// Settle once the rate reaches this; the job stays pending until then
+	LimitRate *float64 `json:"limit_rate,omitempty"`
+	// Cancel the limit order if it has not settled by then
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// JobStatus is the JobStatus schema of the document.
+type JobStatus string
+
+const (
+	JobStatusPending    JobStatus = "pending"
+	JobStatusQueued     JobStatus = "queued"
+	JobStatusInProgress JobStatus = "in_progress"
+	JobStatusCompleted  JobStatus = "completed"
+	JobStatusFailed     JobStatus = "failed"
+	JobStatusCancelled  JobStatus = "cancelled"
+)
+
+// An RFC 7807 problem. Clients match on code, which never changes meaning.
+type Problem struct {
+	// urn:problem: followed by the code in kebab case
+	Type   string  `json:"type"`
+	Title  string  `json:"title"`
+	Status int     `json:"status"`
+	Detail *string `json:"detail,omitempty"`
+	Code   string  `json:"code"`
+	// The correlation id of the request, for support
+	RequestID *string `json:"request_id,omitempty"`
+	// The invalid fields of an INVALID_REQUEST
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// Rate is the Rate schema of the document.
+type Rate struct {
+	Source Currency `json:"source"`
+	Target Currency `json:"target"`
+	// The mid rate
+	Rate float64 `json:"rate"`
+	// What a conversion from source to target executes at, before a tenant's own spread
+	Bid float64 `json:"bid"`
+	Ask float64 `json:"ask"`
+	// The pair's spread; bid and ask sit half of it either side of the mid
+	SpreadBps int    `json:"spread_bps"`
+	Provider  string `json:"provider"`
+	// When the rate was served
+	AsOf time.Time `json:"as_of"`
+}
+
+// RateHistory is the RateHistory schema of the document.
+type RateHistory struct {
+	Source   Currency  `json:"source"`
+	Target   Currency  `json:"target"`
+	Interval string    `json:"interval"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	// Oldest first; intervals without rates have no candle
+	Candles []Candle `json:"candles"`
+}
+
+// RateQuote is the RateQuote schema of the document.
+type RateQuote struct {
+	Source   Currency `json:"source"`
+	Target   Currency `json:"target"`
+	Rate     float64  `json:"rate"`
+	Provider string   `json:"provider"`
+	// When the rate was quoted
+	AsOf       time.Time `json:"as_of"`
+	AgeSeconds float64   `json:"age_seconds"`
+}
+
+// RateSnapshot is the RateSnapshot schema of the document.
+type RateSnapshot struct {
+	Source Currency `json:"source"`
+	Target Currency `json:"target"`
+	Rate   float64  `json:"rate"`
+	// The provider of a recorded rate, or executed when taken from completed conversions; -inverse when inverted from the opposite pair
+	Provider string `json:"provider"`
+	// When the rate was quoted
+	AsOf time.Time `json:"as_of"`
+}
+
+// The accounts valued in one currency, from the balances and rates as they stood at as_of
+type Valuation struct {
+	Currency Currency        `json:"currency"`
+	AsOf     time.Time       `json:"as_of"`
+	Total    float64         `json:"total"`
+	Accounts []ValuedAccount `json:"accounts"`
+	// The rate used for each other currency
+	Rates []RateSnapshot `json:"rates"`
+}
+
+// ValuedAccount is the ValuedAccount schema of the document.
+type ValuedAccount struct {
+	Currency Currency `json:"currency"`
+	Balance  float64  `json:"balance"`
+	// The balance in the valuation currency
+	Value float64 `json:"value"`
+}
+
+// GetBalancesParams are the query parameters of GetBalances.
+type GetBalancesParams struct {
+	UserID string
+	// Value every account in this currency
+	ValuationCurrency *Currency
+	// Value the balances and rates as they stood at this time; needs valuation_currency
+	At *time.Time
+}
+
+// GetBalances calls GET /balances: list a user's accounts by currency, optionally valued in one currency.
+func (c *Client) GetBalances(ctx context.Context, params GetBalancesParams) (*Balances, error) {
+	q := url.Values{}
+	q.Set("user_id", fmt.Sprint(params.UserID))
+	if params.ValuationCurrency != nil {
+		q.Set("valuation_currency", fmt.Sprint(*params.ValuationCurrency))
+	}
+	if params.At != nil {
+		q.Set("at", params.At.Format(time.RFC3339Nano))
+	}
+	var out Balances
+	if err := c.do(ctx, "GET", "/balances", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetBatchParams are the query parameters of GetBatch.
+type GetBatchParams struct {
+	// Only return the batch if it is this user's
+	UserID *string
+}
+
+// GetBatch calls GET /batches/{batch_id}: get a batch's progress with every leg.
+func (c *Client) GetBatch(ctx context.Context, batchID string, params GetBatchParams) (*Batch, error) {
+	q := url.Values{}
+	if params.UserID != nil {
+		q.Set("user_id", fmt.Sprint(*params.UserID))
+	}
+	var out Batch
+	if err := c.do(ctx, "GET", "/batches/"+url.PathEscape(batchID), q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Exchange calls POST /exchange: convert immediately.
+func (c *Client) Exchange(ctx context.Context, body ExchangeRequest) (*ExchangeResponse, error) {
+	var out ExchangeResponse
+	if err := c.do(ctx, "POST", "/exchange", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListJobsParams are the query parameters of ListJobs.
+type ListJobsParams struct {
+	UserID string
+	Limit  *int
+}
+
+// ListJobs calls GET /jobs: list a user's completed jobs, most recent first.
+func (c *Client) ListJobs(ctx context.Context, params ListJobsParams) (*JobList, error) {
+	q := url.Values{}
+	q.Set("user_id", fmt.Sprint(params.UserID))
+	if params.Limit != nil {
+		q.Set("limit", fmt.Sprint(*params.Limit))
+	}
+	var out JobList
+	if err := c.do(ctx, "GET", "/jobs", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateJob calls POST /jobs: queue a conversion, or place a limit order with limit_rate.
+func (c *Client) CreateJob(ctx context.Context, body JobRequest) (*Job, error) {
+	var out Job
+	if err := c.do(ctx, "POST", "/jobs", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateBatch calls POST /jobs/batch: queue up to 100 conversions of one client together.
+func (c *Client) CreateBatch(ctx context.Context, body BatchRequest) (*BatchResponse, error) {
+	var out BatchResponse
+	if err := c.do(ctx, "POST", "/jobs/batch", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetJobParams are the query parameters of GetJob.
+type GetJobParams struct {
+	// Only return the job if it is this user's
+	UserID *string
+}
+
+// GetJob calls GET /jobs/{job_id}: get a completed job.
+func (c *Client) GetJob(ctx context.Context, jobID string, params GetJobParams) (*CompletedJob, error) {
+	q := url.Values{}
+	if params.UserID != nil {
+		q.Set("user_id", fmt.Sprint(*params.UserID))
+	}
+	var out CompletedJob
+	if err := c.do(ctx, "GET", "/jobs/"+url.PathEscape(jobID), q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetRateParams are the query parameters of GetRate.
+type GetRateParams struct {
+	Source string
+	Target string
+}
+
+// GetRate calls GET /rate: quote the mid rate of a pair; fees are priced at settlement.
+func (c *Client) GetRate(ctx context.Context, params GetRateParams) (*Rate, error) {
+	q := url.Values{}
+	q.Set("source", fmt.Sprint(params.Source))
+	q.Set("target", fmt.Sprint(params.Target))
+	var out Rate
+	if err := c.do(ctx, "GET", "/rate", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetRateQuote calls GET /rates/{pair}: get the latest recorded rate of a pair and its age.
+func (c *Client) GetRateQuote(ctx context.Context, pair string) (*RateQuote, error) {
+	var out RateQuote
+	if err := c.do(ctx, "GET", "/rates/"+url.PathEscape(pair), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetRateHistoryParams are the query parameters of GetRateHistory.
+type GetRateHistoryParams struct {
+	// Defaults to 100 intervals before to; rounded down to a whole interval
+	From *time.Time
+	// Defaults to now
+	To *time.Time
+	// Candle size; at most 1000 candles are returned
+	Interval *string
+}
+
+// GetRateHistory calls GET /rates/{pair}/history: get OHLC candles of the recorded rates of a pair.
+func (c *Client) GetRateHistory(ctx context.Context, pair string, params GetRateHistoryParams) (*RateHistory, error) {
+	q := url.Values{}
+	if params.From != nil {
+		q.Set("from", params.From.Format(time.RFC3339Nano))
+	}
+	if params.To != nil {
+		q.Set("to", params.To.Format(time.RFC3339Nano))
+	}
+	if params.Interval != nil {
+		q.Set("interval", fmt.Sprint(*params.Interval))
+	}
+	var out RateHistory
+	if err := c.do(ctx, "GET", "/rates/"+url.PathEscape(pair)+"/history", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,74 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/openapi"
+)
+
+func TestGeneratedClientIsCurrent(t *testing.T) {
+	want, err := openapi.Generate(api.Spec, "apiclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("client.gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Error("client.gen.go is out of date with api/openapi.json; run go generate ./apiclient")
+	}
+}
+
+func TestClient(t *testing.T) {
+	var got struct {
+		method, path, query, auth string
+		body                      JobRequest
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path, got.query, got.auth = r.Method, r.URL.EscapedPath(), r.URL.RawQuery, r.Header.Get("Authorization")
+		switch r.Method + " " + r.URL.Path {
+		case "POST /jobs":
+			_ = json.NewDecoder(r.Body).Decode(&got.body)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"job_id":"9b2c6f3e-8a55-4e0e-9a4b-0c1f2d3e4f50","status":"queued","client_id":"c","source_currency":"USD","target_currency":"EUR","source_amount":10,"idempotency_key":"k","created_at":"2030-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"type":"urn:problem:not-found","title":"Not found","status":404,"code":"NOT_FOUND"}`))
+		}
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL + "/", APIKey: "secret"}
+
+	key := "k"
+	job, err := c.CreateJob(context.Background(), JobRequest{ClientID: "c", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 10, IdempotencyKey: &key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.method != http.MethodPost || got.auth != "Bearer secret" || got.body.IdempotencyKey == nil || *got.body.IdempotencyKey != "k" {
+		t.Errorf("request = %+v", got)
+	}
+	if job.Status != JobStatusQueued || job.IdempotencyKey == nil || job.CreatedAt.Year() != 2030 {
+		t.Errorf("job = %+v", job)
+	}
+
+	limit := 5
+	_, err = c.ListJobs(context.Background(), ListJobsParams{UserID: "u 1", Limit: &limit})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Body.Code != "NOT_FOUND" {
+		t.Errorf("err = %v", err)
+	}
+	if got.method != http.MethodGet || got.query != "limit=5&user_id=u+1" {
+		t.Errorf("list request = %s ?%s", got.method, got.query)
+	}
+	if _, err := c.GetJob(context.Background(), "a/b", GetJobParams{}); err == nil || got.path != "/jobs/a%2Fb" {
+		t.Errorf("path = %s, err %v", got.path, err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+// maxBatchSize caps the legs of one POST /jobs/batch.
+const maxBatchSize = 100
+
+// Batch item statuses.
+const (
+	itemCreated  = "created"
+	itemReplayed = "replayed" // idempotency key matched an existing job
+)
+
+// BatchRequest is the POST /jobs/batch payload. Items default to the batch
+// client_id. With AllOrNothing the consumer settles every leg together and
+// fails them all if any leg cannot settle.
+type BatchRequest struct {
+	ClientID     string       `json:"client_id"`
+	AllOrNothing bool         `json:"all_or_nothing,omitempty"`
+	Jobs         []JobRequest `json:"jobs"`
+}
+
+// BatchItem is the outcome of one item, at its index in the request.
+type BatchItem struct {
+	Index  int         `json:"index"`
+	Status string      `json:"status"`
+	Job    JobResponse `json:"job"`
+}
+
+// BatchResponse is returned by POST /jobs/batch.
+type BatchResponse struct {
+	BatchID       string      `json:"batch_id"`
+	ClientID      string      `json:"client_id"`
+	AllOrNothing  bool        `json:"all_or_nothing"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	Items         []BatchItem `json:"items"`
+}
+
+// validateBatch checks the batch and every item, filling in item client ids.
+// It returns an error about the batch itself, or one FieldError per invalid
+// item field.
+func validateBatch(br *BatchRequest) ([]problem.FieldError, error) {
+	if br.ClientID == "" {
+		return nil, &settlement.InvalidField{Field: "client_id", Err: errors.New("client_id is required")}
+	}
+	if len(br.Jobs) == 0 || len(br.Jobs) > maxBatchSize {
+		return nil, &settlement.InvalidField{Field: "jobs", Err: fmt.Errorf("jobs must contain 1 to %d items", maxBatchSize)}
+	}
+	var errs []problem.FieldError
+	keys := map[string]int{}
+	for i := range br.Jobs {
+		jr := &br.Jobs[i]
+		if jr.ClientID == "" {
+			jr.ClientID = br.ClientID
+		}
+		err := validate(*jr)
+		switch {
+		case err != nil:
+		case jr.LimitRate != nil:
+			err = &settlement.InvalidField{Field: "limit_rate", Err: errors.New("limit orders cannot be batched")}
+		case jr.ClientID != br.ClientID:
+			err = &settlement.InvalidField{Field: "client_id", Err: errors.New("client_id must match the batch client_id")}
+		case jr.IdempotencyKey != nil:
+			if first, dup := keys[*jr.IdempotencyKey]; dup {
+				err = &settlement.InvalidField{Field: "idempotency_key", Err: fmt.Errorf("idempotency_key repeats item %d", first)}
+			}
+			keys[*jr.IdempotencyKey] = i
+		}
+		if err != nil {
+			errs = append(errs, fieldError(fmt.Sprintf("jobs[%d].", i), err))
+		}
+	}
+	return errs, nil
+}
+
+// createBatch handles POST /jobs/batch: every new leg, its hold and its outbox
+// row are inserted in one transaction, then published best effort like single jobs.
+// Items whose idempotency key already exists are reported as replayed and are
+// not part of the new batch; when every item replays nothing is written.
+func (s *Service) createBatch(ctx context.Context, evt events.APIGatewayProxyRequest, correlationID string) (events.APIGatewayProxyResponse, error) {
+	var br BatchRequest
+	if err := json.Unmarshal([]byte(evt.Body), &br); err != nil {
+		return problem.Invalid(fmt.Sprintf("invalid json: %v", err)).Response(ctx), nil
+	}
+	itemErrs, err := validateBatch(&br)
+	if err != nil {
+		return problem.Invalid(err.Error(), fieldError("", err)).Response(ctx), nil
+	}
+	if len(itemErrs) > 0 {
+		return problem.Invalid("invalid batch items", itemErrs...).Response(ctx), nil
+	}
+	ctx = logging.With(ctx, "user_id", br.ClientID)
+
+	opCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp := BatchResponse{
+		BatchID:       uuid.NewString(),
+		ClientID:      br.ClientID,
+		AllOrNothing:  br.AllOrNothing,
+		CorrelationID: correlationID,
+		CreatedAt:     time.Now().UTC(),
+		Items:         make([]BatchItem, len(br.Jobs)),
+	}
+	var legs []BatchItem
+	var payloads [][]byte
+	for i, jr := range br.Jobs {
+		if jr.IdempotencyKey != nil {
+			existing, err := s.Store.JobByIdempotencyKey(opCtx, *jr.IdempotencyKey)
+			switch {
+			case err == nil && !replays(existing, jr):
+				p := problem.New(http.StatusConflict, problem.IdempotencyConflict, fmt.Sprintf("item %d: idempotency_key was used for job %s", i, existing.JobID))
+				p.Errors = []problem.FieldError{{Field: fmt.Sprintf("jobs[%d].idempotency_key", i), Detail: "used for a different request"}}
+				return p.Response(ctx), nil
+			case err == nil:
+				resp.Items[i] = BatchItem{Index: i, Status: itemReplayed, Job: existing}
+				continue
+			case !errors.Is(err, sql.ErrNoRows):
+				return problem.ServerError(ctx, fmt.Errorf("idempotency lookup: %w", err))
+			}
+		}
+		job := JobResponse{
+			JobID:          uuid.NewString(),
+			Status:         "queued",
+			ClientID:       jr.ClientID,
+			SourceCurrency: jr.SourceCurrency,
+			TargetCurrency: jr.TargetCurrency,
+			SourceAmount:   jr.SourceAmount,
+			IdempotencyKey: jr.IdempotencyKey,
+			CorrelationID:  correlationID,
+			CreatedAt:      resp.CreatedAt,
+			BatchID:        resp.BatchID,
+			AllOrNothing:   br.AllOrNothing,
+		}
+		resp.Items[i] = BatchItem{Index: i, Status: itemCreated, Job: job}
+		payload, _ := json.Marshal(job)
+		legs = append(legs, resp.Items[i])
+		payloads = append(payloads, payload)
+	}
+
+	if len(legs) == 0 {
+		// Full replay: report the batch the first item was created in
+		resp.BatchID, resp.AllOrNothing = resp.Items[0].Job.BatchID, resp.Items[0].Job.AllOrNothing
+		slog.InfoContext(ctx, "idempotent batch replay", "batch_id", resp.BatchID)
+		b, _ := json.Marshal(resp)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(b)}, nil
+	}
+
+	ctx = logging.With(ctx, "batch_id", resp.BatchID)
+	outboxIDs, err := s.Store.CreateBatch(opCtx, resp, legs, payloads)
+	if err != nil {
+		return rejected(ctx, err)
+	}
+	for _, leg := range legs {
+		metrics.Count(metrics.JobsCreated, 1, metrics.Pair(leg.Job.SourceCurrency, leg.Job.TargetCurrency))
+	}
+
+	// Publish each leg (best effort); unpublished rows stay in the outbox
+	if s.Publisher != nil {
+		publishCtx, cancelPub := context.WithTimeout(ctx, 5*time.Second)
+		defer cancelPub()
+		for i, payload := range payloads {
+			if err := s.Publisher.Publish(publishCtx, payload, map[string]string{logging.Attribute: correlationID}); err != nil {
+				slog.WarnContext(ctx, "failed to publish SQS message", "job_id", legs[i].Job.JobID, "error", err)
+			} else if err := s.Store.MarkPublished(publishCtx, outboxIDs[i]); err != nil {
+				slog.WarnContext(ctx, "failed to mark outbox row processed", "outbox_id", outboxIDs[i], "error", err)
+			}
+		}
+	}
+
+	slog.InfoContext(ctx, "batch created", "legs", len(legs), "replayed", len(br.Jobs)-len(legs), "all_or_nothing", br.AllOrNothing)
+	b, _ := json.Marshal(resp)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusCreated, Headers: map[string]string{"Content-Type": "application/json", logging.Header: correlationID}, Body: string(b)}, nil
+}
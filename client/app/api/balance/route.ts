@@ -1,12 +1,17 @@
 import { NextRequest } from "next/server";
-import { fetchAccounts } from "@/services/api";
+import { ApiError, fetchAccounts } from "@/services/api";
 
 // Simple proxy route so the client doesn't need direct API Gateway details
 export async function GET(req: NextRequest) {
   const { searchParams } = new URL(req.url);
   const userId = searchParams.get("user_id") || "c1";
+  const valuationCurrency = searchParams.get("valuation_currency") || undefined;
   try {
-  const data = await fetchAccounts(userId);
+  const data = await fetchAccounts(userId, valuationCurrency).catch((err) => {
+      // Still show the balances when a rate for the total is missing
+      if (err instanceof ApiError && err.problem?.code === "RATE_UNAVAILABLE") return fetchAccounts(userId);
+      throw err;
+    });
     return Response.json(data, { status: 200 });
   } catch (err) {
     const message = err instanceof Error ? err.message : "Unknown error";
@@ -0,0 +1,17 @@
+import { NextRequest } from "next/server";
+import { ApiError, CandleInterval, fetchRateHistory } from "@/services/api";
+
+// Proxy for GET /rates/{pair}/history: OHLC candles, oldest first
+export async function GET(req: NextRequest, { params }: { params: Promise<{ pair: string }> }) {
+  const { pair } = await params;
+  const { searchParams } = new URL(req.url);
+  const interval = (searchParams.get("interval") || "1h") as CandleInterval;
+  const from = searchParams.get("from") || undefined;
+  try {
+    const data = await fetchRateHistory(pair, interval, from);
+    return Response.json(data, { status: 200 });
+  } catch (err) {
+    const message = err instanceof Error ? err.message : "Unknown error";
+    return Response.json({ error: message }, { status: err instanceof ApiError ? err.status : 500 });
+  }
+}
@@ -0,0 +1,14 @@
+import { NextRequest } from "next/server";
+import { ApiError, fetchRateQuote } from "@/services/api";
+
+// Proxy for GET /rates/{pair}: the latest quote and its age
+export async function GET(_req: NextRequest, { params }: { params: Promise<{ pair: string }> }) {
+  const { pair } = await params;
+  try {
+    const data = await fetchRateQuote(pair);
+    return Response.json(data, { status: 200 });
+  } catch (err) {
+    const message = err instanceof Error ? err.message : "Unknown error";
+    return Response.json({ error: message }, { status: err instanceof ApiError ? err.status : 500 });
+  }
+}
@@ -0,0 +1,15 @@
+import { NextRequest } from "next/server";
+import { ApiError, fetchStreamToken } from "@/services/api";
+
+// Hands the browser a stream token for /stream, signed with the tenant key it never sees
+export async function GET(req: NextRequest) {
+  const { searchParams } = new URL(req.url);
+  const userId = searchParams.get("user_id") || "c1";
+  try {
+    const data = await fetchStreamToken(userId);
+    return Response.json(data, { status: 200, headers: { "Cache-Control": "no-store" } });
+  } catch (err) {
+    const message = err instanceof Error ? err.message : "Unknown error";
+    return Response.json({ error: message }, { status: err instanceof ApiError ? err.status : 500 });
+  }
+}
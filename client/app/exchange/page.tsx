@@ -2,23 +2,30 @@
 import { useRouter } from "next/navigation";
 import { ArrowLeft, ArrowDown } from "lucide-react";
 import { Button } from "@/components/ui/button";
-import { useState, useEffect, useCallback } from "react";
+import { useState, useEffect } from "react";
 import { Loader2, AlertCircle } from "lucide-react";
 
 // Simple currency symbol map (match home page style)
 const currencySymbols: Record<string, string> = { USD: "$", EUR: "€", GBP: "£", CAD: "$", JPY: "¥" };
 
 interface AccountState { currency: string; balance: number }
+interface Candle { start: string; close: number }
 
-// Static FX rates mapping (from:to => rate)
-const RATES: Record<string, number> = {
-  "USD:EUR": 0.90,
-  "EUR:USD": 1.16,
-  "USD:GBP": 1.26,
-  "GBP:USD": 0.79,
-  "EUR:GBP": 1.16,
-  "GBP:EUR": 0.90,
-};
+// RateChart draws the closes of the pair's recent candles as a line.
+function RateChart({ candles }: { candles: Candle[] }) {
+  if (candles.length < 2) {
+    return <p className="text-xs text-muted-foreground">Not enough rate history to chart yet</p>;
+  }
+  const closes = candles.map(c => c.close);
+  const min = Math.min(...closes);
+  const span = Math.max(...closes) - min || 1;
+  const points = closes.map((c, i) => `${(i / (closes.length - 1)) * 100},${30 - ((c - min) / span) * 28 - 1}`).join(" ");
+  return (
+    <svg viewBox="0 0 100 30" preserveAspectRatio="none" className="w-full h-16 text-accent" aria-label="Rate over the last 24 hours">
+      <polyline points={points} fill="none" stroke="currentColor" strokeWidth="1" vectorEffect="non-scaling-stroke" />
+    </svg>
+  );
+}
 
 export default function ExchangePage() {
   const router = useRouter();
@@ -27,7 +34,9 @@ export default function ExchangePage() {
   const [accountError, setAccountError] = useState<string | null>(null);
   const [from, setFrom] = useState<{ code: string; symbol: string; balance: number }>({ code: "USD", symbol: "$", balance: 0 });
   const [to, setTo] = useState<{ code: string; symbol: string; balance: number }>({ code: "EUR", symbol: "€", balance: 0 });
-  const [rate, setRate] = useState<number>(() => RATES["USD:EUR"]);
+  const [rate, setRate] = useState<number | null>(null);
+  const [rateAge, setRateAge] = useState<number | null>(null);
+  const [candles, setCandles] = useState<Candle[]>([]);
   const [amount, setAmount] = useState<string>("100"); // from amount (no symbol)
   const [flipped, setFlipped] = useState(false);
   const [submitting, setSubmitting] = useState(false);
@@ -36,7 +45,7 @@ export default function ExchangePage() {
   // jobId indicates a successful submission already queued
 
   const parsed = parseFloat(amount || "0") || 0;
-  const toAmount = parsed * rate;
+  const toAmount = parsed * (rate ?? 0);
 
   const formatNumber = (n: number, maxDecimals = 6) => {
     if (!isFinite(n)) return "0";
@@ -44,18 +53,12 @@ export default function ExchangePage() {
     return fixed.replace(/\.?(0+)$/, "");
   };
 
-  const recomputeRate = useCallback((fromCode: string, toCode: string) => {
-    if (fromCode === toCode) return 1;
-    return RATES[`${fromCode}:${toCode}`] ?? 1;
-  }, []);
-
   const swap = () => {
     // Pre-calc new state
     const nextFrom = to;
     const nextTo = from;
     setFrom(nextFrom);
     setTo(nextTo);
-    setRate(recomputeRate(nextFrom.code, nextTo.code));
     if (!isNaN(toAmount) && toAmount > 0) {
       const next = formatNumber(toAmount, 6);
       setAmount(next);
@@ -81,7 +84,6 @@ export default function ExchangePage() {
           const eur = loaded.find(a => a.currency === 'EUR' && a.currency !== usd?.currency) || loaded.find(a => a.currency !== usd?.currency) || loaded[1] || loaded[0];
           if (usd) setFrom({ code: usd.currency, symbol: currencySymbols[usd.currency] || usd.currency, balance: usd.balance });
           if (eur) setTo({ code: eur.currency, symbol: currencySymbols[eur.currency] || eur.currency, balance: eur.balance });
-          if (usd && eur) setRate(recomputeRate(usd.currency, eur.currency));
         } else {
           setAccountError('Malformed response');
         }
@@ -92,7 +94,37 @@ export default function ExchangePage() {
       }
     };
     loadAccounts();
-  }, [recomputeRate]);
+  }, []);
+
+  // Quote the pair and chart its last 24 hours whenever it changes
+  useEffect(() => {
+    if (from.code === to.code) {
+      setRate(1);
+      setRateAge(null);
+      setCandles([]);
+      return;
+    }
+    const pair = `${from.code}-${to.code}`;
+    const controller = new AbortController();
+    setRate(null);
+    const loadRate = async () => {
+      try {
+        const since = new Date(Date.now() - 24 * 3600 * 1000).toISOString();
+        const [quote, history] = await Promise.all([
+          fetch(`/api/rates/${pair}`, { signal: controller.signal }).then(r => r.ok ? r.json() : null),
+          fetch(`/api/rates/${pair}/history?interval=1h&from=${encodeURIComponent(since)}`, { signal: controller.signal }).then(r => r.ok ? r.json() : null),
+        ]);
+        setRate(quote ? quote.rate : null);
+        setRateAge(quote ? quote.age_seconds : null);
+        setCandles(history ? history.candles : []);
+      } catch (e) {
+        if (e instanceof DOMException && e.name === 'AbortError') return;
+        setRate(null);
+      }
+    };
+    loadRate();
+    return () => controller.abort();
+  }, [from.code, to.code]);
 
   // Keep balances in sync if accounts array updates (e.g., refresh)
   useEffect(() => {
@@ -107,7 +139,7 @@ export default function ExchangePage() {
     }
   }, [accounts, from.code, to.code, from.balance, to.balance]);
 
-  const canSubmit = parsed > 0 && !submitting && !jobId;
+  const canSubmit = parsed > 0 && rate !== null && !submitting && !jobId;
 
   const handleSubmit = async () => {
     if (!canSubmit) return;
@@ -157,8 +189,10 @@ export default function ExchangePage() {
         <div className="px-6 mb-6">
             <div className="inline-flex items-center gap-2 px-3 py-1.5 text-xs font-medium bg-glass-primary text-accent shadow-glass-border shadow">
             <span>Rate</span>
-            <span className="font-mono">{from.symbol}1 = {to.symbol}{rate.toFixed(4)}</span>
+            <span className="font-mono">{rate !== null ? `${from.symbol}1 = ${to.symbol}${rate.toFixed(4)}` : "unavailable"}</span>
+            {rateAge !== null && <span className="text-muted-foreground">{Math.round(rateAge)}s ago</span>}
             </div>
+            {from.code !== to.code && <div className="mt-3"><RateChart candles={candles} /></div>}
         </div>
 
 
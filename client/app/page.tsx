@@ -36,6 +36,7 @@ export default function Home() {
   const [accounts, setAccounts] = useState<AccountState[]>([]);
   const [accountError, setAccountError] = useState<string | null>(null);
   const [accountsLoading, setAccountsLoading] = useState<boolean>(false);
+  const [valuation, setValuation] = useState<{ currency: string; total: number } | null>(null);
   const [selectedIndex, setSelectedIndex] = useState(0);
   const [showAccountSheet, setShowAccountSheet] = useState(false);
   const [transactions, setTransactions] = useState<Transaction[]>([]);
@@ -49,12 +50,13 @@ export default function Home() {
     setAccountsLoading(true);
     setAccountError(null);
     try {
-      const res = await fetch(`/api/balance?user_id=c1`, { signal: controller.signal });
+      const res = await fetch(`/api/balance?user_id=c1&valuation_currency=GBP`, { signal: controller.signal });
       if (!res.ok) throw new Error(await res.text());
       const data = await res.json();
       if (Array.isArray(data.accounts)) {
         setAccounts(data.accounts.map((a: { currency: string; balance: number }) => ({ currency: a.currency, balance: a.balance })));
         setSelectedIndex(0);
+        setValuation(data.valuation ? { currency: data.valuation.currency, total: data.valuation.total } : null);
       } else {
         setAccountError('Malformed response');
       }
@@ -76,7 +78,7 @@ export default function Home() {
       const res = await fetch(`/api/transactions?user_id=c1&limit=10`, { signal: controller.signal });
       if (!res.ok) throw new Error(await res.text());
       const transactionsData = await res.json();
-      setTransactions(transactionsData.jobs);
+      setTransactions(transactionsData.jobs ?? []);
     } catch (e: unknown) {
       if (e instanceof DOMException && e.name === 'AbortError') return;
       if (e instanceof Error) {
@@ -100,6 +102,40 @@ export default function Home() {
     };
   }, []);
 
+  // Live updates from the Go stream server (cmd/stream), when configured
+  useEffect(() => {
+    const streamUrl = process.env.NEXT_PUBLIC_STREAM_URL;
+    if (!streamUrl) return;
+    let source: EventSource | null = null;
+    let closed = false;
+    // EventSource cannot send the API key: open the stream with a token from
+    // our API route, and fetch a fresh one whenever the browser gives up
+    // reconnecting (a token only opens streams for a few minutes)
+    const connect = async () => {
+      const res = await fetch(`/api/stream-token?user_id=c1`, { cache: "no-store" });
+      if (!res.ok || closed) return;
+      const { token } = await res.json();
+      source = new EventSource(`${streamUrl}/stream?user_id=c1&token=${encodeURIComponent(token)}`);
+      source.addEventListener("balance", (e) => {
+        const { currency, balance } = JSON.parse((e as MessageEvent).data) as AccountState;
+        setAccounts(prev => prev.some(a => a.currency === currency)
+          ? prev.map(a => a.currency === currency ? { ...a, balance } : a)
+          : [...prev, { currency, balance }]);
+      });
+      // Job rows carry joined fields, so re-fetch the list; resync means updates were missed
+      source.addEventListener("job", () => { loadData(); });
+      source.addEventListener("resync", () => { loadData(); });
+      source.onerror = () => {
+        if (source?.readyState === EventSource.CLOSED && !closed) setTimeout(() => connect().catch(() => {}), 3000);
+      };
+    };
+    connect().catch(() => {});
+    return () => {
+      closed = true;
+      source?.close();
+    };
+  }, []);
+
   return (
   <div className="min-h-screen">
         {/* Header */}
@@ -164,6 +200,12 @@ export default function Home() {
                   )}
                 </button>
                 <p className="text-sm text-muted-foreground mt-1">{accounts[selectedIndex]?.currency || 'Account'}</p>
+                {valuation && (
+                  <p className="text-xs text-muted-foreground tabular-nums">
+                    Total {currencySymbols[valuation.currency] || valuation.currency}
+                    {valuation.total.toLocaleString(undefined, { minimumFractionDigits: 2, maximumFractionDigits: 2 })}
+                  </p>
+                )}
               </div>
               <div className="w-12 h-12 flex items-center justify-center">
                 <span className="text-3xl md:text-[2.2rem] leading-none">{currencyFlags[accounts[selectedIndex]?.currency ?? ""] || '🏳️'}</span>
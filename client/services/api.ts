@@ -1,12 +1,33 @@
+// Types follow the schemas in api/openapi.json, which the Go handlers validate
+// against; change the document first.
 export interface AccountEntry {
   currency: string;
   balance: number;
+  held: number; // reserved for unsettled jobs
+  available: number; // balance less held
+}
+
+export interface RateSnapshot {
+  source: string;
+  target: string;
+  rate: number;
+  provider: string;
+  as_of: string;
+}
+
+// Valuation is the accounts' value in one currency at as_of.
+export interface Valuation {
+  currency: string;
+  as_of: string;
+  total: number;
+  accounts: { currency: string; balance: number; value: number }[];
+  rates: RateSnapshot[];
 }
 
 export interface AccountsResponse {
   user_id: string;
-  accounts: AccountEntry[];
-  [k: string]: unknown;
+  accounts: AccountEntry[] | null;
+  valuation?: Valuation; // with valuation_currency
 }
 
 const apiGatewayId = process.env.API_GATEWAY_ID;
@@ -17,37 +38,73 @@ if (!apiGatewayId) {
   console.warn("API_GATEWAY_ID is not set in environment");
 }
 
+// The tenant's API key. This module only runs in the Next.js API routes, so
+// the key never reaches the browser (no NEXT_PUBLIC_ prefix).
+const tenantKey = process.env.TENANT_API_KEY;
+
+if (!tenantKey) {
+  console.warn("TENANT_API_KEY is not set in environment; the API answers 401");
+}
+
+const authorization: Record<string, string> = tenantKey ? { Authorization: `Bearer ${tenantKey}` } : {};
+
 // Construct base URL for REST API (assuming 'dev' stage)
 const stage = "dev";
 const root = `${base}/restapis/${apiGatewayId}/${stage}/_user_request_`;
 
+// Problem is the RFC 7807 body of every error response. Match on code.
+export interface Problem {
+  type: string;
+  title: string;
+  status: number;
+  detail?: string;
+  code: string; // e.g. INSUFFICIENT_FUNDS
+  request_id?: string;
+  errors?: { field: string; detail: string }[];
+}
+
+export class ApiError extends Error {
+  constructor(readonly status: number, readonly problem?: Problem) {
+    super(`API ${status}: ${problem ? problem.detail || problem.title : 'request failed'}${problem ? ` (${problem.code})` : ''}`);
+  }
+}
+
 async function http<T>(path: string, init?: RequestInit): Promise<T> {
   const url = `${root}${path}`;
-  const res = await fetch(url, { ...init, headers: { 'Content-Type': 'application/json', ...(init?.headers || {}) } });
+  const res = await fetch(url, { ...init, headers: { 'Content-Type': 'application/json', ...authorization, ...(init?.headers || {}) } });
   if (!res.ok) {
-    const text = await res.text();
-    throw new Error(`API ${res.status} ${res.statusText}: ${text}`);
+    const problem = res.headers.get('Content-Type')?.includes('problem+json') ? ((await res.json()) as Problem) : undefined;
+    throw new ApiError(res.status, problem);
   }
   return res.json() as Promise<T>;
 }
 
-export async function fetchAccounts(userId: string): Promise<AccountsResponse> {
-  return http<AccountsResponse>(`/balances?user_id=${encodeURIComponent(userId)}`);
+export async function fetchAccounts(userId: string, valuationCurrency?: string): Promise<AccountsResponse> {
+  const params = new URLSearchParams({ user_id: userId });
+  if (valuationCurrency) params.set('valuation_currency', valuationCurrency);
+  return http<AccountsResponse>(`/balances?${params}`);
 }
 
 // --- Jobs (currency conversion) ---
+export type JobStatus = 'pending' | 'queued' | 'in_progress' | 'completed' | 'failed' | 'cancelled';
+
 export interface ConversionJobRequest {
   client_id: string;
   source_currency: string;
   target_currency: string;
   source_amount: number;
+  idempotency_key?: string;
+  limit_rate?: number; // settle once the rate reaches this
+  expires_at?: string; // cancel the limit order if it has not settled by then
 }
 
 export interface ConversionJobResponse extends ConversionJobRequest {
   job_id: string;
-  status: string; // queued | processing | completed | failed
+  status: JobStatus;
+  correlation_id?: string;
   created_at: string;
-  [k: string]: unknown;
+  batch_id?: string;
+  all_or_nothing?: boolean;
 }
 
 export async function createConversionJob(body: ConversionJobRequest): Promise<ConversionJobResponse> {
@@ -62,18 +119,80 @@ export interface Transaction {
   target_currency: string;
   source_amount: number;
   target_amount: number;
-  rate: number;
+  rate: number; // the bid the job executed at
   fee: number;
-  status: string;
+  status: JobStatus;
   created_at: string;
-  completed_at?: string;
+  completed_at: string;
+  mid_rate?: number;
+  spread_bps?: number;
+  spread?: number; // spread revenue in target units, apart from the fee
 }
 
 export interface TransactionsResponse {
   user_id: string;
-  jobs: Transaction[];
+  jobs: Transaction[] | null;
 }
 
 export async function fetchTransactions(userId: string, limit: number = 10): Promise<TransactionsResponse> {
   return http<TransactionsResponse>(`/jobs?user_id=${encodeURIComponent(userId)}&limit=${limit}`);
 }
+
+// --- Rates ---
+// Pairs are written SOURCE-TARGET, e.g. USD-EUR.
+export interface RateQuote {
+  source: string;
+  target: string;
+  rate: number;
+  provider: string;
+  as_of: string;
+  age_seconds: number;
+}
+
+export interface Candle {
+  start: string;
+  open: number;
+  high: number;
+  low: number;
+  close: number;
+  ticks: number;
+}
+
+export type CandleInterval = '1m' | '5m' | '15m' | '1h' | '4h' | '1d';
+
+export interface RateHistory {
+  source: string;
+  target: string;
+  interval: CandleInterval;
+  from: string;
+  to: string;
+  candles: Candle[];
+}
+
+export async function fetchRateQuote(pair: string): Promise<RateQuote> {
+  return http<RateQuote>(`/rates/${encodeURIComponent(pair)}`);
+}
+
+export async function fetchRateHistory(pair: string, interval: CandleInterval = '1h', from?: string): Promise<RateHistory> {
+  const params = new URLSearchParams({ interval });
+  if (from) params.set('from', from);
+  return http<RateHistory>(`/rates/${encodeURIComponent(pair)}/history?${params}`);
+}
+
+// --- Live updates ---
+// EventSource cannot send the API key, so the browser opens the stream with a
+// short-lived token the stream server issues for one user.
+export interface StreamToken {
+  token: string;
+  expires_at: string;
+}
+
+export async function fetchStreamToken(userId: string): Promise<StreamToken> {
+  const streamUrl = process.env.STREAM_URL || process.env.NEXT_PUBLIC_STREAM_URL;
+  const res = await fetch(`${streamUrl}/stream/token?user_id=${encodeURIComponent(userId)}`, { headers: authorization, cache: 'no-store' });
+  if (!res.ok) {
+    const problem = res.headers.get('Content-Type')?.includes('problem+json') ? ((await res.json()) as Problem) : undefined;
+    throw new ApiError(res.status, problem);
+  }
+  return res.json() as Promise<StreamToken>;
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}, Operators: testOperators} }))
+}
+
+// audited returns "operator action" for each audit entry of the target, oldest first.
+func audited(t *testing.T, targetType, targetID string) []string {
+	t.Helper()
+	var out struct{ Entries []AuditEntry }
+	if status := call(t, svc, adminRequest(http.MethodGet, "/admin/audit?target_type="+targetType+"&target_id="+targetID, makerToken, nil), &out); status != http.StatusOK {
+		t.Fatalf("audit: status %d", status)
+	}
+	var got []string
+	for i := len(out.Entries) - 1; i >= 0; i-- {
+		got = append(got, out.Entries[i].Operator+" "+out.Entries[i].Action.Action)
+	}
+	return got
+}
+
+func TestAdjustmentIsPostedOnceApproved(t *testing.T) {
+	pg := testpg.DB(t)
+	opening := testpg.Funds{"USD": 100}
+	user := testpg.FundedUser(t, pg, opening)
+
+	var adj Adjustment
+	body := adjustmentRequest{UserID: user, Currency: "USD", Amount: -30, Reason: "duplicate deposit"}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments", makerToken, body), &adj); status != http.StatusCreated {
+		t.Fatalf("request: status %d", status)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+adj.AdjustmentID+"/approve", makerToken, nil), nil); status != http.StatusForbidden {
+		t.Errorf("self-approval: status %d, want 403", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+adj.AdjustmentID+"/approve", checkerToken, nil), &adj); status != http.StatusOK || adj.Status != adjustmentApproved {
+		t.Fatalf("approval: status %d, %+v", status, adj)
+	}
+
+	testpg.AssertBalance(t, pg, user, "USD", 70)
+	testpg.AssertLedgerMatchesBalances(t, pg, user, opening)
+	var entryType string
+	var amount float64
+	if err := pg.QueryRow(`SELECT entry_type, amount FROM ledger_entries WHERE adjustment_id=$1`, adj.AdjustmentID).Scan(&entryType, &amount); err != nil || entryType != "debit" || amount != 30 {
+		t.Errorf("ledger entry = %s %v (%v), want a debit of 30", entryType, amount, err)
+	}
+	if got := audited(t, "adjustment", adj.AdjustmentID); len(got) != 1 || got[0] != "checker adjustment.approved" {
+		t.Errorf("audit = %v", got)
+	}
+	if got := audited(t, "account", user+"/USD"); len(got) != 1 || got[0] != "maker adjustment.requested" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestAdjustmentCannotDebitHeldFunds(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	testpg.HeldJob(t, pg, user, "USD", "EUR", 80)
+
+	var adj Adjustment
+	call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments", makerToken, adjustmentRequest{UserID: user, Currency: "USD", Amount: -50, Reason: "chargeback"}), &adj)
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+adj.AdjustmentID+"/approve", checkerToken, nil), nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422", status)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	var pending struct{ Adjustments []Adjustment }
+	call(t, svc, adminRequest(http.MethodGet, "/admin/adjustments?status=pending", makerToken, nil), &pending)
+	found := false
+	for _, a := range pending.Adjustments {
+		found = found || a.AdjustmentID == adj.AdjustmentID
+	}
+	if !found {
+		t.Error("refused approval left the adjustment decided")
+	}
+}
+
+func TestFreezeAccount(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	reason := actionRequest{Reason: "fraud review"}
+
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/"+user+"/USD/freeze", makerToken, reason), nil); status != http.StatusOK {
+		t.Fatalf("freeze: status %d", status)
+	}
+	var status string
+	if err := pg.QueryRow(`SELECT status FROM accounts WHERE user_id=$1 AND currency='USD'`, user).Scan(&status); err != nil || status != accountFrozen {
+		t.Errorf("account status = %q (%v), want frozen", status, err)
+	}
+	if code := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/"+user+"/USD/freeze", makerToken, reason), nil); code != http.StatusConflict {
+		t.Errorf("second freeze: status %d, want 409", code)
+	}
+	if code := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/"+user+"/USD/unfreeze", checkerToken, reason), nil); code != http.StatusOK {
+		t.Errorf("unfreeze: status %d", code)
+	}
+	if got := audited(t, "account", user+"/USD"); len(got) != 2 || got[0] != "maker account.frozen" || got[1] != "checker account.unfrozen" {
+		t.Errorf("audit = %v", got)
+	}
+	if _, err := pg.Exec(`DELETE FROM admin_audit_log WHERE target_id=$1`, user+"/USD"); err == nil {
+		t.Error("audit log rows could be deleted")
+	}
+}
+
+func TestCloseUser(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	reason := actionRequest{Reason: "sanctions"}
+
+	var u User
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/users/"+user+"/close", makerToken, reason), &u); status != http.StatusOK || u.Status != accountClosed {
+		t.Fatalf("close: status %d, %+v", status, u)
+	}
+	var status string
+	if err := pg.QueryRow(`SELECT status FROM users WHERE user_id=$1`, user).Scan(&status); err != nil || status != accountClosed {
+		t.Errorf("user status = %q (%v), want closed", status, err)
+	}
+	if code := call(t, svc, adminRequest(http.MethodPost, "/admin/users/"+user+"/unfreeze", makerToken, reason), nil); code != http.StatusConflict {
+		t.Errorf("unfreeze: status %d, want 409", code)
+	}
+	if code := call(t, svc, adminRequest(http.MethodPost, "/admin/users/no-such-user/freeze", makerToken, reason), nil); code != http.StatusNotFound {
+		t.Errorf("unknown user: status %d, want 404", code)
+	}
+	if got := audited(t, "user", user); len(got) != 1 || got[0] != "maker user.closed" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestRequeueFailedJob(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	jobID := testpg.QueuedJob(t, pg, user, "USD", "EUR", 50)
+	if _, err := pg.Exec(`UPDATE conversion_jobs SET status='failed', metadata=jsonb_build_object('error','INSUFFICIENT_FUNDS') WHERE job_id=$1`, jobID); err != nil {
+		t.Fatal(err)
+	}
+	reason := actionRequest{Reason: "account funded"}
+
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/jobs/"+jobID+"/requeue", makerToken, reason), nil); status != http.StatusOK {
+		t.Fatalf("requeue: status %d", status)
+	}
+	job := testpg.LoadJob(t, pg, jobID)
+	if job.Status != "queued" || job.Metadata["error"] != nil {
+		t.Errorf("job = %+v, want queued without an error", job)
+	}
+	if out := testpg.Outbox(t, pg, jobID); len(out) != 1 || out[0].Topic != "conversion-jobs" || out[0].Payload["job_id"] != jobID {
+		t.Errorf("outbox = %+v, want the job message", out)
+	}
+	if got := audited(t, "job", jobID); len(got) != 1 || got[0] != "maker job.requeued" {
+		t.Errorf("audit = %v", got)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/jobs/"+jobID+"/requeue", makerToken, reason), nil); status != http.StatusConflict {
+		t.Errorf("requeue of a queued job: status %d, want 409", status)
+	}
+}
+
+func TestResetRateBreakerRequeuesHaltedJobs(t *testing.T) {
+	pg := testpg.DB(t)
+	ctx := context.Background()
+	user := testpg.FundedUser(t, pg, testpg.Funds{"NZD": 100})
+	halted, other := testpg.QueuedJob(t, pg, user, "NZD", "SEK", 10), testpg.QueuedJob(t, pg, user, "NZD", "USD", 10)
+	guard := rateguard.PGStore{DB: pg}
+	if err := guard.Accept(ctx, "NZD", "SEK", 6.2, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := guard.Reject(ctx, rateguard.Rejection{Source: "NZD", Target: "SEK", Rate: 0.0062, Provider: "feed", Reason: rateguard.ReasonDeviation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list struct{ Breakers []rateguard.Breaker }
+	if status := call(t, svc, adminRequest(http.MethodGet, "/admin/rate-breakers?status=open", makerToken, nil), &list); status != http.StatusOK ||
+		len(list.Breakers) != 1 || list.Breakers[0].Rejection == nil || list.Breakers[0].Rejection.ID != rejected.ID || *list.Breakers[0].ReferenceRate != 6.2 {
+		t.Fatalf("open breakers: status %d, %+v", status, list.Breakers)
+	}
+	var rejections struct{ Rejections []rateguard.Rejection }
+	if status := call(t, svc, adminRequest(http.MethodGet, "/admin/rate-rejections?pair=NZD-SEK", makerToken, nil), &rejections); status != http.StatusOK ||
+		len(rejections.Rejections) != 1 || rejections.Rejections[0].Rate != 0.0062 {
+		t.Errorf("rejections: status %d, %+v", status, rejections.Rejections)
+	}
+
+	req := adminRequest(http.MethodPost, "/admin/rate-breakers/NZD-SEK/reset", makerToken, resetRequest{Reason: "feed corrected"})
+	delete(req.Headers, tenant.Header)
+	var out struct {
+		Breaker  rateguard.Breaker
+		Requeued []string
+	}
+	if status := call(t, svc, req, &out); status != http.StatusOK || out.Breaker.Status != rateguard.BreakerClosed || out.Breaker.ResetBy != "maker" {
+		t.Fatalf("reset: status %d, %+v", status, out.Breaker)
+	}
+	if len(out.Requeued) != 1 || out.Requeued[0] != halted {
+		t.Errorf("requeued %v, want %s", out.Requeued, halted)
+	}
+	if msgs := testpg.Outbox(t, pg, halted); len(msgs) != 1 || msgs[0].Topic != "conversion-jobs" || msgs[0].Payload["job_id"] != halted {
+		t.Errorf("outbox = %+v, want the halted job's message", msgs)
+	}
+	if msgs := testpg.Outbox(t, pg, other); len(msgs) != 0 {
+		t.Errorf("another pair's job was requeued: %+v", msgs)
+	}
+	if got := audited(t, "rate_pair", "NZD-SEK"); len(got) != 1 || got[0] != "maker rate_breaker.reset" {
+		t.Errorf("audit = %v", got)
+	}
+	// Resetting keeps the reference unless asked to clear it
+	if b, err := guard.Breaker(ctx, "NZD", "SEK"); err != nil || b.Status != rateguard.BreakerClosed || b.ReferenceRate == nil || *b.ReferenceRate != 6.2 {
+		t.Errorf("breaker = %+v, %v", b, err)
+	}
+}
+
+func TestSearchJobs(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	queued := testpg.QueuedJob(t, pg, user, "USD", "EUR", 10)
+	failed := testpg.QueuedJob(t, pg, user, "USD", "GBP", 20)
+	if _, err := pg.Exec(`UPDATE conversion_jobs SET status='failed', metadata=jsonb_build_object('error','INVALID_REQUEST') WHERE job_id=$1`, failed); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(q string) []Job {
+		t.Helper()
+		var out struct{ Jobs []Job }
+		if status := call(t, svc, adminRequest(http.MethodGet, "/admin/jobs?client_id="+user+q, makerToken, nil), &out); status != http.StatusOK {
+			t.Fatalf("search %s: status %d", q, status)
+		}
+		return out.Jobs
+	}
+	if jobs := search(""); len(jobs) != 2 || jobs[0].JobID != failed {
+		t.Errorf("all = %+v, want both, newest first", jobs)
+	}
+	if jobs := search("&status=failed"); len(jobs) != 1 || jobs[0].JobID != failed || jobs[0].Error != "INVALID_REQUEST" {
+		t.Errorf("failed = %+v", jobs)
+	}
+	if jobs := search("&source_currency=USD&target_currency=EUR"); len(jobs) != 1 || jobs[0].JobID != queued {
+		t.Errorf("USD:EUR = %+v", jobs)
+	}
+	if jobs := search("&to=2000-01-01"); len(jobs) != 0 {
+		t.Errorf("before 2000 = %+v, want none", jobs)
+	}
+}
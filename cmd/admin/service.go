@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// Adjustment statuses. Only a pending adjustment can be decided.
+const (
+	adjustmentPending  = "pending"
+	adjustmentApproved = "approved"
+	adjustmentRejected = "rejected"
+)
+
+// Account and user statuses.
+const (
+	accountActive = settlement.AccountActive
+	accountFrozen = settlement.AccountFrozen
+	accountClosed = settlement.AccountClosed
+)
+
+// statusVerbs maps the last segment of a status change route to the new
+// status and the past tense recorded in the audit action.
+var statusVerbs = map[string]struct{ status, done string }{
+	"freeze":   {accountFrozen, "frozen"},
+	"unfreeze": {accountActive, "unfrozen"},
+	"close":    {accountClosed, "closed"},
+}
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// jobStatuses are the values of job_status_enum.
+var jobStatuses = map[string]bool{"pending": true, "queued": true, "in_progress": true, "completed": true, "failed": true, "cancelled": true}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ratePair is a pair in a path or query, e.g. USD-EUR.
+var ratePair = regexp.MustCompile(`^([A-Z]{3})-([A-Z]{3})$`)
+
+// Job is a job in any status, as ops sees it.
+type Job struct {
+	JobID          string     `json:"job_id"`
+	TenantID       string     `json:"tenant_id"`
+	ClientID       string     `json:"client_id"`
+	SourceCurrency string     `json:"source_currency"`
+	TargetCurrency string     `json:"target_currency"`
+	SourceAmount   float64    `json:"source_amount"`
+	TargetAmount   *float64   `json:"target_amount,omitempty"`
+	Rate           *float64   `json:"rate,omitempty"`
+	Fee            *float64   `json:"fee,omitempty"`
+	HeldAmount     float64    `json:"held_amount"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"` // failure reason
+	BatchID        string     `json:"batch_id,omitempty"`
+	AllOrNothing   bool       `json:"all_or_nothing,omitempty"`
+	ScheduleID     string     `json:"schedule_id,omitempty"`
+	ReversalOf     string     `json:"reversal_of,omitempty"`
+	LimitRate      *float64   `json:"limit_rate,omitempty"`
+	CorrelationID  string     `json:"correlation_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// JobFilter narrows a job search; empty fields match every job.
+type JobFilter struct {
+	ClientID       string
+	Status         string
+	SourceCurrency string
+	TargetCurrency string
+	From, To       *time.Time // created_at, To exclusive
+	Limit          int
+}
+
+// JobMessage is a requeued job's outbox payload and SQS message body, in the
+// shape POST /jobs publishes.
+type JobMessage struct {
+	JobID          string    `json:"job_id"`
+	Status         string    `json:"status"`
+	ClientID       string    `json:"client_id"`
+	SourceCurrency string    `json:"source_currency"`
+	TargetCurrency string    `json:"target_currency"`
+	SourceAmount   float64   `json:"source_amount"`
+	CorrelationID  string    `json:"correlation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	BatchID        string    `json:"batch_id,omitempty"`
+	AllOrNothing   bool      `json:"all_or_nothing,omitempty"`
+}
+
+// Requeued is a job put back in the queue and the outbox row carrying it.
+type Requeued struct {
+	Message  JobMessage
+	OutboxID string
+}
+
+// Payload is the job's outbox payload and message body.
+func (j JobMessage) Payload() []byte {
+	b, _ := json.Marshal(j)
+	return b
+}
+
+// Adjustment is a manual balance correction. A positive Amount credits the
+// account, a negative one debits it. It only moves the balance once an
+// operator other than RequestedBy approves it.
+type Adjustment struct {
+	AdjustmentID   string     `json:"adjustment_id"`
+	TenantID       string     `json:"tenant_id"`
+	UserID         string     `json:"user_id"`
+	Currency       string     `json:"currency"`
+	Amount         float64    `json:"amount"`
+	Reason         string     `json:"reason"`
+	Status         string     `json:"status"`
+	RequestedBy    string     `json:"requested_by"`
+	RequestedAt    time.Time  `json:"requested_at"`
+	DecidedBy      string     `json:"decided_by,omitempty"`
+	DecisionReason string     `json:"decision_reason,omitempty"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+}
+
+// Account is one user's balance in one currency.
+type Account struct {
+	UserID   string  `json:"user_id"`
+	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`
+	Held     float64 `json:"held"`
+	Status   string  `json:"status"`
+}
+
+// User is a user's own status, which applies to all their accounts.
+type User struct {
+	UserID string `json:"user_id"`
+	Status string `json:"status"`
+}
+
+// AuditEntry is a recorded admin action.
+type AuditEntry struct {
+	AuditID string `json:"audit_id"`
+	admin.Action
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows an audit log search; empty fields match every entry.
+type AuditFilter struct {
+	Operator   string
+	TargetType string
+	TargetID   string
+	Limit      int
+}
+
+// Store is the back-office persistence. Every method that changes something
+// records its admin.Action in the same transaction.
+type Store interface {
+	// Jobs returns the jobs matching f, most recent first.
+	Jobs(ctx context.Context, f JobFilter) ([]Job, error)
+	// RequestAdjustment inserts a as pending.
+	RequestAdjustment(ctx context.Context, a Adjustment, op admin.Action) (Adjustment, error)
+	// Adjustments lists adjustments in status (any when empty), most recent first.
+	Adjustments(ctx context.Context, status string, limit int) ([]Adjustment, error)
+	// DecideAdjustment locks the adjustment, lets decide approve or reject it
+	// and saves the decision. An approved adjustment is applied to the
+	// account, created if missing, with one ledger entry; a debit the
+	// available balance cannot cover returns errInsufficientFunds. An error
+	// from decide aborts; an unknown id returns sql.ErrNoRows.
+	DecideAdjustment(ctx context.Context, adjustmentID string, op admin.Action, decide func(*Adjustment) error) (Adjustment, error)
+	// SetAccountStatus changes the account's status, or returns sql.ErrNoRows
+	// or the conflictError of statusChange.
+	SetAccountStatus(ctx context.Context, userID, currency, status string, op admin.Action) (Account, error)
+	// SetUserStatus changes the user's status, or returns sql.ErrNoRows for a
+	// user without accounts or the conflictError of statusChange.
+	SetUserStatus(ctx context.Context, userID, status string, op admin.Action) (User, error)
+	// RequeueJob locks the job, lets check refuse it, and puts it back in the
+	// queue without a hold: it is set queued, its failure reason is cleared
+	// and a new outbox row is inserted with msg. An unknown id returns
+	// sql.ErrNoRows.
+	RequeueJob(ctx context.Context, jobID string, op admin.Action, check func(Job) error) (msg JobMessage, outboxID string, err error)
+	// RateBreakers lists the pairs' breakers in status (any when empty),
+	// open ones first.
+	RateBreakers(ctx context.Context, status string) ([]rateguard.Breaker, error)
+	// RateRejections returns the most recent rejected quotes, of the pair
+	// when source and target are set.
+	RateRejections(ctx context.Context, source, target string, limit int) ([]rateguard.Rejection, error)
+	// ResetRateBreaker locks the pair's breaker, lets check refuse it, and
+	// closes it, forgetting its reference rate when clearReference is set.
+	// The pair's queued jobs, left unsettled while it was open, get a new
+	// outbox row each. An unknown pair returns sql.ErrNoRows.
+	ResetRateBreaker(ctx context.Context, source, target string, clearReference bool, op admin.Action, check func(rateguard.Breaker) error) (rateguard.Breaker, []Requeued, error)
+	// MarkPublished records that the outbox row has been delivered.
+	MarkPublished(ctx context.Context, outboxID string) error
+	// Audit returns the audit entries matching f, most recent first.
+	Audit(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+}
+
+// Publisher delivers job messages to the conversion queue.
+type Publisher interface {
+	Publish(ctx context.Context, body []byte, attrs map[string]string) error
+}
+
+// Service handles the back-office API. A nil Publisher leaves requeued jobs
+// to the outbox.
+type Service struct {
+	Store     Store
+	Publisher Publisher
+	Operators admin.Operators
+}
+
+var (
+	errInsufficientFunds = errors.New("insufficient available funds")
+	errSameOperator      = errors.New("an adjustment must be decided by another operator")
+	errTenantRequired    = errors.New(tenant.Header + " is required")
+	errAllTenants        = errors.New("rate breakers are shared by every tenant; reset them without " + tenant.Header)
+)
+
+// conflictError refuses an action the target's state does not allow.
+type conflictError struct{ msg string }
+
+func (e conflictError) Error() string { return e.msg }
+
+// actionRequest is the body of every POST but adjustment requests.
+type actionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// resetRequest is the body of a rate breaker reset. ClearReference makes the
+// next quote the pair's reference, for a market that really did move.
+type resetRequest struct {
+	Reason         string `json:"reason"`
+	ClearReference bool   `json:"clear_reference"`
+}
+
+type adjustmentRequest struct {
+	UserID   string  `json:"user_id"`
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+	Reason   string  `json:"reason"`
+}
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := s.handle(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+// handle supports, for operators only, across every tenant unless the
+// X-Tenant-ID header names one. Requesting an adjustment and changing a
+// status create or look up rows by user, so they need the header:
+//
+//	GET  /admin/jobs?client_id=&status=&source_currency=&target_currency=&from=&to=&limit=
+//	POST /admin/jobs/{job_id}/requeue                     -> re-queue a failed job
+//	POST /admin/adjustments                               -> request a balance adjustment
+//	GET  /admin/adjustments?status=pending&limit=
+//	POST /admin/adjustments/{adjustment_id}/approve       -> by another operator; applies it
+//	POST /admin/adjustments/{adjustment_id}/reject
+//	POST /admin/accounts/{user_id}/{currency}/freeze|unfreeze|close
+//	POST /admin/users/{user_id}/freeze|unfreeze|close     -> every account of the user
+//	GET  /admin/audit?operator=&target_type=&target_id=&limit=
+//	GET  /admin/rate-breakers?status=open|closed
+//	GET  /admin/rate-rejections?pair=USD-EUR&limit=
+//	POST /admin/rate-breakers/{pair}/reset                -> every tenant; requeues the pair's jobs
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.WithCorrelationID(ctx, logging.FromRequest(evt))
+	parts := strings.Split(strings.Trim(evt.Path, "/"), "/")
+	if parts[0] != "admin" || len(parts) < 2 {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	operator, err := s.Operators.Authenticate(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	tenantID, err := tenant.Operator(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	}
+	ctx = logging.With(tenant.With(ctx, tenantID), "operator", operator)
+	q := evt.QueryStringParameters
+	post := evt.HTTPMethod == http.MethodPost
+	get := evt.HTTPMethod == http.MethodGet
+
+	switch resource := parts[1]; {
+	case resource == "jobs" && len(parts) == 2 && get:
+		return s.searchJobs(ctx, q)
+	case resource == "jobs" && len(parts) == 4 && parts[3] == "requeue" && post && uuid.Validate(parts[2]) == nil:
+		return s.requeue(ctx, operator, parts[2], evt.Body)
+	case resource == "adjustments" && len(parts) == 2 && post:
+		return s.requestAdjustment(ctx, operator, evt.Body)
+	case resource == "adjustments" && len(parts) == 2 && get:
+		status := q["status"]
+		if status != "" && status != adjustmentPending && status != adjustmentApproved && status != adjustmentRejected {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "status must be pending, approved or rejected")
+		}
+		limit, err := parseLimit(q["limit"])
+		if err != nil {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		}
+		adjs, err := s.Store.Adjustments(ctx, status, limit)
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(http.StatusOK, map[string]any{"adjustments": adjs})
+	case resource == "adjustments" && len(parts) == 4 && post && uuid.Validate(parts[2]) == nil &&
+		(parts[3] == "approve" || parts[3] == "reject"):
+		return s.decideAdjustment(ctx, operator, parts[2], parts[3] == "approve", evt.Body)
+	case resource == "accounts" && len(parts) == 5 && post && statusVerbs[parts[4]].status != "":
+		return s.setAccountStatus(ctx, operator, parts[2], parts[3], parts[4], evt.Body)
+	case resource == "users" && len(parts) == 4 && post && statusVerbs[parts[3]].status != "":
+		return s.setUserStatus(ctx, operator, parts[2], parts[3], evt.Body)
+	case resource == "audit" && len(parts) == 2 && get:
+		limit, err := parseLimit(q["limit"])
+		if err != nil {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		}
+		entries, err := s.Store.Audit(ctx, AuditFilter{Operator: q["operator"], TargetType: q["target_type"], TargetID: q["target_id"], Limit: limit})
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(http.StatusOK, map[string]any{"entries": entries})
+	case resource == "rate-breakers" && len(parts) == 2 && get:
+		status := q["status"]
+		if status != "" && status != rateguard.BreakerOpen && status != rateguard.BreakerClosed {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "status must be open or closed")
+		}
+		breakers, err := s.Store.RateBreakers(ctx, status)
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(http.StatusOK, map[string]any{"breakers": breakers})
+	case resource == "rate-breakers" && len(parts) == 4 && parts[3] == "reset" && post && ratePair.MatchString(parts[2]):
+		return s.resetBreaker(ctx, operator, parts[2], evt.Body)
+	case resource == "rate-rejections" && len(parts) == 2 && get:
+		var source, target string
+		if pair := q["pair"]; pair != "" {
+			m := ratePair.FindStringSubmatch(pair)
+			if m == nil {
+				return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "pair must be two 3-letter codes, e.g. USD-EUR")
+			}
+			source, target = m[1], m[2]
+		}
+		limit, err := parseLimit(q["limit"])
+		if err != nil {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		}
+		rejections, err := s.Store.RateRejections(ctx, source, target, limit)
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(http.StatusOK, map[string]any{"rejections": rejections})
+	}
+	return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+}
+
+func (s *Service) searchJobs(ctx context.Context, q map[string]string) (events.APIGatewayProxyResponse, error) {
+	f := JobFilter{ClientID: q["client_id"], Status: q["status"], SourceCurrency: q["source_currency"], TargetCurrency: q["target_currency"]}
+	if f.Status != "" && !jobStatuses[f.Status] {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "unknown status "+strconv.Quote(f.Status))
+	}
+	for _, c := range []string{f.SourceCurrency, f.TargetCurrency} {
+		if c != "" && !currencyCode.MatchString(c) {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, settlement.ErrCurrency.Error())
+		}
+	}
+	var err error
+	if f.From, err = parseTime(q["from"], false); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "from: "+err.Error())
+	}
+	if f.To, err = parseTime(q["to"], true); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "to: "+err.Error())
+	}
+	if f.Limit, err = parseLimit(q["limit"]); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	}
+	jobs, err := s.Store.Jobs(ctx, f)
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	return jsonResponse(http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+func (s *Service) requeue(ctx context.Context, operator, jobID, body string) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.With(ctx, "job_id", jobID)
+	reason, resp, ok := requireReason(ctx, body)
+	if !ok {
+		return resp, nil
+	}
+	op := admin.Action{Operator: operator, Action: "job.requeued", TargetType: "job", TargetID: jobID, Reason: reason}
+	msg, outboxID, err := s.Store.RequeueJob(ctx, jobID, op, func(j Job) error {
+		switch {
+		case j.Status != settlement.StatusFailed:
+			return conflictError{"only failed jobs can be requeued; job is " + j.Status}
+		case j.LimitRate != nil:
+			return conflictError{"limit orders cannot be requeued"}
+		case j.AllOrNothing:
+			return conflictError{"legs of all-or-nothing batches cannot be requeued"}
+		}
+		return nil
+	})
+	if err != nil {
+		return s.failure(ctx, err)
+	}
+	slog.InfoContext(ctx, "job requeued", "reason", reason)
+	s.publish(ctx, msg, outboxID)
+	return jsonResponse(http.StatusOK, msg)
+}
+
+// resetBreaker closes an open breaker and requeues the jobs it held back. A
+// breaker covers every tenant, so it is reset across all of them.
+func (s *Service) resetBreaker(ctx context.Context, operator, pair, body string) (events.APIGatewayProxyResponse, error) {
+	if tenant.FromContext(ctx) != tenant.All {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, errAllTenants.Error())
+	}
+	ctx = logging.With(ctx, "pair", pair)
+	reason, resp, ok := requireReason(ctx, body)
+	if !ok {
+		return resp, nil
+	}
+	var req resetRequest
+	_ = json.Unmarshal([]byte(body), &req)
+	m := ratePair.FindStringSubmatch(pair)
+	op := admin.Action{Operator: operator, Action: "rate_breaker.reset", TargetType: "rate_pair", TargetID: pair, Reason: reason}
+	b, requeued, err := s.Store.ResetRateBreaker(ctx, m[1], m[2], req.ClearReference, op, func(b rateguard.Breaker) error {
+		if b.Status != rateguard.BreakerOpen {
+			return conflictError{"breaker is " + b.Status}
+		}
+		return nil
+	})
+	if err != nil {
+		return s.failure(ctx, err)
+	}
+	slog.InfoContext(ctx, "rate breaker reset", "reason", reason, "requeued", len(requeued))
+	jobIDs := make([]string, len(requeued))
+	for i, r := range requeued {
+		s.publish(ctx, r.Message, r.OutboxID)
+		jobIDs[i] = r.Message.JobID
+	}
+	return jsonResponse(http.StatusOK, map[string]any{"breaker": b, "requeued": jobIDs})
+}
+
+// publish sends a requeued job to the queue (best effort); unpublished rows
+// stay in the outbox.
+func (s *Service) publish(ctx context.Context, msg JobMessage, outboxID string) {
+	if s.Publisher == nil {
+		return
+	}
+	publishCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := s.Publisher.Publish(publishCtx, msg.Payload(), map[string]string{logging.Attribute: msg.CorrelationID}); err != nil {
+		slog.WarnContext(ctx, "failed to publish SQS message", "error", err)
+	} else if err := s.Store.MarkPublished(publishCtx, outboxID); err != nil {
+		slog.WarnContext(ctx, "failed to mark outbox row processed", "outbox_id", outboxID, "error", err)
+	}
+}
+
+func (s *Service) requestAdjustment(ctx context.Context, operator, body string) (events.APIGatewayProxyResponse, error) {
+	if tenant.FromContext(ctx) == tenant.All {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, errTenantRequired.Error())
+	}
+	var req adjustmentRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+	}
+	switch {
+	case req.UserID == "":
+		return problem.RespondInvalid(ctx, "user_id", "user_id is required")
+	case !currencyCode.MatchString(req.Currency):
+		return problem.RespondInvalid(ctx, "currency", "currency must be a 3-letter code")
+	case req.Amount == 0:
+		return problem.RespondInvalid(ctx, "amount", "amount must be non-zero")
+	case strings.TrimSpace(req.Reason) == "":
+		return problem.RespondInvalid(ctx, "reason", "reason is required")
+	}
+	adj := Adjustment{UserID: req.UserID, Currency: req.Currency, Amount: req.Amount, Reason: req.Reason, Status: adjustmentPending, RequestedBy: operator}
+	op := admin.Action{Operator: operator, Action: "adjustment.requested", TargetType: "account", TargetID: req.UserID + "/" + req.Currency, Reason: req.Reason}
+	adj, err := s.Store.RequestAdjustment(ctx, adj, op)
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	slog.InfoContext(ctx, "adjustment requested", "adjustment_id", adj.AdjustmentID, "user_id", adj.UserID, "currency", adj.Currency, "amount", adj.Amount)
+	return jsonResponse(http.StatusCreated, adj)
+}
+
+func (s *Service) decideAdjustment(ctx context.Context, operator, adjustmentID string, approve bool, body string) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.With(ctx, "adjustment_id", adjustmentID)
+	var req actionRequest
+	if body != "" {
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+		}
+	}
+	if !approve && strings.TrimSpace(req.Reason) == "" {
+		return problem.RespondInvalid(ctx, "reason", "reason is required")
+	}
+	status, action := adjustmentApproved, "adjustment.approved"
+	if !approve {
+		status, action = adjustmentRejected, "adjustment.rejected"
+	}
+	op := admin.Action{Operator: operator, Action: action, TargetType: "adjustment", TargetID: adjustmentID, Reason: req.Reason}
+	adj, err := s.Store.DecideAdjustment(ctx, adjustmentID, op, func(a *Adjustment) error {
+		if a.Status != adjustmentPending {
+			return conflictError{"adjustment is " + a.Status}
+		}
+		if a.RequestedBy == operator {
+			return errSameOperator
+		}
+		a.Status, a.DecidedBy, a.DecisionReason = status, operator, req.Reason
+		return nil
+	})
+	if err != nil {
+		return s.failure(ctx, err)
+	}
+	slog.InfoContext(ctx, "adjustment "+status, "user_id", adj.UserID, "currency", adj.Currency, "amount", adj.Amount, "requested_by", adj.RequestedBy)
+	return jsonResponse(http.StatusOK, adj)
+}
+
+func (s *Service) setAccountStatus(ctx context.Context, operator, userID, currency, verb, body string) (events.APIGatewayProxyResponse, error) {
+	if tenant.FromContext(ctx) == tenant.All {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, errTenantRequired.Error())
+	}
+	if !currencyCode.MatchString(currency) {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	reason, resp, ok := requireReason(ctx, body)
+	if !ok {
+		return resp, nil
+	}
+	change := statusVerbs[verb]
+	op := admin.Action{Operator: operator, Action: "account." + change.done, TargetType: "account", TargetID: userID + "/" + currency, Reason: reason}
+	acct, err := s.Store.SetAccountStatus(ctx, userID, currency, change.status, op)
+	if err != nil {
+		return s.failure(ctx, err)
+	}
+	slog.InfoContext(ctx, op.Action, "user_id", userID, "currency", currency, "reason", reason)
+	return jsonResponse(http.StatusOK, acct)
+}
+
+func (s *Service) setUserStatus(ctx context.Context, operator, userID, verb, body string) (events.APIGatewayProxyResponse, error) {
+	if tenant.FromContext(ctx) == tenant.All {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, errTenantRequired.Error())
+	}
+	reason, resp, ok := requireReason(ctx, body)
+	if !ok {
+		return resp, nil
+	}
+	change := statusVerbs[verb]
+	op := admin.Action{Operator: operator, Action: "user." + change.done, TargetType: "user", TargetID: userID, Reason: reason}
+	user, err := s.Store.SetUserStatus(ctx, userID, change.status, op)
+	if err != nil {
+		return s.failure(ctx, err)
+	}
+	slog.InfoContext(ctx, op.Action, "user_id", userID, "reason", reason)
+	return jsonResponse(http.StatusOK, user)
+}
+
+// statusChange refuses a status change of a user or account (the target):
+// active and frozen switch back and forth, either can be closed, and closed
+// is final.
+func statusChange(target, from, to string) error {
+	switch {
+	case from == accountClosed:
+		return conflictError{target + " is closed"}
+	case from == to:
+		return conflictError{target + " is already " + to}
+	}
+	return nil
+}
+
+// failure maps a Store error to a response.
+func (s *Service) failure(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	var conflict conflictError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	case errors.As(err, &conflict):
+		return problem.Respond(ctx, http.StatusConflict, problem.Conflict, conflict.Error())
+	case errors.Is(err, errSameOperator):
+		return problem.Respond(ctx, http.StatusForbidden, problem.Forbidden, err.Error())
+	case errors.Is(err, errInsufficientFunds):
+		return problem.Respond(ctx, http.StatusUnprocessableEntity, problem.InsufficientFunds, err.Error())
+	}
+	return problem.ServerError(ctx, err)
+}
+
+// requireReason reads the reason every state-changing action must give; when
+// it is missing, resp is the 400 to answer with.
+func requireReason(ctx context.Context, body string) (reason string, resp events.APIGatewayProxyResponse, ok bool) {
+	var req actionRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		resp, _ = problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+		return "", resp, false
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		resp, _ = problem.RespondInvalid(ctx, "reason", "reason is required")
+		return "", resp, false
+	}
+	return req.Reason, resp, true
+}
+
+func parseLimit(s string) (int, error) {
+	if s == "" {
+		return defaultLimit, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxLimit {
+		return 0, fmt.Errorf("limit must be 1 to %d", maxLimit)
+	}
+	return n, nil
+}
+
+// parseTime reads an RFC 3339 time or a date. A date used as an end bound
+// includes that whole day.
+func parseTime(s string, end bool) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return nil, errors.New("want an RFC 3339 time or YYYY-MM-DD")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func jsonResponse(code int, v any) (events.APIGatewayProxyResponse, error) {
+	b, _ := json.Marshal(v)
+	return events.APIGatewayProxyResponse{StatusCode: code, Body: string(b), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+const (
+	makerToken   = "maker-token-0123456789"
+	checkerToken = "checker-token-0123456789"
+)
+
+var testOperators = admin.Operators{makerToken: "maker", checkerToken: "checker"}
+
+// fakeStore keeps everything in memory and records audited actions.
+type fakeStore struct {
+	jobs        map[string]Job
+	adjustments map[string]Adjustment
+	accounts    map[string]Account // by user_id/currency
+	users       map[string]string  // user_id -> status; absent is active
+	audit       []admin.Action
+	filter      JobFilter
+	published   []string
+	breakers    map[string]rateguard.Breaker // by SRC:TGT
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{jobs: map[string]Job{}, adjustments: map[string]Adjustment{}, users: map[string]string{}, breakers: map[string]rateguard.Breaker{},
+		accounts: map[string]Account{"u1/USD": {UserID: "u1", Currency: "USD", Balance: 100, Held: 40, Status: accountActive}}}
+}
+
+func (f *fakeStore) Jobs(_ context.Context, filter JobFilter) ([]Job, error) {
+	f.filter = filter
+	return []Job{}, nil
+}
+
+func (f *fakeStore) RequestAdjustment(_ context.Context, a Adjustment, op admin.Action) (Adjustment, error) {
+	a.AdjustmentID = uuid.NewString()
+	f.adjustments[a.AdjustmentID] = a
+	f.audit = append(f.audit, op)
+	return a, nil
+}
+
+func (f *fakeStore) Adjustments(context.Context, string, int) ([]Adjustment, error) {
+	return nil, nil
+}
+
+func (f *fakeStore) DecideAdjustment(_ context.Context, id string, op admin.Action, decide func(*Adjustment) error) (Adjustment, error) {
+	a, ok := f.adjustments[id]
+	if !ok {
+		return Adjustment{}, sql.ErrNoRows
+	}
+	if err := decide(&a); err != nil {
+		return Adjustment{}, err
+	}
+	if a.Status == adjustmentApproved {
+		acct := f.accounts[a.UserID+"/"+a.Currency]
+		if acct.Balance-acct.Held+a.Amount < 0 {
+			return Adjustment{}, errInsufficientFunds
+		}
+		acct.Balance += a.Amount
+		f.accounts[a.UserID+"/"+a.Currency] = acct
+	}
+	f.adjustments[id] = a
+	f.audit = append(f.audit, op)
+	return a, nil
+}
+
+func (f *fakeStore) SetAccountStatus(_ context.Context, userID, currency, status string, op admin.Action) (Account, error) {
+	acct, ok := f.accounts[userID+"/"+currency]
+	switch {
+	case !ok:
+		return Account{}, sql.ErrNoRows
+	}
+	if err := statusChange("account", acct.Status, status); err != nil {
+		return Account{}, err
+	}
+	acct.Status = status
+	f.accounts[userID+"/"+currency] = acct
+	f.audit = append(f.audit, op)
+	return acct, nil
+}
+
+func (f *fakeStore) SetUserStatus(_ context.Context, userID, status string, op admin.Action) (User, error) {
+	if _, ok := f.accounts[userID+"/USD"]; !ok {
+		return User{}, sql.ErrNoRows
+	}
+	current := cmp.Or(f.users[userID], accountActive)
+	if err := statusChange("user", current, status); err != nil {
+		return User{}, err
+	}
+	f.users[userID] = status
+	f.audit = append(f.audit, op)
+	return User{UserID: userID, Status: status}, nil
+}
+
+func (f *fakeStore) RequeueJob(_ context.Context, jobID string, op admin.Action, check func(Job) error) (JobMessage, string, error) {
+	j, ok := f.jobs[jobID]
+	if !ok {
+		return JobMessage{}, "", sql.ErrNoRows
+	}
+	if err := check(j); err != nil {
+		return JobMessage{}, "", err
+	}
+	j.Status, j.Error = "queued", ""
+	f.jobs[jobID] = j
+	f.audit = append(f.audit, op)
+	return JobMessage{JobID: jobID, Status: "queued", ClientID: j.ClientID}, "outbox-" + jobID, nil
+}
+
+func (f *fakeStore) RateBreakers(context.Context, string) ([]rateguard.Breaker, error) {
+	out := []rateguard.Breaker{}
+	for _, b := range f.breakers {
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+func (f *fakeStore) RateRejections(context.Context, string, string, int) ([]rateguard.Rejection, error) {
+	return []rateguard.Rejection{}, nil
+}
+
+// ResetRateBreaker requeues the pair's queued jobs.
+func (f *fakeStore) ResetRateBreaker(_ context.Context, source, target string, clearReference bool, op admin.Action, check func(rateguard.Breaker) error) (rateguard.Breaker, []Requeued, error) {
+	b, ok := f.breakers[source+":"+target]
+	if !ok {
+		return rateguard.Breaker{}, nil, sql.ErrNoRows
+	}
+	if err := check(b); err != nil {
+		return rateguard.Breaker{}, nil, err
+	}
+	b.Status, b.ResetBy = rateguard.BreakerClosed, op.Operator
+	if clearReference {
+		b.ReferenceRate = nil
+	}
+	f.breakers[source+":"+target] = b
+	var requeued []Requeued
+	for _, j := range f.jobs {
+		if j.SourceCurrency == source && j.TargetCurrency == target && j.Status == "queued" {
+			requeued = append(requeued, Requeued{Message: JobMessage{JobID: j.JobID, Status: "queued"}, OutboxID: "outbox-" + j.JobID})
+		}
+	}
+	f.audit = append(f.audit, op)
+	return b, requeued, nil
+}
+
+func (f *fakeStore) MarkPublished(_ context.Context, outboxID string) error {
+	f.published = append(f.published, outboxID)
+	return nil
+}
+
+func (f *fakeStore) Audit(context.Context, AuditFilter) ([]AuditEntry, error) {
+	return nil, nil
+}
+
+// recordingPublisher collects published message bodies.
+type recordingPublisher struct{ bodies []string }
+
+func (p *recordingPublisher) Publish(_ context.Context, body []byte, _ map[string]string) error {
+	p.bodies = append(p.bodies, string(body))
+	return nil
+}
+
+// adminRequest builds an operator request narrowed to tenant.Default.
+func adminRequest(method, path, token string, body any) events.APIGatewayProxyRequest {
+	req := testpg.APIRequest(method, path, body)
+	req.Headers[tenant.Header] = tenant.Default
+	if token != "" {
+		req.Headers["authorization"] = "Bearer " + token
+	}
+	return req
+}
+
+func call(t *testing.T, svc *Service, req events.APIGatewayProxyRequest, out any) int {
+	t.Helper()
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		if err := json.Unmarshal([]byte(resp.Body), out); err != nil {
+			t.Fatalf("decode %s: %v", resp.Body, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func actions(store *fakeStore) []string {
+	var out []string
+	for _, a := range store.audit {
+		out = append(out, a.Operator+" "+a.Action)
+	}
+	return out
+}
+
+func TestServiceRequiresOperator(t *testing.T) {
+	svc := &Service{Store: newFakeStore(), Operators: testOperators}
+	for _, token := range []string{"", "not-a-known-token-at-all"} {
+		if status := call(t, svc, adminRequest(http.MethodGet, "/admin/jobs", token, nil), nil); status != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d, want 401", token, status)
+		}
+	}
+	if status := call(t, svc, adminRequest(http.MethodGet, "/admin/nothing", makerToken, nil), nil); status != http.StatusNotFound {
+		t.Errorf("unknown route: status %d, want 404", status)
+	}
+}
+
+func TestAdjustmentNeedsSecondOperator(t *testing.T) {
+	store := newFakeStore()
+	svc := &Service{Store: store, Operators: testOperators}
+
+	body := adjustmentRequest{UserID: "u1", Currency: "USD", Amount: -50, Reason: "duplicate deposit"}
+	var adj Adjustment
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments", makerToken, body), &adj); status != http.StatusCreated || adj.Status != adjustmentPending {
+		t.Fatalf("request: status %d, %+v", status, adj)
+	}
+	if store.accounts["u1/USD"].Balance != 100 {
+		t.Fatal("a pending adjustment moved the balance")
+	}
+
+	path := "/admin/adjustments/" + adj.AdjustmentID + "/approve"
+	if status := call(t, svc, adminRequest(http.MethodPost, path, makerToken, nil), nil); status != http.StatusForbidden {
+		t.Errorf("self-approval: status %d, want 403", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, path, checkerToken, nil), &adj); status != http.StatusOK || adj.DecidedBy != "checker" {
+		t.Fatalf("approval: status %d, %+v", status, adj)
+	}
+	if got := store.accounts["u1/USD"].Balance; got != 50 {
+		t.Errorf("balance = %v, want 50", got)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, path, checkerToken, nil), nil); status != http.StatusConflict {
+		t.Errorf("second approval: status %d, want 409", status)
+	}
+	if got := actions(store); len(got) != 2 || got[0] != "maker adjustment.requested" || got[1] != "checker adjustment.approved" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestAdjustmentRules(t *testing.T) {
+	store := newFakeStore()
+	svc := &Service{Store: store, Operators: testOperators}
+	for field, body := range map[string]adjustmentRequest{
+		"reason":   {UserID: "u1", Currency: "USD", Amount: 5},
+		"amount":   {UserID: "u1", Currency: "USD", Reason: "x"},
+		"currency": {UserID: "u1", Currency: "usd", Amount: 5, Reason: "x"},
+		"user_id":  {Currency: "USD", Amount: 5, Reason: "x"},
+	} {
+		var p problem.Problem
+		if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments", makerToken, body), &p); status != http.StatusBadRequest || len(p.Errors) != 1 || p.Errors[0].Field != field {
+			t.Errorf("invalid %s: status %d, errors %+v", field, status, p.Errors)
+		}
+	}
+
+	// Held funds cannot be adjusted away
+	var adj Adjustment
+	call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments", makerToken, adjustmentRequest{UserID: "u1", Currency: "USD", Amount: -70, Reason: "chargeback"}), &adj)
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+adj.AdjustmentID+"/approve", checkerToken, nil), nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("debit of held funds: status %d, want 422", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+adj.AdjustmentID+"/reject", checkerToken, nil), nil); status != http.StatusBadRequest {
+		t.Errorf("rejection without reason: status %d, want 400", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+adj.AdjustmentID+"/reject", checkerToken, actionRequest{Reason: "not ours"}), nil); status != http.StatusOK {
+		t.Errorf("rejection: status %d, want 200", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/adjustments/"+uuid.NewString()+"/approve", checkerToken, nil), nil); status != http.StatusNotFound {
+		t.Errorf("unknown adjustment: status %d, want 404", status)
+	}
+}
+
+func TestFreezeAndUnfreeze(t *testing.T) {
+	store := newFakeStore()
+	svc := &Service{Store: store, Operators: testOperators}
+	reason := actionRequest{Reason: "fraud review"}
+
+	var acct Account
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/u1/USD/freeze", makerToken, reason), &acct); status != http.StatusOK || acct.Status != accountFrozen {
+		t.Fatalf("freeze: status %d, %+v", status, acct)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/u1/USD/freeze", makerToken, reason), nil); status != http.StatusConflict {
+		t.Errorf("second freeze: status %d, want 409", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/u1/USD/unfreeze", makerToken, actionRequest{}), nil); status != http.StatusBadRequest {
+		t.Errorf("unfreeze without reason: status %d, want 400", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/u1/USD/unfreeze", makerToken, reason), &acct); status != http.StatusOK || acct.Status != accountActive {
+		t.Errorf("unfreeze: status %d, %+v", status, acct)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/nobody/USD/freeze", makerToken, reason), nil); status != http.StatusNotFound {
+		t.Errorf("unknown account: status %d, want 404", status)
+	}
+	if got := actions(store); len(got) != 2 || got[0] != "maker account.frozen" || got[1] != "maker account.unfrozen" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestCloseIsFinal(t *testing.T) {
+	store := newFakeStore()
+	svc := &Service{Store: store, Operators: testOperators}
+	reason := actionRequest{Reason: "sanctions"}
+
+	var user User
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/users/u1/freeze", makerToken, reason), &user); status != http.StatusOK || user.Status != accountFrozen {
+		t.Fatalf("freeze user: status %d, %+v", status, user)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/users/u1/close", makerToken, reason), &user); status != http.StatusOK || user.Status != accountClosed {
+		t.Fatalf("close user: status %d, %+v", status, user)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/users/u1/unfreeze", makerToken, reason), nil); status != http.StatusConflict {
+		t.Errorf("unfreeze closed user: status %d, want 409", status)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/users/nobody/freeze", makerToken, reason), nil); status != http.StatusNotFound {
+		t.Errorf("unknown user: status %d, want 404", status)
+	}
+
+	var acct Account
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/u1/USD/close", makerToken, reason), &acct); status != http.StatusOK || acct.Status != accountClosed {
+		t.Fatalf("close account: status %d, %+v", status, acct)
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/accounts/u1/USD/unfreeze", makerToken, reason), nil); status != http.StatusConflict {
+		t.Errorf("unfreeze closed account: status %d, want 409", status)
+	}
+	if got := actions(store); len(got) != 3 || got[0] != "maker user.frozen" || got[1] != "maker user.closed" || got[2] != "maker account.closed" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestTenantHeader(t *testing.T) {
+	svc := &Service{Store: newFakeStore(), Operators: testOperators}
+	req := adminRequest(http.MethodGet, "/admin/jobs", makerToken, nil)
+	delete(req.Headers, tenant.Header)
+	if status := call(t, svc, req, nil); status != http.StatusOK {
+		t.Errorf("search without tenant: status %d, want 200", status)
+	}
+	req = adminRequest(http.MethodPost, "/admin/accounts/u1/USD/freeze", makerToken, actionRequest{Reason: "fraud review"})
+	delete(req.Headers, tenant.Header)
+	if status := call(t, svc, req, nil); status != http.StatusBadRequest {
+		t.Errorf("freeze without tenant: status %d, want 400", status)
+	}
+	req = adminRequest(http.MethodGet, "/admin/jobs", makerToken, nil)
+	req.Headers[tenant.Header] = "Not A Tenant"
+	if status := call(t, svc, req, nil); status != http.StatusBadRequest {
+		t.Errorf("malformed tenant: status %d, want 400", status)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	store := newFakeStore()
+	pub := &recordingPublisher{}
+	svc := &Service{Store: store, Publisher: pub, Operators: testOperators}
+	rate := 0.9
+	jobs := map[string]Job{
+		"failed":    {Status: "failed", Error: "INSUFFICIENT_FUNDS"},
+		"completed": {Status: "completed"},
+		"limit":     {Status: "failed", LimitRate: &rate},
+		"all":       {Status: "failed", BatchID: uuid.NewString(), AllOrNothing: true},
+	}
+	ids := map[string]string{}
+	for name, j := range jobs {
+		j.JobID, j.ClientID = uuid.NewString(), "u1"
+		store.jobs[j.JobID] = j
+		ids[name] = j.JobID
+	}
+	reason := actionRequest{Reason: "funded since"}
+
+	var msg JobMessage
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/jobs/"+ids["failed"]+"/requeue", makerToken, reason), &msg); status != http.StatusOK || msg.Status != "queued" {
+		t.Fatalf("requeue: status %d, %+v", status, msg)
+	}
+	if len(pub.bodies) != 1 || len(store.published) != 1 {
+		t.Errorf("published %v, marked %v; want the requeued job", pub.bodies, store.published)
+	}
+	for _, name := range []string{"failed", "completed", "limit", "all"} {
+		if status := call(t, svc, adminRequest(http.MethodPost, "/admin/jobs/"+ids[name]+"/requeue", makerToken, reason), nil); status != http.StatusConflict {
+			t.Errorf("%s: status %d, want 409", name, status)
+		}
+	}
+	if status := call(t, svc, adminRequest(http.MethodPost, "/admin/jobs/"+uuid.NewString()+"/requeue", makerToken, reason), nil); status != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", status)
+	}
+	if got := actions(store); len(got) != 1 || got[0] != "maker job.requeued" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestSearchJobsFilters(t *testing.T) {
+	store := newFakeStore()
+	svc := &Service{Store: store, Operators: testOperators}
+
+	path := "/admin/jobs?client_id=c1&status=failed&source_currency=USD&target_currency=EUR&from=2025-03-01T12:00:00Z&to=2025-03-31&limit=10"
+	if status := call(t, svc, adminRequest(http.MethodGet, path, makerToken, nil), nil); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	f := store.filter
+	wantFrom := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	wantTo := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC) // the whole of the 31st
+	if f.ClientID != "c1" || f.Status != "failed" || f.SourceCurrency != "USD" || f.TargetCurrency != "EUR" || f.Limit != 10 ||
+		!f.From.Equal(wantFrom) || !f.To.Equal(wantTo) {
+		t.Errorf("filter = %+v", f)
+	}
+
+	for _, q := range []string{"status=done", "from=yesterday", "source_currency=usd", "limit=1000"} {
+		if status := call(t, svc, adminRequest(http.MethodGet, "/admin/jobs?"+q, makerToken, nil), nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", q, status)
+		}
+	}
+}
+
+func TestResetRateBreaker(t *testing.T) {
+	store := newFakeStore()
+	pub := &recordingPublisher{}
+	svc := &Service{Store: store, Publisher: pub, Operators: testOperators}
+	reference := 0.9
+	store.breakers["USD:EUR"] = rateguard.Breaker{Source: "USD", Target: "EUR", Status: rateguard.BreakerOpen, ReferenceRate: &reference}
+	store.breakers["GBP:USD"] = rateguard.Breaker{Source: "GBP", Target: "USD", Status: rateguard.BreakerClosed}
+	queued := Job{JobID: uuid.NewString(), SourceCurrency: "USD", TargetCurrency: "EUR", Status: "queued"}
+	store.jobs[queued.JobID] = queued
+	store.jobs["other"] = Job{JobID: "other", SourceCurrency: "USD", TargetCurrency: "GBP", Status: "queued"}
+	reset := func(pair string, tenantID string, body any) (int, map[string]json.RawMessage) {
+		req := adminRequest(http.MethodPost, "/admin/rate-breakers/"+pair+"/reset", makerToken, body)
+		if tenantID == "" {
+			delete(req.Headers, tenant.Header)
+		}
+		var out map[string]json.RawMessage
+		return call(t, svc, req, &out), out
+	}
+
+	if status, _ := reset("USD-EUR", tenant.Default, resetRequest{Reason: "bad tick"}); status != http.StatusBadRequest {
+		t.Errorf("reset for one tenant: status %d, want 400", status)
+	}
+	if status, _ := reset("USD-EUR", "", resetRequest{}); status != http.StatusBadRequest {
+		t.Errorf("reset without reason: status %d, want 400", status)
+	}
+	status, out := reset("USD-EUR", "", resetRequest{Reason: "provider fixed the feed", ClearReference: true})
+	var b rateguard.Breaker
+	var requeued []string
+	_ = json.Unmarshal(out["breaker"], &b)
+	_ = json.Unmarshal(out["requeued"], &requeued)
+	if status != http.StatusOK || b.Status != rateguard.BreakerClosed || b.ResetBy != "maker" || b.ReferenceRate != nil {
+		t.Fatalf("reset: status %d, breaker %+v", status, b)
+	}
+	if len(requeued) != 1 || requeued[0] != queued.JobID || len(pub.bodies) != 1 || len(store.published) != 1 {
+		t.Errorf("requeued %v, published %v; want the pair's queued job", requeued, pub.bodies)
+	}
+	if status, _ := reset("USD-EUR", "", resetRequest{Reason: "again"}); status != http.StatusConflict {
+		t.Errorf("reset of a closed breaker: status %d, want 409", status)
+	}
+	if status, _ := reset("CHF-JPY", "", resetRequest{Reason: "unknown"}); status != http.StatusNotFound {
+		t.Errorf("unknown pair: status %d, want 404", status)
+	}
+	if status, _ := reset("usd-eur", "", resetRequest{Reason: "lower case"}); status != http.StatusNotFound {
+		t.Errorf("malformed pair: status %d, want 404", status)
+	}
+	if got := actions(store); len(got) != 1 || got[0] != "maker rate_breaker.reset" {
+		t.Errorf("audit = %v", got)
+	}
+
+	var list struct{ Breakers []rateguard.Breaker }
+	if status := call(t, svc, adminRequest(http.MethodGet, "/admin/rate-breakers", makerToken, nil), &list); status != http.StatusOK || len(list.Breakers) != 2 {
+		t.Errorf("list: status %d, %+v", status, list)
+	}
+	for _, q := range []string{"rate-breakers?status=tripped", "rate-rejections?pair=USDEUR", "rate-rejections?limit=0"} {
+		if status := call(t, svc, adminRequest(http.MethodGet, "/admin/"+q, makerToken, nil), nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", q, status)
+		}
+	}
+}
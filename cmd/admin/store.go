@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/pgtx"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+type scanner interface{ Scan(dest ...any) error }
+
+const jobColumns = `j.job_id, j.tenant_id, j.client_id, j.source_currency, j.target_currency, j.source_amount, j.target_amount, j.rate, j.fee, j.held_amount, j.status,
+	COALESCE(j.metadata->>'error', ''), COALESCE(j.batch_id::text, ''), COALESCE(b.all_or_nothing, false), COALESCE(j.schedule_id::text, ''),
+	COALESCE(j.reversal_of::text, ''), j.limit_rate, COALESCE(j.metadata->>'correlation_id', ''), j.created_at, j.updated_at, j.completed_at`
+
+const jobTables = `conversion_jobs j LEFT JOIN conversion_batches b ON b.batch_id = j.batch_id`
+
+func scanJob(row scanner) (Job, error) {
+	var j Job
+	err := row.Scan(&j.JobID, &j.TenantID, &j.ClientID, &j.SourceCurrency, &j.TargetCurrency, &j.SourceAmount, &j.TargetAmount, &j.Rate, &j.Fee, &j.HeldAmount, &j.Status,
+		&j.Error, &j.BatchID, &j.AllOrNothing, &j.ScheduleID, &j.ReversalOf, &j.LimitRate, &j.CorrelationID, &j.CreatedAt, &j.UpdatedAt, &j.CompletedAt)
+	return j, err
+}
+
+// where builds a WHERE clause from conditions with one %d placeholder each;
+// add only keeps a condition when its filter is set.
+type where struct {
+	conds []string
+	args  []any
+}
+
+func (w *where) add(cond string, v any, set bool) {
+	if set {
+		w.args = append(w.args, v)
+		w.conds = append(w.conds, fmt.Sprintf(cond, len(w.args)))
+	}
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+func (s pgStore) Jobs(ctx context.Context, f JobFilter) ([]Job, error) {
+	var w where
+	w.add("j.client_id = $%d", f.ClientID, f.ClientID != "")
+	w.add("j.status = $%d", f.Status, f.Status != "")
+	w.add("j.source_currency = $%d", f.SourceCurrency, f.SourceCurrency != "")
+	w.add("j.target_currency = $%d", f.TargetCurrency, f.TargetCurrency != "")
+	w.add("j.created_at >= $%d", f.From, f.From != nil)
+	w.add("j.created_at < $%d", f.To, f.To != nil)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM `+jobTables+w.String()+
+		fmt.Sprintf(` ORDER BY j.created_at DESC LIMIT %d`, f.Limit), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s pgStore) RequeueJob(ctx context.Context, jobID string, op admin.Action, check func(Job) error) (JobMessage, string, error) {
+	var msg JobMessage
+	var outboxID string
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		j, err := scanJob(tx.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM `+jobTables+` WHERE j.job_id=$1 FOR UPDATE OF j`, jobID))
+		if err != nil {
+			return err
+		}
+		if err := check(j); err != nil {
+			return err
+		}
+		// The outbox row must belong to the job's tenant
+		if err := tenant.Scope(ctx, tx, j.TenantID); err != nil {
+			return err
+		}
+		// Its hold was released when it failed; it settles against the available balance
+		if _, err := tx.ExecContext(ctx, `UPDATE conversion_jobs SET status='queued', held_amount=0, metadata = metadata - 'error' WHERE job_id=$1`, jobID); err != nil {
+			return fmt.Errorf("requeue job: %w", err)
+		}
+		msg = JobMessage{JobID: j.JobID, Status: "queued", ClientID: j.ClientID, SourceCurrency: j.SourceCurrency, TargetCurrency: j.TargetCurrency,
+			SourceAmount: j.SourceAmount, CorrelationID: logging.CorrelationID(ctx), CreatedAt: j.CreatedAt, BatchID: j.BatchID}
+		if err := tx.QueryRowContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ($1,$2,$3,$4) RETURNING outbox_id`,
+			"conversion_job", jobID, "conversion-jobs", msg.Payload()).Scan(&outboxID); err != nil {
+			return fmt.Errorf("insert outbox: %w", err)
+		}
+		op.Details = map[string]any{"previous_error": j.Error, "outbox_id": outboxID}
+		return admin.Record(ctx, tx, op)
+	})
+	return msg, outboxID, err
+}
+
+const breakerTables = `rate_breakers b LEFT JOIN rate_rejections r ON r.rejection_id = b.rejection_id`
+
+func (s pgStore) RateBreakers(ctx context.Context, status string) ([]rateguard.Breaker, error) {
+	var w where
+	w.add("b.status = $%d", status, status != "")
+	rows, err := s.db.QueryContext(ctx, `SELECT `+rateguard.BreakerColumns+` FROM `+breakerTables+w.String()+
+		` ORDER BY b.status = 'open' DESC, b.source_currency, b.target_currency`, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	breakers := []rateguard.Breaker{}
+	for rows.Next() {
+		b, err := rateguard.ScanBreaker(rows)
+		if err != nil {
+			return nil, err
+		}
+		breakers = append(breakers, b)
+	}
+	return breakers, rows.Err()
+}
+
+func (s pgStore) RateRejections(ctx context.Context, source, target string, limit int) ([]rateguard.Rejection, error) {
+	var w where
+	w.add("source_currency = $%d", source, source != "")
+	w.add("target_currency = $%d", target, target != "")
+	rows, err := s.db.QueryContext(ctx, `SELECT `+rateguard.RejectionColumns+` FROM rate_rejections`+w.String()+
+		fmt.Sprintf(` ORDER BY rejected_at DESC, rejection_id DESC LIMIT %d`, limit), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rejections := []rateguard.Rejection{}
+	for rows.Next() {
+		r, err := rateguard.ScanRejection(rows)
+		if err != nil {
+			return nil, err
+		}
+		rejections = append(rejections, r)
+	}
+	return rejections, rows.Err()
+}
+
+// ResetRateBreaker requeues every queued job of the pair but limit orders,
+// which the limits worker picks up again by itself.
+func (s pgStore) ResetRateBreaker(ctx context.Context, source, target string, clearReference bool, op admin.Action, check func(rateguard.Breaker) error) (rateguard.Breaker, []Requeued, error) {
+	var b rateguard.Breaker
+	var requeued []Requeued
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		requeued = nil
+		var err error
+		b, err = rateguard.ScanBreaker(tx.QueryRowContext(ctx, `SELECT `+rateguard.BreakerColumns+` FROM `+breakerTables+`
+			WHERE b.source_currency=$1 AND b.target_currency=$2 FOR UPDATE OF b`, source, target))
+		if err != nil {
+			return err
+		}
+		if err := check(b); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE rate_breakers SET status='closed', reset_by=$3, reset_at=now(), updated_at=now(),
+				reference_rate = CASE WHEN $4 THEN NULL ELSE reference_rate END, reference_at = CASE WHEN $4 THEN NULL ELSE reference_at END
+			WHERE source_currency=$1 AND target_currency=$2`, source, target, op.Operator, clearReference); err != nil {
+			return fmt.Errorf("reset breaker: %w", err)
+		}
+		if b, err = rateguard.ScanBreaker(tx.QueryRowContext(ctx, `SELECT `+rateguard.BreakerColumns+` FROM `+breakerTables+`
+			WHERE b.source_currency=$1 AND b.target_currency=$2`, source, target)); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT `+jobColumns+` FROM `+jobTables+`
+			WHERE j.source_currency=$1 AND j.target_currency=$2 AND j.status='queued' AND j.limit_rate IS NULL ORDER BY j.created_at`, source, target)
+		if err != nil {
+			return err
+		}
+		var jobs []Job
+		for rows.Next() {
+			j, err := scanJob(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			jobs = append(jobs, j)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if err := tenant.Scope(ctx, tx, j.TenantID); err != nil {
+				return err
+			}
+			r := Requeued{Message: JobMessage{JobID: j.JobID, Status: j.Status, ClientID: j.ClientID, SourceCurrency: j.SourceCurrency, TargetCurrency: j.TargetCurrency,
+				SourceAmount: j.SourceAmount, CorrelationID: logging.CorrelationID(ctx), CreatedAt: j.CreatedAt, BatchID: j.BatchID, AllOrNothing: j.AllOrNothing}}
+			if err := tx.QueryRowContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ($1,$2,$3,$4) RETURNING outbox_id`,
+				"conversion_job", j.JobID, "conversion-jobs", r.Message.Payload()).Scan(&r.OutboxID); err != nil {
+				return fmt.Errorf("insert outbox: %w", err)
+			}
+			requeued = append(requeued, r)
+		}
+		// The reset is every tenant's, not that of the last job requeued
+		if err := tenant.Scope(ctx, tx, tenant.FromContext(ctx)); err != nil {
+			return err
+		}
+		op.Details = map[string]any{"clear_reference": clearReference, "requeued": len(requeued)}
+		if b.Rejection != nil {
+			op.Details["rejection_id"] = b.Rejection.ID
+		}
+		return admin.Record(ctx, tx, op)
+	})
+	return b, requeued, err
+}
+
+func (s pgStore) MarkPublished(ctx context.Context, outboxID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outbox SET processed_at=now() WHERE outbox_id=$1`, outboxID)
+	return err
+}
+
+const adjustmentColumns = `adjustment_id, tenant_id, user_id, currency, amount, reason, status, requested_by, requested_at,
+	COALESCE(decided_by, ''), COALESCE(decision_reason, ''), decided_at`
+
+func scanAdjustment(row scanner) (Adjustment, error) {
+	var a Adjustment
+	err := row.Scan(&a.AdjustmentID, &a.TenantID, &a.UserID, &a.Currency, &a.Amount, &a.Reason, &a.Status, &a.RequestedBy, &a.RequestedAt,
+		&a.DecidedBy, &a.DecisionReason, &a.DecidedAt)
+	return a, err
+}
+
+func (s pgStore) RequestAdjustment(ctx context.Context, a Adjustment, op admin.Action) (Adjustment, error) {
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		a, err = scanAdjustment(tx.QueryRowContext(ctx, `INSERT INTO balance_adjustments (user_id, currency, amount, reason, status, requested_by)
+			VALUES ($1,$2,$3,$4,$5,$6) RETURNING `+adjustmentColumns, a.UserID, a.Currency, a.Amount, a.Reason, a.Status, a.RequestedBy))
+		if err != nil {
+			return err
+		}
+		op.Details = map[string]any{"adjustment_id": a.AdjustmentID, "amount": a.Amount}
+		return admin.Record(ctx, tx, op)
+	})
+	return a, err
+}
+
+func (s pgStore) Adjustments(ctx context.Context, status string, limit int) ([]Adjustment, error) {
+	var w where
+	w.add("status = $%d", status, status != "")
+	rows, err := s.db.QueryContext(ctx, `SELECT `+adjustmentColumns+` FROM balance_adjustments`+w.String()+
+		fmt.Sprintf(` ORDER BY requested_at DESC LIMIT %d`, limit), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	adjs := []Adjustment{}
+	for rows.Next() {
+		a, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		adjs = append(adjs, a)
+	}
+	return adjs, rows.Err()
+}
+
+func (s pgStore) DecideAdjustment(ctx context.Context, adjustmentID string, op admin.Action, decide func(*Adjustment) error) (Adjustment, error) {
+	var a Adjustment
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		a, err = scanAdjustment(tx.QueryRowContext(ctx, `SELECT `+adjustmentColumns+` FROM balance_adjustments WHERE adjustment_id=$1 FOR UPDATE`, adjustmentID))
+		if err != nil {
+			return err
+		}
+		if err := decide(&a); err != nil {
+			return err
+		}
+		if err := tenant.Scope(ctx, tx, a.TenantID); err != nil {
+			return err
+		}
+		a, err = scanAdjustment(tx.QueryRowContext(ctx, `UPDATE balance_adjustments SET status=$2, decided_by=$3, decision_reason=NULLIF($4,''), decided_at=now()
+			WHERE adjustment_id=$1 RETURNING `+adjustmentColumns, adjustmentID, a.Status, a.DecidedBy, a.DecisionReason))
+		if err != nil {
+			return err
+		}
+		op.Details = map[string]any{"user_id": a.UserID, "currency": a.Currency, "amount": a.Amount, "requested_by": a.RequestedBy}
+		if a.Status == adjustmentApproved {
+			if err := applyAdjustment(ctx, tx, a); err != nil {
+				return err
+			}
+		}
+		return admin.Record(ctx, tx, op)
+	})
+	return a, err
+}
+
+// applyAdjustment moves the account balance by the adjustment and posts its
+// ledger entry. A debit may not eat into held funds.
+func applyAdjustment(ctx context.Context, tx *sql.Tx, a Adjustment) error {
+	if _, err := tx.ExecContext(ctx, `INSERT INTO accounts (user_id, currency, balance) VALUES ($1,$2,0) ON CONFLICT (tenant_id, user_id, currency) DO NOTHING`, a.UserID, a.Currency); err != nil {
+		return fmt.Errorf("ensure account: %w", err)
+	}
+	var accountID string
+	err := tx.QueryRowContext(ctx, `UPDATE accounts SET balance = balance + $3 WHERE user_id=$1 AND currency=$2 AND balance - held + $3 >= 0 RETURNING account_id`,
+		a.UserID, a.Currency, a.Amount).Scan(&accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInsufficientFunds
+	}
+	if err != nil {
+		return fmt.Errorf("adjust balance: %w", err)
+	}
+	entryType := "credit"
+	if a.Amount < 0 {
+		entryType = "debit"
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO ledger_entries (account_id, entry_type, amount, currency, adjustment_id) VALUES ($1,$2,$3,$4,$5)`,
+		accountID, entryType, math.Abs(a.Amount), a.Currency, a.AdjustmentID); err != nil {
+		return fmt.Errorf("post ledger: %w", err)
+	}
+	return nil
+}
+
+func (s pgStore) SetAccountStatus(ctx context.Context, userID, currency, status string, op admin.Action) (Account, error) {
+	var acct Account
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `SELECT user_id, currency, balance, held, status FROM accounts WHERE user_id=$1 AND currency=$2 FOR UPDATE`, userID, currency).
+			Scan(&acct.UserID, &acct.Currency, &acct.Balance, &acct.Held, &acct.Status)
+		if err != nil {
+			return err
+		}
+		if err := statusChange("account", acct.Status, status); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE accounts SET status=$3 WHERE user_id=$1 AND currency=$2`, userID, currency, status); err != nil {
+			return err
+		}
+		op.Details = map[string]any{"previous_status": acct.Status}
+		acct.Status = status
+		return admin.Record(ctx, tx, op)
+	})
+	return acct, err
+}
+
+func (s pgStore) SetUserStatus(ctx context.Context, userID, status string, op admin.Action) (User, error) {
+	user := User{UserID: userID}
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		// Users without a row are active; give them one to lock
+		var known bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM accounts WHERE user_id=$1) OR EXISTS (SELECT 1 FROM users WHERE user_id=$1)`, userID).Scan(&known)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return sql.ErrNoRows
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO users (user_id) VALUES ($1) ON CONFLICT (tenant_id, user_id) DO NOTHING`, userID); err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT status FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&user.Status); err != nil {
+			return err
+		}
+		if err := statusChange("user", user.Status, status); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET status=$2 WHERE user_id=$1`, userID, status); err != nil {
+			return err
+		}
+		op.Details = map[string]any{"previous_status": user.Status}
+		user.Status = status
+		return admin.Record(ctx, tx, op)
+	})
+	return user, err
+}
+
+func (s pgStore) Audit(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	var w where
+	w.add("operator = $%d", f.Operator, f.Operator != "")
+	w.add("target_type = $%d", f.TargetType, f.TargetType != "")
+	w.add("target_id = $%d", f.TargetID, f.TargetID != "")
+	rows, err := s.db.QueryContext(ctx, `SELECT audit_id, operator, action, target_type, target_id, COALESCE(reason, ''), details, COALESCE(correlation_id, ''), created_at
+		FROM admin_audit_log`+w.String()+fmt.Sprintf(` ORDER BY created_at DESC LIMIT %d`, f.Limit), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.AuditID, &e.Operator, &e.Action.Action, &e.TargetType, &e.TargetID, &e.Reason, &details, &e.CorrelationID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, fmt.Errorf("audit %s details: %w", e.AuditID, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -2,42 +2,33 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"log/slog"
 	"os"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/irajwani/microservice-go/internal/dbconn"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
 )
 
-type Balance struct {
-	Currency string  `json:"currency"`
-	Balance  float64 `json:"balance"`
-}
-
-type BalanceResponse struct {
-	UserID   string    `json:"user_id"`
-	Accounts []Balance `json:"accounts"`
-}
-
-var db *sql.DB
-var dbErr error
-
-func initDB(ctx context.Context) (*sql.DB, error) {
-	if db != nil || dbErr != nil {
-		return db, dbErr
+// newRateClient returns a client for the rate Lambda named by
+// RATE_LAMBDA_NAME, or nil when it is not set and valuations at current rates
+// are unavailable.
+func newRateClient(ctx context.Context) (RateClient, error) {
+	rateLambda := os.Getenv("RATE_LAMBDA_NAME")
+	if rateLambda == "" {
+		return nil, nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(getenv("AWS_REGION", "eu-central-1")))
+	if err != nil {
+		return nil, fmt.Errorf("aws cfg: %w", err)
 	}
-	host := getenv("DB_HOST", "postgres")
-	port := getenv("DB_PORT", "5432")
-	user := getenv("DB_USER", "postgres")
-	pass := getenv("DB_PASSWORD", "postgrespw")
-	name := getenv("DB_NAME", "jobsdb")
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, pass, host, port, name)
-	db, dbErr = sql.Open("pgx", dsn)
-	return db, dbErr
+	return rates.Lambda{Client: awslambda.NewFromConfig(cfg), Function: rateLambda}, nil
 }
 
 func getenv(k, def string) string {
@@ -47,44 +38,27 @@ func getenv(k, def string) string {
 	return def
 }
 
-func handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	if evt.HTTPMethod != http.MethodGet || evt.Path != "/balances" {
-		return events.APIGatewayProxyResponse{StatusCode: 404, Body: `{"error":"not found"}`}, nil
+func main() {
+	logging.Init("balances")
+	ctx := context.Background()
+	if err := tracing.Init(ctx, "balances"); err != nil {
+		slog.Error("tracing init", "error", err)
 	}
-	userID := evt.QueryStringParameters["user_id"]
-	if userID == "" {
-		return clientError(400, "user_id required")
-	}
-	_, err := initDB(ctx)
+	tenants, err := tenant.Parse(os.Getenv(tenant.Env))
 	if err != nil {
-		return serverError(err)
+		slog.Error("tenant keys", "error", err)
+		os.Exit(1)
 	}
-	rows, err := db.QueryContext(ctx, `SELECT currency, balance FROM accounts WHERE user_id=$1 ORDER BY currency`, userID)
+	db, err := dbconn.Open(ctx, tenant.Options()...)
 	if err != nil {
-		return serverError(err)
-	}
-	defer rows.Close()
-	res := BalanceResponse{UserID: userID}
-	for rows.Next() {
-		var b Balance
-		if err := rows.Scan(&b.Currency, &b.Balance); err != nil {
-			return serverError(err)
-		}
-		res.Accounts = append(res.Accounts, b)
+		slog.Error("db init", "error", err)
+		os.Exit(1)
 	}
-	if err := rows.Err(); err != nil {
-		return serverError(err)
+	rates, err := newRateClient(ctx)
+	if err != nil {
+		slog.Error("rate client init", "error", err)
+		os.Exit(1)
 	}
-	body, _ := json.Marshal(res)
-	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: map[string]string{"Content-Type": "application/json"}}, nil
-}
-
-func main() { lambda.Start(handler) }
-
-func clientError(code int, msg string) (events.APIGatewayProxyResponse, error) {
-	return events.APIGatewayProxyResponse{StatusCode: code, Body: fmt.Sprintf(`{"error":"%s"}`, msg), Headers: map[string]string{"Content-Type": "application/json"}}, nil
-}
-func serverError(err error) (events.APIGatewayProxyResponse, error) {
-	fmt.Println("ERR:", err)
-	return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"error":"internal"}`, Headers: map[string]string{"Content-Type": "application/json"}}, nil
+	svc := &Service{Store: pgStore{db: db}, Rates: rates, Tenants: tenants}
+	lambda.Start(svc.Handler)
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}} }))
+}
+
+func TestBalancesListsAccountsByCurrency(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 12.5, "EUR": 3})
+	testpg.HeldJob(t, pg, user, "USD", "EUR", 2.5)
+
+	req := testpg.APIRequest(http.MethodGet, "/balances?user_id="+user, nil)
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d err %v", resp.StatusCode, err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	var out BalanceResponse
+	if err := json.Unmarshal([]byte(resp.Body), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := []Balance{{Currency: "EUR", Balance: 3, Available: 3}, {Currency: "USD", Balance: 12.5, Held: 2.5, Available: 10}}
+	if out.UserID != user || len(out.Accounts) != 2 || out.Accounts[0] != want[0] || out.Accounts[1] != want[1] {
+		t.Errorf("response = %+v, want %v", out, want)
+	}
+}
+
+func TestBalancesAreIsolatedByTenant(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 12.5})
+	other := testpg.Tenant(t, pg)
+
+	req := testpg.APIRequest(http.MethodGet, "/balances?user_id="+user, nil)
+	req.RequestContext.Authorizer["tenant_id"] = other
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d err %v", resp.StatusCode, err)
+	}
+	var out BalanceResponse
+	if err := json.Unmarshal([]byte(resp.Body), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Accounts) != 0 {
+		t.Errorf("tenant %s sees %s's accounts of the default tenant: %+v", other, user, out.Accounts)
+	}
+
+	req.RequestContext.Authorizer = nil
+	if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a tenant: status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestAppRoleSeesOnlyItsTenant(t *testing.T) {
+	pg := testpg.DB(t)
+	ctx := context.Background()
+	var role string
+	if err := pg.QueryRowContext(ctx, `SELECT current_user`).Scan(&role); err != nil || role != "jobs_app" {
+		t.Fatalf("connected as %q (%v), want jobs_app", role, err)
+	}
+	if err := tenant.CheckRole(ctx, pg); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenant.CheckRole(ctx, testpg.Empty(t)); !errors.Is(err, tenant.ErrBypassesRLS) {
+		t.Errorf("superuser: CheckRole = %v, want ErrBypassesRLS", err)
+	}
+
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 12.5})
+	other := testpg.Tenant(t, pg)
+	for id, want := range map[string]int{tenant.Default: 1, other: 0} {
+		tx, err := pg.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := tenant.Scope(ctx, tx, id); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM accounts WHERE user_id = $1`, user).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		_ = tx.Rollback()
+		if n != want {
+			t.Errorf("tenant %s sees %d of the default tenant's accounts, want %d", id, n, want)
+		}
+	}
+	// Nor can it write rows into another tenant
+	if _, err := pg.ExecContext(ctx, `INSERT INTO accounts (user_id, currency, balance, tenant_id) VALUES ($1, 'EUR', 1, $2)`, user, other); err == nil {
+		t.Error("jobs_app inserted a row for another tenant")
+	}
+}
+
+func TestBalancesRequiresUserID(t *testing.T) {
+	testpg.DB(t)
+	resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodGet, "/balances", nil))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestValuationAtPastTimeUsesLedgerAndExecutedRates(t *testing.T) {
+	pg := testpg.DB(t)
+	ctx := context.Background()
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 90, "EUR": 9})
+	testpg.QueuedJob(t, pg, user, "USD", "EUR", 10)
+	hourAgo := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	for _, q := range []string{
+		`UPDATE accounts SET created_at = $2::timestamptz - interval '2 hours' WHERE user_id = $1`,
+		`UPDATE conversion_jobs SET status='completed', rate=0.8991, mid_rate=0.9, completed_at=$2::timestamptz WHERE client_id=$1`,
+		`INSERT INTO ledger_entries (job_id, account_id, entry_type, amount, currency, created_at)
+			SELECT j.job_id, a.account_id, CASE a.currency WHEN 'USD' THEN 'debit' ELSE 'credit' END::entry_type_enum,
+				CASE a.currency WHEN 'USD' THEN 10 ELSE 9 END, a.currency, $2::timestamptz
+			FROM accounts a JOIN conversion_jobs j ON j.client_id = a.user_id WHERE a.user_id = $1`,
+	} {
+		if _, err := pg.ExecContext(ctx, q, user, hourAgo); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	value := func(at time.Time) (int, *Valuation) {
+		t.Helper()
+		req := testpg.APIRequest(http.MethodGet, "/balances?user_id="+user+"&valuation_currency=USD&at="+at.Format(time.RFC3339), nil)
+		resp, err := svc.Handler(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.Spec.CheckResponse(req, resp); err != nil {
+			t.Error(err)
+		}
+		var out BalanceResponse
+		_ = json.Unmarshal([]byte(resp.Body), &out)
+		return resp.StatusCode, out.Valuation
+	}
+
+	// A later reversal's rate is not a market rate
+	if _, err := pg.ExecContext(ctx, `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, status, target_amount, rate, reversal_of, reversed_amount, completed_at)
+		SELECT gen_random_uuid(), client_id, 'EUR', 'USD', 1, 'completed', 2, 2, job_id, 0.5, $2::timestamptz + interval '10 minutes' FROM conversion_jobs WHERE client_id=$1`, user, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	// After the conversion, EUR is valued at the inverse of its mid rate
+	status, v := value(hourAgo.Add(30 * time.Minute))
+	if status != http.StatusOK || v.Total != 100 || len(v.Accounts) != 2 || v.Accounts[0].Balance != 9 || v.Accounts[1].Balance != 90 {
+		t.Fatalf("status %d valuation %+v", status, v)
+	}
+	if r := v.Rates[0]; r.Source != "EUR" || r.Provider != "executed-inverse" || !r.AsOf.Equal(hourAgo) {
+		t.Errorf("rate = %+v", r)
+	}
+
+	// Before it, the balances are rebuilt but nothing was yet quoted for EUR
+	if status, _ := value(hourAgo.Add(-30 * time.Minute)); status != http.StatusUnprocessableEntity {
+		t.Errorf("before any conversion: status = %d, want 422", status)
+	}
+	if balances, err := (pgStore{db: pg}).BalancesAt(ctx, user, hourAgo.Add(-time.Minute)); err != nil || len(balances) != 2 || balances[0].Balance != 0 || balances[1].Balance != 100 {
+		t.Errorf("balances before = %+v, %v", balances, err)
+	}
+	if balances, _ := (pgStore{db: pg}).BalancesAt(ctx, user, hourAgo.Add(-3*time.Hour)); len(balances) != 0 {
+		t.Errorf("balances before the accounts were opened = %+v", balances)
+	}
+
+	// A rate the rate service recorded later takes over
+	if _, err := pg.ExecContext(ctx, `INSERT INTO fx_rate_ticks (source_currency, target_currency, rate, provider, quoted_at) VALUES ('EUR','USD',1.12,'feed',$1)`, hourAgo.Add(45*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if status, v := value(hourAgo.Add(50 * time.Minute)); status != http.StatusOK || v.Total != 100.08 || v.Rates[0].Provider != "feed" {
+		t.Errorf("with a recorded rate: status %d valuation %+v", status, v)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Balance is one account. Held is reserved for the user's unsettled jobs, so
+// only Available (balance less held) can fund new ones.
+type Balance struct {
+	Currency  string  `json:"currency"`
+	Balance   float64 `json:"balance"`
+	Held      float64 `json:"held"`
+	Available float64 `json:"available"`
+}
+
+type BalanceResponse struct {
+	UserID    string     `json:"user_id"`
+	Accounts  []Balance  `json:"accounts"`
+	Valuation *Valuation `json:"valuation,omitempty"`
+}
+
+// Valuation is the value of the user's accounts in one currency, from the
+// balances and rates as they stood at AsOf.
+type Valuation struct {
+	Currency string          `json:"currency"`
+	AsOf     time.Time       `json:"as_of"`
+	Total    float64         `json:"total"`
+	Accounts []ValuedAccount `json:"accounts"`
+	Rates    []RateSnapshot  `json:"rates"`
+}
+
+// ValuedAccount is one account's balance and its value in the valuation
+// currency.
+type ValuedAccount struct {
+	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`
+	Value    float64 `json:"value"`
+}
+
+// RateSnapshot is a rate a valuation used, and when it was quoted.
+type RateSnapshot struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Rate     float64   `json:"rate"`
+	Provider string    `json:"provider"`
+	AsOf     time.Time `json:"as_of"`
+}
+
+// Store reads account balances.
+type Store interface {
+	// Balances returns the user's accounts ordered by currency.
+	Balances(ctx context.Context, userID string) ([]Balance, error)
+	// BalancesAt returns the balances of the user's accounts as they stood at
+	// t, ordered by currency. Held and Available are not known for the past
+	// and are zero.
+	BalancesAt(ctx context.Context, userID string, t time.Time) ([]Balance, error)
+	// RateAt returns the latest rate for the pair at or before t, or
+	// sql.ErrNoRows.
+	RateAt(ctx context.Context, source, target string, t time.Time) (RateSnapshot, error)
+}
+
+// RateClient fetches current rates from the rate Lambda.
+type RateClient interface {
+	Rate(ctx context.Context, source, target string) (rates.Response, error)
+}
+
+// Service handles GET /balances. Rates is only needed for valuations.
+type Service struct {
+	Store   Store
+	Rates   RateClient
+	Tenants tenant.Keys
+}
+
+// errNoRate is a past valuation needing a rate nothing was quoted at before
+// the valuation time.
+var errNoRate = errors.New("no rate")
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := api.Spec.Wrap(s.handle, "GetBalances")(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.WithCorrelationID(ctx, logging.FromRequest(evt))
+	tenantID, err := s.Tenants.Resolve(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	ctx = tenant.With(ctx, tenantID)
+	if evt.HTTPMethod != http.MethodGet || evt.Path != "/balances" {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	userID := evt.QueryStringParameters["user_id"]
+	if userID == "" {
+		return problem.RespondInvalid(ctx, "user_id", "user_id required")
+	}
+	currency := evt.QueryStringParameters["valuation_currency"]
+	if currency != "" && !currencyCode.MatchString(currency) {
+		return problem.RespondInvalid(ctx, "valuation_currency", "valuation_currency must be a 3-letter code")
+	}
+	var at *time.Time
+	if v := evt.QueryStringParameters["at"]; v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		switch {
+		case err != nil:
+			return problem.RespondInvalid(ctx, "at", "at must be an RFC 3339 timestamp")
+		case currency == "":
+			return problem.RespondInvalid(ctx, "valuation_currency", "at requires valuation_currency")
+		case t.After(time.Now()):
+			return problem.RespondInvalid(ctx, "at", "at must not be in the future")
+		}
+		at = &t
+	}
+
+	accounts, err := s.Store.Balances(ctx, userID)
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	resp := BalanceResponse{UserID: userID, Accounts: accounts}
+	if currency != "" {
+		resp.Valuation, err = s.value(ctx, userID, currency, at, accounts)
+		var unavailable rateUnavailableError
+		switch {
+		case errors.Is(err, errNoRate):
+			return problem.Respond(ctx, http.StatusUnprocessableEntity, problem.RateUnavailable, err.Error())
+		case errors.As(err, &unavailable):
+			slog.WarnContext(ctx, "rate unavailable", "pair", unavailable.pair, "error", unavailable.err)
+			return problem.Respond(ctx, http.StatusServiceUnavailable, problem.RateUnavailable, "no rate for "+unavailable.pair)
+		case err != nil:
+			return problem.ServerError(ctx, err)
+		}
+	}
+	body, _ := json.Marshal(resp)
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+}
+
+// rateUnavailableError is the rate service failing to quote a pair.
+type rateUnavailableError struct {
+	pair string
+	err  error
+}
+
+func (e rateUnavailableError) Error() string { return "rate " + e.pair + ": " + e.err.Error() }
+
+// value values the user's accounts in currency at the current rates or, with
+// at, the balances and rates as they stood then. Each rate is looked up once.
+func (s *Service) value(ctx context.Context, userID, currency string, at *time.Time, accounts []Balance) (*Valuation, error) {
+	v := &Valuation{Currency: currency, AsOf: time.Now().UTC(), Accounts: []ValuedAccount{}, Rates: []RateSnapshot{}}
+	if at != nil {
+		v.AsOf = at.UTC()
+		var err error
+		if accounts, err = s.Store.BalancesAt(ctx, userID, *at); err != nil {
+			return nil, err
+		}
+	}
+	var total float64
+	for _, a := range accounts {
+		rate := 1.0
+		if a.Currency != currency {
+			snap, err := s.rate(ctx, a.Currency, currency, at)
+			if err != nil {
+				return nil, err
+			}
+			v.Rates = append(v.Rates, snap)
+			rate = snap.Rate
+		}
+		value := round8(a.Balance * rate)
+		v.Accounts = append(v.Accounts, ValuedAccount{Currency: a.Currency, Balance: a.Balance, Value: value})
+		total += value
+	}
+	v.Total = round8(total)
+	return v, nil
+}
+
+// rate quotes source in target now, from the rate service, or at a past
+// time from the store.
+func (s *Service) rate(ctx context.Context, source, target string, at *time.Time) (RateSnapshot, error) {
+	if at == nil {
+		if s.Rates == nil {
+			return RateSnapshot{}, rateUnavailableError{pair: source + ":" + target, err: errors.New("no rate service configured")}
+		}
+		r, err := s.Rates.Rate(ctx, source, target)
+		if err == nil && r.Rate <= 0 {
+			err = fmt.Errorf("invalid rate %v", r.Rate)
+		}
+		if err != nil {
+			return RateSnapshot{}, rateUnavailableError{pair: source + ":" + target, err: err}
+		}
+		return RateSnapshot{Source: source, Target: target, Rate: r.Rate, Provider: r.Provider, AsOf: time.Now().UTC()}, nil
+	}
+	snap, err := s.Store.RateAt(ctx, source, target, *at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RateSnapshot{}, fmt.Errorf("%w for %s:%s at %s", errNoRate, source, target, at.UTC().Format(time.RFC3339))
+	}
+	return snap, err
+}
+
+func round8(v float64) float64 {
+	return math.Round(v*1e8) / 1e8
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// memStore serves fixed balances and past rates.
+type memStore struct {
+	accounts []Balance
+	past     []Balance
+	rates    map[string]RateSnapshot // by "SRC:TGT"
+}
+
+func (s *memStore) Balances(context.Context, string) ([]Balance, error) { return s.accounts, nil }
+
+func (s *memStore) BalancesAt(context.Context, string, time.Time) ([]Balance, error) {
+	return s.past, nil
+}
+
+func (s *memStore) RateAt(_ context.Context, source, target string, _ time.Time) (RateSnapshot, error) {
+	if r, ok := s.rates[source+":"+target]; ok {
+		return r, nil
+	}
+	return RateSnapshot{}, sql.ErrNoRows
+}
+
+// fixedRates quotes from a table and fails for other pairs.
+type fixedRates map[string]float64
+
+func (f fixedRates) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	if r, ok := f[source+":"+target]; ok {
+		return rates.Response{Source: source, Target: target, Rate: r, Provider: "test"}, nil
+	}
+	return rates.Response{}, errors.New("rate lambda status 404")
+}
+
+func getBalances(t *testing.T, s *Service, query string) (int, BalanceResponse, problem.Problem) {
+	t.Helper()
+	req := testpg.APIRequest(http.MethodGet, "/balances?user_id=u1"+query, nil)
+	resp, err := s.Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	var out BalanceResponse
+	var p problem.Problem
+	_ = json.Unmarshal([]byte(resp.Body), &out)
+	_ = json.Unmarshal([]byte(resp.Body), &p)
+	return resp.StatusCode, out, p
+}
+
+func TestValuationAtCurrentRates(t *testing.T) {
+	store := &memStore{accounts: []Balance{{Currency: "EUR", Balance: 10, Available: 10}, {Currency: "GBP", Balance: 0}, {Currency: "USD", Balance: 100, Held: 20, Available: 80}}}
+	s := &Service{Store: store, Rates: fixedRates{"USD:EUR": 0.9, "GBP:EUR": 1.15}}
+
+	status, out, _ := getBalances(t, s, "&valuation_currency=EUR")
+	if status != http.StatusOK || out.Valuation == nil {
+		t.Fatalf("status = %d, valuation %+v", status, out.Valuation)
+	}
+	v := out.Valuation
+	if v.Currency != "EUR" || v.Total != 100 || len(v.Accounts) != 3 || v.Accounts[2].Value != 90 {
+		t.Errorf("valuation = %+v", v)
+	}
+	if len(v.Rates) != 2 || v.Rates[1].Source != "USD" || v.Rates[1].Rate != 0.9 || v.Rates[1].Provider != "test" || v.Rates[1].AsOf.IsZero() {
+		t.Errorf("rates = %+v", v.Rates)
+	}
+	if len(out.Accounts) != 3 {
+		t.Errorf("accounts = %+v, want them listed as without a valuation", out.Accounts)
+	}
+
+	if status, out, _ := getBalances(t, s, ""); status != http.StatusOK || out.Valuation != nil {
+		t.Errorf("without valuation_currency: status %d valuation %+v", status, out.Valuation)
+	}
+}
+
+func TestValuationAtPastTime(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	quoted := at.Add(-time.Hour)
+	store := &memStore{
+		past:  []Balance{{Currency: "GBP", Balance: 4}, {Currency: "USD", Balance: 50}},
+		rates: map[string]RateSnapshot{"USD:GBP": {Source: "USD", Target: "GBP", Rate: 0.8, Provider: "executed", AsOf: quoted}},
+	}
+	s := &Service{Store: store} // no rate service needed for the past
+
+	status, out, _ := getBalances(t, s, "&valuation_currency=GBP&at=2025-01-01T12:00:00Z")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+	if v := out.Valuation; !v.AsOf.Equal(at) || v.Total != 44 || len(v.Rates) != 1 || !v.Rates[0].AsOf.Equal(quoted) {
+		t.Errorf("valuation = %+v", v)
+	}
+
+	status, _, p := getBalances(t, s, "&valuation_currency=EUR&at=2025-01-01T12:00:00Z")
+	if status != http.StatusUnprocessableEntity || p.Code != problem.RateUnavailable {
+		t.Errorf("no past rate: status %d problem %+v", status, p)
+	}
+}
+
+func TestValuationRejections(t *testing.T) {
+	s := &Service{Store: &memStore{accounts: []Balance{{Currency: "USD", Balance: 1}}}, Rates: fixedRates{}}
+	for query, want := range map[string]int{
+		"&valuation_currency=EUR":                         http.StatusServiceUnavailable,
+		"&at=2025-01-01T00:00:00Z":                        http.StatusBadRequest,
+		"&valuation_currency=EUR&at=2999-01-01T00:00:00Z": http.StatusBadRequest,
+		"&valuation_currency=eur":                         http.StatusBadRequest,
+	} {
+		if status, _, p := getBalances(t, s, query); status != want || p.Code == "" {
+			t.Errorf("%s: status %d problem %+v, want %d", query, status, p, want)
+		}
+	}
+}
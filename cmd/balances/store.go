@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+func (s pgStore) Balances(ctx context.Context, userID string) ([]Balance, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT currency, balance, held, balance - held FROM accounts WHERE user_id=$1 ORDER BY currency`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Balance
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.Currency, &b.Balance, &b.Held, &b.Available); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// BalancesAt works back from the current balances, undoing the ledger entries
+// posted after t. Accounts opened after t are left out.
+func (s pgStore) BalancesAt(ctx context.Context, userID string, t time.Time) ([]Balance, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT a.currency,
+			a.balance - COALESCE(SUM(CASE l.entry_type WHEN 'credit' THEN l.amount ELSE -l.amount END), 0)
+		FROM accounts a LEFT JOIN ledger_entries l ON l.account_id = a.account_id AND l.created_at > $2
+		WHERE a.user_id=$1 AND a.created_at <= $2
+		GROUP BY a.account_id ORDER BY a.currency`, userID, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Balance
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.Currency, &b.Balance); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// RateAt takes the latest of the rates the rate service recorded for the pair
+// and the mid rates of completed conversions, inverting the reverse pair's
+// when it is more recent. Executed rates cover the time before rates were
+// recorded. A conversion's own rate is the bid after its client's spread, so
+// only conversions from before spreads, which executed at the mid, fall back
+// to it; reversals execute at whatever their basis gave and are skipped.
+func (s pgStore) RateAt(ctx context.Context, source, target string, t time.Time) (RateSnapshot, error) {
+	r := RateSnapshot{Source: source, Target: target}
+	err := s.db.QueryRowContext(ctx, `SELECT rate, provider, as_of FROM (
+			(SELECT rate, provider, quoted_at AS as_of, 0 AS inverse FROM fx_rate_ticks
+				WHERE source_currency=$1 AND target_currency=$2 AND quoted_at <= $3 ORDER BY quoted_at DESC LIMIT 1)
+			UNION ALL
+			(SELECT 1/rate, provider || '-inverse', quoted_at, 1 FROM fx_rate_ticks
+				WHERE source_currency=$2 AND target_currency=$1 AND quoted_at <= $3 ORDER BY quoted_at DESC LIMIT 1)
+			UNION ALL
+			(SELECT COALESCE(mid_rate, rate), 'executed', completed_at, 0 FROM conversion_jobs
+				WHERE status='completed' AND reversal_of IS NULL AND source_currency=$1 AND target_currency=$2 AND completed_at <= $3 ORDER BY completed_at DESC LIMIT 1)
+			UNION ALL
+			(SELECT 1/COALESCE(mid_rate, rate), 'executed-inverse', completed_at, 1 FROM conversion_jobs
+				WHERE status='completed' AND reversal_of IS NULL AND source_currency=$2 AND target_currency=$1 AND completed_at <= $3 ORDER BY completed_at DESC LIMIT 1)
+		) r ORDER BY as_of DESC, inverse LIMIT 1`, source, target, t).Scan(&r.Rate, &r.Provider, &r.AsOf)
+	return r, err
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// fakeRates is served by the stand-in rate Lambda. USD:CHF is a bad tick.
+var fakeRates = map[string]float64{"USD:EUR": 0.90, "EUR:USD": 1.16, "USD:CHF": 0.0009, "CHF:USD": 1.12}
+
+// svc settles against the test database and the stand-in rate Lambda.
+var svc *Service
+
+func TestMain(m *testing.M) {
+	// Stand-in for the Lambda Invoke API returning API Gateway proxy envelopes like cmd/rate.
+	rateLambda := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req events.APIGatewayProxyRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		src, tgt := req.QueryStringParameters["source"], req.QueryStringParameters["target"]
+		rate, ok := fakeRates[src+":"+tgt]
+		resp := events.APIGatewayProxyResponse{StatusCode: 404, Body: `{"error":"rate not found"}`}
+		if ok {
+			resp = events.APIGatewayProxyResponse{StatusCode: 200, Body: fmt.Sprintf(`{"source":%q,"target":%q,"rate":%g,"provider":"test"}`, src, tgt, rate)}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer rateLambda.Close()
+	for k, v := range map[string]string{
+		"AWS_ENDPOINT_URL": rateLambda.URL, "AWS_REGION": "eu-central-1", "RATE_LAMBDA_NAME": "rate",
+		"AWS_ACCESS_KEY_ID": "test", "AWS_SECRET_ACCESS_KEY": "test",
+	} {
+		os.Setenv(k, v)
+	}
+	os.Exit(testpg.Run(m, func(d *sql.DB) {
+		rates, err := newRateClient(context.Background())
+		if err != nil {
+			panic(err)
+		}
+		svc = &Service{Store: pgStore{settlement.PGStore{DB: d}}, Rates: rates}
+	}))
+}
+
+func message(jobID, user, src, tgt string, amount float64) JobMessage {
+	return JobMessage{JobID: jobID, ClientID: user, SourceCurrency: src, TargetCurrency: tgt, SourceAmount: amount, CreatedAt: time.Now()}
+}
+
+func TestConsumerSettlesQueuedJob(t *testing.T) {
+	pg := testpg.DB(t)
+	opening := testpg.Funds{"USD": 1000}
+	user := testpg.FundedUser(t, pg, opening)
+	jobID := testpg.QueuedJob(t, pg, user, "USD", "EUR", 100)
+
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(message(jobID, user, "USD", "EUR", 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	// default schedule: 30 bps below 1000 notional
+	wantTarget := 100 * 0.90 * (1 - 0.003)
+	job := testpg.LoadJob(t, pg, jobID)
+	if job.Status != "completed" || math.Abs(job.TargetAmount.Float64-wantTarget) > 1e-8 || job.FeeVersion.Int64 != 1 {
+		t.Fatalf("job = %+v, want completed with target %.8f priced by schedule v1", job, wantTarget)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 900)
+	testpg.AssertBalance(t, pg, user, "EUR", wantTarget) // EUR account auto-created
+	ledger := testpg.Ledger(t, pg, jobID)
+	if len(ledger) != 2 || ledger[0].EntryType != "debit" || ledger[0].Amount != 100 || ledger[1].Currency != "EUR" {
+		t.Errorf("ledger = %+v", ledger)
+	}
+	testpg.AssertLedgerMatchesBalances(t, pg, user, opening)
+	events := testpg.Outbox(t, pg, jobID)
+	if len(events) != 1 || events[0].Topic != "conversion-events" || events[0].Payload["event"] != "conversion.completed" {
+		t.Errorf("outbox = %+v", events)
+	}
+}
+
+func TestConsumerCapturesHeldJob(t *testing.T) {
+	pg := testpg.DB(t)
+	opening := testpg.Funds{"USD": 100}
+	user := testpg.FundedUser(t, pg, opening)
+	jobID := testpg.HeldJob(t, pg, user, "USD", "EUR", 100)
+
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(message(jobID, user, "USD", "EUR", 100))); err != nil {
+		t.Fatal(err)
+	}
+	if job := testpg.LoadJob(t, pg, jobID); job.Status != "completed" || job.HeldAmount != 0 {
+		t.Fatalf("job = %+v, want completed holding nothing", job)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 0)
+	testpg.AssertHeld(t, pg, user, "USD", 0)
+	testpg.AssertLedgerMatchesBalances(t, pg, user, opening)
+}
+
+func TestConsumerFailsJobOnInsufficientFunds(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 50})
+	jobID := testpg.QueuedJob(t, pg, user, "USD", "EUR", 100)
+
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(message(jobID, user, "USD", "EUR", 100))); err != nil {
+		t.Fatal(err)
+	}
+	job := testpg.LoadJob(t, pg, jobID)
+	if job.Status != "failed" || job.Metadata["error"] != "INSUFFICIENT_FUNDS" {
+		t.Fatalf("job = %+v, want failed with INSUFFICIENT_FUNDS", job)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 50)
+	if n := len(testpg.Ledger(t, pg, jobID)); n != 0 {
+		t.Errorf("ledger entries = %d, want 0", n)
+	}
+}
+
+func TestConsumerIsIdempotentOnRedelivery(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 1000})
+	jobID := testpg.QueuedJob(t, pg, user, "USD", "EUR", 100)
+	msg := message(jobID, user, "USD", "EUR", 100)
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Handler(context.Background(), testpg.SQSEvent(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 900)
+	if n := len(testpg.Ledger(t, pg, jobID)); n != 2 {
+		t.Errorf("ledger entries = %d, want 2", n)
+	}
+}
+
+func TestConsumerLeavesJobQueuedWhenRateUnavailable(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"GBP": 100})
+	jobID := testpg.QueuedJob(t, pg, user, "GBP", "JPY", 10)
+
+	resp, err := svc.Handler(context.Background(), testpg.SQSEvent(message(jobID, user, "GBP", "JPY", 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testpg.LoadJob(t, pg, jobID).Status; got != "queued" {
+		t.Errorf("status = %q, want queued", got)
+	}
+	if len(resp.BatchItemFailures) != 1 {
+		t.Errorf("batch item failures = %+v, want the message redelivered", resp.BatchItemFailures)
+	}
+	testpg.AssertBalance(t, pg, user, "GBP", 100)
+}
+
+func TestConsumerHaltsPairOnRejectedRate(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	first, second := testpg.QueuedJob(t, pg, user, "USD", "CHF", 10), testpg.QueuedJob(t, pg, user, "USD", "CHF", 20)
+	// The stand-in does not date its quotes, so age is not checked
+	guarded := &Service{Store: svc.Store, Rates: rateguard.Guard{Rates: svc.Rates, Store: rateguard.PGStore{DB: pg},
+		Limits: rateguard.Limits{MaxDeviation: 0.1, MaxInverseDeviation: 0.1}}}
+
+	if _, err := guarded.Handler(context.Background(), testpg.SQSEvent(message(first, user, "USD", "CHF", 10), message(second, user, "USD", "CHF", 20))); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{first, second} {
+		if got := testpg.LoadJob(t, pg, id).Status; got != "queued" {
+			t.Errorf("job %s status = %q, want queued", id, got)
+		}
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+
+	b, err := rateguard.PGStore{DB: pg}.Breaker(context.Background(), "USD", "CHF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Status != rateguard.BreakerOpen || b.Rejection == nil || b.Rejection.Rate != 0.0009 || b.Rejection.Reason != rateguard.ReasonInverseDeviation {
+		t.Fatalf("breaker = %+v, want opened by the inverse check", b)
+	}
+	// The second job found the breaker open and was not quoted
+	var n int
+	if err := pg.QueryRow(`SELECT count(*) FROM rate_rejections WHERE source_currency='USD' AND target_currency='CHF'`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("rejections = %d (%v), want 1", n, err)
+	}
+}
+
+func TestConsumerSkipsMalformedMessages(t *testing.T) {
+	testpg.DB(t)
+	evt := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "bad", Body: strings.Repeat("{", 3)}}}
+	if resp, err := svc.Handler(context.Background(), evt); err != nil || len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("malformed message should be dropped, got %+v, %v", resp, err)
+	}
+}
+
+func TestConsumerFailsAllOrNothingBatch(t *testing.T) {
+	pg := testpg.DB(t)
+	opening := testpg.Funds{"USD": 120}
+	user := testpg.FundedUser(t, pg, opening)
+	first, second := testpg.QueuedJob(t, pg, user, "USD", "EUR", 100), testpg.QueuedJob(t, pg, user, "USD", "EUR", 50)
+	batchID := testpg.Batch(t, pg, user, true, first, second)
+
+	msgs := []JobMessage{message(first, user, "USD", "EUR", 100), message(second, user, "USD", "EUR", 50)}
+	for i := range msgs {
+		msgs[i].BatchID, msgs[i].AllOrNothing = batchID, true
+	}
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(msgs[0], msgs[1])); err != nil {
+		t.Fatal(err)
+	}
+	for id, reason := range map[string]string{first: "BATCH_LEG_FAILED", second: "INSUFFICIENT_FUNDS"} {
+		if job := testpg.LoadJob(t, pg, id); job.Status != "failed" || job.Metadata["error"] != reason {
+			t.Errorf("job %s = %+v, want failed with %s", id, job, reason)
+		}
+		if n := len(testpg.Ledger(t, pg, id)); n != 0 {
+			t.Errorf("job %s has %d ledger entries, want 0", id, n)
+		}
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 120)
+	testpg.AssertLedgerMatchesBalances(t, pg, user, opening)
+}
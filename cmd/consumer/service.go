@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// JobMessage mirrors what /jobs publishes
+type JobMessage struct {
+	JobID          string    `json:"job_id"`
+	ClientID       string    `json:"client_id"`
+	SourceCurrency string    `json:"source_currency"`
+	TargetCurrency string    `json:"target_currency"`
+	SourceAmount   float64   `json:"source_amount"`
+	CorrelationID  string    `json:"correlation_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	BatchID        string    `json:"batch_id,omitempty"`
+	AllOrNothing   bool      `json:"all_or_nothing,omitempty"`
+}
+
+// Backlog is the unprocessed outbox rows of one topic.
+type Backlog struct {
+	Topic string
+	Size  int
+	Age   time.Duration // of the oldest row
+}
+
+// Store is the consumer's persistence: the settlement store plus outbox monitoring.
+type Store interface {
+	settlement.Store
+	OutboxBacklog(ctx context.Context) ([]Backlog, error)
+}
+
+// RateClient fetches the FX rate for a pair.
+type RateClient interface {
+	Rate(ctx context.Context, source, target string) (rates.Response, error)
+}
+
+// Service settles queued conversion jobs delivered over SQS.
+type Service struct {
+	Store Store
+	Rates RateClient
+}
+
+// Handler processes a batch of job messages. A job that could not be
+// settled (the rate service failed, the pair's breaker is open, a lock timed
+// out) is left queued with its funds held, and its message is reported in
+// BatchItemFailures so that SQS redelivers it; the rest of the batch is
+// deleted. Malformed messages are logged and dropped.
+func (s *Service) Handler(ctx context.Context, evt events.SQSEvent) (events.SQSEventResponse, error) {
+	var resp events.SQSEventResponse
+	if len(evt.Records) == 0 {
+		return resp, nil
+	}
+	defer tracing.Flush(ctx)
+	// Messages come from every tenant; settlement scopes each job to its own
+	ctx = tenant.With(ctx, tenant.All)
+
+	for _, r := range evt.Records {
+		var msg JobMessage
+		if err := json.Unmarshal([]byte(r.Body), &msg); err != nil {
+			slog.ErrorContext(logging.WithCorrelationID(ctx, logging.FromSQS(r, "")), "bad message", "message_id", r.MessageId, "error", err)
+			continue
+		}
+		msg.CorrelationID = logging.FromSQS(r, msg.CorrelationID)
+		jobCtx := logging.With(logging.WithCorrelationID(ctx, msg.CorrelationID), "job_id", msg.JobID, "user_id", msg.ClientID)
+		jobCtx, span := tracing.Start(tracing.ExtractSQS(jobCtx, r), "process job", trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("job.id", msg.JobID), attribute.String("job.pair", msg.SourceCurrency+":"+msg.TargetCurrency)))
+		if !msg.CreatedAt.IsZero() {
+			tracing.JobPhase(jobCtx, "job.queued", msg.CreatedAt, time.Now())
+		}
+
+		// Transactional execution
+		err := s.processJob(jobCtx, msg)
+		tracing.End(span, err)
+		if err != nil {
+			slog.ErrorContext(jobCtx, "job processing failed", "message_id", r.MessageId, "error", err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: r.MessageId})
+		}
+	}
+	s.recordOutboxBacklog(ctx)
+	return resp, nil
+}
+
+// recordOutboxBacklog reports the age of the oldest unprocessed outbox row and
+// the number of unprocessed rows per topic.
+func (s *Service) recordOutboxBacklog(ctx context.Context) {
+	backlog, err := s.Store.OutboxBacklog(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "outbox backlog query failed", "error", err)
+		return
+	}
+	for _, b := range backlog {
+		metrics.Gauge(metrics.OutboxBacklogSize, metrics.UnitCount, float64(b.Size), metrics.D("topic", b.Topic))
+		metrics.Gauge(metrics.OutboxBacklogAge, metrics.UnitSeconds, b.Age.Seconds(), metrics.D("topic", b.Topic))
+	}
+}
+
+// processJob settles the job through the shared settlement core. Business
+// failures (funds, validation) are committed as failed jobs; errors leave the
+// job queued, and Handler has its message redelivered. A leg of an all-or-nothing batch settles the
+// whole batch; the other legs' messages then find their jobs processed.
+func (s *Service) processJob(ctx context.Context, msg JobMessage) error {
+	settler := settlement.Settler{Store: s.Store, Rates: settlement.RateFunc(rates.Books(s.Rates))}
+	if msg.AllOrNothing && msg.BatchID != "" {
+		_, err := settler.SettleBatch(ctx, msg.BatchID)
+		return err
+	}
+	_, err := settler.Settle(ctx, settlement.Job{
+		ID: msg.JobID, ClientID: msg.ClientID, SourceCurrency: msg.SourceCurrency, TargetCurrency: msg.TargetCurrency,
+		SourceAmount: msg.SourceAmount, CorrelationID: msg.CorrelationID, CreatedAt: msg.CreatedAt,
+	})
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/settlement/settlementtest"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// memStore adds OutboxBacklog to the in-memory settlement store.
+type memStore struct{ *settlementtest.MemStore }
+
+func (s memStore) OutboxBacklog(context.Context) ([]Backlog, error) {
+	return []Backlog{{Topic: "conversion-events", Size: len(s.Outbox)}}, nil
+}
+
+// fixedRates is a RateClient serving a static table.
+type fixedRates map[string]float64
+
+func (r fixedRates) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	rate, ok := r[source+":"+target]
+	if !ok {
+		return rates.Response{}, errors.New("rate not found")
+	}
+	return rates.Response{Source: source, Target: target, Rate: rate, Provider: "test"}, nil
+}
+
+func newTestService(balances map[string]float64) (*Service, *settlementtest.MemStore) {
+	store := settlementtest.New()
+	store.Fund("u1", balances)
+	store.Jobs["j1"] = "queued"
+	return &Service{Store: memStore{store}, Rates: fixedRates{"USD:EUR": 0.9}}, store
+}
+
+func TestServiceSettlesWithFakes(t *testing.T) {
+	svc, store := newTestService(map[string]float64{"USD": 1000})
+	resp, err := svc.Handler(context.Background(), testpg.SQSEvent(message("j1", "u1", "USD", "EUR", 100)))
+	if err != nil || len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("handler = %+v, %v; want no failures", resp, err)
+	}
+	want := 100 * 0.9 * (1 - 0.003)
+	if store.Jobs["j1"] != "completed" || math.Abs(store.Results["j1"].TargetAmount-want) > 1e-9 {
+		t.Fatalf("job %s settled %+v, want completed with %.4f", store.Jobs["j1"], store.Results["j1"], want)
+	}
+	if got := store.Accounts["u1/USD"].Balance; got != 900 {
+		t.Errorf("USD = %v, want 900", got)
+	}
+	if got := store.Accounts["u1/EUR"].Balance; math.Abs(got-want) > 1e-9 {
+		t.Errorf("EUR = %v, want %v", got, want)
+	}
+	if len(store.Ledger) != 2 || len(store.Outbox) != 1 {
+		t.Errorf("ledger %d entries, outbox %d rows; want 2 and 1", len(store.Ledger), len(store.Outbox))
+	}
+}
+
+func TestServiceFailsJobOnInsufficientFundsWithFakes(t *testing.T) {
+	svc, store := newTestService(map[string]float64{"USD": 10})
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(message("j1", "u1", "USD", "EUR", 100))); err != nil {
+		t.Fatal(err)
+	}
+	if store.Jobs["j1"] != "failed" || store.Reasons["j1"] != "INSUFFICIENT_FUNDS" {
+		t.Errorf("job = %s (%s), want failed (INSUFFICIENT_FUNDS)", store.Jobs["j1"], store.Reasons["j1"])
+	}
+	if len(store.Ledger) != 0 || store.Accounts["u1/USD"].Balance != 10 {
+		t.Errorf("funds moved on a failed job: %+v", store)
+	}
+}
+
+func TestServiceRollsBackWhenRateUnavailable(t *testing.T) {
+	svc, store := newTestService(map[string]float64{"GBP": 100, "USD": 1000})
+	store.Jobs["j2"] = "queued"
+	evt := testpg.SQSEvent(message("j1", "u1", "GBP", "JPY", 10), message("j2", "u1", "USD", "EUR", 100))
+	resp, err := svc.Handler(context.Background(), evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Jobs["j1"] != "queued" {
+		t.Errorf("status = %s, want queued for redelivery", store.Jobs["j1"])
+	}
+	// Only the unsettled job's message is redelivered
+	if len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != evt.Records[0].MessageId {
+		t.Errorf("batch item failures = %+v, want %s only", resp.BatchItemFailures, evt.Records[0].MessageId)
+	}
+	if store.Jobs["j2"] != "completed" {
+		t.Errorf("j2 status = %s, want completed", store.Jobs["j2"])
+	}
+	if _, created := store.Accounts["u1/JPY"]; created {
+		t.Error("target account creation should have been rolled back")
+	}
+}
+
+func TestServiceSkipsProcessedJobs(t *testing.T) {
+	svc, store := newTestService(map[string]float64{"USD": 1000})
+	store.Jobs["j1"] = "completed"
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(message("j1", "u1", "USD", "EUR", 100))); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Ledger) != 0 || store.Accounts["u1/USD"].Balance != 1000 {
+		t.Errorf("completed job was settled again")
+	}
+}
+
+func TestServiceSettlesAllOrNothingBatchAsOne(t *testing.T) {
+	svc, store := newTestService(map[string]float64{"USD": 120})
+	store.AddBatch("b1", settlement.Job{ID: "j1", ClientID: "u1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100},
+		settlement.Job{ID: "j2", ClientID: "u1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 50})
+	legs := []JobMessage{message("j1", "u1", "USD", "EUR", 100), message("j2", "u1", "USD", "EUR", 50)}
+	for i := range legs {
+		legs[i].BatchID, legs[i].AllOrNothing = "b1", true
+	}
+	if _, err := svc.Handler(context.Background(), testpg.SQSEvent(legs[0], legs[1])); err != nil {
+		t.Fatal(err)
+	}
+	if store.Reasons["j1"] != settlement.ReasonBatchLegFailed || store.Reasons["j2"] != settlement.ReasonInsufficientFunds {
+		t.Errorf("reasons = %v, want j1 BATCH_LEG_FAILED and j2 INSUFFICIENT_FUNDS", store.Reasons)
+	}
+	if len(store.Ledger) != 0 || len(store.Outbox) != 2 {
+		t.Errorf("ledger %d entries, outbox %d rows; want 0 and one event per leg", len(store.Ledger), len(store.Outbox))
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ settlement.PGStore }
+
+func (s pgStore) OutboxBacklog(ctx context.Context) ([]Backlog, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT topic, count(*), COALESCE(EXTRACT(EPOCH FROM now() - min(created_at)), 0)::float8
+		FROM outbox WHERE processed_at IS NULL GROUP BY topic`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Backlog
+	for rows.Next() {
+		var b Backlog
+		var age float64
+		if err := rows.Scan(&b.Topic, &b.Size, &age); err != nil {
+			return nil, err
+		}
+		b.Age = time.Duration(age * float64(time.Second))
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// TestStressConcurrentJobs queues a deterministic workload of jobs in every
+// direction across a small set of users and lets concurrent batches settle
+// them. Every job must reach a terminal state (a deadlock that exhausted its
+// retries would leave it queued) and the books must balance afterwards.
+func TestStressConcurrentJobs(t *testing.T) {
+	pg := testpg.DB(t)
+	n := testpg.StressOps(t)
+	opening := testpg.Funds{"USD": 5000, "EUR": 5000}
+	users := make([]string, 4)
+	for i := range users {
+		users[i] = testpg.FundedUser(t, pg, opening)
+	}
+
+	var batches [][]any
+	for i, op := range testpg.Workload(34, n, users, "USD", "EUR") {
+		if i%10 == 0 {
+			batches = append(batches, nil)
+		}
+		id := testpg.QueuedJob(t, pg, op.User, op.Source, op.Target, op.Amount)
+		batches[len(batches)-1] = append(batches[len(batches)-1], message(id, op.User, op.Source, op.Target, op.Amount))
+	}
+
+	testpg.Parallel(32, batches, func(batch []any) {
+		if _, err := svc.Handler(context.Background(), testpg.SQSEvent(batch...)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	statuses := testpg.JobStatuses(t, pg, users)
+	t.Logf("%d jobs: %v", n, statuses)
+	if statuses["queued"] != 0 || statuses["completed"]+statuses["failed"] != n {
+		t.Errorf("statuses = %v, want all %d jobs completed or failed", statuses, n)
+	}
+	testpg.AssertNoNegativeBalances(t, pg)
+	for _, u := range users {
+		testpg.AssertLedgerMatchesBalances(t, pg, u, opening)
+	}
+}
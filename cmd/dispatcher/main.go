@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/irajwani/microservice-go/internal/dbconn"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"github.com/irajwani/microservice-go/internal/webhooks"
+)
+
+func main() {
+	logging.Init("dispatcher")
+	metrics.Init("dispatcher")
+	ctx := context.Background()
+	if err := tracing.Init(ctx, "dispatcher"); err != nil {
+		slog.Error("tracing init", "error", err)
+	}
+	db, err := dbconn.Open(ctx, tenant.Options()...)
+	if err != nil {
+		slog.Error("db init", "error", err)
+		os.Exit(1)
+	}
+	svc := &Service{Store: pgStore{db: db}, Client: webhooks.NewClient(10 * time.Second)}
+	lambda.Start(svc.Handler)
+}
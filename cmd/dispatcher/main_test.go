@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+	"github.com/irajwani/microservice-go/internal/webhooks"
+)
+
+var (
+	svc *Service
+	db  *sql.DB
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) {
+		db, svc = d, &Service{Store: pgStore{db: d}, Client: &http.Client{Timeout: 5 * time.Second}}
+	}))
+}
+
+// receiver is a local https webhook endpoint answering with the queued status codes
+// (then 200) and recording every request.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, codes ...int) *receiver {
+	r := &receiver{codes: codes}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests, r.bodies = append(r.requests, req), append(r.bodies, body)
+		code := http.StatusOK
+		if len(r.codes) > 0 {
+			code, r.codes = r.codes[0], r.codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(r.Close)
+	// Every httptest TLS server has the same certificate, which its client trusts
+	svc.Client.Transport = r.Client().Transport
+	return r
+}
+
+func subscribe(t *testing.T, user, url string, events ...string) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`INSERT INTO webhook_subscriptions (client_id, url, events, secret) VALUES ($1,$2,$3,'s3cret') RETURNING subscription_id`, user, url, events).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func conversionEvent(t *testing.T, user, event string) string {
+	t.Helper()
+	jobID := uuid.NewString()
+	return testpg.AppendOutbox(t, db, jobID, settlement.Topic, map[string]any{"event": event, "job_id": jobID, "user_id": user, "source_currency": "USD", "target_currency": "EUR", "source_amount": 100})
+}
+
+type delivery struct {
+	Status   string
+	Attempts int
+	Next     time.Time
+	Log      int
+}
+
+func loadDelivery(t *testing.T, outboxID string) delivery {
+	t.Helper()
+	var d delivery
+	err := db.QueryRow(`SELECT status, attempts, next_attempt_at, (SELECT count(*) FROM webhook_attempts a WHERE a.delivery_id=d.delivery_id)
+		FROM webhook_deliveries d WHERE outbox_id=$1`, outboxID).Scan(&d.Status, &d.Attempts, &d.Next, &d.Log)
+	if err != nil {
+		t.Fatalf("delivery for %s: %v", outboxID, err)
+	}
+	return d
+}
+
+func dispatch(t *testing.T) {
+	t.Helper()
+	if err := svc.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeDue pulls a pending delivery's retry time forward.
+func makeDue(t *testing.T, outboxID string) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE webhook_deliveries SET next_attempt_at=now() WHERE outbox_id=$1`, outboxID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcherDeliversSignedEvents(t *testing.T) {
+	testpg.DB(t)
+	rcv := newReceiver(t)
+	user := "u-" + uuid.NewString()[:8]
+	subscribe(t, user, rcv.URL, "conversion.completed")
+	outboxID := conversionEvent(t, user, "conversion.completed")
+
+	dispatch(t)
+	if len(rcv.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(rcv.requests))
+	}
+	req, body := rcv.requests[0], rcv.bodies[0]
+	if err := webhooks.Verify("s3cret", req.Header.Get(webhooks.SignatureHeader), body, time.Minute); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	if req.Header.Get(webhooks.EventHeader) != "conversion.completed" || req.Header.Get(webhooks.IDHeader) == "" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	if d := loadDelivery(t, outboxID); d.Status != "delivered" || d.Attempts != 1 || d.Log != 1 {
+		t.Errorf("delivery = %+v, want delivered after 1 logged attempt", d)
+	}
+	var processed bool
+	if err := db.QueryRow(`SELECT processed_at IS NOT NULL FROM outbox WHERE outbox_id=$1`, outboxID).Scan(&processed); err != nil || !processed {
+		t.Errorf("outbox row not marked processed (%v)", err)
+	}
+
+	dispatch(t)
+	if len(rcv.requests) != 1 {
+		t.Errorf("delivered event sent again: %d requests", len(rcv.requests))
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	testpg.DB(t)
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	user := "u-" + uuid.NewString()[:8]
+	subscribe(t, user, rcv.URL, webhooks.Events...)
+	outboxID := conversionEvent(t, user, "conversion.failed")
+
+	dispatch(t)
+	d := loadDelivery(t, outboxID)
+	if d.Status != "pending" || d.Attempts != 1 {
+		t.Fatalf("after a 500: %+v, want pending with 1 attempt", d)
+	}
+	if wait := time.Until(d.Next); wait < 20*time.Second || wait > webhooks.Backoff(1) {
+		t.Errorf("next attempt in %s, want about %s", wait, webhooks.Backoff(1))
+	}
+
+	dispatch(t) // not due yet
+	if len(rcv.requests) != 1 {
+		t.Fatalf("retried before the backoff elapsed: %d requests", len(rcv.requests))
+	}
+	makeDue(t, outboxID)
+	dispatch(t)
+	if d := loadDelivery(t, outboxID); d.Status != "delivered" || d.Attempts != 2 || d.Log != 2 {
+		t.Errorf("after retry: %+v, want delivered with 2 logged attempts", d)
+	}
+}
+
+func TestDispatcherGivesUpAfterMaxAttempts(t *testing.T) {
+	testpg.DB(t)
+	codes := make([]int, webhooks.MaxAttempts)
+	for i := range codes {
+		codes[i] = http.StatusServiceUnavailable
+	}
+	rcv := newReceiver(t, codes...)
+	user := "u-" + uuid.NewString()[:8]
+	subscribe(t, user, rcv.URL, "conversion.completed")
+	outboxID := conversionEvent(t, user, "conversion.completed")
+
+	for range webhooks.MaxAttempts {
+		dispatch(t)
+		makeDue(t, outboxID)
+	}
+	dispatch(t)
+	if d := loadDelivery(t, outboxID); d.Status != "failed" || d.Attempts != webhooks.MaxAttempts {
+		t.Errorf("delivery = %+v, want failed after %d attempts", d, webhooks.MaxAttempts)
+	}
+	if len(rcv.requests) != webhooks.MaxAttempts {
+		t.Errorf("receiver got %d requests, want %d", len(rcv.requests), webhooks.MaxAttempts)
+	}
+}
+
+func TestDispatcherOnlyDeliversSubscribedEvents(t *testing.T) {
+	testpg.DB(t)
+	rcv := newReceiver(t)
+	user := "u-" + uuid.NewString()[:8]
+	subscribe(t, user, rcv.URL, "conversion.failed")
+	conversionEvent(t, user, "conversion.completed")
+	conversionEvent(t, "someone-else", "conversion.failed")
+
+	dispatch(t)
+	if len(rcv.requests) != 0 {
+		t.Errorf("receiver got %d requests, want none", len(rcv.requests))
+	}
+}
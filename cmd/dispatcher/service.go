@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/deadline"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"github.com/irajwani/microservice-go/internal/webhooks"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Delivery statuses.
+const (
+	statusPending   = "pending"
+	statusDelivered = "delivered"
+	statusFailed    = "failed"
+)
+
+const (
+	batchSize   = 100
+	concurrency = 8
+	// lease keeps a claimed delivery from being picked up by a concurrent
+	// dispatcher while it is being sent; it must exceed the HTTP timeout.
+	lease = 2 * time.Minute
+)
+
+// Due is a delivery claimed for sending.
+type Due struct {
+	DeliveryID string
+	Event      string
+	Payload    []byte
+	Attempts   int // before this one
+	URL        string
+	Secret     string
+}
+
+// Outcome is the result of one attempt.
+type Outcome struct {
+	Status        string // pending (retry at NextAttemptAt) | delivered | failed
+	StatusCode    int    // 0 when no response was received
+	Error         string
+	Duration      time.Duration
+	NextAttemptAt time.Time
+}
+
+// Store is the dispatcher's persistence.
+type Store interface {
+	// FanOut claims up to limit unprocessed conversion-events outbox rows,
+	// creates a delivery for every active subscription of the event's user
+	// that includes its event type, and marks the rows processed.
+	FanOut(ctx context.Context, limit int) (claimed, deliveries int, err error)
+	// ClaimDue leases up to limit due pending deliveries until leaseUntil.
+	ClaimDue(ctx context.Context, limit int, leaseUntil time.Time) ([]Due, error)
+	// RecordAttempt logs the attempt and updates the delivery.
+	RecordAttempt(ctx context.Context, deliveryID string, o Outcome) error
+}
+
+// Service moves conversion events from the outbox to client webhooks.
+type Service struct {
+	Store  Store
+	Client *http.Client
+}
+
+// Handler runs one dispatch pass per scheduled invocation.
+func (s *Service) Handler(ctx context.Context, _ events.CloudWatchEvent) error {
+	defer tracing.Flush(ctx)
+	return s.Dispatch(ctx)
+}
+
+// Dispatch fans out new outbox events, then sends every due delivery. It
+// returns once nothing is due, or shortly before ctx's deadline.
+func (s *Service) Dispatch(ctx context.Context) error {
+	ctx, span := tracing.Start(tenant.With(ctx, tenant.All), "webhooks.dispatch")
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	for {
+		var claimed, created int
+		claimed, created, err = s.Store.FanOut(ctx, batchSize)
+		if err != nil {
+			return fmt.Errorf("fan out: %w", err)
+		}
+		if claimed > 0 {
+			slog.InfoContext(ctx, "outbox events fanned out", "events", claimed, "deliveries", created)
+		}
+		if claimed < batchSize {
+			break
+		}
+	}
+	for !deadline.Near(ctx) {
+		var due []Due
+		due, err = s.Store.ClaimDue(ctx, batchSize, time.Now().Add(lease))
+		if err != nil {
+			return fmt.Errorf("claim deliveries: %w", err)
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, d := range due {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				s.deliver(ctx, d)
+			}()
+		}
+		wg.Wait()
+	}
+	return nil
+}
+
+// deliver POSTs one delivery and records the outcome. Failures are logged;
+// the delivery stays pending (or is marked failed) and Dispatch carries on.
+func (s *Service) deliver(ctx context.Context, d Due) {
+	ctx = logging.With(ctx, "delivery_id", d.DeliveryID, "event", d.Event)
+	ctx, span := tracing.Start(ctx, "webhook.deliver")
+	span.SetAttributes(attribute.String("webhook.event", d.Event), attribute.Int("webhook.attempt", d.Attempts+1))
+
+	o := s.post(ctx, d)
+	switch {
+	case o.Status == statusDelivered:
+	case d.Attempts+1 >= webhooks.MaxAttempts:
+		o.Status = statusFailed
+	default:
+		o.Status, o.NextAttemptAt = statusPending, time.Now().Add(webhooks.Backoff(d.Attempts+1))
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", o.StatusCode), attribute.String("webhook.status", o.Status))
+	metrics.Count(metrics.WebhookDeliveries, 1, metrics.D("outcome", o.Status))
+	metrics.Observe(metrics.WebhookLatency, metrics.UnitMilliseconds, float64(o.Duration.Milliseconds()))
+
+	err := s.Store.RecordAttempt(ctx, d.DeliveryID, o)
+	tracing.End(span, err)
+	switch {
+	case err != nil:
+		slog.ErrorContext(ctx, "record webhook attempt", "error", err)
+	case o.Status == statusDelivered:
+		slog.InfoContext(ctx, "webhook delivered", "status_code", o.StatusCode, "attempt", d.Attempts+1)
+	default:
+		slog.WarnContext(ctx, "webhook delivery failed", "status_code", o.StatusCode, "error", o.Error, "attempt", d.Attempts+1, "status", o.Status, "next_attempt_at", o.NextAttemptAt)
+	}
+}
+
+// post sends the signed request; any 2xx response counts as delivered.
+func (s *Service) post(ctx context.Context, d Due) Outcome {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return Outcome{Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "microservice-go-webhooks/1")
+	req.Header.Set(webhooks.IDHeader, d.DeliveryID)
+	req.Header.Set(webhooks.EventHeader, d.Event)
+	req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(d.Secret, time.Now(), d.Payload))
+
+	start := time.Now()
+	resp, err := s.Client.Do(req)
+	o := Outcome{Duration: time.Since(start)}
+	if err != nil {
+		o.Error = err.Error()
+		return o
+	}
+	defer resp.Body.Close()
+	o.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		o.Status = statusDelivered
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return o
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	o.Error = "HTTP " + strconv.Itoa(resp.StatusCode)
+	if len(body) > 0 {
+		o.Error += ": " + string(bytes.TrimSpace(body))
+	}
+	return o
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/webhooks"
+)
+
+// memStore serves one pending delivery and records outcomes.
+type memStore struct {
+	due      []Due
+	outcomes []Outcome
+}
+
+func (s *memStore) FanOut(context.Context, int) (int, int, error) { return 0, 0, nil }
+
+func (s *memStore) ClaimDue(context.Context, int, time.Time) ([]Due, error) {
+	due := s.due
+	s.due = nil
+	return due, nil
+}
+
+func (s *memStore) RecordAttempt(_ context.Context, _ string, o Outcome) error {
+	s.outcomes = append(s.outcomes, o)
+	return nil
+}
+
+func TestDeliverOutcomes(t *testing.T) {
+	var gotSig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSig = r.Header.Get(webhooks.SignatureHeader)
+		if r.Header.Get(webhooks.EventHeader) == "conversion.failed" {
+			http.Error(w, "nope", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	for name, tc := range map[string]struct {
+		event      string
+		attempts   int
+		wantStatus string
+		wantRetry  time.Duration
+	}{
+		"2xx delivers":          {"conversion.completed", 0, statusDelivered, 0},
+		"error backs off":       {"conversion.failed", 2, statusPending, webhooks.Backoff(3)},
+		"last attempt gives up": {"conversion.failed", webhooks.MaxAttempts - 1, statusFailed, 0},
+	} {
+		store := &memStore{due: []Due{{DeliveryID: "d1", Event: tc.event, Payload: []byte(`{}`), Attempts: tc.attempts, URL: srv.URL, Secret: "k"}}}
+		svc := &Service{Store: store, Client: srv.Client()}
+		if err := svc.Dispatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(store.outcomes) != 1 {
+			t.Fatalf("%s: %d outcomes recorded", name, len(store.outcomes))
+		}
+		o := store.outcomes[0]
+		if o.Status != tc.wantStatus {
+			t.Errorf("%s: status = %s (%s), want %s", name, o.Status, o.Error, tc.wantStatus)
+		}
+		if tc.wantRetry > 0 {
+			if wait := time.Until(o.NextAttemptAt); wait > tc.wantRetry || wait < tc.wantRetry-time.Second {
+				t.Errorf("%s: retry in %s, want %s", name, wait, tc.wantRetry)
+			}
+			if o.StatusCode != http.StatusBadGateway || o.Error != "HTTP 502: nope" {
+				t.Errorf("%s: recorded %d %q", name, o.StatusCode, o.Error)
+			}
+		}
+		if err := webhooks.Verify("k", gotSig, []byte(`{}`), time.Minute); err != nil {
+			t.Errorf("%s: signature: %v", name, err)
+		}
+	}
+}
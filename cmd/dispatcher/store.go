@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/pgtx"
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+func (s pgStore) FanOut(ctx context.Context, limit int) (claimed, deliveries int, err error) {
+	err = s.db.QueryRowContext(ctx, `WITH claimed AS (
+			UPDATE outbox SET processed_at = now()
+			WHERE outbox_id IN (SELECT outbox_id FROM outbox WHERE topic=$1 AND processed_at IS NULL ORDER BY created_at LIMIT $2 FOR UPDATE SKIP LOCKED)
+			RETURNING outbox_id, tenant_id, payload
+		), created AS (
+			INSERT INTO webhook_deliveries (tenant_id, subscription_id, outbox_id, event, payload)
+			SELECT s.tenant_id, s.subscription_id, c.outbox_id, c.payload->>'event', c.payload
+			FROM claimed c JOIN webhook_subscriptions s ON s.active AND s.tenant_id = c.tenant_id AND s.client_id = c.payload->>'user_id' AND c.payload->>'event' = ANY(s.events)
+			ON CONFLICT (subscription_id, outbox_id) DO NOTHING
+			RETURNING 1
+		)
+		SELECT (SELECT count(*) FROM claimed), (SELECT count(*) FROM created)`, settlement.Topic, limit).Scan(&claimed, &deliveries)
+	return claimed, deliveries, err
+}
+
+func (s pgStore) ClaimDue(ctx context.Context, limit int, leaseUntil time.Time) ([]Due, error) {
+	rows, err := s.db.QueryContext(ctx, `UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM webhook_subscriptions s
+		WHERE s.subscription_id = d.subscription_id AND s.active AND d.delivery_id IN (
+			SELECT delivery_id FROM webhook_deliveries WHERE status='pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING d.delivery_id, d.event, d.payload, d.attempts, s.url, s.secret`, limit, leaseUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Due
+	for rows.Next() {
+		var d Due
+		if err := rows.Scan(&d.DeliveryID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s pgStore) RecordAttempt(ctx context.Context, deliveryID string, o Outcome) error {
+	return pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO webhook_attempts (tenant_id, delivery_id, status_code, error, duration_ms)
+			SELECT tenant_id, delivery_id, NULLIF($2,0), NULLIF($3,''), $4 FROM webhook_deliveries WHERE delivery_id=$1`,
+			deliveryID, o.StatusCode, o.Error, o.Duration.Milliseconds()); err != nil {
+			return err
+		}
+		next := o.NextAttemptAt
+		if next.IsZero() {
+			next = time.Now()
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status=$2, attempts=attempts+1, last_status_code=NULLIF($3,0), last_error=NULLIF($4,''),
+			next_attempt_at=$5, delivered_at=CASE WHEN $2='delivered' THEN now() END WHERE delivery_id=$1`,
+			deliveryID, o.Status, o.StatusCode, o.Error, next)
+		return err
+	})
+}
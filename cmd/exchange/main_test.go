@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/spreads"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) {
+		svc = &Service{Store: settlement.PGStore{DB: d}, Rates: rateguard.Guard{Rates: mockRates{}, Store: rateguard.PGStore{DB: d}, Limits: rateguard.DefaultLimits}}
+	}))
+}
+
+func exchange(t *testing.T, req ExchangeRequest) (int, ExchangeResponse) {
+	t.Helper()
+	evt := testpg.APIRequest(http.MethodPost, "/exchange", req)
+	resp, err := svc.Handler(context.Background(), evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Spec.CheckResponse(evt, resp); err != nil {
+		t.Error(err)
+	}
+	var out ExchangeResponse
+	_ = json.Unmarshal([]byte(resp.Body), &out)
+	return resp.StatusCode, out
+}
+
+func TestExchangeSettlesImmediately(t *testing.T) {
+	pg := testpg.DB(t)
+	opening := testpg.Funds{"USD": 1000}
+	user := testpg.FundedUser(t, pg, opening)
+
+	status, out := exchange(t, ExchangeRequest{UserID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100})
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", status)
+	}
+	// USD->EUR executes at the bid of the mock book, 10 bps wide around 0.90
+	bid := spreads.Book{Mid: 0.90, SpreadBps: 10}.Bid()
+	wantTarget := 100 * bid * (1 - 0.003)
+	if math.Abs(out.TargetAmount-wantTarget) > 1e-8 || out.FeeBps != 30 || out.FeeVersion != 1 {
+		t.Errorf("response = %+v, want target %.8f at 30 bps (schedule v1)", out, wantTarget)
+	}
+	if out.MidRate != 0.90 || out.SpreadBps != 10 || math.Abs(out.Spread-100*(0.90-bid)) > 1e-8 || out.ClientTier != "standard" {
+		t.Errorf("response = %+v, want the spread reported apart from the fee", out)
+	}
+	var mid, spread float64
+	var spreadBps int
+	if err := pg.QueryRow(`SELECT mid_rate, spread_bps, spread FROM conversion_jobs WHERE job_id=$1`, out.JobID).Scan(&mid, &spreadBps, &spread); err != nil {
+		t.Fatal(err)
+	}
+	if mid != 0.90 || spreadBps != 10 || math.Abs(spread-out.Spread) > 1e-8 {
+		t.Errorf("job mid %v, spread %v at %d bps", mid, spread, spreadBps)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 900)
+	testpg.AssertBalance(t, pg, user, "EUR", wantTarget)
+	testpg.AssertLedgerMatchesBalances(t, pg, user, opening)
+	if got := testpg.LoadJob(t, pg, out.JobID).Status; got != "completed" {
+		t.Errorf("job status = %q", got)
+	}
+	events := testpg.Outbox(t, pg, out.JobID)
+	if len(events) != 1 || events[0].Payload["event"] != "conversion.completed" {
+		t.Errorf("outbox = %+v", events)
+	}
+}
+
+func TestExchangeSpreadByTier(t *testing.T) {
+	pg := testpg.DB(t)
+	// A tenant of its own, so its spreads price no other test
+	acme := testpg.Tenant(t, pg)
+	ctx := tenant.With(context.Background(), acme)
+	for _, q := range []string{
+		`INSERT INTO accounts (user_id, currency, balance) VALUES ('vip', 'USD', 1000), ('plain', 'USD', 1000)`,
+		`INSERT INTO users (user_id, tier) VALUES ('vip', 'premium')`,
+		`INSERT INTO fx_spreads (tier, pair, spread_bps) VALUES ('premium', 'EUR-USD', 2), (NULL, NULL, 40)`,
+	} {
+		if _, err := pg.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for user, want := range map[string]struct {
+		tier string
+		bps  int
+	}{"vip": {"premium", 2}, "plain": {"standard", 40}} {
+		req := testpg.APIRequest(http.MethodPost, "/exchange", ExchangeRequest{UserID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100})
+		req.RequestContext.Authorizer["tenant_id"] = acme
+		resp, err := svc.Handler(context.Background(), req)
+		if err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: status %d err %v (%s)", user, resp.StatusCode, err, resp.Body)
+		}
+		var out ExchangeResponse
+		_ = json.Unmarshal([]byte(resp.Body), &out)
+		bid := spreads.Book{Mid: 0.90, SpreadBps: want.bps}.Bid()
+		if out.ClientTier != want.tier || out.SpreadBps != want.bps || math.Abs(out.Rate-bid) > 1e-12 {
+			t.Errorf("%s: response = %+v, want %s at %d bps", user, out, want.tier, want.bps)
+		}
+	}
+}
+
+func TestExchangeRejectsInsufficientFunds(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 10})
+
+	status, _ := exchange(t, ExchangeRequest{UserID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", status)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 10)
+	// The rejected exchange is kept as a failed job, as on the async path
+	var jobID string
+	if err := pg.QueryRow(`SELECT job_id FROM conversion_jobs WHERE client_id=$1`, user).Scan(&jobID); err != nil {
+		t.Fatal(err)
+	}
+	if job := testpg.LoadJob(t, pg, jobID); job.Status != "failed" || job.Metadata["error"] != "INSUFFICIENT_FUNDS" {
+		t.Errorf("job = %+v, want failed with INSUFFICIENT_FUNDS", job)
+	}
+	if len(testpg.Ledger(t, pg, jobID)) != 0 {
+		t.Error("ledger entries posted for a failed exchange")
+	}
+}
+
+func TestExchangeRefusesPairWithOpenBreaker(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"GBP": 100})
+	if _, err := (rateguard.PGStore{DB: pg}).Reject(context.Background(), rateguard.Rejection{Source: "GBP", Target: "EUR", Rate: 9, Provider: "mock-fx", Reason: rateguard.ReasonDeviation}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := exchange(t, ExchangeRequest{UserID: user, SourceCurrency: "GBP", TargetCurrency: "EUR", SourceAmount: 10})
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", status)
+	}
+	// Nothing is written: the exchange rolls back as a whole
+	testpg.AssertBalance(t, pg, user, "GBP", 100)
+	var jobs int
+	if err := pg.QueryRow(`SELECT count(*) FROM conversion_jobs WHERE client_id=$1`, user).Scan(&jobs); err != nil || jobs != 0 {
+		t.Errorf("%d jobs (%v), want none", jobs, err)
+	}
+}
+
+func TestExchangeRefusesPairWithoutBook(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+
+	status, _ := exchange(t, ExchangeRequest{UserID: user, SourceCurrency: "USD", TargetCurrency: "JPY", SourceAmount: 10})
+	if status != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", status)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	var jobs int
+	if err := pg.QueryRow(`SELECT count(*) FROM conversion_jobs WHERE client_id=$1`, user).Scan(&jobs); err != nil || jobs != 0 {
+		t.Errorf("%d jobs (%v), want none", jobs, err)
+	}
+}
+
+func TestExchangeRefusesFrozenAccount(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	if _, err := pg.Exec(`UPDATE accounts SET status='frozen' WHERE user_id=$1 AND currency='USD'`, user); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := exchange(t, ExchangeRequest{UserID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 10})
+	if status != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", status)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	var jobID string
+	if err := pg.QueryRow(`SELECT job_id FROM conversion_jobs WHERE client_id=$1`, user).Scan(&jobID); err != nil {
+		t.Fatal(err)
+	}
+	if job := testpg.LoadJob(t, pg, jobID); job.Status != "failed" || job.Metadata["error"] != settlement.ReasonAccountFrozen {
+		t.Errorf("job = %+v, want failed with ACCOUNT_FROZEN", job)
+	}
+}
+
+func TestExchangeValidation(t *testing.T) {
+	testpg.DB(t)
+	for name, req := range map[string]ExchangeRequest{
+		"same currency":   {UserID: "u", SourceCurrency: "USD", TargetCurrency: "USD", SourceAmount: 1},
+		"non-positive":    {UserID: "u", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 0},
+		"missing user_id": {SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 1},
+	} {
+		if status, _ := exchange(t, req); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, status)
+		}
+	}
+}
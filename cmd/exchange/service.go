@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// ExchangeRequest expects user_id, source_currency, target_currency, amount
+// Using user_id same as client_id for now.
+type ExchangeRequest struct {
+	UserID         string  `json:"user_id"`
+	SourceCurrency string  `json:"source_currency"`
+	TargetCurrency string  `json:"target_currency"`
+	SourceAmount   float64 `json:"source_amount"`
+}
+
+type ExchangeResponse struct {
+	JobID          string  `json:"job_id"`
+	UserID         string  `json:"user_id"`
+	SourceCurrency string  `json:"source_currency"`
+	TargetCurrency string  `json:"target_currency"`
+	SourceAmount   float64 `json:"source_amount"`
+	TargetAmount   float64 `json:"target_amount"`
+	Rate           float64 `json:"rate"`
+	MidRate        float64 `json:"mid_rate"`
+	SpreadBps      int     `json:"spread_bps"`
+	Spread         float64 `json:"spread"`
+	ClientTier     string  `json:"client_tier,omitempty"`
+	Fee            float64 `json:"fee"`
+	FeeBps         int     `json:"fee_bps"`
+	FeeSchedule    string  `json:"fee_schedule_id,omitempty"`
+	FeeVersion     int     `json:"fee_schedule_version,omitempty"`
+	Status         string  `json:"status"`
+}
+
+// Service handles POST /exchange: it creates a job and settles it
+// synchronously through the shared settlement core.
+type Service struct {
+	Store settlement.Store
+	// Rates quotes the pair; main guards mockRates with the pair's breaker.
+	Rates   rateguard.Client
+	Tenants tenant.Keys
+}
+
+func validate(req ExchangeRequest) error {
+	if req.UserID == "" {
+		return &settlement.InvalidField{Field: "user_id", Err: errors.New("user_id required")}
+	}
+	return settlement.Validate(settlement.Job{SourceCurrency: req.SourceCurrency, TargetCurrency: req.TargetCurrency, SourceAmount: req.SourceAmount})
+}
+
+// errUnsupportedPair is returned for a pair the mock provider has no book for.
+var errUnsupportedPair = errors.New("rate not found")
+
+// mockRates quotes the mock provider's books as the rate service does, so a
+// rateguard.Guard can check them; fees are priced by the fee schedule.
+type mockRates struct{}
+
+func (mockRates) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	b, ok := rates.Mock.Book(source, target)
+	if !ok {
+		return rates.Response{}, fmt.Errorf("%s:%s: %w", source, target, errUnsupportedPair)
+	}
+	return rates.Response{Source: source, Target: target, Rate: b.Mid, Bid: b.Bid(), Ask: b.Ask(), SpreadBps: b.SpreadBps,
+		Provider: "mock-fx", AsOf: time.Now().UTC()}, nil
+}
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := api.Spec.Wrap(s.handle, "Exchange")(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	correlationID := logging.FromRequest(evt)
+	ctx = logging.WithCorrelationID(ctx, correlationID)
+	tenantID, err := s.Tenants.Resolve(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	ctx = tenant.With(ctx, tenantID)
+	if evt.HTTPMethod != http.MethodPost || evt.Path != "/exchange" {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	var req ExchangeRequest
+	if err := json.Unmarshal([]byte(evt.Body), &req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+	}
+	if err := validate(req); err != nil {
+		var invalid *settlement.InvalidField
+		errors.As(err, &invalid)
+		return problem.RespondInvalid(ctx, invalid.Field, err.Error())
+	}
+
+	job := settlement.Job{ID: uuid.NewString(), ClientID: req.UserID, SourceCurrency: req.SourceCurrency, TargetCurrency: req.TargetCurrency,
+		SourceAmount: req.SourceAmount, CorrelationID: correlationID, CreatedAt: time.Now().UTC()}
+	ctx = logging.With(ctx, "job_id", job.ID, "user_id", req.UserID)
+	metrics.Count(metrics.JobsCreated, 1, metrics.Pair(req.SourceCurrency, req.TargetCurrency))
+
+	settler := settlement.Settler{Store: s.Store, Rates: settlement.RateFunc(rates.Books(s.Rates))}
+	res, err := settler.CreateAndSettle(ctx, job)
+	switch {
+	case errors.Is(err, errUnsupportedPair):
+		return problem.Respond(ctx, http.StatusNotFound, problem.UnsupportedPair, err.Error())
+	case errors.Is(err, rateguard.ErrBreakerOpen):
+		return problem.Respond(ctx, http.StatusServiceUnavailable, problem.RateUnavailable, err.Error())
+	case err != nil:
+		return problem.ServerError(ctx, err)
+	}
+	switch res.Reason {
+	case "":
+	case settlement.ReasonInsufficientFunds:
+		return problem.Respond(ctx, http.StatusUnprocessableEntity, res.Reason, "insufficient funds")
+	case settlement.ReasonUserFrozen, settlement.ReasonUserClosed, settlement.ReasonAccountFrozen, settlement.ReasonAccountClosed:
+		return problem.Respond(ctx, http.StatusForbidden, res.Reason, "")
+	default:
+		return problem.Respond(ctx, http.StatusBadRequest, res.Reason, "")
+	}
+
+	resp := ExchangeResponse{JobID: job.ID, UserID: req.UserID, SourceCurrency: req.SourceCurrency, TargetCurrency: req.TargetCurrency, SourceAmount: req.SourceAmount,
+		TargetAmount: res.TargetAmount, Rate: res.Rate, MidRate: res.Spread.MidRate, SpreadBps: res.Spread.SpreadBps, Spread: res.Spread.Spread, ClientTier: res.Spread.Tier, Fee: res.Quote.Fee, FeeBps: res.Quote.FeeBps, FeeSchedule: res.Quote.ScheduleID, FeeVersion: res.Quote.ScheduleVersion, Status: res.Status}
+	b, _ := json.Marshal(resp)
+	return events.APIGatewayProxyResponse{StatusCode: 201, Headers: map[string]string{"Content-Type": "application/json", logging.Header: correlationID}, Body: string(b)}, nil
+}
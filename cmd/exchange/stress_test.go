@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// TestStressConcurrentExchanges fires a deterministic workload of exchanges in
+// both directions across a small set of users, so the same accounts are
+// locked from opposite sides concurrently. Every request must either settle
+// or be rejected for funds (no deadlock surfacing as a 500), and the books
+// must balance afterwards.
+func TestStressConcurrentExchanges(t *testing.T) {
+	pg := testpg.DB(t)
+	n := testpg.StressOps(t)
+	opening := testpg.Funds{"USD": 5000, "EUR": 5000, "GBP": 5000}
+	users := make([]string, 4)
+	for i := range users {
+		users[i] = testpg.FundedUser(t, pg, opening)
+	}
+
+	var settled, rejected atomic.Int64
+	testpg.Parallel(32, testpg.Workload(33, n, users, "USD", "EUR", "GBP"), func(op testpg.Conversion) {
+		req := ExchangeRequest{UserID: op.User, SourceCurrency: op.Source, TargetCurrency: op.Target, SourceAmount: op.Amount}
+		resp, err := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/exchange", req))
+		switch {
+		case err != nil:
+			t.Errorf("%+v: %v", op, err)
+		case resp.StatusCode == http.StatusCreated:
+			settled.Add(1)
+		case resp.StatusCode == http.StatusUnprocessableEntity:
+			rejected.Add(1)
+		default:
+			t.Errorf("%+v: status %d %s", op, resp.StatusCode, resp.Body)
+		}
+	})
+	t.Logf("%d exchanges: %d settled, %d rejected for funds", n, settled.Load(), rejected.Load())
+
+	testpg.AssertNoNegativeBalances(t, pg)
+	for _, u := range users {
+		testpg.AssertLedgerMatchesBalances(t, pg, u, opening)
+	}
+	statuses := testpg.JobStatuses(t, pg, users)
+	if int64(statuses["completed"]) != settled.Load() || int64(statuses["failed"]) != rejected.Load() {
+		t.Errorf("jobs = %v, want %d completed and %d failed", statuses, settled.Load(), rejected.Load())
+	}
+}
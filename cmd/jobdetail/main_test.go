@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}} }))
+}
+
+// completedJob inserts a settled job for user and returns its id.
+func completedJob(t *testing.T, pg *sql.DB, user string) string {
+	t.Helper()
+	id := testpg.QueuedJob(t, pg, user, "USD", "EUR", 100)
+	if _, err := pg.Exec(`UPDATE conversion_jobs SET status='completed', target_amount=89.73, rate=0.9, fee=0.27, completed_at=now() WHERE job_id=$1`, id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestJobDetailReturnsCompletedJob(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 0})
+	id := completedJob(t, pg, user)
+
+	req := testpg.APIRequest(http.MethodGet, "/jobs/"+id, nil)
+	req.PathParameters = map[string]string{"job_id": id}
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d err %v", resp.StatusCode, err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	var j Job
+	if err := json.Unmarshal([]byte(resp.Body), &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.JobID != id || j.TargetAmount != 89.73 || j.Status != "completed" {
+		t.Errorf("job = %+v", j)
+	}
+
+	// another user's filter must not reveal it; queued jobs are not listed
+	req.QueryStringParameters["user_id"] = "someone-else"
+	if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("foreign user: status = %d, want 404", resp.StatusCode)
+	}
+	req.QueryStringParameters["user_id"] = user
+	req.RequestContext.Authorizer["tenant_id"] = testpg.Tenant(t, pg)
+	if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("another tenant: status = %d, want 404", resp.StatusCode)
+	}
+	queued := testpg.QueuedJob(t, pg, user, "USD", "EUR", 1)
+	req = testpg.APIRequest(http.MethodGet, "/jobs/"+queued, nil)
+	req.PathParameters = map[string]string{"job_id": queued}
+	if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("queued job: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestJobListByUser(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 0})
+	for i := 0; i < 3; i++ {
+		completedJob(t, pg, user)
+	}
+
+	req := testpg.APIRequest(http.MethodGet, "/jobs?user_id="+user+"&limit=2", nil)
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d err %v", resp.StatusCode, err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	var out struct {
+		UserID string `json:"user_id"`
+		Jobs   []Job  `json:"jobs"`
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.UserID != user || len(out.Jobs) != 2 {
+		t.Errorf("got %d jobs for %q, want 2", len(out.Jobs), out.UserID)
+	}
+}
+
+func TestBatchAggregatesLegs(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 0})
+	done, queued := completedJob(t, pg, user), testpg.QueuedJob(t, pg, user, "USD", "GBP", 5)
+	batchID := testpg.Batch(t, pg, user, false, done, queued)
+
+	get := func() (int, Batch) {
+		req := testpg.APIRequest(http.MethodGet, "/batches/"+batchID, nil)
+		req.PathParameters = map[string]string{"batch_id": batchID}
+		resp, err := svc.Handler(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b Batch
+		_ = json.Unmarshal([]byte(resp.Body), &b)
+		return resp.StatusCode, b
+	}
+	status, b := get()
+	if status != http.StatusOK || b.Status != "in_progress" || len(b.Legs) != 2 || b.Legs[0].JobID != done || b.Legs[0].TargetAmount != 89.73 {
+		t.Fatalf("status %d batch %+v", status, b)
+	}
+	if b.Counts["completed"] != 1 || b.Counts["queued"] != 1 {
+		t.Errorf("counts = %v", b.Counts)
+	}
+
+	if _, err := pg.Exec(`UPDATE conversion_jobs SET status='failed', metadata='{"error":"INSUFFICIENT_FUNDS"}' WHERE job_id=$1`, queued); err != nil {
+		t.Fatal(err)
+	}
+	if _, b = get(); b.Status != "partially_completed" || b.Legs[1].Error != "INSUFFICIENT_FUNDS" {
+		t.Errorf("batch = %+v, want partially_completed with the leg's reason", b)
+	}
+
+	req := testpg.APIRequest(http.MethodGet, "/batches/not-a-uuid", nil)
+	req.PathParameters = map[string]string{"batch_id": "not-a-uuid"}
+	if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("bad id: status = %d, want 404", resp.StatusCode)
+	}
+}
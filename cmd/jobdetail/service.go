@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+type Job struct {
+	JobID          string    `json:"job_id"`
+	ClientID       string    `json:"client_id"`
+	SourceCurrency string    `json:"source_currency"`
+	TargetCurrency string    `json:"target_currency"`
+	SourceAmount   float64   `json:"source_amount"`
+	TargetAmount   float64   `json:"target_amount"`
+	Rate           float64   `json:"rate"`
+	Fee            float64   `json:"fee"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+	CompletedAt    time.Time `json:"completed_at"`
+
+	// Rate is the bid; these are nil for jobs completed before the spread
+	// was recorded.
+	MidRate   *float64 `json:"mid_rate,omitempty"`
+	SpreadBps *int     `json:"spread_bps,omitempty"`
+	Spread    *float64 `json:"spread,omitempty"`
+}
+
+// BatchLeg is one job of a batch, in any status.
+type BatchLeg struct {
+	Index          int     `json:"index"`
+	JobID          string  `json:"job_id"`
+	SourceCurrency string  `json:"source_currency"`
+	TargetCurrency string  `json:"target_currency"`
+	SourceAmount   float64 `json:"source_amount"`
+	TargetAmount   float64 `json:"target_amount,omitempty"`
+	Status         string  `json:"status"`
+	Error          string  `json:"error,omitempty"` // failure reason
+}
+
+// Batch is the progress of a POST /jobs/batch submission.
+type Batch struct {
+	BatchID      string         `json:"batch_id"`
+	ClientID     string         `json:"client_id"`
+	AllOrNothing bool           `json:"all_or_nothing"`
+	Status       string         `json:"status"` // in_progress, completed, failed or partially_completed
+	Counts       map[string]int `json:"counts"` // legs per job status
+	CreatedAt    time.Time      `json:"created_at"`
+	Legs         []BatchLeg     `json:"legs"`
+}
+
+// summarize derives Counts and Status from the legs.
+func (b *Batch) summarize() {
+	b.Counts = map[string]int{"queued": 0, "completed": 0, "failed": 0}
+	for _, l := range b.Legs {
+		b.Counts[l.Status]++
+	}
+	switch {
+	case b.Counts["queued"] > 0:
+		b.Status = "in_progress"
+	case b.Counts["failed"] == 0:
+		b.Status = "completed"
+	case b.Counts["completed"] == 0:
+		b.Status = "failed"
+	default:
+		b.Status = "partially_completed"
+	}
+}
+
+// Store reads completed jobs and batch progress.
+type Store interface {
+	// Job returns a completed job, restricted to userID when set, or sql.ErrNoRows.
+	Job(ctx context.Context, jobID, userID string) (Job, error)
+	// Jobs lists the user's completed jobs, most recent first.
+	Jobs(ctx context.Context, userID string, limit int) ([]Job, error)
+	// Batch returns a batch with all its legs, restricted to userID when set, or sql.ErrNoRows.
+	Batch(ctx context.Context, batchID, userID string) (Batch, error)
+}
+
+// Service handles GET /jobs, GET /jobs/{job_id} and GET /batches/{batch_id}.
+type Service struct {
+	Store   Store
+	Tenants tenant.Keys
+}
+
+// Handler supports:
+// 1. GET /jobs/{job_id}?user_id=...  -> single completed job (optionally verify user)
+// 2. GET /jobs?user_id=...&limit=N   -> list of completed jobs for user (default limit 50)
+// 3. GET /batches/{batch_id}?user_id=... -> batch progress with every leg
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := api.Spec.Wrap(s.handle, "ListJobs", "GetJob", "GetBatch")(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.WithCorrelationID(ctx, logging.FromRequest(evt))
+	tenantID, err := s.Tenants.Resolve(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	ctx = tenant.With(ctx, tenantID)
+	if evt.HTTPMethod != http.MethodGet {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+
+	if batchID := evt.PathParameters["batch_id"]; batchID != "" {
+		if uuid.Validate(batchID) != nil {
+			return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+		}
+		b, err := s.Store.Batch(ctx, batchID, evt.QueryStringParameters["user_id"])
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+			}
+			return problem.ServerError(ctx, err)
+		}
+		b.summarize()
+		body, _ := json.Marshal(b)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+	}
+
+	jobID := evt.PathParameters["job_id"]
+	if jobID != "" { // single job path
+		j, err := s.Store.Job(ctx, jobID, evt.QueryStringParameters["user_id"])
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+			}
+			return problem.ServerError(ctx, err)
+		}
+		b, _ := json.Marshal(j)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(b), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+	}
+
+	// list mode requires user_id
+	userID := evt.QueryStringParameters["user_id"]
+	if userID == "" {
+		return problem.RespondInvalid(ctx, "user_id", "user_id required")
+	}
+	limit := 50
+	if lStr := evt.QueryStringParameters["limit"]; lStr != "" {
+		if n, err := strconv.Atoi(lStr); err == nil && n > 0 && n <= 500 {
+			limit = n
+		}
+	}
+	jobs, err := s.Store.Jobs(ctx, userID, limit)
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	out := struct {
+		UserID string `json:"user_id"`
+		Jobs   []Job  `json:"jobs"`
+	}{UserID: userID, Jobs: jobs}
+	b, _ := json.Marshal(out)
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(b), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+const jobColumns = `job_id, client_id, source_currency, target_currency, source_amount, target_amount, rate, fee, status, created_at, completed_at, mid_rate, spread_bps, spread`
+
+func (s pgStore) Job(ctx context.Context, jobID, userID string) (Job, error) {
+	query := `SELECT ` + jobColumns + `
+	FROM conversion_jobs WHERE job_id=$1 AND status='completed'`
+	args := []any{jobID}
+	if userID != "" {
+		query += " AND client_id=$2"
+		args = append(args, userID)
+	}
+	var j Job
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&j.JobID, &j.ClientID, &j.SourceCurrency, &j.TargetCurrency, &j.SourceAmount, &j.TargetAmount, &j.Rate, &j.Fee, &j.Status, &j.CreatedAt, &j.CompletedAt, &j.MidRate, &j.SpreadBps, &j.Spread)
+	return j, err
+}
+
+func (s pgStore) Jobs(ctx context.Context, userID string, limit int) ([]Job, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+`
+	FROM conversion_jobs WHERE client_id=$1 AND status='completed' ORDER BY completed_at DESC NULLS LAST, created_at DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Job
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.JobID, &j.ClientID, &j.SourceCurrency, &j.TargetCurrency, &j.SourceAmount, &j.TargetAmount, &j.Rate, &j.Fee, &j.Status, &j.CreatedAt, &j.CompletedAt, &j.MidRate, &j.SpreadBps, &j.Spread); err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+func (s pgStore) Batch(ctx context.Context, batchID, userID string) (Batch, error) {
+	query := `SELECT batch_id, client_id, all_or_nothing, created_at FROM conversion_batches WHERE batch_id=$1`
+	args := []any{batchID}
+	if userID != "" {
+		query += " AND client_id=$2"
+		args = append(args, userID)
+	}
+	var b Batch
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&b.BatchID, &b.ClientID, &b.AllOrNothing, &b.CreatedAt); err != nil {
+		return Batch{}, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT batch_index, job_id, source_currency, target_currency, source_amount, COALESCE(target_amount, 0), status, COALESCE(metadata->>'error', '')
+	FROM conversion_jobs WHERE batch_id=$1 ORDER BY batch_index`, batchID)
+	if err != nil {
+		return Batch{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l BatchLeg
+		if err := rows.Scan(&l.Index, &l.JobID, &l.SourceCurrency, &l.TargetCurrency, &l.SourceAmount, &l.TargetAmount, &l.Status, &l.Error); err != nil {
+			return Batch{}, err
+		}
+		b.Legs = append(b.Legs, l)
+	}
+	return b, rows.Err()
+}
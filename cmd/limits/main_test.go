@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// testRates is what the watcher sees; tests move it to trigger orders.
+var testRates = fixedRates{"USD:EUR": 0.90}
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{settlement.PGStore{DB: d}}, Rates: testRates} }))
+}
+
+func TestWatcherSettlesOrderOnceLimitIsMet(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	id := testpg.LimitOrder(t, pg, user, "USD", "EUR", 100, 0.95, time.Time{})
+
+	if err := svc.Run(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if job := testpg.LoadJob(t, pg, id); job.Status != "pending" {
+		t.Fatalf("status at 0.90 = %s, want pending", job.Status)
+	}
+	testpg.AssertHeld(t, pg, user, "USD", 100)
+
+	testRates["USD:EUR"] = 0.96
+	defer func() { testRates["USD:EUR"] = 0.90 }()
+	if err := svc.Run(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	job := testpg.LoadJob(t, pg, id)
+	if job.Status != "completed" || job.Rate.Float64 != 0.96 {
+		t.Fatalf("job = %+v, want completed at 0.96", job)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 0)
+	testpg.AssertHeld(t, pg, user, "USD", 0)
+	testpg.AssertLedgerMatchesBalances(t, pg, user, testpg.Funds{"USD": 100})
+	if events := testpg.Outbox(t, pg, id); len(events) != 1 || events[0].Payload["event"] != "conversion.completed" {
+		t.Errorf("outbox = %+v, want one conversion.completed", events)
+	}
+}
+
+func TestWatcherCancelsExpiredOrders(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	id := testpg.LimitOrder(t, pg, user, "USD", "EUR", 60, 0.80, time.Now().Add(-time.Second)) // met, but expired
+	live := testpg.LimitOrder(t, pg, user, "USD", "EUR", 40, 2, time.Now().Add(time.Hour))
+
+	if err := svc.Run(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	job := testpg.LoadJob(t, pg, id)
+	if job.Status != "cancelled" || job.Metadata["error"] != settlement.ReasonLimitExpired {
+		t.Fatalf("expired order = %+v, want cancelled (LIMIT_EXPIRED)", job)
+	}
+	if events := testpg.Outbox(t, pg, id); len(events) != 1 || events[0].Payload["event"] != "conversion.cancelled" {
+		t.Errorf("outbox = %+v, want one conversion.cancelled", events)
+	}
+	if got := testpg.LoadJob(t, pg, live).Status; got != "pending" {
+		t.Errorf("live order = %s, want pending", got)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	testpg.AssertHeld(t, pg, user, "USD", 40)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/deadline"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/spreads"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+const batchSize = 100
+
+// Pair is a currency pair with pending limit orders.
+type Pair struct{ Source, Target string }
+
+// Store is the watcher's persistence: the settlement store plus the pending
+// limit order queries. The queries do not lock; settlement re-checks that
+// each order is still pending.
+type Store interface {
+	settlement.Store
+	// Expired returns up to limit pending limit orders whose expires_at is at
+	// or before now, oldest expiry first.
+	Expired(ctx context.Context, now time.Time, limit int) ([]settlement.Job, error)
+	// Pairs returns the pairs with pending limit orders.
+	Pairs(ctx context.Context) ([]Pair, error)
+	// Triggered returns the pair's pending limit orders whose limit rate is
+	// at most rate, oldest first.
+	Triggered(ctx context.Context, pair Pair, rate float64, now time.Time) ([]settlement.Job, error)
+}
+
+// RateClient fetches the FX rate for a pair.
+type RateClient interface {
+	Rate(ctx context.Context, source, target string) (rates.Response, error)
+}
+
+// Service cancels expired limit orders and settles those whose limit the
+// current rate meets.
+type Service struct {
+	Store Store
+	Rates RateClient
+}
+
+// Handler runs one pass per scheduled invocation.
+func (s *Service) Handler(ctx context.Context, _ events.CloudWatchEvent) error {
+	defer tracing.Flush(ctx)
+	return s.Run(ctx, time.Now())
+}
+
+// Run expires overdue orders, then re-evaluates every pair with pending
+// orders against one rate lookup. A pair whose rate cannot be fetched is
+// skipped until the next run.
+func (s *Service) Run(ctx context.Context, now time.Time) error {
+	ctx, span := tracing.Start(tenant.With(ctx, tenant.All), "limits.run")
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	settler := settlement.Settler{Store: s.Store}
+	if err = s.expire(ctx, &settler, now); err != nil {
+		return err
+	}
+	var pairs []Pair
+	if pairs, err = s.Store.Pairs(ctx); err != nil {
+		return fmt.Errorf("pairs: %w", err)
+	}
+	for _, pair := range pairs {
+		if deadline.Near(ctx) {
+			return nil
+		}
+		s.evaluate(ctx, pair, now)
+	}
+	return nil
+}
+
+func (s *Service) expire(ctx context.Context, settler *settlement.Settler, now time.Time) error {
+	for !deadline.Near(ctx) {
+		orders, err := s.Store.Expired(ctx, now, batchSize)
+		if err != nil {
+			return fmt.Errorf("expired orders: %w", err)
+		}
+		for _, job := range orders {
+			if _, err := settler.Cancel(jobContext(ctx, job), job, settlement.ReasonLimitExpired); err != nil {
+				return fmt.Errorf("cancel %s: %w", job.ID, err)
+			}
+		}
+		if len(orders) < batchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// evaluate settles the pair's orders whose limit the current rate meets.
+// Orders are selected by the mid; each settles at the bid of the book that
+// selected it, for its client's spread, so one whose limit the bid misses
+// stays pending.
+func (s *Service) evaluate(ctx context.Context, pair Pair, now time.Time) {
+	ctx = logging.With(ctx, "pair", pair.Source+":"+pair.Target)
+	resp, err := rates.Lookup(ctx, s.Rates, pair.Source, pair.Target)
+	if err != nil {
+		slog.WarnContext(ctx, "rate lookup failed", "error", err)
+		return
+	}
+	orders, err := s.Store.Triggered(ctx, pair, resp.Rate, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "triggered orders query failed", "error", err)
+		return
+	}
+	settler := settlement.Settler{Store: s.Store, Rates: settlement.RateFunc(func(context.Context, string, string) (spreads.Book, error) {
+		return resp.Book(), nil
+	})}
+	for _, job := range orders {
+		jobCtx := jobContext(ctx, job)
+		if _, err := settler.Settle(jobCtx, job); err != nil {
+			slog.ErrorContext(jobCtx, "limit order settlement failed", "error", err)
+		}
+	}
+}
+
+func jobContext(ctx context.Context, job settlement.Job) context.Context {
+	return logging.With(logging.WithCorrelationID(ctx, job.CorrelationID), "job_id", job.ID, "user_id", job.ClientID)
+}
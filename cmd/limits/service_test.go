@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/settlement/settlementtest"
+)
+
+// fixedRates is a RateClient serving a static table.
+type fixedRates map[string]float64
+
+func (r fixedRates) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	rate, ok := r[source+":"+target]
+	if !ok {
+		return rates.Response{}, errors.New("rate not found")
+	}
+	return rates.Response{Source: source, Target: target, Rate: rate, Provider: "test"}, nil
+}
+
+// memStore answers the order queries from the pending limit orders it was given.
+type memStore struct {
+	*settlementtest.MemStore
+	orders  []settlement.Job
+	expires map[string]time.Time
+}
+
+func (s *memStore) add(job settlement.Job, expires time.Time) {
+	s.AddLimitOrder(job)
+	s.orders = append(s.orders, job)
+	s.expires[job.ID] = expires
+}
+
+func (s *memStore) pending() []settlement.Job {
+	var out []settlement.Job
+	for _, j := range s.orders {
+		if s.Jobs[j.ID] == settlement.StatusPending {
+			out = append(out, j)
+		}
+	}
+	return out
+}
+
+func (s *memStore) Expired(_ context.Context, now time.Time, _ int) ([]settlement.Job, error) {
+	var out []settlement.Job
+	for _, j := range s.pending() {
+		if exp := s.expires[j.ID]; !exp.IsZero() && !exp.After(now) {
+			out = append(out, j)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) Pairs(context.Context) ([]Pair, error) {
+	seen := map[Pair]bool{}
+	var out []Pair
+	for _, j := range s.pending() {
+		if p := (Pair{j.SourceCurrency, j.TargetCurrency}); !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) Triggered(_ context.Context, pair Pair, rate float64, _ time.Time) ([]settlement.Job, error) {
+	var out []settlement.Job
+	for _, j := range s.pending() {
+		if j.SourceCurrency == pair.Source && j.TargetCurrency == pair.Target && j.LimitRate <= rate {
+			out = append(out, j)
+		}
+	}
+	return out, nil
+}
+
+func order(id, source, target string, limit float64) settlement.Job {
+	return settlement.Job{ID: id, ClientID: "u1", SourceCurrency: source, TargetCurrency: target, SourceAmount: 100, LimitRate: limit}
+}
+
+func TestRunSettlesTriggeredAndExpiresOverdue(t *testing.T) {
+	now := time.Now()
+	store := &memStore{MemStore: settlementtest.New(), expires: map[string]time.Time{}}
+	store.Fund("u1", map[string]float64{"USD": 300, "EUR": 100})
+	store.add(order("met", "USD", "EUR", 0.9), time.Time{})
+	store.add(order("unmet", "USD", "EUR", 0.95), time.Time{})
+	store.add(order("expired", "USD", "EUR", 0.95), now.Add(-time.Minute))
+	store.add(order("no-rate", "EUR", "JPY", 100), time.Time{})
+	svc := &Service{Store: store, Rates: fixedRates{"USD:EUR": 0.9}}
+
+	if err := svc.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{"met": "completed", "unmet": "pending", "expired": "cancelled", "no-rate": "pending"} {
+		if got := store.Jobs[id]; got != want {
+			t.Errorf("%s = %s, want %s", id, got, want)
+		}
+	}
+	if r := store.Results["met"]; r.Rate != 0.9 {
+		t.Errorf("met settled at %v, want 0.9", r.Rate)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 200 || usd.Held != 100 {
+		t.Errorf("USD = %+v, want 200 with 100 still held for the unmet order", usd)
+	}
+}
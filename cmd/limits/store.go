@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ settlement.PGStore }
+
+const orderColumns = `job_id, client_id, source_currency, target_currency, source_amount, limit_rate, COALESCE(metadata->>'correlation_id', ''), created_at`
+
+func (s pgStore) Expired(ctx context.Context, now time.Time, limit int) ([]settlement.Job, error) {
+	return s.orders(ctx, `SELECT `+orderColumns+` FROM conversion_jobs
+		WHERE status='pending' AND expires_at <= $1 ORDER BY expires_at LIMIT $2`, now, limit)
+}
+
+func (s pgStore) Pairs(ctx context.Context) ([]Pair, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT DISTINCT source_currency, target_currency FROM conversion_jobs WHERE status='pending' AND limit_rate IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pairs []Pair
+	for rows.Next() {
+		var p Pair
+		if err := rows.Scan(&p.Source, &p.Target); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, rows.Err()
+}
+
+// Triggered leaves orders expiring by now to Expired.
+func (s pgStore) Triggered(ctx context.Context, pair Pair, rate float64, now time.Time) ([]settlement.Job, error) {
+	return s.orders(ctx, `SELECT `+orderColumns+` FROM conversion_jobs
+		WHERE status='pending' AND source_currency=$1 AND target_currency=$2 AND limit_rate <= $3 AND (expires_at IS NULL OR expires_at > $4)
+		ORDER BY created_at`, pair.Source, pair.Target, rate, now)
+}
+
+func (s pgStore) orders(ctx context.Context, query string, args ...any) ([]settlement.Job, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []settlement.Job
+	for rows.Next() {
+		var j settlement.Job
+		var limit sql.NullFloat64
+		if err := rows.Scan(&j.ID, &j.ClientID, &j.SourceCurrency, &j.TargetCurrency, &j.SourceAmount, &limit, &j.CorrelationID, &j.CreatedAt); err != nil {
+			return nil, err
+		}
+		j.LimitRate = limit.Float64
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
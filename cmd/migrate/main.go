@@ -0,0 +1,138 @@
+// Command migrate applies the SQL migrations embedded from db/migrations.
+//
+//	migrate up               apply pending migrations
+//	migrate status           list migrations and when they were applied
+//	migrate down-to VERSION  revert migrations newer than VERSION
+//
+// Connection settings come from DATABASE_URL or the DB_* variables used by the
+// Lambdas, naming the role that owns the schema rather than jobs_app. With
+// APP_DB_PASSWORD set, up also sets the password jobs_app logs in with.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/migrate"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func dsn() string {
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		return v
+	}
+	host := getenv("DB_HOST", "localhost")
+	port := getenv("DB_PORT", "5432")
+	user := getenv("DB_USER", "postgres")
+	pass := getenv("DB_PASSWORD", "postgrespw")
+	name := getenv("DB_NAME", "jobsdb")
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, pass, host, port, name)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | status | down-to VERSION")
+	os.Exit(2)
+}
+
+func main() {
+	logging.Init("migrate")
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	db, err := sql.Open("pgx", dsn())
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+	r, err := migrate.New(db)
+	if err != nil {
+		fail(err)
+	}
+	r.Logf = func(format string, args ...any) { slog.Info(fmt.Sprintf(format, args...)) }
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := r.Up(ctx)
+		if err != nil {
+			fail(err)
+		}
+		slog.Info("migrations applied", "count", len(applied), "version", r.Latest())
+		if pass := os.Getenv("APP_DB_PASSWORD"); pass != "" {
+			if err := setAppPassword(ctx, db, pass); err != nil {
+				fail(err)
+			}
+			slog.Info("app role password set", "role", appRole)
+		}
+	case "status":
+		st, err := r.Status(ctx)
+		if err != nil {
+			fail(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tSTATE")
+		for _, s := range st {
+			applied, state := "-", "pending"
+			if s.AppliedAt != nil {
+				applied, state = s.AppliedAt.Format("2006-01-02 15:04:05Z07:00"), "applied"
+			}
+			if s.Modified {
+				state = "MODIFIED"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, applied, state)
+		}
+		w.Flush()
+	case "down-to":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		version, err := strconv.Atoi(os.Args[2])
+		if err != nil || version < 0 {
+			usage()
+		}
+		reverted, err := r.DownTo(ctx, version)
+		if err != nil {
+			fail(err)
+		}
+		slog.Info("migrations reverted", "count", len(reverted), "version", version)
+	default:
+		usage()
+	}
+}
+
+// appRole is the role the services connect as (db/migrations/0018_app_role.sql).
+const appRole = "jobs_app"
+
+// setAppPassword lets appRole log in with pass. ALTER ROLE takes no
+// parameters, so the statement is quoted by the server.
+func setAppPassword(ctx context.Context, db *sql.DB, pass string) error {
+	var stmt string
+	if err := db.QueryRowContext(ctx, `SELECT format('ALTER ROLE %I LOGIN PASSWORD %L', $1::text, $2::text)`, appRole, pass).Scan(&stmt); err != nil {
+		return fmt.Errorf("set %s password: %w", appRole, err)
+	}
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("set %s password: %w", appRole, err)
+	}
+	return nil
+}
+
+func fail(err error) {
+	slog.Error("migrate failed", "error", err)
+	os.Exit(1)
+}
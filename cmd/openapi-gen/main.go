@@ -0,0 +1,30 @@
+// Command openapi-gen writes the Go client for api/openapi.json.
+//
+//	openapi-gen -pkg apiclient -o client.gen.go
+//
+// It is run by go generate in apiclient.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/openapi"
+)
+
+func main() {
+	pkg := flag.String("pkg", "apiclient", "package name of the generated file")
+	out := flag.String("o", "client.gen.go", "file to write")
+	flag.Parse()
+
+	src, err := openapi.Generate(api.Spec, *pkg)
+	if err == nil {
+		err = os.WriteFile(*out, src, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "openapi-gen:", err)
+		os.Exit(1)
+	}
+}
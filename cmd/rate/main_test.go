@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var store pgStore
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { store = pgStore{db: d} }))
+}
+
+func TestCandlesAggregateTicks(t *testing.T) {
+	testpg.DB(t)
+	ctx := context.Background()
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, tick := range []struct {
+		rate float64
+		at   time.Duration
+	}{
+		{0.90, 5 * time.Minute}, {0.93, 20 * time.Minute}, {0.88, 40 * time.Minute}, {0.91, 40 * time.Minute}, // same time: insertion order
+		{0.95, 2*time.Hour + time.Minute},
+		{0.99, 3 * time.Hour}, // outside [from, to)
+	} {
+		if err := store.Record(ctx, RateResponse{Source: "AUD", Target: "NZD", Rate: tick.rate, Provider: "feed"}, hour.Add(tick.at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	candles, err := store.Candles(ctx, "AUD", "NZD", hour, hour.Add(3*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Candle{
+		{Start: hour, Open: 0.90, High: 0.93, Low: 0.88, Close: 0.91, Ticks: 4},
+		{Start: hour.Add(2 * time.Hour), Open: 0.95, High: 0.95, Low: 0.95, Close: 0.95, Ticks: 1},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("candles = %+v, want %+v", candles, want)
+	}
+	for i := range want {
+		if !candles[i].Start.Equal(want[i].Start) || candles[i].Open != want[i].Open || candles[i].High != want[i].High ||
+			candles[i].Low != want[i].Low || candles[i].Close != want[i].Close || candles[i].Ticks != want[i].Ticks {
+			t.Errorf("candle %d = %+v, want %+v", i, candles[i], want[i])
+		}
+	}
+
+	q, err := store.Latest(ctx, "AUD", "NZD")
+	if err != nil || q.Rate != 0.99 || !q.AsOf.Equal(hour.Add(3*time.Hour)) {
+		t.Errorf("latest = %+v, %v", q, err)
+	}
+	if _, err := store.Latest(ctx, "NZD", "AUD"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("latest of an unquoted pair: %v", err)
+	}
+}
+
+func TestRateServedIsStored(t *testing.T) {
+	testpg.DB(t)
+	s := &Service{Books: rates.Mock, Store: store}
+	_, body := get(t, s, "/rates/EUR-GBP", nil)
+	var q Quote
+	_ = json.Unmarshal(body, &q)
+	_, body = get(t, s, "/rates/EUR-GBP/history", map[string]string{"interval": "1m"})
+	var h History
+	_ = json.Unmarshal(body, &h)
+	// EUR-GBP is quoted from the GBP:EUR book; ticks keep 12 decimals
+	want := 1 / 0.90
+	if math.Abs(q.Rate-want) > 1e-9 || len(h.Candles) != 1 || math.Abs(h.Candles[0].Close-want) > 1e-9 || h.Candles[0].Ticks != 1 {
+		t.Errorf("quote %+v history %+v", q, h)
+	}
+	if status, _ := get(t, s, "/rates/EUR-GBP", nil); status != http.StatusOK {
+		t.Errorf("status = %d", status)
+	}
+}
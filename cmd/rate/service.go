@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/spreads"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// RateResponse represents FX rate information: Rate is the mid, and Bid and
+// Ask are the pair's spread around it (internal/spreads). A tenant may price
+// its clients at another spread, and fees are not quoted here; both are
+// applied at settlement. AsOf is stamped when the rate is served, so callers
+// can refuse stale quotes.
+type RateResponse struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	Rate      float64   `json:"rate"`
+	Bid       float64   `json:"bid"`
+	Ask       float64   `json:"ask"`
+	SpreadBps int       `json:"spread_bps"`
+	Provider  string    `json:"provider"`
+	AsOf      time.Time `json:"as_of"`
+}
+
+// Quote is the latest rate of a pair and how long ago it was quoted.
+type Quote struct {
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	Rate       float64   `json:"rate"`
+	Provider   string    `json:"provider"`
+	AsOf       time.Time `json:"as_of"`
+	AgeSeconds float64   `json:"age_seconds"`
+}
+
+// Candle is the open, high, low and close of the ticks in one interval
+// starting at Start.
+type Candle struct {
+	Start time.Time `json:"start"`
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+	Ticks int       `json:"ticks"`
+}
+
+// History is a pair's candles between From and To, oldest first. Intervals
+// without ticks have no candle.
+type History struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Interval string    `json:"interval"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Candles  []Candle  `json:"candles"`
+}
+
+// Store keeps the rate history.
+type Store interface {
+	// Record stores a rate served or ingested at t.
+	Record(ctx context.Context, r RateResponse, t time.Time) error
+	// Latest returns the pair's most recent tick, or sql.ErrNoRows.
+	Latest(ctx context.Context, source, target string) (Quote, error)
+	// Candles aggregates the pair's ticks in [from, to) into candles of the
+	// interval, aligned to the Unix epoch.
+	Candles(ctx context.Context, source, target string, from, to time.Time, interval time.Duration) ([]Candle, error)
+}
+
+// intervals are the candle sizes GET /rates/{pair}/history accepts.
+var intervals = map[string]time.Duration{
+	"1m": time.Minute, "5m": 5 * time.Minute, "15m": 15 * time.Minute,
+	"1h": time.Hour, "4h": 4 * time.Hour, "1d": 24 * time.Hour,
+}
+
+const (
+	// defaultCandles is how far back history goes without from.
+	defaultCandles = 100
+	maxCandles     = 1000
+)
+
+var pairCode = regexp.MustCompile(`^([A-Z]{3})-([A-Z]{3})$`)
+
+// Service serves rates from static books and records each one it serves.
+type Service struct {
+	Books spreads.Books
+	// StaticRate, when positive, is served without a spread for pairs
+	// missing from Books.
+	StaticRate float64
+	Store      Store
+}
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, req)
+	defer tracing.Flush(ctx)
+	resp, err := api.Spec.Wrap(s.handle, "GetRate", "GetRateQuote", "GetRateHistory")(ctx, req)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *Service) handle(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.WithCorrelationID(ctx, logging.FromRequest(req))
+	// GET /rates/{pair} and GET /rates/{pair}/history
+	if parts := strings.Split(strings.Trim(req.Path, "/"), "/"); parts[0] == "rates" {
+		if req.HTTPMethod != http.MethodGet || len(parts) < 2 || len(parts) > 3 || len(parts) == 3 && parts[2] != "history" {
+			return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+		}
+		m := pairCode.FindStringSubmatch(parts[1])
+		if m == nil {
+			return problem.RespondInvalid(ctx, "pair", "pair must be two 3-letter codes, e.g. USD-EUR")
+		}
+		if len(parts) == 3 {
+			return s.history(ctx, m[1], m[2], req.QueryStringParameters)
+		}
+		return s.latest(ctx, m[1], m[2])
+	}
+
+	// Expect query params source, target OR override via body {source,target}
+	source := strings.ToUpper(req.QueryStringParameters["source"])
+	target := strings.ToUpper(req.QueryStringParameters["target"])
+	if source == "" || target == "" {
+		// Allow JSON body fallback
+		var body struct{ Source, Target string }
+		if req.Body != "" {
+			_ = json.Unmarshal([]byte(req.Body), &body)
+			if body.Source != "" {
+				source = strings.ToUpper(body.Source)
+			}
+			if body.Target != "" {
+				target = strings.ToUpper(body.Target)
+			}
+		}
+	}
+	if len(source) != 3 || len(target) != 3 {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "source/target must be 3-letter codes")
+	}
+	resp, ok := s.quote(ctx, source, target)
+	if !ok {
+		return problem.Respond(ctx, http.StatusNotFound, problem.UnsupportedPair, "rate not found")
+	}
+	return respond(resp)
+}
+
+// quote prices the pair from its book, or the reverse pair's, and records the
+// rate it serves. A failure to record is logged: quotes are not refused
+// because the history is unavailable.
+func (s *Service) quote(ctx context.Context, source, target string) (RateResponse, bool) {
+	key := source + ":" + target
+	provider := "mock-fx"
+	book, ok := s.Books.Book(source, target)
+	if !ok && s.StaticRate > 0 {
+		// STATIC_RATE override for unknown pair
+		book, provider, ok = spreads.Book{Mid: s.StaticRate}, "env-mock", true
+	}
+	if !ok {
+		slog.WarnContext(ctx, "rate not found", "pair", key)
+		return RateResponse{}, false
+	}
+	resp := RateResponse{Source: source, Target: target, Rate: book.Mid, Bid: book.Bid(), Ask: book.Ask(), SpreadBps: book.SpreadBps,
+		Provider: provider, AsOf: time.Now().UTC()}
+	if err := s.Store.Record(ctx, resp, resp.AsOf); err != nil {
+		slog.WarnContext(ctx, "rate not recorded", "pair", key, "error", err)
+	}
+	slog.DebugContext(ctx, "rate served", "pair", key, "rate", resp.Rate, "provider", resp.Provider)
+	return resp, true
+}
+
+// latest answers the pair's most recent tick. A pair that has never been
+// quoted is quoted now.
+func (s *Service) latest(ctx context.Context, source, target string) (events.APIGatewayProxyResponse, error) {
+	q, err := s.Store.Latest(ctx, source, target)
+	if errors.Is(err, sql.ErrNoRows) {
+		r, ok := s.quote(ctx, source, target)
+		if !ok {
+			return problem.Respond(ctx, http.StatusNotFound, problem.UnsupportedPair, "no rate for "+source+"-"+target)
+		}
+		q, err = Quote{Source: r.Source, Target: r.Target, Rate: r.Rate, Provider: r.Provider, AsOf: time.Now().UTC()}, nil
+	}
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	q.AgeSeconds = math.Max(0, math.Round(time.Since(q.AsOf).Seconds()*1000)/1000)
+	return respond(q)
+}
+
+// history answers the pair's candles. Without to it ends now, and without
+// from it covers the last defaultCandles intervals.
+func (s *Service) history(ctx context.Context, source, target string, params map[string]string) (events.APIGatewayProxyResponse, error) {
+	h := History{Source: source, Target: target, Interval: params["interval"], Candles: []Candle{}}
+	if h.Interval == "" {
+		h.Interval = "1h"
+	}
+	interval, ok := intervals[h.Interval]
+	if !ok {
+		return problem.RespondInvalid(ctx, "interval", "interval must be one of 1m, 5m, 15m, 1h, 4h, 1d")
+	}
+	h.To = time.Now().UTC()
+	if v := params["to"]; v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return problem.RespondInvalid(ctx, "to", "to must be an RFC 3339 timestamp")
+		}
+		h.To = t.UTC()
+	}
+	h.From = h.To.Add(-defaultCandles * interval)
+	if v := params["from"]; v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return problem.RespondInvalid(ctx, "from", "from must be an RFC 3339 timestamp")
+		}
+		h.From = t.UTC()
+	}
+	// The first candle starts at an interval boundary, so it is never partial
+	h.From = h.From.Truncate(interval)
+	switch {
+	case !h.From.Before(h.To):
+		return problem.RespondInvalid(ctx, "from", "from must be before to")
+	case h.To.Sub(h.From) > maxCandles*interval:
+		return problem.RespondInvalid(ctx, "from", "at most 1000 candles; use a longer interval")
+	}
+	candles, err := s.Store.Candles(ctx, source, target, h.From, h.To, interval)
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	if candles != nil {
+		h.Candles = candles
+	}
+	return respond(h)
+}
+
+func respond(v any) (events.APIGatewayProxyResponse, error) {
+	b, _ := json.Marshal(v)
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(b), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rates"
+)
+
+// memStore keeps ticks in memory; candles are not aggregated, only asked for.
+type memStore struct {
+	ticks   []Quote
+	candles []Candle
+	asked   struct {
+		from, to time.Time
+		interval time.Duration
+	}
+}
+
+func (m *memStore) Record(_ context.Context, r RateResponse, t time.Time) error {
+	m.ticks = append(m.ticks, Quote{Source: r.Source, Target: r.Target, Rate: r.Rate, Provider: r.Provider, AsOf: t})
+	return nil
+}
+
+func (m *memStore) Latest(_ context.Context, source, target string) (Quote, error) {
+	for i := len(m.ticks) - 1; i >= 0; i-- {
+		if q := m.ticks[i]; q.Source == source && q.Target == target {
+			return q, nil
+		}
+	}
+	return Quote{}, sql.ErrNoRows
+}
+
+func (m *memStore) Candles(_ context.Context, _, _ string, from, to time.Time, interval time.Duration) ([]Candle, error) {
+	m.asked.from, m.asked.to, m.asked.interval = from, to, interval
+	return m.candles, nil
+}
+
+var svc = &Service{Books: rates.Mock, Store: &memStore{}}
+
+func TestRateKnownPair(t *testing.T) {
+	req := events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/rate", QueryStringParameters: map[string]string{"source": "usd", "target": "eur"}}
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d err %v", resp.StatusCode, err)
+	}
+	var r RateResponse
+	if err := json.Unmarshal([]byte(resp.Body), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Source != "USD" || r.Target != "EUR" || r.Rate != 0.90 || time.Since(r.AsOf) > time.Minute {
+		t.Errorf("rate = %+v, want it dated now", r)
+	}
+	if r.SpreadBps != 10 || !(r.Bid < r.Rate && r.Rate < r.Ask) {
+		t.Errorf("rate = %+v, want bid and ask 10 bps around the mid", r)
+	}
+}
+
+func TestRateReversePairSharesBook(t *testing.T) {
+	quote := func(source, target string) RateResponse {
+		resp, _ := svc.Handler(context.Background(), events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"source": source, "target": target}})
+		var r RateResponse
+		_ = json.Unmarshal([]byte(resp.Body), &r)
+		return r
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-12 }
+	usdEUR, eurUSD := quote("USD", "EUR"), quote("EUR", "USD")
+	if !near(eurUSD.Rate, 1/usdEUR.Rate) || !near(eurUSD.Bid, 1/usdEUR.Ask) || !near(eurUSD.Ask, 1/usdEUR.Bid) || eurUSD.SpreadBps != usdEUR.SpreadBps {
+		t.Errorf("EUR:USD %+v is not the inverse of USD:EUR %+v", eurUSD, usdEUR)
+	}
+}
+
+func TestRateServedIsRecorded(t *testing.T) {
+	store := &memStore{}
+	s := &Service{Books: rates.Mock, Store: store}
+	_, _ = s.Handler(context.Background(), events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"source": "GBP", "target": "EUR"}})
+	_, _ = s.Handler(context.Background(), events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"source": "JPY", "target": "CHF"}})
+	if len(store.ticks) != 1 || store.ticks[0].Source != "GBP" || store.ticks[0].Rate != 0.90 || store.ticks[0].Provider != "mock-fx" {
+		t.Errorf("ticks = %+v", store.ticks)
+	}
+}
+
+func get(t *testing.T, s *Service, path string, query map[string]string) (int, []byte) {
+	t.Helper()
+	req := events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: path, QueryStringParameters: query}
+	resp, err := s.Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	return resp.StatusCode, []byte(resp.Body)
+}
+
+func TestRateQuoteReportsAge(t *testing.T) {
+	store := &memStore{ticks: []Quote{{Source: "USD", Target: "EUR", Rate: 0.91, Provider: "feed", AsOf: time.Now().Add(-90 * time.Second)}}}
+	s := &Service{Books: rates.Mock, Store: store}
+
+	status, body := get(t, s, "/rates/USD-EUR", nil)
+	var q Quote
+	_ = json.Unmarshal(body, &q)
+	if status != http.StatusOK || q.Rate != 0.91 || q.Provider != "feed" || q.AgeSeconds < 90 || q.AgeSeconds > 100 {
+		t.Errorf("status %d quote %+v", status, q)
+	}
+
+	// A pair never quoted is quoted, and recorded, now
+	status, body = get(t, s, "/rates/GBP-USD", nil)
+	_ = json.Unmarshal(body, &q)
+	if status != http.StatusOK || q.Rate != 0.79 || q.AgeSeconds > 1 || len(store.ticks) != 2 {
+		t.Errorf("status %d quote %+v ticks %d", status, q, len(store.ticks))
+	}
+
+	var p problem.Problem
+	status, body = get(t, s, "/rates/JPY-CHF", nil)
+	if _ = json.Unmarshal(body, &p); status != http.StatusNotFound || p.Code != problem.UnsupportedPair {
+		t.Errorf("unknown pair: status %d %s", status, body)
+	}
+	if status, _ = get(t, s, "/rates/usd-eur", nil); status != http.StatusBadRequest {
+		t.Errorf("lower-case pair: status = %d", status)
+	}
+}
+
+func TestRateHistory(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	store := &memStore{candles: []Candle{{Start: start, Open: 0.9, High: 0.92, Low: 0.89, Close: 0.91, Ticks: 4}}}
+	s := &Service{Books: rates.Mock, Store: store}
+
+	status, body := get(t, s, "/rates/USD-EUR/history", map[string]string{"from": "2026-03-01T10:20:00Z", "to": "2026-03-01T14:00:00Z"})
+	var h History
+	_ = json.Unmarshal(body, &h)
+	if status != http.StatusOK || h.Interval != "1h" || len(h.Candles) != 1 || h.Candles[0].Close != 0.91 {
+		t.Fatalf("status %d history %+v", status, h)
+	}
+	if !store.asked.from.Equal(start) || !h.From.Equal(start) || store.asked.interval != time.Hour {
+		t.Errorf("from %v, interval %v; want %v rounded down to the hour", store.asked.from, store.asked.interval, start)
+	}
+
+	// Without from, the last 100 intervals
+	store.candles = nil
+	status, body = get(t, s, "/rates/USD-EUR/history", map[string]string{"to": "2026-03-01T14:00:00Z", "interval": "15m"})
+	_ = json.Unmarshal(body, &h)
+	if want := start.Add(4*time.Hour - 100*15*time.Minute); status != http.StatusOK || !store.asked.from.Equal(want) || h.Candles == nil {
+		t.Errorf("status %d from %v, want %v; candles %v", status, store.asked.from, want, h.Candles)
+	}
+
+	for name, query := range map[string]map[string]string{
+		"interval":      {"interval": "2h"},
+		"from after to": {"from": "2026-03-02T00:00:00Z", "to": "2026-03-01T00:00:00Z"},
+		"too many":      {"from": "2025-01-01T00:00:00Z", "to": "2026-03-01T00:00:00Z", "interval": "1m"},
+		"not a time":    {"from": "yesterday"},
+	} {
+		if status, body := get(t, s, "/rates/USD-EUR/history", query); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d %s", name, status, body)
+		}
+	}
+}
+
+func TestRateBodyFallback(t *testing.T) {
+	resp, _ := svc.Handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"source":"GBP","target":"USD"}`})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+}
+
+func TestRateErrors(t *testing.T) {
+	cases := map[string]struct {
+		source, target string
+		want           int
+	}{
+		"unknown pair": {"JPY", "CHF", http.StatusNotFound},
+		"bad code":     {"US", "EUR", http.StatusBadRequest},
+	}
+	for name, c := range cases {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"source": c.source, "target": c.target}}
+		if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != c.want {
+			t.Errorf("%s: status = %d, want %d", name, resp.StatusCode, c.want)
+		}
+	}
+}
+
+func TestRateStaticFallback(t *testing.T) {
+	s := &Service{Books: rates.Mock, StaticRate: 1.1, Store: &memStore{}}
+	resp, _ := s.Handler(context.Background(), events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"source": "JPY", "target": "CHF"}})
+	var r RateResponse
+	_ = json.Unmarshal([]byte(resp.Body), &r)
+	if resp.StatusCode != http.StatusOK || r.Rate != 1.1 || r.Provider != "env-mock" {
+		t.Errorf("status %d rate %+v", resp.StatusCode, r)
+	}
+}
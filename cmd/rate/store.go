@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// pgStore is the Postgres Store, on fx_rate_ticks.
+type pgStore struct{ db *sql.DB }
+
+func (s pgStore) Record(ctx context.Context, r RateResponse, t time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO fx_rate_ticks (source_currency, target_currency, rate, provider, quoted_at) VALUES ($1,$2,$3,$4,$5)`,
+		r.Source, r.Target, r.Rate, r.Provider, t)
+	return err
+}
+
+func (s pgStore) Latest(ctx context.Context, source, target string) (Quote, error) {
+	q := Quote{Source: source, Target: target}
+	err := s.db.QueryRowContext(ctx, `SELECT rate, provider, quoted_at FROM fx_rate_ticks
+		WHERE source_currency=$1 AND target_currency=$2 ORDER BY quoted_at DESC, tick_id DESC LIMIT 1`, source, target).
+		Scan(&q.Rate, &q.Provider, &q.AsOf)
+	q.AsOf = q.AsOf.UTC()
+	return q, err
+}
+
+// Candles buckets ticks by whole intervals since the epoch; the first and
+// last tick of a bucket, by time then insertion order, are its open and close.
+func (s pgStore) Candles(ctx context.Context, source, target string, from, to time.Time, interval time.Duration) ([]Candle, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT to_timestamp(floor(extract(epoch FROM quoted_at) / $5::bigint) * $5::bigint) AS bucket,
+			(array_agg(rate ORDER BY quoted_at, tick_id))[1], max(rate), min(rate),
+			(array_agg(rate ORDER BY quoted_at DESC, tick_id DESC))[1], count(*)
+		FROM fx_rate_ticks
+		WHERE source_currency=$1 AND target_currency=$2 AND quoted_at >= $3 AND quoted_at < $4
+		GROUP BY bucket ORDER BY bucket`, source, target, from, to, int64(interval/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Candle
+	for rows.Next() {
+		var c Candle
+		if err := rows.Scan(&c.Start, &c.Open, &c.High, &c.Low, &c.Close, &c.Ticks); err != nil {
+			return nil, err
+		}
+		c.Start = c.Start.UTC()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
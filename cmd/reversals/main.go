@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/dbconn"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// newRateClient loads the AWS config once per execution environment and
+// returns a client for the rate Lambda named by RATE_LAMBDA_NAME.
+func newRateClient(ctx context.Context) (RateClient, error) {
+	rateLambda := os.Getenv("RATE_LAMBDA_NAME")
+	if rateLambda == "" {
+		return nil, errors.New("RATE_LAMBDA_NAME not set")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(getenv("AWS_REGION", "eu-central-1")))
+	if err != nil {
+		return nil, fmt.Errorf("aws cfg: %w", err)
+	}
+	return rates.Lambda{Client: awslambda.NewFromConfig(cfg), Function: rateLambda}, nil
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	logging.Init("reversals")
+	metrics.Init("reversals")
+	ctx := context.Background()
+	if err := tracing.Init(ctx, "reversals"); err != nil {
+		slog.Error("tracing init", "error", err)
+	}
+	operators, err := admin.Parse(os.Getenv(admin.Env))
+	if err != nil {
+		slog.Error("admin tokens", "error", err)
+		os.Exit(1)
+	}
+	db, err := dbconn.Open(ctx, tenant.Options()...)
+	if err != nil {
+		slog.Error("db init", "error", err)
+		os.Exit(1)
+	}
+	rates, err := newRateClient(ctx)
+	if err != nil {
+		slog.Error("rate client init", "error", err)
+		os.Exit(1)
+	}
+	limits, err := rateguard.LimitsFromEnv()
+	if err != nil {
+		slog.Error("rate guard config", "error", err)
+		os.Exit(1)
+	}
+	svc := &Service{Store: settlement.PGStore{DB: db}, Rates: rateguard.Guard{Rates: rates, Store: rateguard.PGStore{DB: db}, Limits: limits}, Operators: operators}
+	lambda.Start(svc.Handler)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) {
+		svc = &Service{Store: settlement.PGStore{DB: d}, Rates: fixedRates{"USD:EUR": 0.9}, Operators: testOperators}
+	}))
+}
+
+// completed settles a conversion the way POST /exchange does and returns its
+// id and target amount.
+func completed(t *testing.T, pg *sql.DB, user string, amount float64) (string, float64) {
+	t.Helper()
+	settler := settlement.Settler{Store: settlement.PGStore{DB: pg}, Rates: settlement.RateFunc(rates.Books(svc.Rates))}
+	job := settlement.Job{ID: uuid.NewString(), ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: amount}
+	res, err := settler.CreateAndSettle(context.Background(), job)
+	if err != nil || res.Status != settlement.StatusCompleted {
+		t.Fatalf("settle: %+v, %v", res, err)
+	}
+	return job.ID, res.TargetAmount
+}
+
+func TestReversalRestoresBalancesWithCompensatingEntries(t *testing.T) {
+	pg := testpg.DB(t)
+	opening := testpg.Funds{"USD": 100}
+	user := testpg.FundedUser(t, pg, opening)
+	jobID, _ := completed(t, pg, user, 100)
+	original := testpg.LoadJob(t, pg, jobID)
+
+	status, first, body := reverse(t, svc, reverseRequest(jobID, testToken, ReverseRequest{Amount: 40, Reason: "partial refund"}))
+	if status != http.StatusCreated || first.Credit != 40 || first.Remaining != 60 {
+		t.Fatalf("partial: %d %s", status, body)
+	}
+	status, rest, body := reverse(t, svc, reverseRequest(jobID, testToken, ReverseRequest{Reason: "full refund"}))
+	if status != http.StatusCreated || rest.ReversedAmount != 60 || rest.Remaining != 0 {
+		t.Fatalf("rest: %d %s", status, body)
+	}
+	if status, _, _ := reverse(t, svc, reverseRequest(jobID, testToken, ReverseRequest{Reason: "again"})); status != http.StatusConflict {
+		t.Errorf("second full reversal: status %d, want 409", status)
+	}
+
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	testpg.AssertBalance(t, pg, user, "EUR", 0)
+	testpg.AssertLedgerMatchesBalances(t, pg, user, opening)
+	if got := testpg.LoadJob(t, pg, jobID); got.Status != "completed" || got.TargetAmount != original.TargetAmount {
+		t.Errorf("original job = %+v, want it untouched", got)
+	}
+	for _, id := range []string{first.ReversalJobID, rest.ReversalJobID} {
+		rev := testpg.LoadJob(t, pg, id)
+		if rev.Status != "completed" || rev.Metadata["operator"] != "ops" || rev.Metadata["rate_basis"] != "original" {
+			t.Errorf("reversal %s = %+v", id, rev)
+		}
+		if ledger := testpg.Ledger(t, pg, id); len(ledger) != 2 {
+			t.Errorf("reversal %s ledger = %+v, want a debit and a credit", id, ledger)
+		}
+	}
+	var linked int
+	var reversed float64
+	if err := pg.QueryRow(`SELECT count(*), SUM(reversed_amount) FROM conversion_jobs WHERE reversal_of=$1`, jobID).Scan(&linked, &reversed); err != nil {
+		t.Fatal(err)
+	}
+	if linked != 2 || reversed != 100 {
+		t.Errorf("%d reversals of %v, want 2 covering 100", linked, reversed)
+	}
+	var audited int
+	if err := pg.QueryRow(`SELECT count(*) FROM admin_audit_log WHERE action='job.reversed' AND target_id=$1 AND operator='ops'`, jobID).Scan(&audited); err != nil || audited != 2 {
+		t.Errorf("audit rows = %d (%v), want one per reversal", audited, err)
+	}
+	var events []string
+	for _, e := range testpg.Outbox(t, pg, jobID) {
+		events = append(events, e.Payload["event"].(string))
+	}
+	if len(events) != 3 || events[1] != "conversion.reversed" || events[2] != "conversion.reversed" {
+		t.Errorf("events = %v, want completed then two reversed", events)
+	}
+}
+
+func TestReversalAtCurrentRateNeedsTargetFunds(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	jobID, target := completed(t, pg, user, 100)
+	if _, err := pg.Exec(`UPDATE accounts SET balance = 1 WHERE user_id=$1 AND currency='EUR'`, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _, body := reverse(t, svc, reverseRequest(jobID, testToken, ReverseRequest{RateBasis: "current", Reason: "refund"})); status != http.StatusUnprocessableEntity {
+		t.Errorf("status %d (%s), want 422 with %v EUR spent", status, body, target-1)
+	}
+	testpg.AssertBalance(t, pg, user, "EUR", 1)
+	if n := len(testpg.Outbox(t, pg, jobID)); n != 1 {
+		t.Errorf("outbox rows = %d, want only conversion.completed", n)
+	}
+}
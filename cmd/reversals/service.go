@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// ReverseRequest is the POST /jobs/{job_id}/reverse payload. Amount is the
+// part of the job's source amount to reverse; omitted, the rest of it is.
+type ReverseRequest struct {
+	Amount    float64 `json:"amount,omitempty"`
+	RateBasis string  `json:"rate_basis,omitempty"` // original (default) or current
+	Reason    string  `json:"reason"`
+}
+
+// ReversalResponse describes the reversal job: it debited Debit of the
+// original target currency and credited Credit of the original source
+// currency.
+type ReversalResponse struct {
+	ReversalJobID  string  `json:"reversal_job_id"`
+	JobID          string  `json:"job_id"`
+	ReversedAmount float64 `json:"reversed_amount"`
+	Debit          float64 `json:"debit"`
+	Credit         float64 `json:"credit"`
+	Rate           float64 `json:"rate"`
+	RateBasis      string  `json:"rate_basis"`
+	Remaining      float64 `json:"remaining"`
+	Reason         string  `json:"reason"`
+	Operator       string  `json:"operator"`
+}
+
+// RateClient fetches the FX rate for a pair.
+type RateClient interface {
+	Rate(ctx context.Context, source, target string) (rates.Response, error)
+}
+
+// Service handles POST /jobs/{job_id}/reverse for admin operators.
+type Service struct {
+	Store     settlement.Store
+	Rates     RateClient
+	Operators admin.Operators
+}
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := s.handle(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	correlationID := logging.FromRequest(evt)
+	ctx = logging.WithCorrelationID(ctx, correlationID)
+	jobID := evt.PathParameters["job_id"]
+	if evt.HTTPMethod != http.MethodPost || !strings.HasSuffix(evt.Path, "/reverse") || uuid.Validate(jobID) != nil {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	operator, err := s.Operators.Authenticate(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	// Operators reverse any tenant's conversions; settlement scopes to the job's
+	ctx = logging.With(tenant.With(ctx, tenant.All), "operator", operator)
+
+	var req ReverseRequest
+	if err := json.Unmarshal([]byte(evt.Body), &req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, fmt.Sprintf("invalid json: %v", err))
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return problem.RespondInvalid(ctx, "reason", "reason is required")
+	}
+	if req.RateBasis == "" {
+		req.RateBasis = settlement.BasisOriginal
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rev := settlement.Reversal{ID: uuid.NewString(), JobID: jobID, Amount: req.Amount, Basis: req.RateBasis, Reason: req.Reason, Operator: operator}
+	settler := settlement.Settler{Store: s.Store, Rates: settlement.RateFunc(rates.Books(s.Rates))}
+	res, err := settler.Reverse(opCtx, rev)
+	switch {
+	case errors.Is(err, settlement.ErrJobNotFound):
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	case errors.Is(err, settlement.ErrReversalBasis):
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	case errors.Is(err, settlement.ErrNotReversible), errors.Is(err, settlement.ErrFullyReversed):
+		return problem.Respond(ctx, http.StatusConflict, problem.Conflict, err.Error())
+	case errors.Is(err, settlement.ErrReversalAmount):
+		return problem.Respond(ctx, http.StatusUnprocessableEntity, problem.InvalidRequest, err.Error())
+	case errors.Is(err, settlement.ErrReversalFunds):
+		return problem.Respond(ctx, http.StatusUnprocessableEntity, problem.InsufficientFunds, err.Error())
+	case errors.Is(err, rateguard.ErrBreakerOpen):
+		return problem.Respond(ctx, http.StatusServiceUnavailable, problem.RateUnavailable, err.Error())
+	case err != nil:
+		return problem.ServerError(ctx, err)
+	}
+
+	b, _ := json.Marshal(ReversalResponse{
+		ReversalJobID: rev.ID, JobID: jobID, ReversedAmount: res.Amount, Debit: res.Debit, Credit: res.Credit,
+		Rate: res.Rate, RateBasis: rev.Basis, Remaining: res.Remaining, Reason: rev.Reason, Operator: operator,
+	})
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusCreated, Headers: map[string]string{"Content-Type": "application/json", logging.Header: correlationID}, Body: string(b)}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/rates"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/settlement/settlementtest"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+const (
+	testJobID = "6f1c2b1e-8a4d-4c3e-9b8a-2f6d5e4c3b2a"
+	testToken = "ops-token-0123456789"
+)
+
+var testOperators = admin.Operators{testToken: "ops"}
+
+// fixedRates is a RateClient serving a static table.
+type fixedRates map[string]float64
+
+func (r fixedRates) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	rate, ok := r[source+":"+target]
+	if !ok {
+		return rates.Response{}, errors.New("rate not found")
+	}
+	return rates.Response{Source: source, Target: target, Rate: rate, Provider: "test"}, nil
+}
+
+func reverseRequest(jobID, token string, body any) events.APIGatewayProxyRequest {
+	req := testpg.APIRequest(http.MethodPost, "/jobs/"+jobID+"/reverse", body)
+	req.PathParameters = map[string]string{"job_id": jobID}
+	if token != "" {
+		req.Headers["Authorization"] = "Bearer " + token
+	}
+	return req
+}
+
+func reverse(t *testing.T, svc *Service, req events.APIGatewayProxyRequest) (int, ReversalResponse, string) {
+	t.Helper()
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out ReversalResponse
+	_ = json.Unmarshal([]byte(resp.Body), &out)
+	return resp.StatusCode, out, resp.Body
+}
+
+func newService() (*Service, *settlementtest.MemStore) {
+	store := settlementtest.New()
+	store.Fund("u1", map[string]float64{"EUR": 90})
+	store.AddCompleted(settlement.Job{ID: testJobID, ClientID: "u1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100}, 90)
+	return &Service{Store: store, Rates: fixedRates{"USD:EUR": 0.75}, Operators: testOperators}, store
+}
+
+func TestServiceReversesAtEitherBasis(t *testing.T) {
+	svc, store := newService()
+
+	status, out, body := reverse(t, svc, reverseRequest(testJobID, testToken, ReverseRequest{Amount: 50, Reason: "refund"}))
+	if status != http.StatusCreated || out.Debit != 45 || out.Credit != 50 || out.RateBasis != "original" || out.Operator != "ops" || out.Remaining != 50 {
+		t.Fatalf("original: %d %s", status, body)
+	}
+	status, out, body = reverse(t, svc, reverseRequest(testJobID, testToken, ReverseRequest{RateBasis: "current", Reason: "refund"}))
+	if status != http.StatusCreated || out.Debit != 45 || out.Credit != 60 || out.Remaining != 0 {
+		t.Fatalf("current: %d %s", status, body)
+	}
+	if usd := store.Accounts["u1/USD"].Balance; usd != 110 {
+		t.Errorf("USD = %v, want 110", usd)
+	}
+	if status, _, _ := reverse(t, svc, reverseRequest(testJobID, testToken, ReverseRequest{Reason: "again"})); status != http.StatusConflict {
+		t.Errorf("third reversal status = %d, want 409", status)
+	}
+}
+
+// haltedRates is a RateClient whose every pair has its breaker open.
+type haltedRates struct{}
+
+func (haltedRates) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	return rates.Response{}, fmt.Errorf("%s:%s: %w", source, target, rateguard.ErrBreakerOpen)
+}
+
+func TestServiceRefusesCurrentRateWhileBreakerOpen(t *testing.T) {
+	svc, store := newService()
+	svc.Rates = haltedRates{}
+	status, _, body := reverse(t, svc, reverseRequest(testJobID, testToken, ReverseRequest{RateBasis: "current", Reason: "refund"}))
+	var p problem.Problem
+	_ = json.Unmarshal([]byte(body), &p)
+	if status != http.StatusServiceUnavailable || p.Code != problem.RateUnavailable || len(store.Ledger) != 0 {
+		t.Errorf("status = %d (%s), want 503 RATE_UNAVAILABLE with nothing posted", status, body)
+	}
+	// The original basis needs no rate
+	if status, _, body := reverse(t, svc, reverseRequest(testJobID, testToken, ReverseRequest{Amount: 10, Reason: "refund"})); status != http.StatusCreated {
+		t.Errorf("original basis: %d %s", status, body)
+	}
+}
+
+func TestServiceRejects(t *testing.T) {
+	cases := map[string]struct {
+		req  events.APIGatewayProxyRequest
+		want int
+	}{
+		"no token":       {reverseRequest(testJobID, "", ReverseRequest{Reason: "r"}), http.StatusUnauthorized},
+		"unknown token":  {reverseRequest(testJobID, "not-an-operator-token", ReverseRequest{Reason: "r"}), http.StatusUnauthorized},
+		"no reason":      {reverseRequest(testJobID, testToken, ReverseRequest{}), http.StatusBadRequest},
+		"bad basis":      {reverseRequest(testJobID, testToken, ReverseRequest{RateBasis: "best", Reason: "r"}), http.StatusBadRequest},
+		"too much":       {reverseRequest(testJobID, testToken, ReverseRequest{Amount: 101, Reason: "r"}), http.StatusUnprocessableEntity},
+		"unknown job":    {reverseRequest("0b7e6f5d-1c2a-4e3b-8d9f-0a1b2c3d4e5f", testToken, ReverseRequest{Reason: "r"}), http.StatusNotFound},
+		"not a uuid":     {reverseRequest("j1", testToken, ReverseRequest{Reason: "r"}), http.StatusNotFound},
+		"wrong method":   {testpg.APIRequest(http.MethodGet, "/jobs/"+testJobID+"/reverse", nil), http.StatusNotFound},
+		"malformed body": {reverseRequest(testJobID, testToken, "{"), http.StatusBadRequest},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			svc, store := newService()
+			if status, _, body := reverse(t, svc, c.req); status != c.want {
+				t.Errorf("status = %d, want %d (%s)", status, c.want, body)
+			}
+			if len(store.Ledger) != 0 {
+				t.Errorf("ledger = %v, want nothing posted", store.Ledger)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/irajwani/microservice-go/internal/dbconn"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/queue"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	logging.Init("scheduler")
+	metrics.Init("scheduler")
+	ctx := context.Background()
+	if err := tracing.Init(ctx, "scheduler"); err != nil {
+		slog.Error("tracing init", "error", err)
+	}
+	db, err := dbconn.Open(ctx, tenant.Options()...)
+	if err != nil {
+		slog.Error("db init", "error", err)
+		os.Exit(1)
+	}
+	svc := &Service{Store: pgStore{db: db}}
+	if queueURL := os.Getenv("QUEUE_URL"); queueURL != "" {
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(getenv("AWS_REGION", "eu-central-1")))
+		if err != nil {
+			slog.Error("aws config", "error", err)
+			os.Exit(1)
+		}
+		svc.Publisher = queue.SQSPublisher{Client: sqs.NewFromConfig(cfg), QueueURL: queueURL}
+	}
+	lambda.Start(svc.Handler)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}} }))
+}
+
+// insertSchedule inserts an active schedule due at next and returns its id.
+func insertSchedule(t *testing.T, pg *sql.DB, recurrence string, next time.Time) string {
+	t.Helper()
+	id := uuid.NewString()
+	var rec, runAt any = recurrence, nil
+	if recurrence == "" {
+		rec, runAt = nil, next
+	}
+	if _, err := pg.ExecContext(context.Background(), `INSERT INTO scheduled_conversions (schedule_id, client_id, source_currency, target_currency, source_amount, recurrence, run_at, next_run_at)
+		VALUES ($1,$2,'USD','EUR',500,$3,$4,$5)`, id, "c-"+id[:8], rec, runAt, next); err != nil {
+		t.Fatalf("insert schedule: %v", err)
+	}
+	return id
+}
+
+func scheduledJobs(t *testing.T, pg *sql.DB, scheduleID string) []string {
+	t.Helper()
+	rows, err := pg.QueryContext(context.Background(), `SELECT job_id FROM conversion_jobs WHERE schedule_id=$1 ORDER BY created_at`, scheduleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func scheduleState(t *testing.T, pg *sql.DB, id string) (status string, next sql.NullTime, failures int) {
+	t.Helper()
+	if err := pg.QueryRowContext(context.Background(), `SELECT status, next_run_at, consecutive_failures FROM scheduled_conversions WHERE schedule_id=$1`, id).
+		Scan(&status, &next, &failures); err != nil {
+		t.Fatal(err)
+	}
+	return status, next, failures
+}
+
+func TestSchedulerBooksOccurrenceOnce(t *testing.T) {
+	pg := testpg.DB(t)
+	due := time.Now().UTC().Truncate(time.Minute).Add(-time.Minute)
+	id := insertSchedule(t, pg, "* * * * *", due)
+	now := due.Add(30 * time.Second)
+
+	if err := svc.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	jobs := scheduledJobs(t, pg, id)
+	if len(jobs) != 1 {
+		t.Fatalf("jobs = %v, want 1", jobs)
+	}
+	if job := testpg.LoadJob(t, pg, jobs[0]); job.Status != "queued" {
+		t.Errorf("job status = %s, want queued", job.Status)
+	}
+	if out := testpg.Outbox(t, pg, jobs[0]); len(out) != 1 || out[0].Topic != "conversion-jobs" || out[0].Payload["schedule_id"] != id {
+		t.Errorf("outbox = %+v", out)
+	}
+	status, next, _ := scheduleState(t, pg, id)
+	if status != "active" || !next.Valid || !next.Time.Equal(due.Add(time.Minute)) {
+		t.Errorf("schedule = %s next %v, want active at %v", status, next.Time, due.Add(time.Minute))
+	}
+
+	// A restarted scheduler that re-reads the old next_run_at must not book
+	// the occurrence again.
+	if _, err := pg.Exec(`UPDATE scheduled_conversions SET next_run_at=$2 WHERE schedule_id=$1`, id, due); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := scheduledJobs(t, pg, id); len(jobs) != 1 {
+		t.Errorf("jobs after rerun = %v, want 1", jobs)
+	}
+}
+
+func TestSchedulerCompletesOneOff(t *testing.T) {
+	pg := testpg.DB(t)
+	now := time.Now()
+	id := insertSchedule(t, pg, "", now.Add(-time.Second))
+	future := insertSchedule(t, pg, "", now.Add(time.Hour))
+
+	if err := svc.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if status, next, _ := scheduleState(t, pg, id); status != "completed" || next.Valid {
+		t.Errorf("one-off = %s next %v, want completed", status, next)
+	}
+	if len(scheduledJobs(t, pg, id)) != 1 || len(scheduledJobs(t, pg, future)) != 0 {
+		t.Error("want one job for the due one-off and none for the future one")
+	}
+}
+
+func TestSchedulerDisablesInvalidRecurrence(t *testing.T) {
+	pg := testpg.DB(t)
+	now := time.Now()
+	id := insertSchedule(t, pg, "0 9 * *", now.Add(-time.Second))
+
+	if err := svc.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := scheduleState(t, pg, id); status != "disabled" || len(scheduledJobs(t, pg, id)) != 0 {
+		t.Errorf("schedule = %s, want disabled without jobs", status)
+	}
+}
+
+func TestScheduleDisabledAfterFundingFailures(t *testing.T) {
+	pg := testpg.DB(t)
+	id := insertSchedule(t, pg, "* * * * *", time.Now().Add(-time.Minute))
+	settle := func(status, reason string) {
+		t.Helper()
+		jobID := testpg.QueuedJob(t, pg, "c-"+id[:8], "USD", "EUR", 500)
+		if _, err := pg.Exec(`UPDATE conversion_jobs SET schedule_id=$2, status=$3, metadata=jsonb_build_object('error',$4::text) WHERE job_id=$1`, jobID, id, status, reason); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	settle("failed", settlement.ReasonInsufficientFunds)
+	settle("failed", settlement.ReasonInsufficientFunds)
+	settle("completed", "")
+	if status, _, failures := scheduleState(t, pg, id); status != "active" || failures != 0 {
+		t.Fatalf("after success: %s with %d failures, want active with 0", status, failures)
+	}
+
+	settle("failed", settlement.ReasonInvalidRequest) // not a funding failure
+	for i := 0; i < 3; i++ {
+		settle("failed", settlement.ReasonInsufficientFunds)
+	}
+	status, _, failures := scheduleState(t, pg, id)
+	if status != "disabled" || failures != 3 {
+		t.Errorf("schedule = %s with %d failures, want disabled with 3", status, failures)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/deadline"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/schedule"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+const batchSize = 100
+
+// Schedule is an active schedule claimed because it is due.
+type Schedule struct {
+	ID             string
+	TenantID       string
+	ClientID       string
+	SourceCurrency string
+	TargetCurrency string
+	SourceAmount   float64
+	Recurrence     string // empty for a one-off
+	Timezone       string
+	NextRunAt      time.Time // the occurrence being booked
+}
+
+// JobMessage is the queued job an occurrence books; it is also the SQS
+// message body, in the shape POST /jobs publishes.
+type JobMessage struct {
+	JobID          string    `json:"job_id"`
+	Status         string    `json:"status"`
+	ClientID       string    `json:"client_id"`
+	SourceCurrency string    `json:"source_currency"`
+	TargetCurrency string    `json:"target_currency"`
+	SourceAmount   float64   `json:"source_amount"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	CorrelationID  string    `json:"correlation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ScheduleID     string    `json:"schedule_id"`
+}
+
+// Payload is the job's outbox payload and message body.
+func (j JobMessage) Payload() []byte {
+	b, _ := json.Marshal(j)
+	return b
+}
+
+// Occurrence is the plan for one due schedule.
+type Occurrence struct {
+	ScheduleID string
+	At         time.Time // the occurrence's scheduled time
+	Job        JobMessage
+	// Next is when the schedule runs again; nil completes it.
+	Next *time.Time
+	// Disable, when set, disables the schedule with this reason instead of
+	// booking anything.
+	Disable string
+}
+
+// Booked is an occurrence whose job was inserted.
+type Booked struct {
+	Occurrence
+	OutboxID string
+}
+
+// Store is the scheduler's persistence.
+type Store interface {
+	// Materialize locks up to limit active schedules due at now, skipping any
+	// a concurrent scheduler holds, and calls plan for each. In the same
+	// transaction it inserts every occurrence's queued job and outbox row,
+	// unless a job with its idempotency key already exists, and advances the
+	// schedule to Next. It returns how many schedules were claimed and the
+	// occurrences whose job was inserted.
+	Materialize(ctx context.Context, now time.Time, limit int, plan func(Schedule) Occurrence) (claimed int, booked []Booked, err error)
+	// MarkPublished records that the outbox row has been delivered.
+	MarkPublished(ctx context.Context, outboxID string) error
+}
+
+// Publisher delivers job messages to the conversion queue.
+type Publisher interface {
+	Publish(ctx context.Context, body []byte, attrs map[string]string) error
+}
+
+// Service books due scheduled conversions as queued jobs. A nil Publisher
+// leaves delivery to the outbox.
+type Service struct {
+	Store     Store
+	Publisher Publisher
+}
+
+// Handler runs one scheduling pass per scheduled invocation.
+func (s *Service) Handler(ctx context.Context, _ events.CloudWatchEvent) error {
+	defer tracing.Flush(ctx)
+	return s.Run(ctx, time.Now())
+}
+
+// Run books every schedule due at now. It returns once nothing is due, or
+// shortly before ctx's deadline.
+func (s *Service) Run(ctx context.Context, now time.Time) error {
+	ctx, span := tracing.Start(tenant.With(ctx, tenant.All), "schedules.run")
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	for !deadline.Near(ctx) {
+		var claimed int
+		var booked []Booked
+		claimed, booked, err = s.Store.Materialize(ctx, now, batchSize, func(sched Schedule) Occurrence { return plan(sched, now) })
+		if err != nil {
+			return fmt.Errorf("materialize: %w", err)
+		}
+		for _, b := range booked {
+			s.publish(ctx, b)
+		}
+		if claimed < batchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// plan books the schedule's due occurrence. The job's idempotency key is
+// derived from the schedule and occurrence time, so the same occurrence can
+// never be booked twice. Occurrences missed while the scheduler was down
+// are collapsed into this one: the schedule next runs after now.
+func plan(sched Schedule, now time.Time) Occurrence {
+	occ := Occurrence{ScheduleID: sched.ID, At: sched.NextRunAt}
+	if sched.Recurrence != "" {
+		spec, err := schedule.Parse(sched.Recurrence, sched.Timezone)
+		if err != nil {
+			occ.Disable = "invalid recurrence: " + err.Error()
+			return occ
+		}
+		after := now
+		if sched.NextRunAt.After(now) {
+			after = sched.NextRunAt
+		}
+		if next := spec.Next(after); !next.IsZero() {
+			occ.Next = &next
+		}
+	}
+	occ.Job = JobMessage{
+		JobID:          uuid.NewString(),
+		Status:         "queued",
+		ClientID:       sched.ClientID,
+		SourceCurrency: sched.SourceCurrency,
+		TargetCurrency: sched.TargetCurrency,
+		SourceAmount:   sched.SourceAmount,
+		IdempotencyKey: IdempotencyKey(sched.ID, sched.NextRunAt),
+		CorrelationID:  uuid.NewString(),
+		CreatedAt:      now.UTC(),
+		ScheduleID:     sched.ID,
+	}
+	return occ
+}
+
+// IdempotencyKey is the idempotency key of a schedule's occurrence at t.
+func IdempotencyKey(scheduleID string, t time.Time) string {
+	return "schedule:" + scheduleID + ":" + t.UTC().Format(time.RFC3339)
+}
+
+// publish sends a booked job to the queue (best effort); unpublished rows
+// stay in the outbox.
+func (s *Service) publish(ctx context.Context, b Booked) {
+	ctx = logging.With(logging.WithCorrelationID(ctx, b.Job.CorrelationID), "job_id", b.Job.JobID, "schedule_id", b.ScheduleID)
+	metrics.Count(metrics.JobsCreated, 1, metrics.Pair(b.Job.SourceCurrency, b.Job.TargetCurrency))
+	slog.InfoContext(ctx, "scheduled job booked", "occurrence", b.At, "next_run_at", b.Next)
+	if s.Publisher == nil {
+		return
+	}
+	publishCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := s.Publisher.Publish(publishCtx, b.Job.Payload(), map[string]string{logging.Attribute: b.Job.CorrelationID}); err != nil {
+		slog.WarnContext(ctx, "failed to publish SQS message", "error", err)
+	} else if err := s.Store.MarkPublished(publishCtx, b.OutboxID); err != nil {
+		slog.WarnContext(ctx, "failed to mark outbox row processed", "outbox_id", b.OutboxID, "error", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPlanRecurring(t *testing.T) {
+	now := time.Date(2025, 3, 10, 9, 0, 30, 0, time.UTC) // a Monday
+	sched := Schedule{ID: "s1", ClientID: "c", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 500, Recurrence: "0 9 * * MON", Timezone: "UTC", NextRunAt: time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)}
+
+	occ := plan(sched, now)
+	if occ.Disable != "" || occ.Next == nil || !occ.Next.Equal(time.Date(2025, 3, 17, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("plan = %+v, want next Monday", occ)
+	}
+	if want := "schedule:s1:2025-03-10T09:00:00Z"; occ.Job.IdempotencyKey != want {
+		t.Errorf("idempotency key = %q, want %q", occ.Job.IdempotencyKey, want)
+	}
+	if again := plan(sched, now.Add(time.Minute)); again.Job.IdempotencyKey != occ.Job.IdempotencyKey {
+		t.Errorf("replanned key %q differs from %q", again.Job.IdempotencyKey, occ.Job.IdempotencyKey)
+	}
+	if occ.Job.Status != "queued" || occ.Job.ScheduleID != "s1" || occ.Job.SourceAmount != 500 {
+		t.Errorf("job = %+v", occ.Job)
+	}
+}
+
+func TestPlanCollapsesMissedOccurrences(t *testing.T) {
+	// Down for three days: one job for the oldest missed occurrence, then the
+	// schedule resumes after now.
+	sched := Schedule{ID: "s1", Recurrence: "@daily", NextRunAt: time.Date(2025, 3, 7, 0, 0, 0, 0, time.UTC)}
+	occ := plan(sched, time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	if !occ.At.Equal(sched.NextRunAt) || occ.Next == nil || !occ.Next.Equal(time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("plan = at %v next %v", occ.At, occ.Next)
+	}
+}
+
+func TestPlanOneOffAndInvalid(t *testing.T) {
+	now := time.Now()
+	if occ := plan(Schedule{ID: "s1", NextRunAt: now}, now); occ.Next != nil || occ.Disable != "" {
+		t.Errorf("one-off plan = %+v, want it completed", occ)
+	}
+	if occ := plan(Schedule{ID: "s1", Recurrence: "every monday", NextRunAt: now}, now); occ.Disable == "" {
+		t.Errorf("invalid recurrence not disabled: %+v", occ)
+	}
+}
+
+// memStore books every planned occurrence once per idempotency key.
+type memStore struct {
+	due       []Schedule
+	keys      map[string]bool
+	published []string
+}
+
+func (s *memStore) Materialize(_ context.Context, _ time.Time, _ int, plan func(Schedule) Occurrence) (int, []Booked, error) {
+	var booked []Booked
+	for _, sched := range s.due {
+		occ := plan(sched)
+		if occ.Disable != "" || s.keys[occ.Job.IdempotencyKey] {
+			continue
+		}
+		s.keys[occ.Job.IdempotencyKey] = true
+		booked = append(booked, Booked{Occurrence: occ, OutboxID: "outbox-" + sched.ID})
+	}
+	claimed := len(s.due)
+	s.due = nil
+	return claimed, booked, nil
+}
+
+func (s *memStore) MarkPublished(_ context.Context, outboxID string) error {
+	s.published = append(s.published, outboxID)
+	return nil
+}
+
+type fakePublisher struct {
+	bodies [][]byte
+	err    error
+}
+
+func (p *fakePublisher) Publish(_ context.Context, body []byte, _ map[string]string) error {
+	p.bodies = append(p.bodies, body)
+	return p.err
+}
+
+func TestRunPublishesBookedJobs(t *testing.T) {
+	now := time.Now()
+	due := []Schedule{{ID: "a", Recurrence: "@hourly", NextRunAt: now}, {ID: "b", NextRunAt: now}}
+	store := &memStore{due: due, keys: map[string]bool{}}
+	pub := &fakePublisher{}
+	s := &Service{Store: store, Publisher: pub}
+
+	if err := s.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.bodies) != 2 || len(store.published) != 2 {
+		t.Fatalf("published %d messages, marked %v", len(pub.bodies), store.published)
+	}
+
+	// A failed publish leaves the outbox row unprocessed
+	store.due, pub.err = []Schedule{{ID: "c", NextRunAt: now}}, errors.New("sqs down")
+	if err := s.Run(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.published) != 2 {
+		t.Errorf("marked %v after failed publish", store.published)
+	}
+}
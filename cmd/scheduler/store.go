@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/pgtx"
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+func (s pgStore) Materialize(ctx context.Context, now time.Time, limit int, plan func(Schedule) Occurrence) (int, []Booked, error) {
+	var claimed int
+	var booked []Booked
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		claimed, booked = 0, nil
+		rows, err := tx.QueryContext(ctx, `SELECT schedule_id, tenant_id, client_id, source_currency, target_currency, source_amount, COALESCE(recurrence, ''), timezone, next_run_at
+			FROM scheduled_conversions WHERE status='active' AND next_run_at <= $1 ORDER BY next_run_at LIMIT $2 FOR UPDATE SKIP LOCKED`, now, limit)
+		if err != nil {
+			return err
+		}
+		var due []Schedule
+		for rows.Next() {
+			var sched Schedule
+			if err := rows.Scan(&sched.ID, &sched.TenantID, &sched.ClientID, &sched.SourceCurrency, &sched.TargetCurrency, &sched.SourceAmount, &sched.Recurrence, &sched.Timezone, &sched.NextRunAt); err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, sched)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		claimed = len(due)
+
+		for _, sched := range due {
+			// What an occurrence writes belongs to its schedule's tenant
+			if err := tenant.Scope(ctx, tx, sched.TenantID); err != nil {
+				return err
+			}
+			occ := plan(sched)
+			if occ.Disable != "" {
+				if _, err := tx.ExecContext(ctx, `UPDATE scheduled_conversions SET status='disabled', disabled_reason=$2 WHERE schedule_id=$1`, sched.ID, occ.Disable); err != nil {
+					return fmt.Errorf("disable schedule %s: %w", sched.ID, err)
+				}
+				continue
+			}
+			b, err := book(ctx, tx, occ)
+			switch {
+			case err == nil:
+				booked = append(booked, b)
+			case !errors.Is(err, sql.ErrNoRows): // no rows: already booked
+				return fmt.Errorf("book schedule %s: %w", sched.ID, err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE scheduled_conversions SET next_run_at=$2, last_run_at=$3,
+					status = CASE WHEN $2::timestamptz IS NULL THEN 'completed' ELSE status END WHERE schedule_id=$1`,
+				sched.ID, occ.Next, occ.At); err != nil {
+				return fmt.Errorf("advance schedule %s: %w", sched.ID, err)
+			}
+		}
+		return nil
+	})
+	return claimed, booked, err
+}
+
+// book inserts the occurrence's job and outbox row, or returns sql.ErrNoRows
+// when a job with its idempotency key exists. The job holds its amount when
+// the available balance covers it; otherwise it is booked without a hold and
+// settlement fails it with INSUFFICIENT_FUNDS unless the account is funded by
+// then, which is what counts towards the schedule's max_failures.
+func book(ctx context.Context, tx *sql.Tx, occ Occurrence) (Booked, error) {
+	job := occ.Job
+	var jobID string
+	err := tx.QueryRowContext(ctx, `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, status, idempotency_key, schedule_id, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,'queued',$6,$7,$8,$8) ON CONFLICT (tenant_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING RETURNING job_id`,
+		job.JobID, job.ClientID, job.SourceCurrency, job.TargetCurrency, job.SourceAmount, job.IdempotencyKey, job.ScheduleID, job.CreatedAt).Scan(&jobID)
+	if err != nil {
+		return Booked{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `WITH hold AS (
+			UPDATE accounts SET held = held + $3 WHERE user_id=$1 AND currency=$2 AND balance - held >= $3 RETURNING 1)
+		UPDATE conversion_jobs SET held_amount = $3 WHERE job_id=$4 AND EXISTS (SELECT 1 FROM hold)`,
+		job.ClientID, job.SourceCurrency, job.SourceAmount, job.JobID); err != nil {
+		return Booked{}, fmt.Errorf("hold funds: %w", err)
+	}
+	b := Booked{Occurrence: occ}
+	err = tx.QueryRowContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ($1,$2,$3,$4) RETURNING outbox_id`,
+		"conversion_job", job.JobID, "conversion-jobs", job.Payload()).Scan(&b.OutboxID)
+	return b, err
+}
+
+func (s pgStore) MarkPublished(ctx context.Context, outboxID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outbox SET processed_at=now() WHERE outbox_id=$1`, outboxID)
+	return err
+}
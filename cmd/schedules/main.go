@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/irajwani/microservice-go/internal/dbconn"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+func main() {
+	logging.Init("schedules")
+	metrics.Init("schedules")
+	ctx := context.Background()
+	if err := tracing.Init(ctx, "schedules"); err != nil {
+		slog.Error("tracing init", "error", err)
+	}
+	tenants, err := tenant.Parse(os.Getenv(tenant.Env))
+	if err != nil {
+		slog.Error("tenant keys", "error", err)
+		os.Exit(1)
+	}
+	db, err := dbconn.Open(ctx, tenant.Options()...)
+	if err != nil {
+		slog.Error("db init", "error", err)
+		os.Exit(1)
+	}
+	svc := &Service{Store: pgStore{db: db}, Tenants: tenants}
+	lambda.Start(svc.Handler)
+}
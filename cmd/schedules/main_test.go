@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}} }))
+}
+
+func call(t *testing.T, method, path string, body any, out any) int {
+	t.Helper()
+	resp, err := svc.Handler(context.Background(), testpg.APIRequest(method, path, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		_ = json.Unmarshal([]byte(resp.Body), out)
+	}
+	return resp.StatusCode
+}
+
+func TestScheduleLifecycle(t *testing.T) {
+	testpg.DB(t)
+	client := "c-" + uuid.NewString()[:8]
+
+	var created Schedule
+	status := call(t, http.MethodPost, "/schedules", map[string]any{
+		"client_id": client, "source_currency": "USD", "target_currency": "EUR", "source_amount": 500,
+		"recurrence": "0 9 * * MON", "timezone": "Europe/Berlin",
+	}, &created)
+	if status != http.StatusCreated || created.ScheduleID == "" || created.Status != statusActive || created.NextRunAt == nil {
+		t.Fatalf("create: %d %+v", status, created)
+	}
+	if local := created.NextRunAt.In(mustLoad(t, "Europe/Berlin")); local.Weekday() != time.Monday || local.Hour() != 9 {
+		t.Errorf("next_run_at = %v, want Monday 09:00 Berlin", local)
+	}
+	path := "/schedules/" + created.ScheduleID
+
+	var list struct{ Schedules []Schedule }
+	if status := call(t, http.MethodGet, "/schedules?client_id="+client, nil, &list); status != http.StatusOK || len(list.Schedules) != 1 {
+		t.Fatalf("list: %d %+v", status, list)
+	}
+
+	var got Schedule
+	if status := call(t, http.MethodPost, path+"/pause", nil, &got); status != http.StatusOK || got.Status != statusPaused {
+		t.Fatalf("pause: %d %+v", status, got)
+	}
+	if status := call(t, http.MethodPost, path+"/pause", nil, nil); status != http.StatusConflict {
+		t.Errorf("second pause: %d, want 409", status)
+	}
+	if status := call(t, http.MethodPatch, path, map[string]any{"source_amount": 750, "max_failures": 5}, &got); status != http.StatusOK || got.SourceAmount != 750 || got.MaxFailures != 5 || got.Status != statusPaused {
+		t.Errorf("patch: %d %+v", status, got)
+	}
+	if status := call(t, http.MethodPost, path+"/resume", nil, &got); status != http.StatusOK || got.Status != statusActive || got.NextRunAt == nil {
+		t.Errorf("resume: %d %+v", status, got)
+	}
+
+	runAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	if status := call(t, http.MethodPatch, path, map[string]any{"run_at": runAt}, &got); status != http.StatusOK || got.Recurrence != "" || got.NextRunAt == nil || !got.NextRunAt.Equal(runAt) {
+		t.Errorf("patch to one-off: %d %+v", status, got)
+	}
+
+	if status := call(t, http.MethodDelete, path, nil, nil); status != http.StatusNoContent {
+		t.Errorf("delete: %d, want 204", status)
+	}
+	if status := call(t, http.MethodDelete, path, nil, nil); status != http.StatusNotFound {
+		t.Errorf("second delete: %d, want 404", status)
+	}
+	if status := call(t, http.MethodPost, path+"/resume", nil, nil); status != http.StatusConflict {
+		t.Errorf("resume cancelled: %d, want 409", status)
+	}
+	if call(t, http.MethodGet, "/schedules?client_id="+client, nil, &list); len(list.Schedules) != 0 {
+		t.Errorf("cancelled schedule still listed: %+v", list)
+	}
+}
+
+func TestResumeReenablesDisabledSchedule(t *testing.T) {
+	pg := testpg.DB(t)
+	var created Schedule
+	call(t, http.MethodPost, "/schedules", map[string]any{
+		"client_id": "c-" + uuid.NewString()[:8], "source_currency": "USD", "target_currency": "EUR", "source_amount": 10, "recurrence": "@daily",
+	}, &created)
+	if _, err := pg.Exec(`UPDATE scheduled_conversions SET status='disabled', consecutive_failures=3, disabled_reason='3 consecutive INSUFFICIENT_FUNDS failures' WHERE schedule_id=$1`, created.ScheduleID); err != nil {
+		t.Fatal(err)
+	}
+
+	var got Schedule
+	if status := call(t, http.MethodPost, "/schedules/"+created.ScheduleID+"/resume", nil, &got); status != http.StatusOK {
+		t.Fatalf("resume: %d", status)
+	}
+	if got.Status != statusActive || got.ConsecutiveFailures != 0 || got.DisabledReason != "" {
+		t.Errorf("resumed = %+v, want active with the failure streak cleared", got)
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	testpg.DB(t)
+	base := func(extra map[string]any) map[string]any {
+		req := map[string]any{"client_id": "c", "source_currency": "USD", "target_currency": "EUR", "source_amount": 10}
+		for k, v := range extra {
+			req[k] = v
+		}
+		return req
+	}
+	for name, req := range map[string]map[string]any{
+		"no timing":      base(nil),
+		"both timings":   base(map[string]any{"recurrence": "@daily", "run_at": time.Now().Add(time.Hour)}),
+		"bad cron":       base(map[string]any{"recurrence": "0 25 * * *"}),
+		"never fires":    base(map[string]any{"recurrence": "0 0 30 2 *"}),
+		"past run_at":    base(map[string]any{"run_at": time.Now().Add(-time.Hour)}),
+		"bad timezone":   base(map[string]any{"recurrence": "@daily", "timezone": "Mars/Olympus"}),
+		"same currency":  base(map[string]any{"recurrence": "@daily", "target_currency": "USD"}),
+		"zero max":       base(map[string]any{"recurrence": "@daily", "max_failures": 0}),
+		"missing client": base(map[string]any{"recurrence": "@daily", "client_id": ""}),
+	} {
+		if status := call(t, http.MethodPost, "/schedules", req, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, status)
+		}
+	}
+	if status := call(t, http.MethodGet, "/schedules/"+uuid.NewString(), nil, nil); status != http.StatusNotFound {
+		t.Errorf("unknown schedule: %d, want 404", status)
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/schedule"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// Schedule statuses. Only active schedules are run by cmd/scheduler.
+const (
+	statusActive    = "active"
+	statusPaused    = "paused"
+	statusDisabled  = "disabled" // too many consecutive funding failures
+	statusCompleted = "completed"
+	statusCancelled = "cancelled"
+)
+
+const (
+	defaultMaxFailures = 3
+	maxMaxFailures     = 100
+)
+
+// Schedule is a one-off (RunAt) or recurring (Recurrence) conversion.
+type Schedule struct {
+	ScheduleID          string     `json:"schedule_id"`
+	ClientID            string     `json:"client_id"`
+	SourceCurrency      string     `json:"source_currency"`
+	TargetCurrency      string     `json:"target_currency"`
+	SourceAmount        float64    `json:"source_amount"`
+	Recurrence          string     `json:"recurrence,omitempty"` // cron expression or RRULE
+	RunAt               *time.Time `json:"run_at,omitempty"`
+	Timezone            string     `json:"timezone"`
+	Status              string     `json:"status"`
+	NextRunAt           *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt           *time.Time `json:"last_run_at,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	MaxFailures         int        `json:"max_failures"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// Store persists schedules.
+type Store interface {
+	CreateSchedule(ctx context.Context, s Schedule) (Schedule, error)
+	// Schedules lists the client's schedules that are not cancelled, oldest first.
+	Schedules(ctx context.Context, clientID string) ([]Schedule, error)
+	// Schedule returns one schedule or sql.ErrNoRows.
+	Schedule(ctx context.Context, scheduleID string) (Schedule, error)
+	// UpdateSchedule locks the schedule, applies fn and saves the result in
+	// one transaction, or returns sql.ErrNoRows. An error from fn aborts it.
+	UpdateSchedule(ctx context.Context, scheduleID string, fn func(*Schedule) error) (Schedule, error)
+}
+
+// Service handles the schedules API.
+type Service struct {
+	Store   Store
+	Now     func() time.Time // defaults to time.Now
+	Tenants tenant.Keys
+}
+
+// scheduleRequest is the POST and PATCH body; PATCH may only change the
+// amount, timing and failure limit.
+type scheduleRequest struct {
+	ClientID       string     `json:"client_id"`
+	SourceCurrency string     `json:"source_currency"`
+	TargetCurrency string     `json:"target_currency"`
+	SourceAmount   *float64   `json:"source_amount"`
+	Recurrence     *string    `json:"recurrence"`
+	RunAt          *time.Time `json:"run_at"`
+	Timezone       *string    `json:"timezone"`
+	MaxFailures    *int       `json:"max_failures"`
+}
+
+// apply copies the fields set in r onto s. Setting a recurrence turns a
+// one-off into a recurring schedule and vice versa.
+func (r scheduleRequest) apply(s *Schedule) {
+	if r.SourceAmount != nil {
+		s.SourceAmount = *r.SourceAmount
+	}
+	if r.Recurrence != nil {
+		s.Recurrence, s.RunAt = strings.TrimSpace(*r.Recurrence), nil
+	}
+	if r.RunAt != nil {
+		s.RunAt, s.Recurrence = r.RunAt, ""
+	}
+	if r.Timezone != nil {
+		s.Timezone = *r.Timezone
+	}
+	if r.MaxFailures != nil {
+		s.MaxFailures = *r.MaxFailures
+	}
+}
+
+// conflictError is returned by UpdateSchedule callbacks for a transition the
+// schedule's status does not allow.
+type conflictError struct{ status string }
+
+func (e conflictError) Error() string { return "schedule is " + e.status }
+
+func (s *Service) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// validationError is a rejected schedule; callbacks return it to make
+// UpdateSchedule answer 400.
+type validationError struct{ err error }
+
+func (e *validationError) Error() string { return e.err.Error() }
+
+// validate checks sched and returns when it should next run.
+func validate(sched Schedule, now time.Time) (time.Time, error) {
+	next, err := nextRun(sched, now)
+	if err != nil {
+		return time.Time{}, &validationError{err}
+	}
+	return next, nil
+}
+
+func nextRun(sched Schedule, now time.Time) (time.Time, error) {
+	if sched.ClientID == "" {
+		return time.Time{}, errors.New("client_id is required")
+	}
+	if err := settlement.Validate(settlement.Job{SourceCurrency: sched.SourceCurrency, TargetCurrency: sched.TargetCurrency, SourceAmount: sched.SourceAmount}); err != nil {
+		return time.Time{}, err
+	}
+	if sched.MaxFailures < 1 || sched.MaxFailures > maxMaxFailures {
+		return time.Time{}, fmt.Errorf("max_failures must be 1 to %d", maxMaxFailures)
+	}
+	if (sched.Recurrence == "") == (sched.RunAt == nil) {
+		return time.Time{}, errors.New("exactly one of recurrence and run_at is required")
+	}
+	if sched.RunAt != nil {
+		if _, err := time.LoadLocation(sched.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %q", sched.Timezone)
+		}
+		if !sched.RunAt.After(now) { // overdue, e.g. while paused: run now
+			return now, nil
+		}
+		return *sched.RunAt, nil
+	}
+	spec, err := schedule.Parse(sched.Recurrence, sched.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("recurrence: %w", err)
+	}
+	return spec.Next(now), nil
+}
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := s.handle(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+// handle supports:
+//
+//	POST   /schedules                       -> create
+//	GET    /schedules?client_id=...         -> list (cancelled schedules omitted)
+//	GET    /schedules/{schedule_id}         -> one schedule
+//	PATCH  /schedules/{schedule_id}         -> change amount, timing or max_failures
+//	DELETE /schedules/{schedule_id}         -> cancel
+//	POST   /schedules/{schedule_id}/pause
+//	POST   /schedules/{schedule_id}/resume  -> also re-enables a disabled schedule
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.WithCorrelationID(ctx, logging.FromRequest(evt))
+	tenantID, err := s.Tenants.Resolve(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	ctx = tenant.With(ctx, tenantID)
+	parts := strings.Split(strings.Trim(evt.Path, "/"), "/")
+	if parts[0] != "schedules" || (len(parts) > 1 && uuid.Validate(parts[1]) != nil) {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	if len(parts) > 1 {
+		ctx = logging.With(ctx, "schedule_id", parts[1])
+	}
+	switch {
+	case len(parts) == 1 && evt.HTTPMethod == http.MethodPost:
+		return s.create(ctx, evt.Body)
+	case len(parts) == 1 && evt.HTTPMethod == http.MethodGet:
+		clientID := evt.QueryStringParameters["client_id"]
+		if clientID == "" {
+			return problem.RespondInvalid(ctx, "client_id", "client_id required")
+		}
+		scheds, err := s.Store.Schedules(ctx, clientID)
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(200, map[string]any{"client_id": clientID, "schedules": scheds})
+	case len(parts) == 2 && evt.HTTPMethod == http.MethodGet:
+		sched, err := s.Store.Schedule(ctx, parts[1])
+		return s.result(ctx, sched, err, http.StatusOK)
+	case len(parts) == 2 && evt.HTTPMethod == http.MethodPatch:
+		return s.update(ctx, parts[1], evt.Body)
+	case len(parts) == 2 && evt.HTTPMethod == http.MethodDelete:
+		_, err := s.Store.UpdateSchedule(ctx, parts[1], func(sched *Schedule) error {
+			switch sched.Status {
+			case statusCancelled:
+				return sql.ErrNoRows
+			case statusCompleted:
+				return conflictError{sched.Status}
+			}
+			sched.Status, sched.NextRunAt = statusCancelled, nil
+			return nil
+		})
+		if err == nil {
+			slog.InfoContext(ctx, "schedule cancelled")
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusNoContent}, nil
+		}
+		return s.result(ctx, Schedule{}, err, 0)
+	case len(parts) == 3 && parts[2] == "pause" && evt.HTTPMethod == http.MethodPost:
+		sched, err := s.Store.UpdateSchedule(ctx, parts[1], func(sched *Schedule) error {
+			if sched.Status != statusActive {
+				return conflictError{sched.Status}
+			}
+			sched.Status = statusPaused
+			return nil
+		})
+		return s.result(ctx, sched, err, http.StatusOK)
+	case len(parts) == 3 && parts[2] == "resume" && evt.HTTPMethod == http.MethodPost:
+		now := s.now()
+		sched, err := s.Store.UpdateSchedule(ctx, parts[1], func(sched *Schedule) error {
+			if sched.Status != statusPaused && sched.Status != statusDisabled {
+				return conflictError{sched.Status}
+			}
+			// Missed occurrences are skipped; an overdue one-off runs now
+			next, err := validate(*sched, now)
+			if err != nil {
+				return err
+			}
+			sched.Status, sched.NextRunAt, sched.ConsecutiveFailures, sched.DisabledReason = statusActive, &next, 0, ""
+			return nil
+		})
+		return s.result(ctx, sched, err, http.StatusOK)
+	}
+	return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+}
+
+func (s *Service) create(ctx context.Context, body string) (events.APIGatewayProxyResponse, error) {
+	var req scheduleRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+	}
+	now := s.now()
+	if req.RunAt != nil && !req.RunAt.After(now) {
+		return problem.RespondInvalid(ctx, "run_at", "run_at must be in the future")
+	}
+	sched := Schedule{ClientID: req.ClientID, SourceCurrency: req.SourceCurrency, TargetCurrency: req.TargetCurrency, Timezone: "UTC", Status: statusActive, MaxFailures: defaultMaxFailures}
+	req.apply(&sched)
+	next, err := validate(sched, now)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	}
+	sched.NextRunAt = &next
+	sched, err = s.Store.CreateSchedule(ctx, sched)
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	slog.InfoContext(ctx, "schedule created", "schedule_id", sched.ScheduleID, "client_id", sched.ClientID, "recurrence", sched.Recurrence, "next_run_at", next)
+	return jsonResponse(http.StatusCreated, sched)
+}
+
+func (s *Service) update(ctx context.Context, scheduleID, body string) (events.APIGatewayProxyResponse, error) {
+	var req scheduleRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+	}
+	if req.ClientID != "" || req.SourceCurrency != "" || req.TargetCurrency != "" {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "client_id and currencies cannot be changed")
+	}
+	now := s.now()
+	if req.RunAt != nil && !req.RunAt.After(now) {
+		return problem.RespondInvalid(ctx, "run_at", "run_at must be in the future")
+	}
+	sched, err := s.Store.UpdateSchedule(ctx, scheduleID, func(sched *Schedule) error {
+		if sched.Status == statusCompleted || sched.Status == statusCancelled {
+			return conflictError{sched.Status}
+		}
+		req.apply(sched)
+		next, err := validate(*sched, now)
+		if err != nil {
+			return err
+		}
+		// Paused and disabled schedules are rescheduled when resumed
+		if sched.Status == statusActive {
+			sched.NextRunAt = &next
+		}
+		return nil
+	})
+	return s.result(ctx, sched, err, http.StatusOK)
+}
+
+// result maps a Store error (or success) to a response. Errors other than
+// not-found, conflicts and server errors come from validate.
+func (s *Service) result(ctx context.Context, sched Schedule, err error, okStatus int) (events.APIGatewayProxyResponse, error) {
+	var conflict conflictError
+	var invalid *validationError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	case errors.As(err, &conflict):
+		return problem.Respond(ctx, http.StatusConflict, problem.Conflict, conflict.Error())
+	case errors.As(err, &invalid):
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, invalid.Error())
+	case err != nil:
+		return problem.ServerError(ctx, err)
+	}
+	return jsonResponse(okStatus, sched)
+}
+
+func jsonResponse(code int, v any) (events.APIGatewayProxyResponse, error) {
+	b, _ := json.Marshal(v)
+	return events.APIGatewayProxyResponse{StatusCode: code, Body: string(b), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+}
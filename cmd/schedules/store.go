@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/irajwani/microservice-go/internal/pgtx"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+const scheduleColumns = `schedule_id, client_id, source_currency, target_currency, source_amount, COALESCE(recurrence, ''), run_at, timezone,
+	status, next_run_at, last_run_at, consecutive_failures, max_failures, COALESCE(disabled_reason, ''), created_at`
+
+type scanner interface{ Scan(dest ...any) error }
+
+func scanSchedule(row scanner) (Schedule, error) {
+	var s Schedule
+	err := row.Scan(&s.ScheduleID, &s.ClientID, &s.SourceCurrency, &s.TargetCurrency, &s.SourceAmount, &s.Recurrence, &s.RunAt, &s.Timezone,
+		&s.Status, &s.NextRunAt, &s.LastRunAt, &s.ConsecutiveFailures, &s.MaxFailures, &s.DisabledReason, &s.CreatedAt)
+	return s, err
+}
+
+func (s pgStore) CreateSchedule(ctx context.Context, sched Schedule) (Schedule, error) {
+	return scanSchedule(s.db.QueryRowContext(ctx, `INSERT INTO scheduled_conversions
+			(client_id, source_currency, target_currency, source_amount, recurrence, run_at, timezone, status, next_run_at, max_failures)
+		VALUES ($1,$2,$3,$4,NULLIF($5,''),$6,$7,$8,$9,$10) RETURNING `+scheduleColumns,
+		sched.ClientID, sched.SourceCurrency, sched.TargetCurrency, sched.SourceAmount, sched.Recurrence, sched.RunAt, sched.Timezone, sched.Status, sched.NextRunAt, sched.MaxFailures))
+}
+
+func (s pgStore) Schedules(ctx context.Context, clientID string) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+scheduleColumns+` FROM scheduled_conversions WHERE client_id=$1 AND status <> 'cancelled' ORDER BY created_at`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Schedule{}
+	for rows.Next() {
+		sched, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sched)
+	}
+	return out, rows.Err()
+}
+
+func (s pgStore) Schedule(ctx context.Context, scheduleID string) (Schedule, error) {
+	return scanSchedule(s.db.QueryRowContext(ctx, `SELECT `+scheduleColumns+` FROM scheduled_conversions WHERE schedule_id=$1`, scheduleID))
+}
+
+func (s pgStore) UpdateSchedule(ctx context.Context, scheduleID string, fn func(*Schedule) error) (Schedule, error) {
+	var sched Schedule
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		sched, err = scanSchedule(tx.QueryRowContext(ctx, `SELECT `+scheduleColumns+` FROM scheduled_conversions WHERE schedule_id=$1 FOR UPDATE`, scheduleID))
+		if err != nil {
+			return err
+		}
+		if err := fn(&sched); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE scheduled_conversions SET source_amount=$2, recurrence=NULLIF($3,''), run_at=$4, timezone=$5, status=$6,
+			next_run_at=$7, consecutive_failures=$8, max_failures=$9, disabled_reason=NULLIF($10,'') WHERE schedule_id=$1`,
+			scheduleID, sched.SourceAmount, sched.Recurrence, sched.RunAt, sched.Timezone, sched.Status,
+			sched.NextRunAt, sched.ConsecutiveFailures, sched.MaxFailures, sched.DisabledReason)
+		return err
+	})
+	return sched, err
+}
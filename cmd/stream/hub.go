@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Event is one live update for a user, as sent by the notify triggers.
+type Event struct {
+	Type     string          `json:"type"` // job | balance | resync
+	TenantID string          `json:"tenant_id"`
+	UserID   string          `json:"user_id"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// audience is whom an event is for: user ids are only unique per tenant.
+type audience struct{ tenantID, userID string }
+
+// subscriberBuffer bounds the events queued for one slow client; beyond it the
+// client is disconnected and resyncs when its EventSource reconnects.
+const subscriberBuffer = 64
+
+type subscriber struct {
+	events chan Event
+	closed bool
+}
+
+// Hub fans events out to the streams subscribed to each user.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[audience]map[*subscriber]struct{}
+}
+
+// NewHub returns an empty Hub.
+func NewHub() *Hub {
+	return &Hub{subs: map[audience]map[*subscriber]struct{}{}}
+}
+
+// Subscribe registers a stream for the tenant's user. The channel is closed
+// when the subscriber falls too far behind or after cancel is called.
+func (h *Hub) Subscribe(tenantID, userID string) (<-chan Event, func()) {
+	to := audience{tenantID, userID}
+	sub := &subscriber{events: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	if h.subs[to] == nil {
+		h.subs[to] = map[*subscriber]struct{}{}
+	}
+	h.subs[to][sub] = struct{}{}
+	h.mu.Unlock()
+	return sub.events, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(to, sub)
+	}
+}
+
+// Publish delivers e to the user's streams without blocking.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	to := audience{e.TenantID, e.UserID}
+	for sub := range h.subs[to] {
+		h.send(to, sub, e)
+	}
+}
+
+// Broadcast delivers an event of type typ to every stream.
+func (h *Hub) Broadcast(typ string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for to, subs := range h.subs {
+		for sub := range subs {
+			h.send(to, sub, Event{Type: typ, TenantID: to.tenantID, UserID: to.userID, Data: json.RawMessage(`{}`)})
+		}
+	}
+}
+
+// Subscribers returns the number of open streams.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, subs := range h.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+func (h *Hub) send(to audience, sub *subscriber, e Event) {
+	select {
+	case sub.events <- e:
+	default:
+		h.remove(to, sub)
+	}
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(to audience, sub *subscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	delete(h.subs[to], sub)
+	if len(h.subs[to]) == 0 {
+		delete(h.subs, to)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// channel is the NOTIFY channel written by the 0005_live_updates triggers.
+const channel = "live_updates"
+
+// Listen feeds hub from LISTEN live_updates until ctx is done, reconnecting
+// with backoff. After every reconnect it broadcasts a resync event, since
+// notifications sent while disconnected are lost.
+func Listen(ctx context.Context, db *sql.DB, hub *Hub) {
+	delay := time.Second
+	for first := true; ; first = false {
+		start := time.Now()
+		err := listen(ctx, db, hub, !first)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			delay = time.Second
+		}
+		slog.WarnContext(ctx, "listener disconnected", "error", err, "retry_in", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, 30*time.Second)
+	}
+}
+
+// listen holds one pooled connection in LISTEN mode and publishes every
+// notification until the connection or ctx fails.
+func listen(ctx context.Context, db *sql.DB, hub *Hub, resync bool) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		pc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("LISTEN needs a pgx connection, got %T", driverConn)
+		}
+		if _, err := pc.Conn().Exec(ctx, "LISTEN "+channel); err != nil {
+			return err
+		}
+		// Don't hand a listening connection back to the pool
+		defer pc.Conn().Exec(context.Background(), "UNLISTEN *")
+		slog.InfoContext(ctx, "listening", "channel", channel)
+		if resync {
+			hub.Broadcast("resync")
+		}
+		for {
+			n, err := pc.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Payload), &e); err != nil || e.UserID == "" {
+				slog.WarnContext(ctx, "bad notification", "payload", n.Payload, "error", err)
+				continue
+			}
+			hub.Publish(e)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+// Command stream is a long-running HTTP server (not a Lambda) pushing job and
+// balance changes to clients as Server-Sent Events, fed by Postgres
+// LISTEN/NOTIFY.
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/dbconn"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	logging.Init("stream")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	db, err := dbconn.Open(ctx, tenant.Options()...)
+	if err != nil {
+		slog.Error("db init", "error", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	tenants, err := tenant.Parse(os.Getenv(tenant.Env))
+	if err != nil {
+		slog.Error("tenant keys", "error", err)
+		os.Exit(1)
+	}
+
+	hub := NewHub()
+	go Listen(ctx, db, hub)
+	svc := &Service{Hub: hub, Tenants: tenants, AllowOrigin: getenv("STREAM_ALLOW_ORIGIN", "*")}
+	srv := &http.Server{
+		Addr:              getenv("STREAM_ADDR", ":8080"),
+		Handler:           svc.Routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+		// Streams watch this context, so they end on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	slog.Info("stream server listening", "addr", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("serve", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var hub *Hub
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) {
+		hub = NewHub()
+		go Listen(context.Background(), d, hub)
+	}))
+}
+
+// next returns the next event of type typ on events, failing t after 5s.
+func next(t *testing.T, events <-chan Event, typ string) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event within 5s", typ)
+			return Event{}
+		}
+	}
+}
+
+func TestTriggersStreamBalanceAndJobChanges(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{})
+	events, cancel := hub.Subscribe(tenant.Default, user)
+	defer cancel()
+
+	// The listener connects in the background; write until it hears us
+	var first Event
+	for deadline := time.Now().Add(10 * time.Second); first.Type == ""; {
+		if time.Now().After(deadline) {
+			t.Fatal("listener never received a notification")
+		}
+		testpg.FundedUser(t, pg, testpg.Funds{}) // other users' changes must not reach this stream
+		if _, err := pg.Exec(`INSERT INTO accounts (user_id, currency, balance) VALUES ($1,'USD',100) ON CONFLICT (tenant_id, user_id, currency) DO UPDATE SET balance=100`, user); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case first = <-events:
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	var bal struct {
+		Currency string
+		Balance  float64
+	}
+	if err := json.Unmarshal(first.Data, &bal); err != nil || first.Type != "balance" || bal.Currency != "USD" || bal.Balance != 100 {
+		t.Fatalf("first event = %s %s (%v)", first.Type, first.Data, err)
+	}
+	// Rolled-back changes are never streamed
+	tx, err := pg.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`UPDATE accounts SET balance=1 WHERE user_id=$1`, user); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Rollback()
+	if _, err := pg.Exec(`UPDATE accounts SET balance=50 WHERE user_id=$1`, user); err != nil {
+		t.Fatal(err)
+	}
+	for e := next(t, events, "balance"); ; e = next(t, events, "balance") {
+		_ = json.Unmarshal(e.Data, &bal)
+		if bal.Balance == 1 {
+			t.Fatal("rolled-back balance was streamed")
+		}
+		if bal.Balance == 50 {
+			break
+		}
+	}
+
+	jobID := testpg.QueuedJob(t, pg, user, "USD", "EUR", 10)
+	var job struct {
+		JobID  string `json:"job_id"`
+		Status string
+	}
+	e := next(t, events, "job")
+	_ = json.Unmarshal(e.Data, &job)
+	if e.Type != "job" || job.JobID != jobID || job.Status != "queued" {
+		t.Fatalf("event = %s %s, want job %s queued", e.Type, e.Data, jobID)
+	}
+	if _, err := pg.Exec(`UPDATE conversion_jobs SET status='completed' WHERE job_id=$1`, jobID); err != nil {
+		t.Fatal(err)
+	}
+	e = next(t, events, "job")
+	_ = json.Unmarshal(e.Data, &job)
+	if e.Type != "job" || job.Status != "completed" {
+		t.Errorf("event = %s %s, want job completed", e.Type, e.Data)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+// heartbeat keeps idle streams open through proxies and load balancers.
+const heartbeat = 15 * time.Second
+
+// tokenTTL is how long a stream token may be used to open a stream. Open
+// streams outlive it.
+const tokenTTL = 5 * time.Minute
+
+// Service serves GET /stream?user_id=... as Server-Sent Events to callers
+// holding their tenant's API key, or a stream token issued for the user by
+// GET /stream/token.
+type Service struct {
+	Hub     *Hub
+	Tenants tenant.Keys
+	// AllowOrigin is sent as Access-Control-Allow-Origin so browser clients on
+	// another origin can use EventSource.
+	AllowOrigin string
+}
+
+// Routes returns the server's handler.
+func (s *Service) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stream", s.stream)
+	mux.HandleFunc("GET /stream/token", s.token)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprintln(w, "ok") })
+	mux.Handle("GET /metrics", metrics.Handler())
+	return mux
+}
+
+// TokenResponse is the body of GET /stream/token.
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// token issues a stream token for ?user_id= to a caller holding the tenant's
+// API key, typically a web server handing it on to a browser's EventSource
+// as /stream?user_id=...&token=...
+func (s *Service) token(w http.ResponseWriter, r *http.Request) {
+	ctx := logging.WithCorrelationID(r.Context(), r.Header.Get(logging.Header))
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	userID := r.URL.Query().Get("user_id")
+	exp := time.Now().Add(tokenTTL).Truncate(time.Second)
+	token, err := s.Tenants.Token(key, userID, exp)
+	if err != nil {
+		problem.New(http.StatusUnauthorized, problem.Unauthorized, err.Error()).Write(ctx, w)
+		return
+	}
+	if userID == "" {
+		problem.Invalid("user_id required", problem.FieldError{Field: "user_id", Detail: "user_id required"}).Write(ctx, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(TokenResponse{Token: token, ExpiresAt: exp.UTC()})
+}
+
+// authenticate returns the tenant of a stream request: from the stream token
+// in ?token= when there is one, else from the API key.
+func (s *Service) authenticate(r *http.Request, userID string) (string, error) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return s.Tenants.VerifyToken(token, userID, time.Now())
+	}
+	return s.Tenants.Bearer(r.Header.Get("Authorization"))
+}
+
+// stream writes one SSE event per job or balance change of the user:
+//
+//	event: job | balance | resync
+//	data: {...}
+//
+// resync means updates may have been missed and the client should re-fetch.
+func (s *Service) stream(w http.ResponseWriter, r *http.Request) {
+	if s.AllowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", s.AllowOrigin)
+	}
+	ctx := logging.WithCorrelationID(r.Context(), r.Header.Get(logging.Header))
+	userID := r.URL.Query().Get("user_id")
+	tenantID, err := s.authenticate(r, userID)
+	if err != nil {
+		problem.New(http.StatusUnauthorized, problem.Unauthorized, err.Error()).Write(ctx, w)
+		return
+	}
+	ctx = logging.With(tenant.With(ctx, tenantID), "user_id", userID)
+	if userID == "" {
+		problem.Invalid("user_id required", problem.FieldError{Field: "user_id", Detail: "user_id required"}).Write(ctx, w)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem.New(http.StatusInternalServerError, problem.Internal, "streaming unsupported").Write(ctx, w)
+		return
+	}
+
+	events, cancel := s.Hub.Subscribe(tenantID, userID)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+	slog.InfoContext(ctx, "stream opened", "streams", s.Hub.Subscribers())
+	defer slog.InfoContext(ctx, "stream closed")
+
+	tick := time.NewTicker(heartbeat)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, open := <-events:
+			if !open {
+				// Fell behind; the client reconnects and resyncs
+				fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, e.Data)
+		}
+		flusher.Flush()
+	}
+}
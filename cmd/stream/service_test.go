@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+func TestHubRoutesEventsByTenantAndUser(t *testing.T) {
+	hub := NewHub()
+	u1, cancel1 := hub.Subscribe("t1", "u1")
+	defer cancel1()
+	u2, cancel2 := hub.Subscribe("t1", "u2")
+
+	hub.Publish(Event{Type: "balance", TenantID: "t1", UserID: "u1", Data: json.RawMessage(`{"currency":"USD"}`)})
+	if e := <-u1; e.Type != "balance" || string(e.Data) != `{"currency":"USD"}` {
+		t.Errorf("u1 got %+v", e)
+	}
+	hub.Publish(Event{Type: "balance", TenantID: "t2", UserID: "u1"})
+	select {
+	case e := <-u1:
+		t.Errorf("u1 got another tenant's event %+v", e)
+	case e := <-u2:
+		t.Errorf("u2 got u1's event %+v", e)
+	default:
+	}
+
+	cancel2()
+	cancel2() // idempotent
+	if _, open := <-u2; open || hub.Subscribers() != 1 {
+		t.Errorf("cancelled stream still open (subscribers %d)", hub.Subscribers())
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewHub()
+	events, cancel := hub.Subscribe("t1", "u1")
+	defer cancel()
+	for range subscriberBuffer + 1 {
+		hub.Publish(Event{Type: "job", TenantID: "t1", UserID: "u1"})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != subscriberBuffer || hub.Subscribers() != 0 {
+		t.Errorf("drained %d events, %d subscribers; want %d and the slow stream closed", n, hub.Subscribers(), subscriberBuffer)
+	}
+}
+
+func TestStreamWritesServerSentEvents(t *testing.T) {
+	hub := NewHub()
+	srv := httptest.NewServer((&Service{Hub: hub, Tenants: tenant.Keys{testKey: "t1"}, AllowOrigin: "*"}).Routes())
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL + "/stream?user_id=u1"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without key: %v %v", resp.Status, err)
+	}
+	if resp, err := get(srv.URL + "/stream"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("without user_id: %v %v", resp.Status, err)
+	}
+
+	resp, err := get(srv.URL + "/stream?user_id=u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("headers = %v", resp.Header)
+	}
+	for deadline := time.Now().Add(2 * time.Second); hub.Subscribers() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("stream never subscribed")
+		}
+	}
+	hub.Publish(Event{Type: "job", TenantID: "t1", UserID: "u1", Data: json.RawMessage(`{"status":"completed"}`)})
+
+	got := readEvent(t, bufio.NewReader(resp.Body))
+	if got != "event: job\ndata: {\"status\":\"completed\"}" {
+		t.Errorf("event = %q", got)
+	}
+}
+
+func TestStreamAcceptsTokens(t *testing.T) {
+	hub := NewHub()
+	srv := httptest.NewServer((&Service{Hub: hub, Tenants: tenant.Keys{testKey: "t1"}}).Routes())
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL + "/stream/token?user_id=u1"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("token without key: %v %v", resp.Status, err)
+	}
+	if resp, err := get(srv.URL + "/stream/token"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("token without user_id: %v %v", resp.Status, err)
+	}
+	resp, err := get(srv.URL + "/stream/token?user_id=u1")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("token: %v %v", resp.Status, err)
+	}
+	var tok TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tok)
+	resp.Body.Close()
+	if err != nil || tok.Token == "" || time.Until(tok.ExpiresAt) > tokenTTL {
+		t.Fatalf("token = %+v, %v", tok, err)
+	}
+
+	// The token only opens the stream of the user it was issued for
+	if resp, err := http.Get(srv.URL + "/stream?user_id=u2&token=" + url.QueryEscape(tok.Token)); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("token of another user: %v %v", resp.Status, err)
+	}
+	resp, err = http.Get(srv.URL + "/stream?user_id=u1&token=" + url.QueryEscape(tok.Token))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream with token: %v %v", resp.Status, err)
+	}
+	defer resp.Body.Close()
+	for deadline := time.Now().Add(2 * time.Second); hub.Subscribers() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("stream never subscribed")
+		}
+	}
+	hub.Publish(Event{Type: "balance", TenantID: "t1", UserID: "u1", Data: json.RawMessage(`{"currency":"USD"}`)})
+	if got := readEvent(t, bufio.NewReader(resp.Body)); got != "event: balance\ndata: {\"currency\":\"USD\"}" {
+		t.Errorf("event = %q", got)
+	}
+}
+
+const testKey = "t1-key-0123456789"
+
+// get requests url with the API key of tenant t1.
+func get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+testKey)
+	return http.DefaultClient.Do(req)
+}
+
+// readEvent returns the next SSE event block, skipping retry: and comment lines.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(lines) > 0:
+			return strings.Join(lines, "\n")
+		case line == "", strings.HasPrefix(line, ":"), strings.HasPrefix(line, "retry:"):
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
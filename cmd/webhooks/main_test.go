@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}} }))
+}
+
+func call(t *testing.T, method, path string, body any, out any) int {
+	t.Helper()
+	resp, err := svc.Handler(context.Background(), testpg.APIRequest(method, path, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		_ = json.Unmarshal([]byte(resp.Body), out)
+	}
+	return resp.StatusCode
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	testpg.DB(t)
+	client := "c-" + uuid.NewString()[:8]
+
+	var created Subscription
+	status := call(t, http.MethodPost, "/webhooks", map[string]any{"client_id": client, "url": "https://example.com/hook", "events": []string{"conversion.completed"}}, &created)
+	if status != http.StatusCreated || created.SubscriptionID == "" || !strings.HasPrefix(created.Secret, "whsec_") {
+		t.Fatalf("create: %d %+v", status, created)
+	}
+
+	var list struct{ Subscriptions []Subscription }
+	if status := call(t, http.MethodGet, "/webhooks?client_id="+client, nil, &list); status != http.StatusOK || len(list.Subscriptions) != 1 {
+		t.Fatalf("list: %d %+v", status, list)
+	}
+	if got := list.Subscriptions[0]; got.Secret != "" || len(got.Events) != 1 || got.Events[0] != "conversion.completed" {
+		t.Errorf("listed subscription = %+v, want events without the secret", got)
+	}
+
+	if status := call(t, http.MethodDelete, "/webhooks/"+created.SubscriptionID, nil, nil); status != http.StatusNoContent {
+		t.Errorf("delete: %d, want 204", status)
+	}
+	if status := call(t, http.MethodDelete, "/webhooks/"+created.SubscriptionID, nil, nil); status != http.StatusNotFound {
+		t.Errorf("second delete: %d, want 404", status)
+	}
+	if call(t, http.MethodGet, "/webhooks?client_id="+client, nil, &list); len(list.Subscriptions) != 0 {
+		t.Errorf("deactivated subscription still listed: %+v", list)
+	}
+}
+
+func TestSubscriptionValidation(t *testing.T) {
+	testpg.DB(t)
+	for name, req := range map[string]map[string]any{
+		"missing client": {"url": "https://example.com", "events": []string{"conversion.completed"}},
+		"relative url":   {"client_id": "c", "url": "/hook", "events": []string{"conversion.completed"}},
+		"plain http":     {"client_id": "c", "url": "http://example.com/hook", "events": []string{"conversion.completed"}},
+		"metadata ip":    {"client_id": "c", "url": "https://169.254.169.254/latest", "events": []string{"conversion.completed"}},
+		"no events":      {"client_id": "c", "url": "https://example.com"},
+		"unknown event":  {"client_id": "c", "url": "https://example.com", "events": []string{"conversion.started"}},
+	} {
+		if status := call(t, http.MethodPost, "/webhooks", req, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, status)
+		}
+	}
+}
+
+func TestDeliveryLogAndReplay(t *testing.T) {
+	pg := testpg.DB(t)
+	client := "c-" + uuid.NewString()[:8]
+	var sub Subscription
+	call(t, http.MethodPost, "/webhooks", map[string]any{"client_id": client, "url": "https://example.com/hook", "events": []string{"conversion.failed"}}, &sub)
+
+	jobID := uuid.NewString()
+	outboxID := testpg.AppendOutbox(t, pg, jobID, settlement.Topic, map[string]any{"event": "conversion.failed", "job_id": jobID, "user_id": client})
+	var deliveryID string
+	if err := pg.QueryRow(`INSERT INTO webhook_deliveries (subscription_id, outbox_id, event, payload, status, attempts, last_status_code, last_error)
+		SELECT $1, outbox_id, 'conversion.failed', payload, 'failed', 8, 500, 'HTTP 500' FROM outbox WHERE outbox_id=$2 RETURNING delivery_id`, sub.SubscriptionID, outboxID).Scan(&deliveryID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pg.Exec(`INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms) VALUES ($1, 500, 'HTTP 500', 12)`, deliveryID); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct{ Deliveries []Delivery }
+	if status := call(t, http.MethodGet, "/webhooks/"+sub.SubscriptionID+"/deliveries", nil, &log); status != http.StatusOK || len(log.Deliveries) != 1 {
+		t.Fatalf("deliveries: %d %+v", status, log)
+	}
+	if d := log.Deliveries[0]; d.Status != "failed" || len(d.History) != 1 || *d.History[0].StatusCode != 500 {
+		t.Errorf("delivery = %+v", d)
+	}
+
+	if status := call(t, http.MethodPost, "/webhooks/deliveries/"+deliveryID+"/replay", nil, nil); status != http.StatusAccepted {
+		t.Fatalf("replay: %d, want 202", status)
+	}
+	var st string
+	var attempts int
+	if err := pg.QueryRow(`SELECT status, attempts FROM webhook_deliveries WHERE delivery_id=$1`, deliveryID).Scan(&st, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if st != "pending" || attempts != 0 {
+		t.Errorf("after replay: %s with %d attempts, want pending with 0", st, attempts)
+	}
+	if status := call(t, http.MethodPost, "/webhooks/deliveries/"+uuid.NewString()+"/replay", nil, nil); status != http.StatusNotFound {
+		t.Errorf("replay unknown delivery: %d, want 404", status)
+	}
+}
+
+func TestDeliveriesStayWithinTenant(t *testing.T) {
+	pg := testpg.DB(t)
+	client := "c-" + uuid.NewString()[:8]
+	var sub Subscription
+	call(t, http.MethodPost, "/webhooks", map[string]any{"client_id": client, "url": "https://example.com/hook", "events": []string{"conversion.failed"}}, &sub)
+	jobID := uuid.NewString()
+	outboxID := testpg.AppendOutbox(t, pg, jobID, settlement.Topic, map[string]any{"event": "conversion.failed", "job_id": jobID, "user_id": client})
+	var deliveryID string
+	if err := pg.QueryRow(`INSERT INTO webhook_deliveries (subscription_id, outbox_id, event, payload, status)
+		SELECT $1, outbox_id, 'conversion.failed', payload, 'failed' FROM outbox WHERE outbox_id=$2 RETURNING delivery_id`, sub.SubscriptionID, outboxID).Scan(&deliveryID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pg.Exec(`INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms) VALUES ($1, 500, 'HTTP 500', 12)`, deliveryID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another tenant that knows the subscription and delivery ids
+	other := testpg.Tenant(t, pg)
+	as := func(method, path string) (int, string) {
+		req := testpg.APIRequest(method, path, nil)
+		req.RequestContext.Authorizer["tenant_id"] = other
+		resp, err := svc.Handler(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, resp.Body
+	}
+	var log struct{ Deliveries []Delivery }
+	status, body := as(http.MethodGet, "/webhooks/"+sub.SubscriptionID+"/deliveries")
+	if err := json.Unmarshal([]byte(body), &log); err != nil || status != http.StatusOK || len(log.Deliveries) != 0 {
+		t.Errorf("other tenant's deliveries: %d %s, want none", status, body)
+	}
+	if status, _ := as(http.MethodPost, "/webhooks/deliveries/"+deliveryID+"/replay"); status != http.StatusNotFound {
+		t.Errorf("other tenant's replay: %d, want 404", status)
+	}
+
+	if status := call(t, http.MethodGet, "/webhooks/"+sub.SubscriptionID+"/deliveries", nil, &log); status != http.StatusOK || len(log.Deliveries) != 1 || len(log.Deliveries[0].History) != 1 {
+		t.Errorf("own deliveries: %d %+v", status, log)
+	}
+}
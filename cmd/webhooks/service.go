@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"github.com/irajwani/microservice-go/internal/webhooks"
+)
+
+// Subscription is a client's webhook endpoint.
+type Subscription struct {
+	SubscriptionID string    `json:"subscription_id"`
+	ClientID       string    `json:"client_id"`
+	URL            string    `json:"url"`
+	Events         []string  `json:"events"`
+	Secret         string    `json:"secret,omitempty"` // only returned when the subscription is created
+	Active         bool      `json:"active"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Delivery is one event sent (or being sent) to one subscription.
+type Delivery struct {
+	DeliveryID     string          `json:"delivery_id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"` // pending | delivered | failed
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	History        []Attempt       `json:"history"`
+}
+
+// Attempt is one logged HTTP attempt of a delivery.
+type Attempt struct {
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// Store persists subscriptions and reads the delivery log.
+type Store interface {
+	CreateSubscription(ctx context.Context, sub Subscription) (Subscription, error)
+	// Subscriptions lists the client's active subscriptions, secrets omitted.
+	Subscriptions(ctx context.Context, clientID string) ([]Subscription, error)
+	// DeactivateSubscription stops deliveries to a subscription, failing any
+	// still pending, or returns sql.ErrNoRows.
+	DeactivateSubscription(ctx context.Context, subscriptionID string) error
+	// Deliveries lists a subscription's deliveries with their attempt logs, most recent first.
+	Deliveries(ctx context.Context, subscriptionID string, limit int) ([]Delivery, error)
+	// ReplayDelivery queues a delivery to be sent again now with a fresh retry
+	// budget, whatever its status, or returns sql.ErrNoRows (also when the
+	// subscription has been deactivated).
+	ReplayDelivery(ctx context.Context, deliveryID string) error
+}
+
+// Service handles the webhook subscription API.
+type Service struct {
+	Store   Store
+	Tenants tenant.Keys
+}
+
+type subscriptionRequest struct {
+	ClientID string   `json:"client_id"`
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+	Secret   string   `json:"secret"` // optional; generated when empty
+}
+
+func validate(req subscriptionRequest) error {
+	if req.ClientID == "" {
+		return errors.New("client_id is required")
+	}
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		return err
+	}
+	if len(req.Events) == 0 {
+		return errors.New("events is required")
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhooks.Events, e) {
+			return fmt.Errorf("unknown event %q (want one of %s)", e, strings.Join(webhooks.Events, ", "))
+		}
+	}
+	return nil
+}
+
+// Handler wraps handle in the request span.
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := s.handle(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+// handle supports:
+//
+//	POST   /webhooks                              -> create subscription (secret returned once)
+//	GET    /webhooks?client_id=...                -> list active subscriptions
+//	DELETE /webhooks/{subscription_id}            -> deactivate
+//	GET    /webhooks/{subscription_id}/deliveries -> delivery log (limit=N, default 50)
+//	POST   /webhooks/deliveries/{delivery_id}/replay
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx = logging.WithCorrelationID(ctx, logging.FromRequest(evt))
+	tenantID, err := s.Tenants.Resolve(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	ctx = tenant.With(ctx, tenantID)
+	parts := strings.Split(strings.Trim(evt.Path, "/"), "/")
+	if parts[0] != "webhooks" {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+	// Ids are UUIDs; anything else cannot exist
+	for _, p := range parts[1:] {
+		if p != "deliveries" && p != "replay" && uuid.Validate(p) != nil {
+			return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+		}
+	}
+	switch {
+	case len(parts) == 1 && evt.HTTPMethod == http.MethodPost:
+		return s.create(ctx, evt.Body)
+	case len(parts) == 1 && evt.HTTPMethod == http.MethodGet:
+		clientID := evt.QueryStringParameters["client_id"]
+		if clientID == "" {
+			return problem.RespondInvalid(ctx, "client_id", "client_id required")
+		}
+		subs, err := s.Store.Subscriptions(ctx, clientID)
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(200, map[string]any{"client_id": clientID, "subscriptions": subs})
+	case len(parts) == 2 && evt.HTTPMethod == http.MethodDelete:
+		return s.result(ctx, s.Store.DeactivateSubscription(ctx, parts[1]), http.StatusNoContent)
+	case len(parts) == 3 && parts[2] == "deliveries" && evt.HTTPMethod == http.MethodGet:
+		limit := 50
+		if n, err := strconv.Atoi(evt.QueryStringParameters["limit"]); err == nil && n > 0 && n <= 500 {
+			limit = n
+		}
+		deliveries, err := s.Store.Deliveries(ctx, parts[1], limit)
+		if err != nil {
+			return problem.ServerError(ctx, err)
+		}
+		return jsonResponse(200, map[string]any{"subscription_id": parts[1], "deliveries": deliveries})
+	case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "replay" && evt.HTTPMethod == http.MethodPost:
+		ctx = logging.With(ctx, "delivery_id", parts[2])
+		if err := s.Store.ReplayDelivery(ctx, parts[2]); err != nil {
+			return s.result(ctx, err, 0)
+		}
+		slog.InfoContext(ctx, "webhook delivery replayed")
+		return jsonResponse(http.StatusAccepted, map[string]string{"delivery_id": parts[2], "status": "pending"})
+	}
+	return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+}
+
+func (s *Service) create(ctx context.Context, body string) (events.APIGatewayProxyResponse, error) {
+	var req subscriptionRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, "invalid json")
+	}
+	if err := validate(req); err != nil {
+		return problem.Respond(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	}
+	if req.Secret == "" {
+		req.Secret = webhooks.NewSecret()
+	}
+	sub, err := s.Store.CreateSubscription(ctx, Subscription{ClientID: req.ClientID, URL: req.URL, Events: slices.Compact(slices.Sorted(slices.Values(req.Events))), Secret: req.Secret, Active: true})
+	if err != nil {
+		return problem.ServerError(ctx, err)
+	}
+	slog.InfoContext(ctx, "webhook subscription created", "subscription_id", sub.SubscriptionID, "client_id", sub.ClientID, "events", sub.Events)
+	return jsonResponse(http.StatusCreated, sub)
+}
+
+// result maps a Store error (or success) to a response.
+func (s *Service) result(ctx context.Context, err error, okStatus int) (events.APIGatewayProxyResponse, error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	case err != nil:
+		return problem.ServerError(ctx, err)
+	}
+	return events.APIGatewayProxyResponse{StatusCode: okStatus}, nil
+}
+
+func jsonResponse(code int, v any) (events.APIGatewayProxyResponse, error) {
+	b, _ := json.Marshal(v)
+	return events.APIGatewayProxyResponse{StatusCode: code, Body: string(b), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/irajwani/microservice-go/internal/pgtx"
+)
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+func (s pgStore) CreateSubscription(ctx context.Context, sub Subscription) (Subscription, error) {
+	err := s.db.QueryRowContext(ctx, `INSERT INTO webhook_subscriptions (client_id, url, events, secret) VALUES ($1,$2,$3,$4) RETURNING subscription_id, created_at`,
+		sub.ClientID, sub.URL, sub.Events, sub.Secret).Scan(&sub.SubscriptionID, &sub.CreatedAt)
+	return sub, err
+}
+
+func (s pgStore) Subscriptions(ctx context.Context, clientID string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT subscription_id, client_id, url, array_to_json(events), active, created_at
+		FROM webhook_subscriptions WHERE client_id=$1 AND active ORDER BY created_at`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		var evts []byte
+		if err := rows.Scan(&sub.SubscriptionID, &sub.ClientID, &sub.URL, &evts, &sub.Active, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(evts, &sub.Events); err != nil {
+			return nil, err
+		}
+		out = append(out, sub)
+	}
+	return out, rows.Err()
+}
+
+func (s pgStore) DeactivateSubscription(ctx context.Context, subscriptionID string) error {
+	return pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE webhook_subscriptions SET active=false WHERE subscription_id=$1 AND active`, subscriptionID)
+		if err := affected(res, err); err != nil {
+			return err
+		}
+		// Nothing will send them any more
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status='failed', last_error='subscription deactivated' WHERE subscription_id=$1 AND status='pending'`, subscriptionID)
+		return err
+	})
+}
+
+func (s pgStore) Deliveries(ctx context.Context, subscriptionID string, limit int) ([]Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT d.delivery_id, d.subscription_id, d.event, d.payload, d.status, d.attempts,
+			CASE WHEN d.status='pending' THEN d.next_attempt_at END, d.last_status_code, COALESCE(d.last_error,''), d.delivered_at, d.created_at,
+			COALESCE((SELECT json_agg(json_build_object('status_code', a.status_code, 'error', a.error, 'duration_ms', a.duration_ms, 'attempted_at', a.attempted_at) ORDER BY a.attempt_id)
+				FROM webhook_attempts a WHERE a.delivery_id = d.delivery_id), '[]')
+		FROM webhook_deliveries d WHERE d.subscription_id=$1 ORDER BY d.created_at DESC LIMIT $2`, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var payload, history []byte
+		if err := rows.Scan(&d.DeliveryID, &d.SubscriptionID, &d.Event, &payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.DeliveredAt, &d.CreatedAt, &history); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		if err := json.Unmarshal(history, &d.History); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s pgStore) ReplayDelivery(ctx context.Context, deliveryID string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE webhook_deliveries d SET status='pending', attempts=0, next_attempt_at=now(), delivered_at=NULL
+		FROM webhook_subscriptions s WHERE d.delivery_id=$1 AND s.subscription_id=d.subscription_id AND s.active`, deliveryID)
+	return affected(res, err)
+}
+
+// affected turns "no row updated" into sql.ErrNoRows.
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
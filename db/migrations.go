@@ -0,0 +1,10 @@
+// Package db embeds the SQL migrations so cmd/migrate and the Lambdas can apply
+// and verify them without shipping the files separately.
+package db
+
+import "embed"
+
+// Migrations holds migrations/NNNN_name.sql (up) and migrations/down/NNNN_name.sql (down).
+//
+//go:embed migrations/*.sql migrations/down/*.sql
+var Migrations embed.FS
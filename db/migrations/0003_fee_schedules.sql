@@ -0,0 +1,54 @@
+-- 0003_fee_schedules.sql
+-- Configurable fee schedules: notional tiers, per-pair / per-client overrides, absolute min/max fees and
+-- promotional windows. Schedules are versioned; a change is a new row (version + 1) and the old one is
+-- deactivated so every job can point at the exact schedule version that priced it.
+
+CREATE TABLE IF NOT EXISTS fee_schedules (
+  schedule_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  version INT NOT NULL DEFAULT 1 CHECK (version > 0),
+  client_id TEXT,                                                       -- NULL = all clients
+  source_currency CHAR(3) CHECK (source_currency ~ '^[A-Z]{3}$'),       -- NULL = any source
+  target_currency CHAR(3) CHECK (target_currency ~ '^[A-Z]{3}$'),       -- NULL = any target
+  min_fee NUMERIC(20,8) NOT NULL DEFAULT 0 CHECK (min_fee >= 0),        -- absolute, target currency units
+  max_fee NUMERIC(20,8) CHECK (max_fee IS NULL OR max_fee >= min_fee),  -- absolute, target currency units
+  valid_from TIMESTAMPTZ,                                               -- promotional window start (NULL = open)
+  valid_until TIMESTAMPTZ CHECK (valid_until IS NULL OR valid_from IS NULL OR valid_until > valid_from),
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (name, version)
+);
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_fee_schedules_updated_at BEFORE UPDATE ON fee_schedules
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_fee_schedules_lookup ON fee_schedules (client_id, source_currency, target_currency) WHERE active;
+
+-- Tiers by notional (source currency units). The tier with the highest min_notional <= notional applies.
+CREATE TABLE IF NOT EXISTS fee_schedule_tiers (
+  schedule_id UUID NOT NULL REFERENCES fee_schedules(schedule_id) ON DELETE CASCADE,
+  min_notional NUMERIC(20,8) NOT NULL DEFAULT 0 CHECK (min_notional >= 0),
+  fee_bps INT NOT NULL CHECK (fee_bps >= 0 AND fee_bps <= 10000),
+  PRIMARY KEY (schedule_id, min_notional)
+);
+
+COMMENT ON TABLE fee_schedules IS 'Versioned fee schedules. Most specific active match wins: client+pair, client, pair, global; promotional windows beat open-ended rows at equal specificity.';
+COMMENT ON TABLE fee_schedule_tiers IS 'Notional tiers per fee schedule (basis points).';
+
+-- Seed the global default (replaces the hard-coded tiers that used to live in cmd/exchange and cmd/rate).
+INSERT INTO fee_schedules (schedule_id, name, version)
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 1)
+ON CONFLICT DO NOTHING;
+INSERT INTO fee_schedule_tiers (schedule_id, min_notional, fee_bps) VALUES
+  ('00000000-0000-0000-0000-000000000001', 0, 30),
+  ('00000000-0000-0000-0000-000000000001', 1000, 20),
+  ('00000000-0000-0000-0000-000000000001', 10000, 10)
+ON CONFLICT DO NOTHING;
+
+-- Record which schedule version priced each job
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN fee_schedule_id UUID REFERENCES fee_schedules(schedule_id); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN fee_schedule_version INT; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN fee_bps INT; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
@@ -0,0 +1,63 @@
+-- 0004_webhooks.sql
+-- Per-client webhook subscriptions and the delivery log. The dispatcher fans conversion-events outbox rows out
+-- into one delivery per matching subscription and POSTs them, HMAC-signed, with exponential backoff.
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+  subscription_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  client_id TEXT NOT NULL,
+  url TEXT NOT NULL CHECK (url ~ '^https?://'),
+  events TEXT[] NOT NULL CHECK (cardinality(events) > 0),   -- e.g. {conversion.completed,conversion.failed}
+  secret TEXT NOT NULL,                                     -- HMAC-SHA256 signing key
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_webhook_subscriptions_updated_at BEFORE UPDATE ON webhook_subscriptions
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_client ON webhook_subscriptions (client_id) WHERE active;
+
+-- One row per (subscription, outbox event). next_attempt_at doubles as a lease while a dispatcher is sending.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  delivery_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(subscription_id) ON DELETE CASCADE,
+  outbox_id UUID NOT NULL REFERENCES outbox(outbox_id),
+  event TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','delivered','failed')),
+  attempts INT NOT NULL DEFAULT 0 CHECK (attempts >= 0),
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_status_code INT,
+  last_error TEXT,
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (subscription_id, outbox_id)
+);
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_webhook_deliveries_updated_at BEFORE UPDATE ON webhook_deliveries
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at DESC);
+
+-- Every HTTP attempt, including replays.
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+  attempt_id BIGSERIAL PRIMARY KEY,
+  delivery_id UUID NOT NULL REFERENCES webhook_deliveries(delivery_id) ON DELETE CASCADE,
+  status_code INT,                    -- NULL when no response was received
+  error TEXT,
+  duration_ms INT NOT NULL,
+  attempted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts (delivery_id, attempt_id);
+
+COMMENT ON TABLE webhook_subscriptions IS 'Client webhook endpoints and the event types they receive.';
+COMMENT ON TABLE webhook_deliveries IS 'One outbox event to one subscription; retried with exponential backoff until delivered or failed.';
+COMMENT ON TABLE webhook_attempts IS 'HTTP attempt log per webhook delivery.';
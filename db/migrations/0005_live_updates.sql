@@ -0,0 +1,39 @@
+-- 0005_live_updates.sql
+-- NOTIFY live_updates on job status and balance changes so cmd/stream can push them to clients as
+-- Server-Sent Events. Payload: {"type": "job"|"balance", "user_id": ..., "data": {...}}. Notifications are
+-- only delivered when the writing transaction commits, so rolled-back settlements are never streamed.
+
+CREATE OR REPLACE FUNCTION notify_job_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('live_updates', json_build_object(
+    'type', 'job',
+    'user_id', NEW.client_id,
+    'data', json_build_object(
+      'job_id', NEW.job_id, 'status', NEW.status, 'source_currency', NEW.source_currency, 'target_currency', NEW.target_currency,
+      'source_amount', NEW.source_amount, 'target_amount', NEW.target_amount, 'rate', NEW.rate, 'fee', NEW.fee,
+      'error', NEW.metadata->>'error', 'updated_at', NEW.updated_at)
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_balance_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('live_updates', json_build_object(
+    'type', 'balance',
+    'user_id', NEW.user_id,
+    'data', json_build_object('account_id', NEW.account_id, 'currency', NEW.currency, 'balance', NEW.balance)
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_conversion_jobs_notify AFTER INSERT OR UPDATE OF status ON conversion_jobs
+  FOR EACH ROW EXECUTE FUNCTION notify_job_change();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_accounts_notify AFTER INSERT OR UPDATE OF balance ON accounts
+  FOR EACH ROW EXECUTE FUNCTION notify_balance_change();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
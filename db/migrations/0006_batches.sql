@@ -0,0 +1,20 @@
+-- 0006_batches.sql
+-- Batches group jobs submitted together through POST /jobs/batch. In all-or-nothing batches the consumer settles
+-- every leg in one transaction and fails them all if any leg cannot settle.
+
+CREATE TABLE IF NOT EXISTS conversion_batches (
+  batch_id UUID PRIMARY KEY,
+  client_id TEXT NOT NULL,
+  all_or_nothing BOOLEAN NOT NULL DEFAULT false,
+  size INT NOT NULL CHECK (size > 0),      -- legs created with the batch (replayed items are not counted)
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversion_batches_client ON conversion_batches (client_id, created_at DESC);
+
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN batch_id UUID REFERENCES conversion_batches(batch_id); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN batch_index INT; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_batch ON conversion_jobs (batch_id, batch_index) WHERE batch_id IS NOT NULL;
+
+COMMENT ON TABLE conversion_batches IS 'Jobs submitted together via POST /jobs/batch; legs reference it through conversion_jobs.batch_id.';
@@ -0,0 +1,62 @@
+-- 0007_schedules.sql
+-- Scheduled and recurring conversions. cmd/scheduler materializes every due occurrence into a normal queued
+-- conversion_jobs row plus outbox row, keyed by a deterministic idempotency key, and advances next_run_at in the
+-- same transaction. A trigger counts consecutive insufficient_funds failures of a schedule's jobs and disables the
+-- schedule once max_failures is reached.
+
+CREATE TABLE IF NOT EXISTS scheduled_conversions (
+  schedule_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  client_id TEXT NOT NULL,
+  source_currency CHAR(3) NOT NULL CHECK (source_currency ~ '^[A-Z]{3}$'),
+  target_currency CHAR(3) NOT NULL CHECK (target_currency ~ '^[A-Z]{3}$'),
+  source_amount NUMERIC(20,8) NOT NULL CHECK (source_amount > 0),
+  recurrence TEXT,                       -- cron expression or RRULE; NULL for a one-off at run_at
+  run_at TIMESTAMPTZ,                    -- one-off time
+  timezone TEXT NOT NULL DEFAULT 'UTC',  -- IANA zone the recurrence is evaluated in
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active','paused','disabled','completed','cancelled')),
+  next_run_at TIMESTAMPTZ,               -- NULL once nothing is left to run
+  last_run_at TIMESTAMPTZ,
+  consecutive_failures INT NOT NULL DEFAULT 0 CHECK (consecutive_failures >= 0),
+  max_failures INT NOT NULL DEFAULT 3 CHECK (max_failures > 0),
+  disabled_reason TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK ((recurrence IS NULL) <> (run_at IS NULL))
+);
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_scheduled_conversions_updated_at BEFORE UPDATE ON scheduled_conversions
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_conversions_due ON scheduled_conversions (next_run_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_scheduled_conversions_client ON scheduled_conversions (client_id, created_at);
+
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS schedule_id UUID REFERENCES scheduled_conversions(schedule_id);
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_schedule ON conversion_jobs (schedule_id, created_at DESC) WHERE schedule_id IS NOT NULL;
+
+-- A completed job resets the failure streak; an insufficient_funds failure extends it and disables the schedule
+-- at max_failures. Other failures (validation, amount too small) leave the streak alone.
+CREATE OR REPLACE FUNCTION track_schedule_outcome() RETURNS trigger AS $$
+BEGIN
+  IF NEW.status = 'completed' THEN
+    UPDATE scheduled_conversions SET consecutive_failures = 0 WHERE schedule_id = NEW.schedule_id AND consecutive_failures > 0;
+  ELSIF NEW.status = 'failed' AND NEW.metadata->>'error' = 'insufficient_funds' THEN
+    UPDATE scheduled_conversions SET
+      consecutive_failures = consecutive_failures + 1,
+      status = CASE WHEN status = 'active' AND consecutive_failures + 1 >= max_failures THEN 'disabled' ELSE status END,
+      disabled_reason = CASE WHEN status = 'active' AND consecutive_failures + 1 >= max_failures
+        THEN format('%s consecutive insufficient_funds failures', consecutive_failures + 1) ELSE disabled_reason END
+    WHERE schedule_id = NEW.schedule_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_conversion_jobs_schedule_outcome AFTER UPDATE OF status ON conversion_jobs
+  FOR EACH ROW WHEN (NEW.schedule_id IS NOT NULL AND NEW.status IS DISTINCT FROM OLD.status)
+  EXECUTE FUNCTION track_schedule_outcome();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+COMMENT ON TABLE scheduled_conversions IS 'One-off and recurring conversions; cmd/scheduler books each due occurrence as a queued job.';
@@ -0,0 +1,21 @@
+-- 0008_limit_orders.sql
+-- Limit orders: a job with limit_rate stays 'pending' until the provider rate reaches the limit, then cmd/limits
+-- settles it; past expires_at it is cancelled. The source amount is reserved in accounts.held while the order is
+-- pending, so only balance - held is available to other conversions.
+
+-- Not usable in this transaction (see the 0001 note on enums), so nothing below refers to it
+ALTER TYPE job_status_enum ADD VALUE IF NOT EXISTS 'pending' BEFORE 'queued';
+
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN limit_rate NUMERIC(20,10) CHECK (limit_rate > 0); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN expires_at TIMESTAMPTZ; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_limit ON conversion_jobs (source_currency, target_currency, limit_rate) WHERE limit_rate IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_expiry ON conversion_jobs (expires_at) WHERE expires_at IS NOT NULL;
+
+DO $$ BEGIN ALTER TABLE accounts ADD COLUMN held NUMERIC(20,8) NOT NULL DEFAULT 0; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN
+  ALTER TABLE accounts ADD CONSTRAINT accounts_held_check CHECK (held >= 0 AND held <= balance);
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+COMMENT ON COLUMN accounts.held IS 'Reserved for pending limit orders; the available balance is balance - held.';
+COMMENT ON COLUMN conversion_jobs.limit_rate IS 'Minimum rate (target per source) a pending limit order executes at.';
@@ -0,0 +1,32 @@
+-- 0009_holds.sql
+-- Funds reservation: POST /jobs holds a job's source amount in accounts.held in the transaction that inserts it,
+-- so jobs that the available balance (balance - held) cannot cover are rejected up front. held_amount records
+-- what each job holds; settlement captures it and failure or cancellation releases it. Jobs with no hold
+-- (synchronous exchanges, jobs from before this migration) are checked against the available balance instead.
+
+DO $$ BEGIN
+  ALTER TABLE conversion_jobs ADD COLUMN held_amount NUMERIC(20,8) NOT NULL DEFAULT 0 CHECK (held_amount >= 0);
+EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+
+-- Pending limit orders already hold their whole amount (0008)
+UPDATE conversion_jobs SET held_amount = source_amount WHERE status = 'pending' AND held_amount = 0;
+
+COMMENT ON COLUMN accounts.held IS 'Sum of held_amount over the account''s unsettled jobs; the available balance is balance - held.';
+COMMENT ON COLUMN conversion_jobs.held_amount IS 'Source amount held for the job at creation; captured on completion, released on failure or cancellation.';
+
+-- Live balance updates (0005) carry the split too, and fire when only the held amount changes
+CREATE OR REPLACE FUNCTION notify_balance_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('live_updates', json_build_object(
+    'type', 'balance',
+    'user_id', NEW.user_id,
+    'data', json_build_object('account_id', NEW.account_id, 'currency', NEW.currency, 'balance', NEW.balance,
+                              'held', NEW.held, 'available', NEW.balance - NEW.held)
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_accounts_notify ON accounts;
+CREATE TRIGGER trg_accounts_notify AFTER INSERT OR UPDATE OF balance, held ON accounts
+FOR EACH ROW EXECUTE FUNCTION notify_balance_change();
@@ -0,0 +1,16 @@
+-- 0010_reversals.sql
+-- Reversals: POST /jobs/{job_id}/reverse corrects a completed conversion with a new, completed reversal job that
+-- converts (part of) the target amount back into the source currency. The reversal's own ledger entries compensate
+-- the original ones; the original job and ledger rows are never updated. reversed_amount is the part of the
+-- original source_amount the reversal covers, so what is left to reverse is source_amount - SUM(reversed_amount).
+
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN reversal_of UUID REFERENCES conversion_jobs(job_id); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN reversed_amount NUMERIC(20,8) CHECK (reversed_amount > 0); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN
+  ALTER TABLE conversion_jobs ADD CONSTRAINT conversion_jobs_reversal_check CHECK ((reversal_of IS NULL) = (reversed_amount IS NULL));
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_reversal_of ON conversion_jobs (reversal_of) WHERE reversal_of IS NOT NULL;
+
+COMMENT ON COLUMN conversion_jobs.reversal_of IS 'For a reversal job, the completed conversion it reverses.';
+COMMENT ON COLUMN conversion_jobs.reversed_amount IS 'For a reversal job, the part of the original source_amount it reverses.';
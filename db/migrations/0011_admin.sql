@@ -0,0 +1,67 @@
+-- 0011_admin.sql
+-- Back-office API (cmd/admin): account freezes, manual balance adjustments with maker-checker approval, and an
+-- append-only audit log of every admin action. An approved adjustment moves the balance and posts one ledger entry
+-- on the adjusted account, linked through ledger_entries.adjustment_id instead of a job.
+
+DO $$ BEGIN
+  ALTER TABLE accounts ADD COLUMN status TEXT NOT NULL DEFAULT 'active' CONSTRAINT accounts_status_check CHECK (status IN ('active','frozen'));
+EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+
+COMMENT ON COLUMN accounts.status IS 'active, or frozen by an operator through the admin API.';
+
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+  adjustment_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id TEXT NOT NULL,
+  currency CHAR(3) NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+  amount NUMERIC(20,8) NOT NULL CHECK (amount <> 0),
+  reason TEXT NOT NULL CHECK (reason <> ''),
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','approved','rejected')),
+  requested_by TEXT NOT NULL,
+  requested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  decided_by TEXT,
+  decision_reason TEXT,
+  decided_at TIMESTAMPTZ,
+  -- Maker-checker: the operator deciding is never the one who asked
+  CONSTRAINT balance_adjustments_checker_check CHECK (decided_by <> requested_by),
+  CONSTRAINT balance_adjustments_decision_check CHECK ((status = 'pending') = (decided_by IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_balance_adjustments_pending ON balance_adjustments (requested_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_balance_adjustments_user ON balance_adjustments (user_id, requested_at DESC);
+
+COMMENT ON TABLE balance_adjustments IS 'Manual balance corrections. Signed amount; applied only once a second operator approves.';
+
+DO $$ BEGIN
+  ALTER TABLE ledger_entries ADD COLUMN adjustment_id UUID REFERENCES balance_adjustments(adjustment_id);
+EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+
+CREATE INDEX IF NOT EXISTS idx_ledger_adjustment ON ledger_entries (adjustment_id) WHERE adjustment_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+  audit_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  operator TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target_type TEXT NOT NULL,
+  target_id TEXT NOT NULL,
+  reason TEXT,
+  details JSONB NOT NULL DEFAULT '{}'::jsonb,
+  correlation_id TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_target ON admin_audit_log (target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_operator ON admin_audit_log (operator, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created_at ON admin_audit_log (created_at DESC);
+
+COMMENT ON TABLE admin_audit_log IS 'One row per admin action, written in the action''s transaction. Append-only.';
+
+CREATE OR REPLACE FUNCTION admin_audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'admin_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_admin_audit_log_append_only BEFORE UPDATE OR DELETE ON admin_audit_log
+  FOR EACH ROW EXECUTE FUNCTION admin_audit_log_append_only();
+EXCEPTION WHEN duplicate_object THEN NULL; END $$;
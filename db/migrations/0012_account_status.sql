@@ -0,0 +1,23 @@
+-- 0012_account_status.sql
+-- Account and user status. Accounts may now also be closed, and users get a status of their own: a frozen or closed
+-- user or account is refused at job creation and fails settlement with user_frozen, user_closed, account_frozen or
+-- account_closed. Closed users get no new accounts. A user without a users row is active.
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_status_check;
+ALTER TABLE accounts ADD CONSTRAINT accounts_status_check CHECK (status IN ('active','frozen','closed'));
+
+COMMENT ON COLUMN accounts.status IS 'active, frozen or closed by an operator through the admin API.';
+
+CREATE TABLE IF NOT EXISTS users (
+  user_id TEXT PRIMARY KEY,
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active','frozen','closed')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE users IS 'Status of users an operator has frozen or closed; users without a row are active.';
+
+DO $$ BEGIN
+  CREATE TRIGGER trg_users_updated_at BEFORE UPDATE ON users
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+EXCEPTION WHEN duplicate_object THEN null; END $$;
@@ -0,0 +1,80 @@
+-- 0013_tenants.sql
+-- Tenants: the business units sharing this service, each with its own currency configuration and fee schedules.
+-- Every tenant-owned table gets a tenant_id, backfilled to 'default', and a row-level security policy matching it
+-- against the app.tenant_id setting the service sets on each connection (internal/tenant). Without the setting no
+-- rows are visible and inserts fail; background workers that scan every tenant set it to '*'. Policies are FORCEd,
+-- so they also bind the table owner: later migrations that change data must set app.tenant_id themselves.
+-- Superusers bypass row-level security, so the service must not connect as one.
+
+CREATE TABLE IF NOT EXISTS tenants (
+  tenant_id TEXT PRIMARY KEY CHECK (tenant_id ~ '^[a-z0-9][a-z0-9_-]{0,62}$'),
+  name TEXT NOT NULL,
+  currencies CHAR(3)[] CHECK (cardinality(currencies) > 0),  -- NULL = every currency
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE tenants IS 'Business units; currencies lists the currencies a tenant may convert between (NULL = any).';
+
+INSERT INTO tenants (tenant_id, name) VALUES ('default', 'Default') ON CONFLICT DO NOTHING;
+
+DO $$
+DECLARE t TEXT;
+BEGIN
+  FOREACH t IN ARRAY ARRAY['conversion_jobs','accounts','ledger_entries','outbox','micro_orders','trade_ledger',
+                           'conversion_batches','scheduled_conversions','webhook_subscriptions','fee_schedules',
+                           'balance_adjustments','users'] LOOP
+    EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT %L REFERENCES tenants(tenant_id)', t, 'default');
+    EXECUTE format('ALTER TABLE %I ALTER COLUMN tenant_id SET DEFAULT current_setting(%L, true)', t, 'app.tenant_id');
+    EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+    EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+    EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+    EXECUTE format($p$CREATE POLICY tenant_isolation ON %I
+      USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.tenant_id', true) = '*')
+      WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.tenant_id', true) = '*')$p$, t);
+  END LOOP;
+END $$;
+
+-- Natural keys are unique per tenant
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_user_id_currency_key;
+DO $$ BEGIN
+  ALTER TABLE accounts ADD CONSTRAINT accounts_tenant_user_currency_key UNIQUE (tenant_id, user_id, currency);
+EXCEPTION WHEN duplicate_table OR duplicate_object THEN NULL; END $$;
+
+DROP INDEX IF EXISTS ux_conversion_jobs_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS ux_conversion_jobs_tenant_idempotency_key ON conversion_jobs (tenant_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+ALTER TABLE fee_schedules DROP CONSTRAINT IF EXISTS fee_schedules_name_version_key;
+DO $$ BEGIN
+  ALTER TABLE fee_schedules ADD CONSTRAINT fee_schedules_tenant_name_version_key UNIQUE (tenant_id, name, version);
+EXCEPTION WHEN duplicate_table OR duplicate_object THEN NULL; END $$;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_pkey;
+ALTER TABLE users ADD PRIMARY KEY (tenant_id, user_id);
+
+-- Live updates carry the tenant, so cmd/stream only fans them out to that tenant's streams
+CREATE OR REPLACE FUNCTION notify_job_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('live_updates', json_build_object(
+    'type', 'job',
+    'tenant_id', NEW.tenant_id,
+    'user_id', NEW.client_id,
+    'data', json_build_object(
+      'job_id', NEW.job_id, 'status', NEW.status, 'source_currency', NEW.source_currency, 'target_currency', NEW.target_currency,
+      'source_amount', NEW.source_amount, 'target_amount', NEW.target_amount, 'rate', NEW.rate, 'fee', NEW.fee,
+      'error', NEW.metadata->>'error', 'updated_at', NEW.updated_at)
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_balance_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('live_updates', json_build_object(
+    'type', 'balance',
+    'tenant_id', NEW.tenant_id,
+    'user_id', NEW.user_id,
+    'data', json_build_object('account_id', NEW.account_id, 'currency', NEW.currency, 'balance', NEW.balance)
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
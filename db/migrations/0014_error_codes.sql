@@ -0,0 +1,25 @@
+-- 0014_error_codes.sql
+-- Failure reasons in conversion_jobs.metadata.error become the API's error codes (internal/problem), e.g.
+-- insufficient_funds -> INSUFFICIENT_FUNDS. Existing rows are rewritten for every tenant, and the schedule trigger
+-- that counts funding failures matches the new code.
+SELECT set_config('app.tenant_id', '*', true);
+
+UPDATE conversion_jobs SET metadata = jsonb_set(metadata, '{error}', to_jsonb(upper(metadata->>'error')))
+WHERE metadata->>'error' IN ('insufficient_funds','invalid_request','amount_too_small','limit_expired','user_frozen',
+                             'user_closed','account_frozen','account_closed','currency_not_enabled','batch_leg_failed');
+
+CREATE OR REPLACE FUNCTION track_schedule_outcome() RETURNS trigger AS $$
+BEGIN
+  IF NEW.status = 'completed' THEN
+    UPDATE scheduled_conversions SET consecutive_failures = 0 WHERE schedule_id = NEW.schedule_id AND consecutive_failures > 0;
+  ELSIF NEW.status = 'failed' AND NEW.metadata->>'error' = 'INSUFFICIENT_FUNDS' THEN
+    UPDATE scheduled_conversions SET
+      consecutive_failures = consecutive_failures + 1,
+      status = CASE WHEN status = 'active' AND consecutive_failures + 1 >= max_failures THEN 'disabled' ELSE status END,
+      disabled_reason = CASE WHEN status = 'active' AND consecutive_failures + 1 >= max_failures
+        THEN format('%s consecutive INSUFFICIENT_FUNDS failures', consecutive_failures + 1) ELSE disabled_reason END
+    WHERE schedule_id = NEW.schedule_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,17 @@
+-- 0015_rate_ticks.sql
+-- Rate history: every rate the rate service (cmd/rate) serves or ingests is kept as a tick, so rates can be charted
+-- as OHLC candles and past valuations (GET /balances?at=) price with the rate of the time. Market rates are shared
+-- by all tenants, so the table has no tenant_id and no row-level security.
+
+CREATE TABLE IF NOT EXISTS fx_rate_ticks (
+  tick_id BIGSERIAL PRIMARY KEY,
+  source_currency CHAR(3) NOT NULL CHECK (source_currency ~ '^[A-Z]{3}$'),
+  target_currency CHAR(3) NOT NULL CHECK (target_currency ~ '^[A-Z]{3}$'),
+  rate NUMERIC(30,12) NOT NULL CHECK (rate > 0),
+  provider TEXT NOT NULL,
+  quoted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_fx_rate_ticks_pair ON fx_rate_ticks (source_currency, target_currency, quoted_at DESC);
+
+COMMENT ON TABLE fx_rate_ticks IS 'Append-only rate history: one row per rate served or ingested, by pair and time.';
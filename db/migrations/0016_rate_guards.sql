@@ -0,0 +1,39 @@
+-- 0016_rate_guards.sql
+-- Rate guards (internal/rateguard): quotes that are stale, not a positive number, or too far from the pair's last
+-- accepted rate or the inverse of the reverse pair are rejected before they price a conversion. Each rejection is kept
+-- with the offending quote and opens the pair's breaker, which halts execution on the pair until an operator resets
+-- it (POST /admin/rate-breakers/{pair}/reset). Market rates are shared by all tenants, so neither table has a
+-- tenant_id or row-level security.
+
+CREATE TABLE IF NOT EXISTS rate_rejections (
+  rejection_id BIGSERIAL PRIMARY KEY,
+  source_currency CHAR(3) NOT NULL CHECK (source_currency ~ '^[A-Z]{3}$'),
+  target_currency CHAR(3) NOT NULL CHECK (target_currency ~ '^[A-Z]{3}$'),
+  rate DOUBLE PRECISION NOT NULL,  -- as quoted, which may be zero or negative
+  provider TEXT NOT NULL,
+  quoted_at TIMESTAMPTZ,           -- NULL for an undated quote
+  reason TEXT NOT NULL CHECK (reason IN ('invalid','stale','deviation','inverse_deviation')),
+  reference_rate DOUBLE PRECISION, -- the rate a deviation is measured from
+  deviation DOUBLE PRECISION,
+  rejected_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_rejections_pair ON rate_rejections (source_currency, target_currency, rejected_at DESC);
+
+COMMENT ON TABLE rate_rejections IS 'Append-only: every quote the rate guards refused, with the reason and reference.';
+
+CREATE TABLE IF NOT EXISTS rate_breakers (
+  source_currency CHAR(3) NOT NULL CHECK (source_currency ~ '^[A-Z]{3}$'),
+  target_currency CHAR(3) NOT NULL CHECK (target_currency ~ '^[A-Z]{3}$'),
+  status TEXT NOT NULL DEFAULT 'closed' CHECK (status IN ('closed','open')),
+  rejection_id BIGINT REFERENCES rate_rejections(rejection_id),  -- the rejection that opened it
+  reference_rate NUMERIC(30,12) CHECK (reference_rate > 0),     -- last accepted rate
+  reference_at TIMESTAMPTZ,
+  reset_by TEXT,
+  reset_at TIMESTAMPTZ,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (source_currency, target_currency),
+  CONSTRAINT rate_breakers_open_check CHECK (status = 'closed' OR rejection_id IS NOT NULL)
+);
+
+COMMENT ON TABLE rate_breakers IS 'Per-pair circuit breaker and reference rate. An open pair is not quoted, so its jobs stay queued.';
@@ -0,0 +1,38 @@
+-- 0017_spreads.sql
+-- Bid/ask spreads (internal/spreads). The rate service quotes each pair from one book: a mid and the pair's spread
+-- around it, so both directions of a pair are priced consistently. A conversion executes at the bid, and the
+-- difference from the mid is spread revenue, recorded on the job apart from the fee. fx_spreads lets a tenant
+-- override the book's spread by client tier and pair; users.tier puts a client in a tier.
+
+DO $$ BEGIN
+  ALTER TABLE users ADD COLUMN tier TEXT NOT NULL DEFAULT 'standard' CHECK (tier ~ '^[a-z][a-z0-9_-]{0,31}$');
+EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+
+COMMENT ON COLUMN users.tier IS 'Pricing tier for fx_spreads; a user without a users row is standard.';
+
+CREATE TABLE IF NOT EXISTS fx_spreads (
+  spread_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  tenant_id TEXT NOT NULL DEFAULT current_setting('app.tenant_id', true) REFERENCES tenants(tenant_id),
+  tier TEXT CHECK (tier ~ '^[a-z][a-z0-9_-]{0,31}$'),  -- NULL = every tier
+  pair TEXT CHECK (pair ~ '^[A-Z]{3}-[A-Z]{3}$' AND left(pair, 3) < right(pair, 3)),  -- alphabetical, e.g. EUR-USD; NULL = every pair
+  spread_bps INT NOT NULL CHECK (spread_bps >= 0 AND spread_bps < 10000),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ux_fx_spreads_tenant_tier_pair ON fx_spreads (tenant_id, COALESCE(tier, ''), COALESCE(pair, ''));
+
+ALTER TABLE fx_spreads ENABLE ROW LEVEL SECURITY;
+ALTER TABLE fx_spreads FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON fx_spreads;
+CREATE POLICY tenant_isolation ON fx_spreads
+  USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.tenant_id', true) = '*')
+  WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.tenant_id', true) = '*');
+
+COMMENT ON TABLE fx_spreads IS 'Spread overrides. A pair is stored once, in alphabetical order, and prices both directions. Most specific match wins: tier+pair, tier, pair, global; without one the book''s spread applies.';
+
+-- What each job's rate was made of: the mid, the spread and the spread revenue (target currency units)
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN mid_rate NUMERIC(30,12) CHECK (mid_rate > 0); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN spread_bps INT; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN spread NUMERIC(20,8); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN spread_id UUID REFERENCES fx_spreads(spread_id); EXCEPTION WHEN duplicate_column THEN NULL; END $$;
+DO $$ BEGIN ALTER TABLE conversion_jobs ADD COLUMN client_tier TEXT; EXCEPTION WHEN duplicate_column THEN NULL; END $$;
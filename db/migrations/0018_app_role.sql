@@ -0,0 +1,19 @@
+-- 0018_app_role.sql
+-- The role the services connect as. Row-level security (0013) does not bind superusers or roles with BYPASSRLS, so
+-- the services must not use the owner that runs these migrations: jobs_app is an ordinary role that may read and write
+-- rows, read schema_migrations (migrate.Check) and nothing else. Every binary refuses to start as a role that bypasses
+-- row-level security (tenant.CheckRole). Roles belong to the cluster, so an existing jobs_app is reused; its password
+-- is set by `migrate up` from APP_DB_PASSWORD, and without one it cannot log in.
+
+DO $$ BEGIN
+  CREATE ROLE jobs_app LOGIN NOSUPERUSER NOBYPASSRLS NOCREATEDB NOCREATEROLE;
+EXCEPTION WHEN duplicate_object OR unique_violation THEN NULL; END $$;
+
+GRANT USAGE ON SCHEMA public TO jobs_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO jobs_app;
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO jobs_app;
+REVOKE INSERT, UPDATE, DELETE ON schema_migrations FROM jobs_app;
+
+-- Tables and sequences later migrations create get the same grants
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO jobs_app;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO jobs_app;
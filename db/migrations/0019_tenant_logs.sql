@@ -0,0 +1,51 @@
+-- 0019_tenant_logs.sql
+-- The tables 0013 left out: webhook deliveries and their attempts, and the admin audit log, get a tenant_id and the
+-- same tenant_isolation policy. Without it a caller holding another tenant's subscription id could read that
+-- tenant's event payloads. Deliveries take the tenant of their subscription and attempts that of their delivery.
+-- Audit rows take the tenant the operator acted in; actions across every tenant (a rate breaker reset) have none
+-- and are only visible across every tenant.
+SELECT set_config('app.tenant_id', '*', true);
+
+DO $$
+DECLARE t TEXT;
+BEGIN
+  FOREACH t IN ARRAY ARRAY['webhook_deliveries','webhook_attempts','admin_audit_log'] LOOP
+    EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS tenant_id TEXT REFERENCES tenants(tenant_id)', t);
+  END LOOP;
+END $$;
+
+UPDATE webhook_deliveries d SET tenant_id = s.tenant_id FROM webhook_subscriptions s WHERE s.subscription_id = d.subscription_id AND d.tenant_id IS NULL;
+UPDATE webhook_attempts a SET tenant_id = d.tenant_id FROM webhook_deliveries d WHERE d.delivery_id = a.delivery_id AND a.tenant_id IS NULL;
+
+-- Audit rows name their target: jobs and adjustments know their tenant, accounts and users do when only one tenant
+-- has the user. The rest stay NULL.
+ALTER TABLE admin_audit_log DISABLE TRIGGER trg_admin_audit_log_append_only;
+UPDATE admin_audit_log l SET tenant_id = CASE l.target_type
+    WHEN 'job' THEN (SELECT j.tenant_id FROM conversion_jobs j WHERE j.job_id::text = l.target_id)
+    WHEN 'adjustment' THEN (SELECT a.tenant_id FROM balance_adjustments a WHERE a.adjustment_id::text = l.target_id)
+    WHEN 'account' THEN (SELECT min(a.tenant_id) FROM accounts a WHERE a.user_id = split_part(l.target_id, '/', 1) HAVING count(DISTINCT a.tenant_id) = 1)
+    WHEN 'user' THEN (SELECT min(a.tenant_id) FROM accounts a WHERE a.user_id = l.target_id HAVING count(DISTINCT a.tenant_id) = 1)
+  END
+WHERE l.tenant_id IS NULL;
+ALTER TABLE admin_audit_log ENABLE TRIGGER trg_admin_audit_log_append_only;
+
+ALTER TABLE webhook_deliveries ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id', true);
+ALTER TABLE webhook_deliveries ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE webhook_attempts ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id', true);
+ALTER TABLE webhook_attempts ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE admin_audit_log ALTER COLUMN tenant_id SET DEFAULT NULLIF(current_setting('app.tenant_id', true), '*');
+
+DO $$
+DECLARE t TEXT;
+BEGIN
+  FOREACH t IN ARRAY ARRAY['webhook_deliveries','webhook_attempts','admin_audit_log'] LOOP
+    EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+    EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+    EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+    EXECUTE format($p$CREATE POLICY tenant_isolation ON %I
+      USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.tenant_id', true) = '*')
+      WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.tenant_id', true) = '*')$p$, t);
+  END LOOP;
+END $$;
+
+COMMENT ON COLUMN admin_audit_log.tenant_id IS 'Tenant the action was taken in; NULL for actions across every tenant.';
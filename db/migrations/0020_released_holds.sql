@@ -0,0 +1,8 @@
+-- 0020_released_holds.sql
+-- A job's held_amount is what it still holds: settlement zeroes it when it captures or releases the hold, so jobs
+-- that completed, failed or were cancelled before then are zeroed here.
+SELECT set_config('app.tenant_id', '*', true);
+
+UPDATE conversion_jobs SET held_amount = 0 WHERE status IN ('completed','failed','cancelled') AND held_amount <> 0;
+
+COMMENT ON COLUMN conversion_jobs.held_amount IS 'Source amount the job holds; set at creation, zeroed when completion captures it or failure or cancellation releases it.';
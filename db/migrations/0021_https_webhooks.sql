@@ -0,0 +1,10 @@
+-- 0021_https_webhooks.sql
+-- Webhook endpoints must be https, as the API already requires. Subscriptions stored with an http URL are
+-- deactivated and kept for their delivery history: the constraint is NOT VALID so they stay, but they cannot be
+-- changed or reactivated without an https URL.
+SELECT set_config('app.tenant_id', '*', true);
+
+UPDATE webhook_subscriptions SET active = false WHERE url !~ '^https://' AND active;
+
+ALTER TABLE webhook_subscriptions DROP CONSTRAINT IF EXISTS webhook_subscriptions_url_check;
+ALTER TABLE webhook_subscriptions ADD CONSTRAINT webhook_subscriptions_url_check CHECK (url ~ '^https://') NOT VALID;
@@ -0,0 +1,8 @@
+-- Reverts 0001_init.sql
+DROP TABLE IF EXISTS trade_ledger;
+DROP TABLE IF EXISTS micro_orders;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS conversion_jobs;
+DROP FUNCTION IF EXISTS set_updated_at();
+DROP TYPE IF EXISTS micro_order_status_enum;
+DROP TYPE IF EXISTS job_status_enum;
@@ -0,0 +1,9 @@
+-- Reverts 0002_accounts_ledger.sql
+DROP INDEX IF EXISTS idx_conversion_jobs_completed_at;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS fee;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS rate;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS target_amount;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS accounts;
+DROP TYPE IF EXISTS entry_type_enum;
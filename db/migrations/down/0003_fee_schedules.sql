@@ -0,0 +1,6 @@
+-- Reverts 0003_fee_schedules.sql
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS fee_bps;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS fee_schedule_version;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS fee_schedule_id;
+DROP TABLE IF EXISTS fee_schedule_tiers;
+DROP TABLE IF EXISTS fee_schedules;
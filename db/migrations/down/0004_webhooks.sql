@@ -0,0 +1,4 @@
+-- Reverts 0004_webhooks.sql
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
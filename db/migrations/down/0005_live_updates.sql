@@ -0,0 +1,5 @@
+-- Reverts 0005_live_updates.sql
+DROP TRIGGER IF EXISTS trg_accounts_notify ON accounts;
+DROP TRIGGER IF EXISTS trg_conversion_jobs_notify ON conversion_jobs;
+DROP FUNCTION IF EXISTS notify_balance_change();
+DROP FUNCTION IF EXISTS notify_job_change();
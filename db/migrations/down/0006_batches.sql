@@ -0,0 +1,5 @@
+-- Reverts 0006_batches.sql
+DROP INDEX IF EXISTS idx_conversion_jobs_batch;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS batch_index;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS batch_id;
+DROP TABLE IF EXISTS conversion_batches;
@@ -0,0 +1,6 @@
+-- Reverts 0007_schedules.sql
+DROP TRIGGER IF EXISTS trg_conversion_jobs_schedule_outcome ON conversion_jobs;
+DROP FUNCTION IF EXISTS track_schedule_outcome();
+DROP INDEX IF EXISTS idx_conversion_jobs_schedule;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS schedule_id;
+DROP TABLE IF EXISTS scheduled_conversions;
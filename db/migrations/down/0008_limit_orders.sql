@@ -0,0 +1,10 @@
+-- Reverts 0008_limit_orders.sql. Enum values cannot be dropped, so 'pending' stays in job_status_enum; pending
+-- limit orders are cancelled first so no job is left in it.
+UPDATE conversion_jobs SET status='cancelled', updated_at=now(), metadata = jsonb_set(metadata,'{"error"}','"limit_orders_removed"')
+  WHERE status::text = 'pending';
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_held_check;
+ALTER TABLE accounts DROP COLUMN IF EXISTS held;
+DROP INDEX IF EXISTS idx_conversion_jobs_expiry;
+DROP INDEX IF EXISTS idx_conversion_jobs_limit;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS limit_rate;
@@ -0,0 +1,21 @@
+-- Reverts 0009_holds.sql. Holds of queued jobs are released so accounts.held only covers pending limit orders
+-- again, as 0008 expects.
+UPDATE accounts a SET held = a.held - q.amount
+  FROM (SELECT client_id, source_currency, SUM(held_amount) AS amount FROM conversion_jobs
+        WHERE status = 'queued' AND held_amount > 0 GROUP BY client_id, source_currency) q
+  WHERE a.user_id = q.client_id AND a.currency = q.source_currency;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS held_amount;
+
+DROP TRIGGER IF EXISTS trg_accounts_notify ON accounts;
+CREATE TRIGGER trg_accounts_notify AFTER INSERT OR UPDATE OF balance ON accounts
+FOR EACH ROW EXECUTE FUNCTION notify_balance_change();
+CREATE OR REPLACE FUNCTION notify_balance_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('live_updates', json_build_object(
+    'type', 'balance',
+    'user_id', NEW.user_id,
+    'data', json_build_object('account_id', NEW.account_id, 'currency', NEW.currency, 'balance', NEW.balance)
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
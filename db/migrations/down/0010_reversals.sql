@@ -0,0 +1,6 @@
+-- Reverts 0010_reversals.sql. Reversal jobs and their ledger entries are kept; they only lose the link to the
+-- conversion they reversed.
+DROP INDEX IF EXISTS idx_conversion_jobs_reversal_of;
+ALTER TABLE conversion_jobs DROP CONSTRAINT IF EXISTS conversion_jobs_reversal_check;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS reversed_amount;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS reversal_of;
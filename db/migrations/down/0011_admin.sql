@@ -0,0 +1,8 @@
+-- Reverts 0011_admin.sql. Balances moved by approved adjustments stay as they are; their ledger entries are kept
+-- but lose the link to the adjustment. The audit log is dropped with everything it recorded.
+DROP TABLE IF EXISTS admin_audit_log;
+DROP FUNCTION IF EXISTS admin_audit_log_append_only();
+DROP INDEX IF EXISTS idx_ledger_adjustment;
+ALTER TABLE ledger_entries DROP COLUMN IF EXISTS adjustment_id;
+DROP TABLE IF EXISTS balance_adjustments;
+ALTER TABLE accounts DROP COLUMN IF EXISTS status;
@@ -0,0 +1,6 @@
+-- Reverts 0012_account_status.sql. Closed accounts become frozen, so they stay blocked; user statuses are dropped.
+DROP TABLE IF EXISTS users;
+UPDATE accounts SET status = 'frozen' WHERE status = 'closed';
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_status_check;
+ALTER TABLE accounts ADD CONSTRAINT accounts_status_check CHECK (status IN ('active','frozen'));
+COMMENT ON COLUMN accounts.status IS 'active, or frozen by an operator through the admin API.';
@@ -0,0 +1,22 @@
+-- Reverts 0014_error_codes.sql: failure reasons go back to lower case.
+SELECT set_config('app.tenant_id', '*', true);
+
+UPDATE conversion_jobs SET metadata = jsonb_set(metadata, '{error}', to_jsonb(lower(metadata->>'error')))
+WHERE metadata->>'error' IN ('INSUFFICIENT_FUNDS','INVALID_REQUEST','AMOUNT_TOO_SMALL','LIMIT_EXPIRED','USER_FROZEN',
+                             'USER_CLOSED','ACCOUNT_FROZEN','ACCOUNT_CLOSED','CURRENCY_NOT_ENABLED','BATCH_LEG_FAILED');
+
+CREATE OR REPLACE FUNCTION track_schedule_outcome() RETURNS trigger AS $$
+BEGIN
+  IF NEW.status = 'completed' THEN
+    UPDATE scheduled_conversions SET consecutive_failures = 0 WHERE schedule_id = NEW.schedule_id AND consecutive_failures > 0;
+  ELSIF NEW.status = 'failed' AND NEW.metadata->>'error' = 'insufficient_funds' THEN
+    UPDATE scheduled_conversions SET
+      consecutive_failures = consecutive_failures + 1,
+      status = CASE WHEN status = 'active' AND consecutive_failures + 1 >= max_failures THEN 'disabled' ELSE status END,
+      disabled_reason = CASE WHEN status = 'active' AND consecutive_failures + 1 >= max_failures
+        THEN format('%s consecutive insufficient_funds failures', consecutive_failures + 1) ELSE disabled_reason END
+    WHERE schedule_id = NEW.schedule_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
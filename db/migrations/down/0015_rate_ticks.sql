@@ -0,0 +1,2 @@
+-- Reverts 0015_rate_ticks.sql. The rate history is dropped; past valuations fall back to executed job rates.
+DROP TABLE IF EXISTS fx_rate_ticks;
@@ -0,0 +1,3 @@
+-- Reverts 0016_rate_guards.sql. Breakers and the rejection record are dropped; open pairs execute again.
+DROP TABLE IF EXISTS rate_breakers;
+DROP TABLE IF EXISTS rate_rejections;
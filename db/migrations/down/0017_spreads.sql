@@ -0,0 +1,8 @@
+-- Reverts 0017_spreads.sql. Jobs lose their spread breakdown; their rate is the rate they executed at.
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS client_tier;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS spread_id;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS spread;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS spread_bps;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS mid_rate;
+DROP TABLE IF EXISTS fx_spreads;
+ALTER TABLE users DROP COLUMN IF EXISTS tier;
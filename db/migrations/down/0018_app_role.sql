@@ -0,0 +1,10 @@
+-- Reverts 0018_app_role.sql. The role is dropped unless it still holds privileges in another database of the cluster.
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE USAGE, SELECT ON SEQUENCES FROM jobs_app;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM jobs_app;
+REVOKE ALL ON ALL SEQUENCES IN SCHEMA public FROM jobs_app;
+REVOKE ALL ON ALL TABLES IN SCHEMA public FROM jobs_app;
+REVOKE ALL ON SCHEMA public FROM jobs_app;
+
+DO $$ BEGIN
+  DROP ROLE IF EXISTS jobs_app;
+EXCEPTION WHEN dependent_objects_still_exist THEN NULL; END $$;
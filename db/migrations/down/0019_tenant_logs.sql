@@ -0,0 +1,11 @@
+-- Reverts 0019_tenant_logs.sql. Deliveries, attempts and audit rows of every tenant become visible to all.
+DO $$
+DECLARE t TEXT;
+BEGIN
+  FOREACH t IN ARRAY ARRAY['webhook_deliveries','webhook_attempts','admin_audit_log'] LOOP
+    EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+    EXECUTE format('ALTER TABLE %I NO FORCE ROW LEVEL SECURITY', t);
+    EXECUTE format('ALTER TABLE %I DISABLE ROW LEVEL SECURITY', t);
+    EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS tenant_id', t);
+  END LOOP;
+END $$;
@@ -0,0 +1,2 @@
+-- Reverts 0020_released_holds.sql. Amounts already zeroed are not restored.
+COMMENT ON COLUMN conversion_jobs.held_amount IS 'Source amount held for the job at creation; captured on completion, released on failure or cancellation.';
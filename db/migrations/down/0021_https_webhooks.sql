@@ -0,0 +1,3 @@
+-- Reverts 0021_https_webhooks.sql. Subscriptions it deactivated stay inactive.
+ALTER TABLE webhook_subscriptions DROP CONSTRAINT IF EXISTS webhook_subscriptions_url_check;
+ALTER TABLE webhook_subscriptions ADD CONSTRAINT webhook_subscriptions_url_check CHECK (url ~ '^https?://');
@@ -0,0 +1,68 @@
+// Package admin authenticates back-office requests. Operators present a
+// bearer token; each token belongs to a named operator, whose name is recorded
+// with what they do.
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Env is the variable Parse reads operators from.
+const Env = "ADMIN_TOKENS"
+
+// ErrUnauthorized rejects a request without a known operator token.
+var ErrUnauthorized = errors.New("admin token required")
+
+// Operators maps tokens to operator names.
+type Operators map[string]string
+
+// Parse reads a comma-separated list of name:token pairs. An empty list is
+// valid and authenticates nobody.
+func Parse(s string) (Operators, error) {
+	ops := Operators{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || len(token) < 16 {
+			return nil, fmt.Errorf("%s: want name:token with a token of at least 16 characters, got %q", Env, name)
+		}
+		if _, dup := ops[token]; dup {
+			return nil, fmt.Errorf("%s: token of %s is not unique", Env, name)
+		}
+		ops[token] = name
+	}
+	return ops, nil
+}
+
+// Authenticate returns the operator whose token the request's
+// "Authorization: Bearer" header carries. Every token is compared in
+// constant time.
+func (o Operators) Authenticate(evt events.APIGatewayProxyRequest) (string, error) {
+	var header string
+	for k, v := range evt.Headers {
+		if strings.EqualFold(k, "Authorization") {
+			header = v
+		}
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return "", ErrUnauthorized
+	}
+	operator := ""
+	for t, name := range o {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			operator = name
+		}
+	}
+	if operator == "" {
+		return "", ErrUnauthorized
+	}
+	return operator, nil
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParse(t *testing.T) {
+	ops, err := Parse(" alice:aaaaaaaaaaaaaaaa, bob:bbbbbbbbbbbbbbbb ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops["aaaaaaaaaaaaaaaa"] != "alice" || ops["bbbbbbbbbbbbbbbb"] != "bob" {
+		t.Errorf("operators = %v", ops)
+	}
+	for _, bad := range []string{"alice", "alice:short", ":aaaaaaaaaaaaaaaa", "a:aaaaaaaaaaaaaaaa,b:aaaaaaaaaaaaaaaa"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ops := Operators{"aaaaaaaaaaaaaaaa": "alice"}
+	req := func(header string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"authorization": header}}
+	}
+	if op, err := ops.Authenticate(req("Bearer aaaaaaaaaaaaaaaa")); err != nil || op != "alice" {
+		t.Errorf("operator = %q, %v; want alice", op, err)
+	}
+	for _, header := range []string{"", "Bearer ", "Bearer bbbbbbbbbbbbbbbb", "aaaaaaaaaaaaaaaa"} {
+		if _, err := ops.Authenticate(req(header)); err != ErrUnauthorized {
+			t.Errorf("%q: err = %v, want ErrUnauthorized", header, err)
+		}
+	}
+	if _, err := (Operators{}).Authenticate(req("Bearer aaaaaaaaaaaaaaaa")); err != ErrUnauthorized {
+		t.Errorf("no operators: err = %v, want ErrUnauthorized", err)
+	}
+}
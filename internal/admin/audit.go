@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/irajwani/microservice-go/internal/logging"
+)
+
+// Action is one row of the admin audit log.
+type Action struct {
+	Operator   string         `json:"operator"`
+	Action     string         `json:"action"` // e.g. adjustment.approved, account.frozen
+	TargetType string         `json:"target_type"`
+	TargetID   string         `json:"target_id"`
+	Reason     string         `json:"reason,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// Execer is the part of *sql.Tx that Record needs.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Record appends a to admin_audit_log with the request's correlation id. Call
+// it inside the transaction that performs the action, so an action is never
+// committed without its audit row.
+func Record(ctx context.Context, tx Execer, a Action) error {
+	details, err := json.Marshal(a.Details)
+	if err != nil {
+		return fmt.Errorf("audit details: %w", err)
+	}
+	if a.Details == nil {
+		details = []byte("{}")
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO admin_audit_log (operator, action, target_type, target_id, reason, details, correlation_id)
+		VALUES ($1,$2,$3,$4,NULLIF($5,''),$6,NULLIF($7,''))`,
+		a.Operator, a.Action, a.TargetType, a.TargetID, a.Reason, details, logging.CorrelationID(ctx))
+	if err != nil {
+		return fmt.Errorf("audit %s: %w", a.Action, err)
+	}
+	return nil
+}
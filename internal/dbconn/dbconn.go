@@ -0,0 +1,57 @@
+// Package dbconn opens the application database the same way in every
+// binary that serves it.
+package dbconn
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/migrate"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Open connects with the DB_* variables, as jobs_app unless DB_USER says
+// otherwise, and pings the database. opts configure the pgx connections, e.g.
+// tenant.Options(). It refuses a role that row-level security does not bind
+// and, unless SCHEMA_CHECK=off, a schema this binary was not built for.
+func Open(ctx context.Context, opts ...stdlib.OptionOpenDB) (*sql.DB, error) {
+	host := getenv("DB_HOST", "postgres")
+	port := getenv("DB_PORT", "5432")
+	user := getenv("DB_USER", "jobs_app")
+	pass := getenv("DB_PASSWORD", "jobsapppw")
+	name := getenv("DB_NAME", "jobsdb")
+	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, pass, host, port, name)
+	db, err := tracing.OpenDB(dsn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := db.PingContext(c); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := tenant.CheckRole(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if getenv("SCHEMA_CHECK", "on") != "off" {
+		if err := migrate.Check(ctx, db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,17 @@
+// Package deadline tells scheduled Lambdas that work in a loop when to stop,
+// so an invocation finishes its current item before it is killed.
+package deadline
+
+import (
+	"context"
+	"time"
+)
+
+// Margin is how much of the invocation must remain to start more work.
+const Margin = 10 * time.Second
+
+// Near reports whether ctx is done or fewer than Margin of its deadline remain.
+func Near(ctx context.Context) bool {
+	d, ok := ctx.Deadline()
+	return ctx.Err() != nil || (ok && time.Until(d) < Margin)
+}
@@ -0,0 +1,29 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNear(t *testing.T) {
+	far, cancelFar := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelFar()
+	near, cancelNear := context.WithTimeout(context.Background(), Margin/2)
+	defer cancelNear()
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, tc := range map[string]struct {
+		ctx  context.Context
+		want bool
+	}{
+		"no deadline": {context.Background(), false},
+		"far":         {far, false},
+		"near":        {near, true},
+		"cancelled":   {done, true},
+	} {
+		if got := Near(tc.ctx); got != tc.want {
+			t.Errorf("%s: Near = %v, want %v", name, got, tc.want)
+		}
+	}
+}
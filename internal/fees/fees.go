@@ -0,0 +1,122 @@
+// Package fees resolves and applies the fee schedules stored in Postgres
+// (see db/migrations/0003_fee_schedules.sql).
+package fees
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Tier applies FeeBps to conversions whose notional (source units) is >= MinNotional.
+type Tier struct {
+	MinNotional float64 `json:"min_notional"`
+	FeeBps      int     `json:"fee_bps"`
+}
+
+// Schedule is one version of a fee schedule. MinFee / MaxFee are absolute
+// bounds in target currency units; MaxFee nil means uncapped.
+type Schedule struct {
+	ID      string   `json:"schedule_id,omitempty"`
+	Name    string   `json:"name"`
+	Version int      `json:"version"`
+	Tiers   []Tier   `json:"tiers"`
+	MinFee  float64  `json:"min_fee"`
+	MaxFee  *float64 `json:"max_fee,omitempty"`
+}
+
+// Quote is the priced fee for a single conversion.
+type Quote struct {
+	ScheduleID      string  `json:"fee_schedule_id,omitempty"`
+	ScheduleVersion int     `json:"fee_schedule_version,omitempty"`
+	FeeBps          int     `json:"fee_bps"`
+	Fee             float64 `json:"fee"`
+}
+
+// Default is used when no schedule row matches (e.g. an empty fee_schedules
+// table). It mirrors the seeded 'default' schedule.
+var Default = Schedule{
+	Name:  "default",
+	Tiers: []Tier{{MinNotional: 0, FeeBps: 30}, {MinNotional: 1000, FeeBps: 20}, {MinNotional: 10000, FeeBps: 10}},
+}
+
+// Bps returns the basis points of the highest tier whose MinNotional <= notional.
+// Tiers may be stored in any order.
+func (s Schedule) Bps(notional float64) int {
+	tiers := append([]Tier(nil), s.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinNotional > tiers[j].MinNotional })
+	for _, t := range tiers {
+		if notional >= t.MinNotional {
+			return t.FeeBps
+		}
+	}
+	return 0
+}
+
+// Quote prices a conversion of notional source units that yields gross target
+// units before fees. The fee is clamped to [MinFee, MaxFee] and never exceeds gross.
+func (s Schedule) Quote(notional, gross float64) Quote {
+	bps := s.Bps(notional)
+	fee := gross * float64(bps) / 10000.0
+	fee = math.Max(fee, s.MinFee)
+	if s.MaxFee != nil {
+		fee = math.Min(fee, *s.MaxFee)
+	}
+	fee = math.Min(fee, gross)
+	return Quote{ScheduleID: s.ID, ScheduleVersion: s.Version, FeeBps: bps, Fee: fee}
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Lookup returns the most specific active schedule for client and pair at the
+// given instant: client+pair, client, pair, then global. At equal specificity a
+// schedule with a promotional window beats an open-ended one, then the highest
+// version wins. Falls back to Default when nothing matches.
+func Lookup(ctx context.Context, q Querier, clientID, source, target string, at time.Time) (Schedule, error) {
+	var s Schedule
+	var maxFee sql.NullFloat64
+	err := q.QueryRowContext(ctx, `SELECT schedule_id, name, version, min_fee, max_fee
+		FROM fee_schedules
+		WHERE active
+		  AND (client_id IS NULL OR client_id = $1)
+		  AND (source_currency IS NULL OR source_currency = $2)
+		  AND (target_currency IS NULL OR target_currency = $3)
+		  AND (valid_from IS NULL OR valid_from <= $4)
+		  AND (valid_until IS NULL OR valid_until > $4)
+		ORDER BY (client_id IS NOT NULL) DESC,
+		         (source_currency IS NOT NULL)::int + (target_currency IS NOT NULL)::int DESC,
+		         (valid_from IS NOT NULL OR valid_until IS NOT NULL) DESC,
+		         version DESC
+		LIMIT 1`, clientID, source, target, at).Scan(&s.ID, &s.Name, &s.Version, &s.MinFee, &maxFee)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Default, nil
+	}
+	if err != nil {
+		return Schedule{}, fmt.Errorf("lookup fee schedule: %w", err)
+	}
+	if maxFee.Valid {
+		s.MaxFee = &maxFee.Float64
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT min_notional, fee_bps FROM fee_schedule_tiers WHERE schedule_id=$1 ORDER BY min_notional`, s.ID)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("load fee tiers: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Tier
+		if err := rows.Scan(&t.MinNotional, &t.FeeBps); err != nil {
+			return Schedule{}, err
+		}
+		s.Tiers = append(s.Tiers, t)
+	}
+	return s, rows.Err()
+}
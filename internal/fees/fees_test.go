@@ -0,0 +1,195 @@
+package fees_test
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/fees"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+func TestMain(m *testing.M) { os.Exit(testpg.Run(m, nil)) }
+
+func TestBps(t *testing.T) {
+	unordered := fees.Schedule{Tiers: []fees.Tier{{MinNotional: 10000, FeeBps: 10}, {MinNotional: 100, FeeBps: 30}, {MinNotional: 1000, FeeBps: 20}}}
+	for _, tc := range []struct {
+		name     string
+		s        fees.Schedule
+		notional float64
+		want     int
+	}{
+		{"default zero", fees.Default, 0, 30},
+		{"default below 1000", fees.Default, 999.99, 30},
+		{"default at 1000", fees.Default, 1000, 20},
+		{"default below 10000", fees.Default, 9999.99, 20},
+		{"default at 10000", fees.Default, 10000, 10},
+		{"default above 10000", fees.Default, 1_000_000, 10},
+		{"unordered below lowest tier", unordered, 99, 0},
+		{"unordered at lowest tier", unordered, 100, 30},
+		{"unordered middle", unordered, 5000, 20},
+		{"unordered above 10000", unordered, 10001, 10},
+		{"no tiers", fees.Schedule{}, 500, 0},
+	} {
+		if got := tc.s.Bps(tc.notional); got != tc.want {
+			t.Errorf("%s: Bps(%v) = %d, want %d", tc.name, tc.notional, got, tc.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	maxFee := 5.0
+	flat := []fees.Tier{{FeeBps: 30}}
+	for _, tc := range []struct {
+		name            string
+		s               fees.Schedule
+		notional, gross float64
+		want            float64
+	}{
+		{"bps of gross", fees.Schedule{Tiers: flat}, 1000, 900, 2.7},
+		{"min fee", fees.Schedule{Tiers: flat, MinFee: 1}, 100, 90, 1},
+		{"above min fee", fees.Schedule{Tiers: flat, MinFee: 1}, 1000, 900, 2.7},
+		{"max fee", fees.Schedule{Tiers: flat, MaxFee: &maxFee}, 10000, 9000, 5},
+		{"below max fee", fees.Schedule{Tiers: flat, MaxFee: &maxFee}, 1000, 900, 2.7},
+		{"capped at gross", fees.Schedule{Tiers: flat, MinFee: 2}, 1, 0.9, 0.9},
+		{"no tier", fees.Schedule{MinFee: 0.5}, 10, 9, 0.5},
+	} {
+		q := tc.s.Quote(tc.notional, tc.gross)
+		if math.Abs(q.Fee-tc.want) > 1e-9 {
+			t.Errorf("%s: fee = %v, want %v", tc.name, q.Fee, tc.want)
+		}
+		if q.Fee > tc.gross {
+			t.Errorf("%s: fee %v exceeds gross %v", tc.name, q.Fee, tc.gross)
+		}
+	}
+
+	q := fees.Schedule{ID: "s1", Version: 3, Tiers: fees.Default.Tiers}.Quote(20000, 18000)
+	if q.ScheduleID != "s1" || q.ScheduleVersion != 3 || q.FeeBps != 10 || math.Abs(q.Fee-18) > 1e-9 {
+		t.Errorf("quote = %+v, want s1 v3 at 10 bps", q)
+	}
+}
+
+type row struct {
+	name, client, source, target string
+	version, bps                 int
+	from, until                  *time.Time
+	inactive                     bool
+}
+
+// scoped returns a transaction narrowed to a tenant of its own, rolled back
+// when the test ends, and the tenant's context.
+func scoped(t *testing.T) (context.Context, *sql.Tx) {
+	t.Helper()
+	pg := testpg.DB(t)
+	ctx := tenant.With(context.Background(), testpg.Tenant(t, pg))
+	tx, err := pg.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tx.Rollback() })
+	if err := tenant.Scope(ctx, tx, tenant.FromContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, tx
+}
+
+func insert(t *testing.T, tx *sql.Tx, r row) {
+	t.Helper()
+	null := func(s string) any {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	var id string
+	err := tx.QueryRowContext(context.Background(), `INSERT INTO fee_schedules (name, version, client_id, source_currency, target_currency, valid_from, valid_until, active)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING schedule_id`,
+		r.name, max(r.version, 1), null(r.client), null(r.source), null(r.target), r.from, r.until, !r.inactive).Scan(&id)
+	if err != nil {
+		t.Fatalf("insert %s: %v", r.name, err)
+	}
+	if _, err := tx.ExecContext(context.Background(), `INSERT INTO fee_schedule_tiers (schedule_id, min_notional, fee_bps) VALUES ($1,0,$2),($1,1000,$3)`, id, r.bps, r.bps/2); err != nil {
+		t.Fatalf("insert tiers of %s: %v", r.name, err)
+	}
+}
+
+func TestLookupPrecedence(t *testing.T) {
+	ctx, tx := scoped(t)
+	for _, r := range []row{
+		{name: "global", version: 1, bps: 40},
+		{name: "global", version: 2, bps: 35},
+		{name: "global", version: 3, bps: 1, inactive: true},
+		{name: "usd-eur", source: "USD", target: "EUR", bps: 30},
+		{name: "usd-gbp", source: "USD", target: "GBP", bps: 25},
+		{name: "usd-any", source: "USD", bps: 28},
+		{name: "vip", client: "vip", bps: 20},
+		{name: "vip-usd-eur", client: "vip", source: "USD", target: "EUR", version: 1, bps: 12},
+		{name: "vip-usd-eur", client: "vip", source: "USD", target: "EUR", version: 2, bps: 10},
+	} {
+		insert(t, tx, r)
+	}
+
+	at := time.Now()
+	for _, tc := range []struct {
+		client, source, target string
+		want                   string
+		version, bps           int
+	}{
+		{"vip", "USD", "EUR", "vip-usd-eur", 2, 10}, // client+pair, latest version
+		{"vip", "USD", "GBP", "vip", 1, 20},         // client beats pair
+		{"vip", "JPY", "CHF", "vip", 1, 20},         // client beats global
+		{"other", "USD", "EUR", "usd-eur", 1, 30},   // pair
+		{"other", "USD", "GBP", "usd-gbp", 1, 25},   // full pair beats source only
+		{"other", "USD", "JPY", "usd-any", 1, 28},   // source only beats global
+		{"other", "JPY", "CHF", "global", 2, 35},    // global, latest active version
+	} {
+		s, err := fees.Lookup(ctx, tx, tc.client, tc.source, tc.target, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name != tc.want || s.Version != tc.version || s.Bps(0) != tc.bps || len(s.Tiers) != 2 {
+			t.Errorf("%s %s->%s: got %s v%d %+v, want %s v%d at %d bps", tc.client, tc.source, tc.target, s.Name, s.Version, s.Tiers, tc.want, tc.version, tc.bps)
+		}
+	}
+}
+
+func TestLookupPromoWindow(t *testing.T) {
+	ctx, tx := scoped(t)
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	insert(t, tx, row{name: "standard", source: "USD", target: "EUR", bps: 30})
+	insert(t, tx, row{name: "promo", source: "USD", target: "EUR", bps: 5, from: &start, until: &end})
+
+	for _, tc := range []struct {
+		at   time.Time
+		want string
+	}{
+		{start.Add(-time.Second), "standard"},
+		{start, "promo"}, // valid_from is inclusive
+		{end.Add(-time.Second), "promo"},
+		{end, "standard"}, // valid_until is exclusive
+	} {
+		s, err := fees.Lookup(ctx, tx, "c1", "USD", "EUR", tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name != tc.want {
+			t.Errorf("at %v: got %s, want %s", tc.at, s.Name, tc.want)
+		}
+	}
+}
+
+func TestLookupDefault(t *testing.T) {
+	ctx, tx := scoped(t)
+	s, err := fees.Lookup(ctx, tx, "c1", "USD", "EUR", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != "" || s.Name != fees.Default.Name || s.Bps(0) != 30 {
+		t.Errorf("schedule = %+v, want fees.Default", s)
+	}
+}
@@ -0,0 +1,109 @@
+// Package logging provides the structured JSON logger shared by every binary and
+// carries the correlation id that follows a job from POST /jobs to settlement.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+)
+
+const (
+	// Header is the HTTP header callers may use to supply their own correlation id.
+	Header = "X-Correlation-ID"
+	// Attribute is the outbox payload field and SQS message attribute name.
+	Attribute = "correlation_id"
+)
+
+type ctxKey struct{}
+
+// New returns a JSON logger tagged with the service name. LOG_LEVEL
+// (debug|info|warn|error) controls verbosity; default info.
+func New(service string) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	return slog.New(contextHandler{h}).With("service", service)
+}
+
+// Init installs New(service) as the slog default and returns it.
+func Init(service string) *slog.Logger {
+	l := New(service)
+	slog.SetDefault(l)
+	return l
+}
+
+// With returns a context whose log records carry the given attributes
+// (in addition to any already attached).
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := append(attrsFrom(ctx), slog.Group("", args...).Value.Group()...)
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+// WithCorrelationID attaches the correlation id to ctx.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return With(ctx, Attribute, id)
+}
+
+// CorrelationID returns the id attached by WithCorrelationID, or "".
+func CorrelationID(ctx context.Context) string {
+	for _, a := range attrsFrom(ctx) {
+		if a.Key == Attribute {
+			return a.Value.String()
+		}
+	}
+	return ""
+}
+
+// FromRequest resolves the correlation id for an API Gateway request: the
+// X-Correlation-ID header if present, else the gateway request id, else a new UUID.
+func FromRequest(evt events.APIGatewayProxyRequest) string {
+	for k, v := range evt.Headers {
+		if strings.EqualFold(k, Header) && v != "" {
+			return v
+		}
+	}
+	if evt.RequestContext.RequestID != "" {
+		return evt.RequestContext.RequestID
+	}
+	return uuid.NewString()
+}
+
+// FromSQS resolves the correlation id of an SQS record from its message
+// attribute, falling back to the given payload value and then the message id.
+func FromSQS(r events.SQSMessage, payloadID string) string {
+	if a, ok := r.MessageAttributes[Attribute]; ok && a.StringValue != nil && *a.StringValue != "" {
+		return *a.StringValue
+	}
+	if payloadID != "" {
+		return payloadID
+	}
+	return r.MessageId
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs[:len(attrs):len(attrs)]
+}
+
+// contextHandler adds attributes stored via With to every record.
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(attrsFrom(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
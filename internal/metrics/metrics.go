@@ -0,0 +1,232 @@
+// Package metrics records business and operational metrics. Each data point is
+// written as a CloudWatch Embedded Metric Format (EMF) log line when running in
+// Lambda and aggregated in-process for an optional Prometheus /metrics endpoint.
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Units understood by CloudWatch.
+const (
+	UnitCount        = "Count"
+	UnitMilliseconds = "Milliseconds"
+	UnitSeconds      = "Seconds"
+	UnitNone         = "None"
+)
+
+// Metric names shared by the binaries.
+const (
+	JobsCreated       = "jobs_created"
+	JobsCompleted     = "jobs_completed"
+	JobsFailed        = "jobs_failed"
+	JobsCancelled     = "jobs_cancelled"
+	JobsReversed      = "jobs_reversed"
+	NotionalVolume    = "notional_volume"
+	FeeRevenue        = "fee_revenue"
+	SpreadRevenue     = "spread_revenue"
+	JobLatency        = "job_latency_ms"
+	OutboxBacklogAge  = "outbox_backlog_age_seconds"
+	OutboxBacklogSize = "outbox_backlog_size"
+	RateLookupLatency = "rate_lookup_latency_ms"
+	RateLookupErrors  = "rate_lookup_errors"
+	RateRejections    = "rate_rejections"
+	RateBreakerHalts  = "rate_breaker_halts"
+	TxRetries         = "tx_retries"
+	WebhookDeliveries = "webhook_deliveries"
+	WebhookLatency    = "webhook_latency_ms"
+)
+
+// Dim is a metric dimension (CloudWatch) / label (Prometheus).
+type Dim struct{ Name, Value string }
+
+// D is shorthand for Dim{name, value}.
+func D(name, value string) Dim { return Dim{name, value} }
+
+// Pair is the currency pair dimension, e.g. pair=USD:EUR.
+func Pair(source, target string) Dim { return Dim{"pair", source + ":" + target} }
+
+type series struct {
+	name  string
+	dims  []Dim
+	unit  string
+	kind  string // counter | summary | gauge
+	sum   float64
+	count uint64
+}
+
+var (
+	mu        sync.Mutex
+	service   = "unknown"
+	namespace = "MicroserviceGo"
+	emf       bool
+	out       io.Writer = os.Stdout
+	registry            = map[string]*series{}
+)
+
+// Init sets the service dimension. EMF output is enabled inside Lambda
+// (AWS_LAMBDA_FUNCTION_NAME set) or with METRICS_EMF=on; METRICS_NAMESPACE
+// overrides the CloudWatch namespace. When METRICS_ADDR is set (local server
+// mode) a Prometheus endpoint is served at METRICS_ADDR/metrics.
+func Init(svc string) {
+	mu.Lock()
+	service = svc
+	if ns := os.Getenv("METRICS_NAMESPACE"); ns != "" {
+		namespace = ns
+	}
+	switch os.Getenv("METRICS_EMF") {
+	case "on":
+		emf = true
+	case "off":
+		emf = false
+	default:
+		emf = os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != ""
+	}
+	mu.Unlock()
+
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", Handler())
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				slog.Error("metrics server", "addr", addr, "error", err)
+			}
+		}()
+	}
+}
+
+// Count adds v to a counter.
+func Count(name string, v float64, dims ...Dim) { record(name, UnitCount, "counter", v, dims) }
+
+// Observe records a single observation (latency, amount, age...).
+func Observe(name, unit string, v float64, dims ...Dim) { record(name, unit, "summary", v, dims) }
+
+// Since observes the milliseconds elapsed since start.
+func Since(name string, start time.Time, dims ...Dim) {
+	Observe(name, UnitMilliseconds, float64(time.Since(start).Milliseconds()), dims...)
+}
+
+// Gauge records a point-in-time value. Prometheus exposes the last value.
+func Gauge(name, unit string, v float64, dims ...Dim) { record(name, unit, "gauge", v, dims) }
+
+func record(name, unit, kind string, v float64, dims []Dim) {
+	mu.Lock()
+	defer mu.Unlock()
+	// service first, the caller's dimensions after it by name; the caller's
+	// slice is left as it was
+	all := make([]Dim, 0, len(dims)+1)
+	all = append(append(all, Dim{"service", service}), dims...)
+	sort.SliceStable(all[1:], func(i, j int) bool { return all[1+i].Name < all[1+j].Name })
+	dims = all
+
+	key := seriesKey(name, dims)
+	s, ok := registry[key]
+	if !ok {
+		s = &series{name: name, dims: dims, unit: unit, kind: kind}
+		registry[key] = s
+	}
+	if kind == "gauge" {
+		s.sum = v
+	} else {
+		s.sum += v
+	}
+	s.count++
+	if emf {
+		writeEMF(name, unit, v, dims)
+	}
+}
+
+func writeEMF(name, unit string, v float64, dims []Dim) {
+	names := make([]string, len(dims))
+	doc := map[string]any{}
+	for i, d := range dims {
+		names[i] = d.Name
+		doc[d.Name] = d.Value
+	}
+	doc[name] = v
+	doc["_aws"] = map[string]any{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []any{map[string]any{
+			"Namespace":  namespace,
+			"Dimensions": [][]string{names},
+			"Metrics":    []any{map[string]string{"Name": name, "Unit": unit}},
+		}},
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(out, string(b))
+}
+
+// Handler serves the in-process series in Prometheus text exposition format.
+// Counters are exposed as <name>_total, observations as a summary
+// (<name>_sum / <name>_count) and gauges as their last value.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		all := make([]*series, 0, len(registry))
+		for _, s := range registry {
+			c := *s
+			all = append(all, &c)
+		}
+		mu.Unlock()
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].name != all[j].name {
+				return all[i].name < all[j].name
+			}
+			return seriesKey(all[i].name, all[i].dims) < seriesKey(all[j].name, all[j].dims)
+		})
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		typed := map[string]bool{}
+		for _, s := range all {
+			labels := promLabels(s.dims)
+			switch s.kind {
+			case "counter":
+				if !typed[s.name] {
+					fmt.Fprintf(w, "# TYPE %s_total counter\n", s.name)
+				}
+				fmt.Fprintf(w, "%s_total%s %g\n", s.name, labels, s.sum)
+			case "gauge":
+				if !typed[s.name] {
+					fmt.Fprintf(w, "# TYPE %s gauge\n", s.name)
+				}
+				fmt.Fprintf(w, "%s%s %g\n", s.name, labels, s.sum)
+			default:
+				if !typed[s.name] {
+					fmt.Fprintf(w, "# TYPE %s summary\n", s.name)
+				}
+				fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", s.name, labels, s.sum, s.name, labels, s.count)
+			}
+			typed[s.name] = true
+		}
+	})
+}
+
+func promLabels(dims []Dim) string {
+	parts := make([]string, len(dims))
+	for i, d := range dims {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(d.Value)
+		parts[i] = fmt.Sprintf(`%s="%s"`, d.Name, v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func seriesKey(name string, dims []Dim) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, d := range dims {
+		b.WriteString("|" + d.Name + "=" + d.Value)
+	}
+	return b.String()
+}
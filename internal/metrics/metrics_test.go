@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reset points the package at a fresh registry and captures EMF output.
+func reset(t *testing.T, enableEMF bool) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	mu.Lock()
+	prevService, prevEMF, prevOut, prevRegistry := service, emf, out, registry
+	service, emf, out, registry = "test", enableEMF, &buf, map[string]*series{}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		service, emf, out, registry = prevService, prevEMF, prevOut, prevRegistry
+		mu.Unlock()
+	})
+	return &buf
+}
+
+func TestEMFDimensions(t *testing.T) {
+	buf := reset(t, true)
+	dims := []Dim{D("status", "completed"), Pair("USD", "EUR")}
+	Count(JobsCompleted, 1, dims...)
+
+	var doc struct {
+		AWS struct {
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []map[string]string
+			}
+		} `json:"_aws"`
+		Service string  `json:"service"`
+		Pair    string  `json:"pair"`
+		Status  string  `json:"status"`
+		Value   float64 `json:"jobs_completed"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	if len(doc.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("CloudWatchMetrics = %+v", doc.AWS.CloudWatchMetrics)
+	}
+	m := doc.AWS.CloudWatchMetrics[0]
+	if want := [][]string{{"service", "pair", "status"}}; !reflect.DeepEqual(m.Dimensions, want) {
+		t.Errorf("Dimensions = %v, want %v", m.Dimensions, want)
+	}
+	if len(m.Metrics) != 1 || m.Metrics[0]["Name"] != JobsCompleted || m.Metrics[0]["Unit"] != UnitCount {
+		t.Errorf("Metrics = %v", m.Metrics)
+	}
+	if doc.Service != "test" || doc.Pair != "USD:EUR" || doc.Status != "completed" || doc.Value != 1 {
+		t.Errorf("members = %+v", doc)
+	}
+	if dims[0].Name != "status" || dims[1].Name != "pair" {
+		t.Errorf("record reordered the caller's dimensions: %v", dims)
+	}
+}
+
+func TestEMFOff(t *testing.T) {
+	buf := reset(t, false)
+	Count(JobsCreated, 1)
+	if buf.Len() != 0 {
+		t.Errorf("EMF written while off: %q", buf.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	reset(t, false)
+	Count(JobsCreated, 1, Pair("USD", "EUR"))
+	Count(JobsCreated, 2, Pair("USD", "EUR"))
+	Observe(JobLatency, UnitMilliseconds, 40, Pair("USD", "EUR"))
+	Observe(JobLatency, UnitMilliseconds, 60, Pair("USD", "EUR"))
+	Gauge(OutboxBacklogSize, UnitCount, 7)
+	Gauge(OutboxBacklogSize, UnitCount, 3)
+	Count(WebhookDeliveries, 1, D("url", `a"b\c`+"\nd"))
+
+	body := scrape(t)
+	for _, line := range []string{
+		`# TYPE jobs_created_total counter`,
+		`jobs_created_total{service="test",pair="USD:EUR"} 3`,
+		`# TYPE job_latency_ms summary`,
+		`job_latency_ms_sum{service="test",pair="USD:EUR"} 100`,
+		`job_latency_ms_count{service="test",pair="USD:EUR"} 2`,
+		`# TYPE outbox_backlog_size gauge`,
+		`outbox_backlog_size{service="test"} 3`,
+		`webhook_deliveries_total{service="test",url="a\"b\\c\nd"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+	if n := strings.Count(body, "# TYPE jobs_created_total"); n != 1 {
+		t.Errorf("jobs_created_total typed %d times", n)
+	}
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	b, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,312 @@
+// Package migrate applies the embedded SQL migrations (see package db) and
+// records them in schema_migrations with a checksum, serialised across
+// concurrent runners by a Postgres advisory lock.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/irajwani/microservice-go/db"
+)
+
+// lockKey is the pg_advisory_lock key held while migrating ("migrate" in ASCII).
+const lockKey = 0x6d696772617465
+
+// ErrSchemaMismatch is returned by Check when the database is not at the
+// version embedded in the binary.
+var ErrSchemaMismatch = errors.New("schema version mismatch")
+
+var (
+	fileName = regexp.MustCompile(`^(\d+)_(.+)\.sql$`)
+	// Files that manage their own transaction (e.g. 0002) are run as-is.
+	ownsTx = regexp.MustCompile(`(?im)^\s*BEGIN\s*;`)
+)
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string // empty when the migration cannot be reverted
+	Checksum string // sha256 of Up
+}
+
+// Status describes a migration relative to the database.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// Modified is set when the applied checksum differs from the embedded file.
+	Modified bool
+}
+
+// Load reads migrations/NNNN_name.sql and their optional
+// migrations/down/NNNN_name.sql counterparts from fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		if prev, dup := seen[version]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, prev, e.Name())
+		}
+		seen[version] = e.Name()
+		up, err := fs.ReadFile(fsys, path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		down, err := fs.ReadFile(fsys, path.Join("migrations", "down", e.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		sum := sha256.Sum256(up)
+		out = append(out, Migration{Version: version, Name: m[2], Up: string(up), Down: string(down), Checksum: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Runner applies migrations against a database.
+type Runner struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Logf reports progress; defaults to discarding.
+	Logf func(format string, args ...any)
+}
+
+// New returns a Runner over the migrations embedded in package db.
+func New(database *sql.DB) (*Runner, error) {
+	ms, err := Load(db.Migrations)
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+	return &Runner{DB: database, Migrations: ms, Logf: func(string, ...any) {}}, nil
+}
+
+// Latest returns the highest embedded migration version.
+func (r *Runner) Latest() int {
+	if len(r.Migrations) == 0 {
+		return 0
+	}
+	return r.Migrations[len(r.Migrations)-1].Version
+}
+
+// Status reports every embedded migration and whether/when it was applied.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	return r.status(ctx, conn)
+}
+
+// Up applies all pending migrations in order and returns the ones applied. It
+// refuses to run if an applied migration was edited after the fact.
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		st, err := r.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkModified(st); err != nil {
+			return err
+		}
+		for _, s := range st {
+			if s.AppliedAt != nil {
+				continue
+			}
+			r.Logf("applying %04d_%s", s.Version, s.Name)
+			start := time.Now()
+			if err := run(ctx, conn, s.Up, func(ex execer) error {
+				_, err := ex.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, execution_ms) VALUES ($1,$2,$3,$4)`,
+					s.Version, s.Name, s.Checksum, time.Since(start).Milliseconds())
+				return err
+			}); err != nil {
+				return fmt.Errorf("apply %04d_%s: %w", s.Version, s.Name, err)
+			}
+			applied = append(applied, s.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// DownTo reverts applied migrations newer than version, newest first. Every
+// migration to revert must have a down file.
+func (r *Runner) DownTo(ctx context.Context, version int) ([]Migration, error) {
+	var reverted []Migration
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		st, err := r.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkModified(st); err != nil {
+			return err
+		}
+		for i := len(st) - 1; i >= 0; i-- {
+			s := st[i]
+			if s.Version <= version || s.AppliedAt == nil {
+				continue
+			}
+			if strings.TrimSpace(s.Down) == "" {
+				return fmt.Errorf("%04d_%s has no down migration", s.Version, s.Name)
+			}
+			r.Logf("reverting %04d_%s", s.Version, s.Name)
+			if err := run(ctx, conn, s.Down, func(ex execer) error {
+				_, err := ex.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version=$1`, s.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("revert %04d_%s: %w", s.Version, s.Name, err)
+			}
+			reverted = append(reverted, s.Migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Check verifies that the database schema is exactly at the latest embedded
+// version. Lambdas call it at cold start and refuse to serve on mismatch.
+func Check(ctx context.Context, database *sql.DB) error {
+	r, err := New(database)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = database.QueryRowContext(ctx, `SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("%w: read schema_migrations: %v", ErrSchemaMismatch, err)
+	}
+	if current != r.Latest() {
+		return fmt.Errorf("%w: database at %d, binary expects %d", ErrSchemaMismatch, current, r.Latest())
+	}
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// run executes body and then record in one transaction, unless body manages
+// its own transaction, in which case record runs after it. A body that fails
+// inside its own transaction is rolled back rather than left open.
+func run(ctx context.Context, conn *sql.Conn, body string, record func(execer) error) error {
+	if ownsTx.MatchString(body) {
+		if _, err := conn.ExecContext(ctx, body); err != nil {
+			_, _ = conn.ExecContext(context.WithoutCancel(ctx), `ROLLBACK`)
+			return err
+		}
+		return record(conn)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Runner) locked(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockKey)
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		execution_ms BIGINT NOT NULL DEFAULT 0,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	return err
+}
+
+func (r *Runner) status(ctx context.Context, conn *sql.Conn) ([]Status, error) {
+	type applied struct {
+		checksum string
+		at       time.Time
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := map[int]applied{}
+	for rows.Next() {
+		var v int
+		var a applied
+		if err := rows.Scan(&v, &a.checksum, &a.at); err != nil {
+			return nil, err
+		}
+		done[v] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(r.Migrations))
+	for _, m := range r.Migrations {
+		s := Status{Migration: m}
+		if a, ok := done[m.Version]; ok {
+			at := a.at
+			s.AppliedAt = &at
+			s.Modified = a.checksum != m.Checksum
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func checkModified(st []Status) error {
+	var bad []string
+	for _, s := range st {
+		if s.Modified {
+			bad = append(bad, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("applied migrations were modified (checksum mismatch): %s", strings.Join(bad, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/irajwani/microservice-go/internal/migrate"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+func TestMain(m *testing.M) { os.Exit(testpg.Run(m, nil)) }
+
+// files are three migrations; 0002 sleeps so that concurrent runners overlap.
+var files = fstest.MapFS{
+	"migrations/0001_a.sql":      {Data: []byte(`CREATE TABLE a (id INT);`)},
+	"migrations/0002_b.sql":      {Data: []byte(`SELECT pg_sleep(0.2); CREATE TABLE b (id INT);`)},
+	"migrations/0003_c.sql":      {Data: []byte("BEGIN;\nCREATE TABLE c (id INT);\nCOMMIT;\n")},
+	"migrations/down/0002_b.sql": {Data: []byte(`DROP TABLE b;`)},
+	"migrations/down/0003_c.sql": {Data: []byte(`DROP TABLE c;`)},
+	"migrations/README.md":       {Data: []byte(`not a migration`)},
+}
+
+func runner(t *testing.T, db *sql.DB, fsys fstest.MapFS) *migrate.Runner {
+	t.Helper()
+	ms, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &migrate.Runner{DB: db, Migrations: ms, Logf: t.Logf}
+}
+
+// with returns a copy of files with name replaced (or removed when body is "").
+func with(name, body string) fstest.MapFS {
+	out := fstest.MapFS{}
+	for k, v := range files {
+		out[k] = v
+	}
+	delete(out, name)
+	if body != "" {
+		out[name] = &fstest.MapFile{Data: []byte(body)}
+	}
+	return out
+}
+
+func applied(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	rows, err := db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func exists(t *testing.T, db *sql.DB, table string) bool {
+	t.Helper()
+	var ok bool
+	if err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, table).Scan(&ok); err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func versions(ms []migrate.Migration) []int {
+	out := make([]int, len(ms))
+	for i, m := range ms {
+		out[i] = m.Version
+	}
+	return out
+}
+
+func TestLoad(t *testing.T) {
+	ms, err := migrate.Load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions(ms), []int{1, 2, 3}) || ms[0].Down != "" || ms[1].Down == "" || ms[0].Checksum == ms[1].Checksum {
+		t.Errorf("migrations = %+v", ms)
+	}
+	if _, err := migrate.Load(with("migrations/01_dup.sql", `SELECT 1`)); err == nil {
+		t.Error("duplicate version loaded")
+	}
+}
+
+func TestUpAndDownTo(t *testing.T) {
+	db := testpg.Empty(t)
+	ctx := context.Background()
+	r := runner(t, db, files)
+
+	done, err := r.Up(ctx)
+	if err != nil || !slices.Equal(versions(done), []int{1, 2, 3}) {
+		t.Fatalf("up = %v, %v", versions(done), err)
+	}
+	if done, err := r.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second up = %v, %v; want nothing to do", versions(done), err)
+	}
+
+	reverted, err := r.DownTo(ctx, 1)
+	if err != nil || !slices.Equal(versions(reverted), []int{3, 2}) {
+		t.Fatalf("down-to 1 = %v, %v; want 3 then 2", versions(reverted), err)
+	}
+	if got := applied(t, db); !slices.Equal(got, []int{1}) || exists(t, db, "b") || exists(t, db, "c") || !exists(t, db, "a") {
+		t.Errorf("after down-to 1: applied %v", got)
+	}
+	if _, err := r.DownTo(ctx, 0); err == nil || !strings.Contains(err.Error(), "no down migration") {
+		t.Errorf("down-to 0 = %v, want no down migration for 0001", err)
+	}
+	if got := applied(t, db); !slices.Equal(got, []int{1}) {
+		t.Errorf("refused down-to changed applied to %v", got)
+	}
+}
+
+func TestUpRefusesModifiedMigration(t *testing.T) {
+	db := testpg.Empty(t)
+	ctx := context.Background()
+	if _, err := runner(t, db, files).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := runner(t, db, with("migrations/0002_b.sql", `CREATE TABLE b (id BIGINT);`))
+	if _, err := edited.Up(ctx); err == nil || !strings.Contains(err.Error(), "checksum mismatch") || !strings.Contains(err.Error(), "0002_b") {
+		t.Errorf("up = %v, want checksum mismatch on 0002_b", err)
+	}
+	if _, err := edited.DownTo(ctx, 1); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("down-to = %v, want checksum mismatch", err)
+	}
+	st, err := edited.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range st {
+		if s.AppliedAt == nil || s.Modified != (s.Version == 2) {
+			t.Errorf("status %04d: applied %v, modified %v", s.Version, s.AppliedAt, s.Modified)
+		}
+	}
+}
+
+func TestUpStopsAtFailedMigration(t *testing.T) {
+	db := testpg.Empty(t)
+	ctx := context.Background()
+
+	// 0002 fails after creating its table; 0003 manages its own transaction and
+	// fails the same way
+	broken := with("migrations/0002_b.sql", `CREATE TABLE b (id INT); SELECT no_such_function();`)
+	done, err := runner(t, db, broken).Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "0002_b") || !slices.Equal(versions(done), []int{1}) {
+		t.Fatalf("up = %v, %v; want 0001 applied and 0002 failed", versions(done), err)
+	}
+	if got := applied(t, db); !slices.Equal(got, []int{1}) || exists(t, db, "b") {
+		t.Errorf("failed 0002 left applied %v, table b %v", got, exists(t, db, "b"))
+	}
+
+	broken = with("migrations/0003_c.sql", "BEGIN;\nCREATE TABLE c (id INT);\nSELECT no_such_function();\nCOMMIT;\n")
+	if _, err := runner(t, db, broken).Up(ctx); err == nil || !strings.Contains(err.Error(), "0003_c") {
+		t.Fatalf("up = %v, want 0003 failed", err)
+	}
+	if got := applied(t, db); !slices.Equal(got, []int{1, 2}) || exists(t, db, "c") {
+		t.Errorf("failed 0003 left applied %v, table c %v", got, exists(t, db, "c"))
+	}
+
+	// Fixed files pick up where the failure stopped, and the lock was released
+	if done, err := runner(t, db, files).Up(ctx); err != nil || !slices.Equal(versions(done), []int{3}) {
+		t.Errorf("up after fix = %v, %v; want 0003", versions(done), err)
+	}
+}
+
+func TestConcurrentUp(t *testing.T) {
+	db := testpg.Empty(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	runners := []*migrate.Runner{runner(t, db, files), runner(t, db, files)}
+	results := make([][]migrate.Migration, len(runners))
+	errs := make([]error, len(runners))
+	for i, r := range runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = r.Up(ctx)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	// One runner applies everything; the other waits on the lock and finds
+	// nothing left to do
+	if n := len(results[0]) + len(results[1]); n != 3 || (len(results[0]) != 0 && len(results[1]) != 0) {
+		t.Errorf("applied %v and %v; want all three by one runner", versions(results[0]), versions(results[1]))
+	}
+	if got := applied(t, db); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("applied = %v", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db := testpg.Empty(t)
+	ctx := context.Background()
+	if err := migrate.Check(ctx, db); !errors.Is(err, migrate.ErrSchemaMismatch) {
+		t.Errorf("check without schema_migrations = %v, want ErrSchemaMismatch", err)
+	}
+	r, err := migrate.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.Check(ctx, db); err != nil {
+		t.Errorf("check at latest = %v", err)
+	}
+	if _, err := r.DownTo(ctx, r.Latest()-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.Check(ctx, db); !errors.Is(err, migrate.ErrSchemaMismatch) {
+		t.Errorf("check one behind = %v, want ErrSchemaMismatch", err)
+	}
+}
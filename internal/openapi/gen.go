@@ -0,0 +1,248 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// initialisms are written in capitals in Go names, as golint expects.
+var initialisms = map[string]bool{"id": true, "url": true, "uuid": true, "api": true, "http": true}
+
+// Generate writes a Go client package for the document: a type for each
+// component schema and a Client method for each operation. The package must
+// also declare Client and its do method, which the generated methods call as
+//
+//	c.do(ctx, method, path string, query url.Values, body, out any) error
+func Generate(d *Document, pkg string) ([]byte, error) {
+	g := &generator{imports: map[string]bool{"context": true}}
+	for _, name := range sortedKeys(d.Components.Schemas) {
+		if err := g.schema(name, d.Components.Schemas[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, op := range d.Operations() {
+		if err := g.operation(op); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by cmd/openapi-gen from api/openapi.json. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	for _, imp := range sortedKeys(g.imports) {
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	out.WriteString(")\n")
+	out.Write(g.buf.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("openapi: format generated code: %w", err)
+	}
+	return src, nil
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// comment writes text as a doc comment, if there is any.
+func (g *generator) comment(indent, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if line != "" {
+			g.printf("%s// %s\n", indent, line)
+		}
+	}
+}
+
+// schema declares the Go type of a component schema.
+func (g *generator) schema(name string, s *Schema) error {
+	g.printf("\n")
+	if s.Description == "" {
+		g.printf("// %s is the %s schema of the document.\n", name, name)
+	}
+	g.comment("", s.Description)
+	switch {
+	case s.Type.Has("object") && s.Extra == nil:
+		g.printf("type %s struct {\n", name)
+		for _, prop := range s.Order {
+			p := s.Properties[prop]
+			required := contains(s.Required, prop)
+			typ, err := g.goType(p, !required)
+			if err != nil {
+				return fmt.Errorf("openapi: %s.%s: %w", name, prop, err)
+			}
+			tag := prop
+			if !required {
+				tag += ",omitempty"
+			}
+			g.comment("\t", p.Description)
+			g.printf("\t%s %s `json:%q`\n", goName(prop), typ, tag)
+		}
+		g.printf("}\n")
+	case s.Type.Has("string"):
+		g.printf("type %s string\n", name)
+		if len(s.Enum) > 0 {
+			g.printf("\nconst (\n")
+			for _, v := range s.Enum {
+				g.printf("\t%s%s %s = %q\n", name, goName(fmt.Sprint(v)), name, v)
+			}
+			g.printf(")\n")
+		}
+	default:
+		return fmt.Errorf("openapi: %s: component schemas of type %v are not supported", name, s.Type)
+	}
+	return nil
+}
+
+// goType returns the Go type for s. Optional scalars and structs are
+// pointers so that a missing value is not confused with its zero value.
+func (g *generator) goType(s *Schema, optional bool) (string, error) {
+	ptr := ""
+	if optional {
+		ptr = "*"
+	}
+	switch {
+	case s.Target != nil:
+		return ptr + s.Name, nil
+	case s.Type.Has("array"):
+		if s.Items == nil {
+			return "[]any", nil
+		}
+		elem, err := g.goType(s.Items, false)
+		return "[]" + elem, err
+	case s.Type.Has("object"):
+		if s.Extra == nil {
+			return "map[string]any", nil
+		}
+		elem, err := g.goType(s.Extra, false)
+		return "map[string]" + elem, err
+	case s.Type.Has("string") && s.Format == "date-time":
+		g.imports["time"] = true
+		return ptr + "time.Time", nil
+	case s.Type.Has("string"):
+		return ptr + "string", nil
+	case s.Type.Has("integer"):
+		return ptr + "int", nil
+	case s.Type.Has("number"):
+		return ptr + "float64", nil
+	case s.Type.Has("boolean"):
+		return ptr + "bool", nil
+	}
+	return "", fmt.Errorf("unsupported type %v", s.Type)
+}
+
+// operation declares the Client method for op, and its parameters struct
+// when it takes query parameters.
+func (g *generator) operation(op *Operation) error {
+	_, success := op.Success()
+	result := success.Schema()
+	if result == nil || result.Target == nil {
+		return fmt.Errorf("openapi: %s: the success response must $ref a component schema", op.OperationID)
+	}
+
+	args := []string{"ctx context.Context"}
+	path := fmt.Sprintf("%q", op.Path)
+	var query []*Parameter
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			g.imports["net/url"] = true
+			arg := lowerFirst(goName(p.Name))
+			args = append(args, arg+" string")
+			path = strings.Replace(path, "{"+p.Name+"}", `" + url.PathEscape(`+arg+`) + "`, 1)
+		case "query":
+			query = append(query, p)
+		}
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path, `"" + `), ` + ""`)
+
+	params := op.OperationID + "Params"
+	if len(query) > 0 {
+		g.printf("\n// %s are the query parameters of %s.\ntype %s struct {\n", params, op.OperationID, params)
+		for _, p := range query {
+			typ, err := g.goType(p.Schema, !p.Required)
+			if err != nil {
+				return fmt.Errorf("openapi: %s %s: %w", op.OperationID, p.Name, err)
+			}
+			g.comment("\t", p.Description)
+			g.printf("\t%s %s\n", goName(p.Name), typ)
+		}
+		g.printf("}\n")
+		args = append(args, "params "+params)
+	}
+	body := "nil"
+	if schema := op.RequestBody.Schema(); schema != nil {
+		if schema.Target == nil {
+			return fmt.Errorf("openapi: %s: the request body must $ref a component schema", op.OperationID)
+		}
+		args = append(args, "body "+schema.Name)
+		body = "body"
+	}
+
+	g.printf("\n")
+	g.comment("", op.OperationID+" calls "+op.Method+" "+op.Path+": "+lowerFirst(op.Summary)+".")
+	g.printf("func (c *Client) %s(%s) (*%s, error) {\n", op.OperationID, strings.Join(args, ", "), result.Name)
+	q := "nil"
+	if len(query) > 0 {
+		g.imports["net/url"] = true
+		g.imports["fmt"] = true
+		q = "q"
+		g.printf("\tq := url.Values{}\n")
+		for _, p := range query {
+			field := "params." + goName(p.Name)
+			value, deref := "fmt.Sprint(%s)", "*"
+			if p.Schema.Format == "date-time" {
+				value, deref = "%s.Format(time.RFC3339Nano)", "" // Format has a value receiver
+			}
+			if p.Required {
+				g.printf("\tq.Set(%q, "+value+")\n", p.Name, field)
+				continue
+			}
+			g.printf("\tif %s != nil {\n\t\tq.Set(%q, "+value+")\n\t}\n", field, p.Name, deref+field)
+		}
+	}
+	g.printf("\tvar out %s\n", result.Name)
+	g.printf("\tif err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\n\t\treturn nil, err\n\t}\n", op.Method, path, q, body)
+	g.printf("\treturn &out, nil\n}\n")
+	return nil
+}
+
+// goName turns a snake_case JSON name into an exported Go name.
+func goName(s string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' }) {
+		if initialisms[word] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// lowerFirst lowers the first word of s, keeping an initialism together.
+func lowerFirst(s string) string {
+	n := 0
+	for n < len(s) && s[n] >= 'A' && s[n] <= 'Z' {
+		n++
+	}
+	if n > 1 && n < len(s) {
+		n-- // "IDField" -> "idField"
+	}
+	return strings.ToLower(s[:n]) + s[n:]
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,339 @@
+// Package openapi loads the API's OpenAPI 3.1 document (api/openapi.json) and
+// validates API Gateway requests and responses against it. It understands the
+// part of JSON Schema the document uses: type, properties, required,
+// additionalProperties, items, enum, pattern, format (uuid, date-time),
+// minimum, maximum, exclusiveMinimum, minLength, maxLength, minItems, maxItems
+// and $ref to components.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Document is a loaded OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+
+	routes []route
+}
+
+// PathItem holds the operations of one path.
+type PathItem struct {
+	Get    *Operation `json:"get"`
+	Post   *Operation `json:"post"`
+	Put    *Operation `json:"put"`
+	Patch  *Operation `json:"patch"`
+	Delete *Operation `json:"delete"`
+}
+
+// Operation is one method on one path.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+
+	Method, Path string `json:"-"`
+}
+
+// Parameter is a path or query parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is an operation's JSON body.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is one declared response, or a $ref to components.responses.
+type Response struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType wraps a body schema.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 Types              `json:"type"`
+	Description          string             `json:"description"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	Format               string             `json:"format"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Default              any                `json:"default"`
+
+	// Resolved by Load
+	Name    string   `json:"-"` // component name of a $ref
+	Target  *Schema  `json:"-"` // what Ref points to
+	Extra   *Schema  `json:"-"` // additionalProperties as a schema
+	Closed  bool     `json:"-"` // additionalProperties: false
+	Order   []string `json:"-"` // property names in document order
+	pattern *regexp.Regexp
+}
+
+// Types is a schema's type: one name, or a list such as ["array", "null"].
+type Types []string
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+// Has reports whether name is one of the types.
+func (t Types) Has(name string) bool {
+	for _, n := range t {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Load parses and resolves an OpenAPI document.
+func Load(raw []byte) (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	if !strings.HasPrefix(d.OpenAPI, "3.1") {
+		return nil, fmt.Errorf("openapi: want version 3.1, got %q", d.OpenAPI)
+	}
+	orders, err := propertyOrder(raw)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	for name, s := range d.Components.Schemas {
+		if err := d.resolve(s, "#/components/schemas/"+name); err != nil {
+			return nil, err
+		}
+		s.Order = orders[name]
+	}
+	for _, p := range sortedKeys(d.Paths) {
+		for _, op := range d.Paths[p].operations() {
+			op.Path = p
+			if op.OperationID == "" {
+				return nil, fmt.Errorf("openapi: %s %s has no operationId", op.Method, p)
+			}
+			for _, param := range op.Parameters {
+				if param.In != "path" && param.In != "query" {
+					return nil, fmt.Errorf("openapi: %s: parameters in %s are not supported", op.OperationID, param.In)
+				}
+				if err := d.resolve(param.Schema, op.OperationID+" "+param.Name); err != nil {
+					return nil, err
+				}
+			}
+			if op.RequestBody != nil {
+				if err := d.resolve(op.RequestBody.Schema(), op.OperationID+" body"); err != nil {
+					return nil, err
+				}
+			}
+			for code, resp := range op.Responses {
+				if resp.Ref != "" {
+					name := strings.TrimPrefix(resp.Ref, "#/components/responses/")
+					if op.Responses[code] = d.Components.Responses[name]; op.Responses[code] == nil {
+						return nil, fmt.Errorf("openapi: %s: unknown response %s", op.OperationID, resp.Ref)
+					}
+				}
+				if err := d.resolve(op.Responses[code].Schema(), op.OperationID+" "+code); err != nil {
+					return nil, err
+				}
+			}
+			d.routes = append(d.routes, newRoute(op))
+		}
+	}
+	return &d, nil
+}
+
+// MustLoad is Load for documents embedded at build time.
+func MustLoad(raw []byte) *Document {
+	d, err := Load(raw)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Operations returns every operation, by path then method.
+func (d *Document) Operations() []*Operation {
+	ops := make([]*Operation, len(d.routes))
+	for i, r := range d.routes {
+		ops[i] = r.op
+	}
+	return ops
+}
+
+func (p *PathItem) operations() []*Operation {
+	var ops []*Operation
+	for _, m := range []struct {
+		method string
+		op     *Operation
+	}{{http.MethodGet, p.Get}, {http.MethodPost, p.Post}, {http.MethodPut, p.Put}, {http.MethodPatch, p.Patch}, {http.MethodDelete, p.Delete}} {
+		if m.op != nil {
+			m.op.Method = m.method
+			ops = append(ops, m.op)
+		}
+	}
+	return ops
+}
+
+// Schema returns the JSON body schema, or nil.
+func (b *RequestBody) Schema() *Schema {
+	if b == nil || b.Content["application/json"] == nil {
+		return nil
+	}
+	return b.Content["application/json"].Schema
+}
+
+// Schema returns the JSON or problem+json body schema, or nil.
+func (r *Response) Schema() *Schema {
+	if r == nil {
+		return nil
+	}
+	for _, typ := range []string{"application/json", "application/problem+json"} {
+		if r.Content[typ] != nil {
+			return r.Content[typ].Schema
+		}
+	}
+	return nil
+}
+
+// Success returns the operation's first 2xx response and its status code.
+func (op *Operation) Success() (int, *Response) {
+	for code := 200; code < 300; code++ {
+		if r := op.Responses[fmt.Sprint(code)]; r != nil {
+			return code, r
+		}
+	}
+	return 0, nil
+}
+
+// resolve links $refs and compiles patterns in s and everything below it.
+func (d *Document) resolve(s *Schema, at string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if s.Target = d.Components.Schemas[name]; !ok || s.Target == nil {
+			return fmt.Errorf("openapi: %s: unknown schema %s", at, s.Ref)
+		}
+		s.Name = name
+		return nil
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("openapi: %s: %w", at, err)
+		}
+		s.pattern = re
+	}
+	switch extra := strings.TrimSpace(string(s.AdditionalProperties)); extra {
+	case "", "true":
+	case "false":
+		s.Closed = true
+	default:
+		s.Extra = new(Schema)
+		if err := json.Unmarshal(s.AdditionalProperties, s.Extra); err != nil {
+			return fmt.Errorf("openapi: %s: additionalProperties: %w", at, err)
+		}
+		if err := d.resolve(s.Extra, at+"{}"); err != nil {
+			return err
+		}
+	}
+	for name, p := range s.Properties {
+		if err := d.resolve(p, at+"."+name); err != nil {
+			return err
+		}
+	}
+	return d.resolve(s.Items, at+"[]")
+}
+
+// propertyOrder returns the property names of each component schema in
+// document order, which encoding/json loses, so generated code follows the
+// document.
+func propertyOrder(raw []byte) (map[string][]string, error) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	orders := map[string][]string{}
+	for name, s := range doc.Components.Schemas {
+		if len(s.Properties) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(s.Properties))
+		if _, err := dec.Token(); err != nil { // {
+			return nil, err
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			orders[name] = append(orders[name], tok.(string))
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return orders, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,160 @@
+package openapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/openapi"
+	"github.com/irajwani/microservice-go/internal/problem"
+)
+
+func request(method, path string, query map[string]string, body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{HTTPMethod: method, Path: path, QueryStringParameters: query, Body: body}
+}
+
+func TestFind(t *testing.T) {
+	cases := map[string]string{
+		"POST /jobs":                   "CreateJob",
+		"POST /jobs/batch":             "CreateBatch",
+		"GET /jobs/abc":                "GetJob",
+		"GET /batches/abc":             "GetBatch",
+		"GET /rates/USD-EUR":           "GetRateQuote",
+		"GET /rates/USD-EUR/history":   "GetRateHistory",
+		"GET /rates/USD-EUR/history/x": "",
+		"DELETE /jobs/abc":             "",
+		"GET /admin/jobs/x/y/z":        "",
+	}
+	for req, want := range cases {
+		method, path, _ := strings.Cut(req, " ")
+		op, _ := api.Spec.Find(method, path)
+		if got := ""; op != nil {
+			got = op.OperationID
+			if got != want {
+				t.Errorf("%s: %s, want %s", req, got, want)
+			}
+		} else if want != "" {
+			t.Errorf("%s: no operation, want %s", req, want)
+		}
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	job := `{"client_id":"c","source_currency":"USD","target_currency":"EUR","source_amount":1}`
+	valid := []events.APIGatewayProxyRequest{
+		request(http.MethodPost, "/jobs", nil, job),
+		request(http.MethodPost, "/jobs", nil, `{"client_id":"c","source_currency":"USD","target_currency":"EUR","source_amount":1,"limit_rate":0.9,"expires_at":"2030-01-01T00:00:00Z"}`),
+		request(http.MethodGet, "/jobs", map[string]string{"user_id": "u", "limit": "10"}, ""),
+		request(http.MethodGet, "/jobs/9b2c6f3e-8a55-4e0e-9a4b-0c1f2d3e4f50", nil, ""),
+		request(http.MethodGet, "/rate", map[string]string{"source": "usd", "target": "EUR"}, ""),
+		request(http.MethodGet, "/undeclared", nil, "{"),
+	}
+	for _, req := range valid {
+		if err := api.Spec.CheckRequest(req); err != nil {
+			t.Errorf("%s %s %s: %v", req.HTTPMethod, req.Path, req.Body, err)
+		}
+	}
+
+	invalid := map[string]events.APIGatewayProxyRequest{
+		"query parameter limit: want an integer":          request(http.MethodGet, "/jobs", map[string]string{"user_id": "u", "limit": "ten"}, ""),
+		"query parameter limit: want at most 500":         request(http.MethodGet, "/jobs", map[string]string{"user_id": "u", "limit": "501"}, ""),
+		"query parameter user_id is required":             request(http.MethodGet, "/jobs", nil, ""),
+		"query parameter source: \"US\" does not match":   request(http.MethodGet, "/rate", map[string]string{"source": "US", "target": "EUR"}, ""),
+		"request body is required":                        request(http.MethodPost, "/jobs", nil, ""),
+		"invalid json":                                    request(http.MethodPost, "/jobs", nil, "{"),
+		"request body: client_id is required":             request(http.MethodPost, "/jobs", nil, `{"source_currency":"USD","target_currency":"EUR","source_amount":1}`),
+		"request body.source_amount: want more than 0":    request(http.MethodPost, "/jobs", nil, strings.Replace(job, `"source_amount":1`, `"source_amount":0`, 1)),
+		"request body.source_currency: \"usd\" does not":  request(http.MethodPost, "/jobs", nil, strings.Replace(job, "USD", "usd", 1)),
+		"request body.jobs[1].source_amount: want number": request(http.MethodPost, "/jobs/batch", nil, `{"client_id":"c","jobs":[{"source_currency":"USD","target_currency":"EUR","source_amount":1},{"source_currency":"USD","target_currency":"EUR","source_amount":"1"}]}`),
+		"request body.jobs: want at least 1 items":        request(http.MethodPost, "/jobs/batch", nil, `{"client_id":"c","jobs":[]}`),
+	}
+	for want, req := range invalid {
+		err := api.Spec.CheckRequest(req)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s %s: err = %v, want %q", req.HTTPMethod, req.Path, err, want)
+		}
+	}
+
+	fields := map[string]events.APIGatewayProxyRequest{
+		"limit":                 invalid["query parameter limit: want at most 500"],
+		"client_id":             invalid["request body: client_id is required"],
+		"jobs[1].source_amount": invalid["request body.jobs[1].source_amount: want number"],
+		"":                      invalid["invalid json"],
+	}
+	for want, req := range fields {
+		var re *openapi.RequestError
+		if err := api.Spec.CheckRequest(req); !errors.As(err, &re) || re.Field != want {
+			t.Errorf("%s %s: err = %#v, want field %q", req.HTTPMethod, req.Path, err, want)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	req := request(http.MethodGet, "/balances", map[string]string{"user_id": "u"}, "")
+	respond := func(status int, body string) events.APIGatewayProxyResponse {
+		return events.APIGatewayProxyResponse{StatusCode: status, Body: body}
+	}
+	if err := api.Spec.CheckResponse(req, respond(200, `{"user_id":"u","accounts":[{"currency":"USD","balance":10,"held":2,"available":8}]}`)); err != nil {
+		t.Errorf("balances: %v", err)
+	}
+	if err := api.Spec.CheckResponse(req, respond(200, `{"user_id":"u","accounts":null}`)); err != nil {
+		t.Errorf("no accounts: %v", err)
+	}
+	if err := api.Spec.CheckResponse(req, respond(404, `{"type":"urn:problem:not-found","title":"Not found","status":404,"code":"NOT_FOUND"}`)); err != nil {
+		t.Errorf("default error response: %v", err)
+	}
+	for body, want := range map[string]string{
+		`{"user_id":"u","accounts":[{"currency":"USD","balance":10}]}`:                          "GetBalances 200: response body.accounts[0]: held is required",
+		`{"user_id":"u","accounts":[],"total":1}`:                                               "GetBalances 200: response body: unknown property total",
+		`{"user_id":"u","accounts":[{"currency":"USD","balance":"10","held":0,"available":0}]}`: "GetBalances 200: response body.accounts[0].balance: want number, got string",
+		`not json`: "GetBalances 200: invalid json",
+	} {
+		if err := api.Spec.CheckResponse(req, respond(200, body)); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	called := false
+	h := api.Spec.Wrap(func(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		called = true
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: `{"unexpected":true}`}, nil
+	}, "GetBalances")
+	resp, err := h(context.Background(), request(http.MethodGet, "/balances", nil, ""))
+	if err != nil || resp.StatusCode != http.StatusBadRequest || called {
+		t.Errorf("invalid request: status %d err %v called %v", resp.StatusCode, err, called)
+	}
+	var p problem.Problem
+	if err := json.Unmarshal([]byte(resp.Body), &p); err != nil || p.Code != problem.InvalidRequest || len(p.Errors) != 1 || p.Errors[0].Field != "user_id" {
+		t.Errorf("body = %s", resp.Body)
+	}
+	if resp.Headers["Content-Type"] != problem.ContentType {
+		t.Errorf("content type = %q", resp.Headers["Content-Type"])
+	}
+	// A response that does not match is logged, not replaced
+	resp, _ = h(context.Background(), request(http.MethodGet, "/balances", map[string]string{"user_id": "u"}, ""))
+	if !called || resp.StatusCode != http.StatusOK || resp.Body != `{"unexpected":true}` {
+		t.Errorf("valid request: status %d body %s", resp.StatusCode, resp.Body)
+	}
+	// Operations next does not serve are left to it
+	called = false
+	if resp, _ = h(context.Background(), request(http.MethodGet, "/jobs", nil, "")); !called || resp.StatusCode != http.StatusOK {
+		t.Errorf("other operation: status %d called %v", resp.StatusCode, called)
+	}
+}
+
+func TestLoadRejectsUnknownRefs(t *testing.T) {
+	doc := `{"openapi":"3.1.0","paths":{"/x":{"get":{"operationId":"X","responses":{"200":{"description":"ok","content":{"application/json":{"schema":{"$ref":"#/components/schemas/Missing"}}}}}}}}}`
+	if _, err := openapi.Load([]byte(doc)); err == nil || !strings.Contains(err.Error(), "unknown schema") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := openapi.Load([]byte(`{"openapi":"3.0.3"}`)); err == nil {
+		t.Error("3.0 document loaded")
+	}
+}
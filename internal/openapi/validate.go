@@ -0,0 +1,345 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/problem"
+)
+
+// Handler is an API Gateway proxy handler.
+type Handler func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// route matches request paths to an operation.
+type route struct {
+	op       *Operation
+	segments []string // "" for a {param}
+	params   []string // name of each {param}
+}
+
+func newRoute(op *Operation) route {
+	r := route{op: op}
+	for _, seg := range strings.Split(strings.Trim(op.Path, "/"), "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			r.segments = append(r.segments, "")
+			r.params = append(r.params, strings.TrimSuffix(name, "}"))
+			continue
+		}
+		r.segments = append(r.segments, seg)
+	}
+	return r
+}
+
+// match returns the path parameters when path matches the route.
+func (r route) match(path string) (map[string]string, bool) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) != len(r.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, seg := range segs {
+		switch {
+		case r.segments[i] != "" && r.segments[i] != seg:
+			return nil, false
+		case r.segments[i] == "":
+			if seg == "" {
+				return nil, false
+			}
+			params[r.params[len(params)]] = seg
+		}
+	}
+	return params, true
+}
+
+// Find returns the operation for the request and its path parameters. Of
+// several matching paths the one with the fewest parameters wins, so
+// /jobs/batch is preferred over /jobs/{job_id}.
+func (d *Document) Find(method, path string) (*Operation, map[string]string) {
+	var best *Operation
+	var bestParams map[string]string
+	for _, r := range d.routes {
+		if r.op.Method != method {
+			continue
+		}
+		if params, ok := r.match(path); ok && (best == nil || len(params) < len(bestParams)) {
+			best, bestParams = r.op, params
+		}
+	}
+	return best, bestParams
+}
+
+// RequestError is a request that does not conform to the document. Field is
+// the parameter name, or the body field as a JSON path such as
+// jobs[1].source_amount, and is empty when the body as a whole is at fault;
+// Detail says what is wrong with it.
+type RequestError struct {
+	Field  string
+	Detail string
+	msg    string
+}
+
+func (e *RequestError) Error() string { return e.msg }
+
+// Wrap validates requests for the named operations, the ones next serves,
+// before next sees them, answering a 400 problem when one does not conform,
+// with a RequestError's field in its errors, and checks
+// next's responses, logging a mismatch rather than failing the request. Any
+// other request passes through unchecked, so next still answers its 404s.
+func (d *Document) Wrap(next Handler, operationIDs ...string) Handler {
+	return func(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if op, _ := d.Find(evt.HTTPMethod, evt.Path); op == nil || !contains(operationIDs, op.OperationID) {
+			return next(ctx, evt)
+		}
+		if err := d.CheckRequest(evt); err != nil {
+			var errs []problem.FieldError
+			if re := (*RequestError)(nil); errors.As(err, &re) && re.Field != "" {
+				errs = append(errs, problem.FieldError{Field: re.Field, Detail: re.Detail})
+			}
+			return problem.Invalid(err.Error(), errs...).Response(ctx), nil
+		}
+		resp, err := next(ctx, evt)
+		if err == nil {
+			if err := d.CheckResponse(evt, resp); err != nil {
+				slog.ErrorContext(ctx, "response does not match the OpenAPI document", "status", resp.StatusCode, "error", err)
+			}
+		}
+		return resp, err
+	}
+}
+
+// CheckRequest validates the request's path and query parameters and JSON
+// body, returning a *RequestError. An undeclared operation is not an error.
+func (d *Document) CheckRequest(evt events.APIGatewayProxyRequest) error {
+	op, pathParams := d.Find(evt.HTTPMethod, evt.Path)
+	if op == nil {
+		return nil
+	}
+	for _, p := range op.Parameters {
+		v, ok := pathParams[p.Name], p.In == "path"
+		if p.In == "query" {
+			v, ok = evt.QueryStringParameters[p.Name]
+		}
+		if !ok || v == "" {
+			if p.Required {
+				return &RequestError{p.Name, "is required", fmt.Sprintf("%s parameter %s is required", p.In, p.Name)}
+			}
+			continue
+		}
+		value, err := p.Schema.parse(v)
+		if err != nil {
+			return &RequestError{p.Name, err.Error(), fmt.Sprintf("%s parameter %s: %v", p.In, p.Name, err)}
+		}
+		if err := p.Schema.Validate(value); err != nil {
+			return &RequestError{p.Name, err.(*schemaError).detail, fmt.Sprintf("%s parameter %s%v", p.In, p.Name, err)}
+		}
+	}
+	schema := op.RequestBody.Schema()
+	if schema == nil {
+		return nil
+	}
+	if strings.TrimSpace(evt.Body) == "" {
+		if op.RequestBody.Required {
+			return &RequestError{msg: "request body is required"}
+		}
+		return nil
+	}
+	var body any
+	if err := json.Unmarshal([]byte(evt.Body), &body); err != nil {
+		return &RequestError{msg: fmt.Sprintf("invalid json: %v", err)}
+	}
+	if err := schema.Validate(body); err != nil {
+		se := err.(*schemaError)
+		return &RequestError{strings.TrimPrefix(se.field, "."), se.detail, "request body" + se.Error()}
+	}
+	return nil
+}
+
+// CheckResponse validates resp against what the request's operation declares
+// for its status code, or its default response. An undeclared operation is
+// not an error.
+func (d *Document) CheckResponse(evt events.APIGatewayProxyRequest, resp events.APIGatewayProxyResponse) error {
+	op, _ := d.Find(evt.HTTPMethod, evt.Path)
+	if op == nil {
+		return nil
+	}
+	declared := op.Responses[strconv.Itoa(resp.StatusCode)]
+	if declared == nil {
+		declared = op.Responses["default"]
+	}
+	if declared == nil {
+		return fmt.Errorf("%s does not declare status %d", op.OperationID, resp.StatusCode)
+	}
+	schema := declared.Schema()
+	if schema == nil {
+		return nil
+	}
+	var body any
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		return fmt.Errorf("%s %d: invalid json: %w", op.OperationID, resp.StatusCode, err)
+	}
+	if err := schema.Validate(body); err != nil {
+		return fmt.Errorf("%s %d: response body%w", op.OperationID, resp.StatusCode, err)
+	}
+	return nil
+}
+
+// parse converts a parameter's string value to the schema's type.
+func (s *Schema) parse(v string) (any, error) {
+	switch {
+	case s.Type.Has("integer"):
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("want an integer")
+		}
+		return float64(n), nil
+	case s.Type.Has("number"):
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("want a number")
+		}
+		return f, nil
+	case s.Type.Has("boolean"):
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("want true or false")
+		}
+		return b, nil
+	}
+	return v, nil
+}
+
+// schemaError locates a value that does not match its schema. field is at,
+// or for a missing property the path the property would have had.
+type schemaError struct {
+	at, field, detail string
+}
+
+func (e *schemaError) Error() string { return e.at + ": " + e.detail }
+
+// Validate checks a decoded JSON value against the schema. Errors start with
+// the location of the offending value, e.g. ".jobs[0].source_amount: ...".
+func (s *Schema) Validate(v any) error {
+	if err := s.validate(v, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Schema) validate(v any, at string) *schemaError {
+	if s.Target != nil {
+		return s.Target.validate(v, at)
+	}
+	fail := func(format string, args ...any) *schemaError {
+		return &schemaError{at: at, field: at, detail: fmt.Sprintf(format, args...)}
+	}
+	if len(s.Type) > 0 && !s.Type.Has(typeOf(v)) && !(s.Type.Has("number") && typeOf(v) == "integer") {
+		return fail("want %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+	}
+	if len(s.Enum) > 0 {
+		ok := false
+		for _, e := range s.Enum {
+			ok = ok || reflect.DeepEqual(e, v)
+		}
+		if !ok {
+			return fail("want one of %v", s.Enum)
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		switch {
+		case s.MinLength != nil && n < *s.MinLength:
+			return fail("want at least %d characters", *s.MinLength)
+		case s.MaxLength != nil && n > *s.MaxLength:
+			return fail("want at most %d characters", *s.MaxLength)
+		case s.pattern != nil && !s.pattern.MatchString(v):
+			return fail("%q does not match %s", v, s.Pattern)
+		case s.Format == "uuid" && uuid.Validate(v) != nil:
+			return fail("%q is not a UUID", v)
+		case s.Format == "date-time" && !isDateTime(v):
+			return fail("%q is not an RFC 3339 date-time", v)
+		}
+	case float64:
+		switch {
+		case s.Minimum != nil && v < *s.Minimum:
+			return fail("want at least %v", *s.Minimum)
+		case s.Maximum != nil && v > *s.Maximum:
+			return fail("want at most %v", *s.Maximum)
+		case s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum:
+			return fail("want more than %v", *s.ExclusiveMinimum)
+		}
+	case []any:
+		switch {
+		case s.MinItems != nil && len(v) < *s.MinItems:
+			return fail("want at least %d items", *s.MinItems)
+		case s.MaxItems != nil && len(v) > *s.MaxItems:
+			return fail("want at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				err := fail("%s is required", name)
+				err.field = at + "." + name
+				return err
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			prop := s.Properties[name]
+			switch {
+			case prop != nil:
+			case s.Extra != nil:
+				prop = s.Extra
+			case s.Closed:
+				return fail("unknown property %s", name)
+			default:
+				continue
+			}
+			if err := prop.validate(v[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typeOf names the JSON Schema type of a decoded JSON value.
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func isDateTime(v string) bool {
+	_, err := time.Parse(time.RFC3339Nano, v)
+	return err == nil
+}
@@ -0,0 +1,68 @@
+// Package pgtx runs database/sql transactions against Postgres, retrying the
+// whole transaction when Postgres aborts it with a deadlock (40P01) or a
+// serialization failure (40001).
+package pgtx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MaxAttempts bounds how often Run executes a transaction.
+const MaxAttempts = 5
+
+// Retryable reports whether err is a deadlock or serialization failure, after
+// which the transaction may succeed if simply run again.
+func Retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40P01" || pgErr.Code == "40001"
+}
+
+// Run executes fn in a transaction and commits it. When fn or the commit fails
+// with a Retryable error the transaction is rolled back and fn runs again
+// after a short jittered backoff, up to MaxAttempts times. fn must therefore
+// not have side effects outside the transaction that cannot be repeated.
+func Run(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runOnce(ctx, db, fn)
+		if err == nil || !Retryable(err) || attempt == MaxAttempts {
+			return err
+		}
+		slog.WarnContext(ctx, "retrying transaction", "attempt", attempt, "error", err)
+		metrics.Count(metrics.TxRetries, 1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+func runOnce(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backoff is 10ms, 20ms, 40ms... plus up to 100% jitter so that the
+// transactions that collided do not collide again.
+func backoff(attempt int) time.Duration {
+	d := 10 * time.Millisecond << (attempt - 1)
+	return d + rand.N(d)
+}
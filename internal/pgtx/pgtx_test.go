@@ -0,0 +1,38 @@
+package pgtx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"deadlock":         {&pgconn.PgError{Code: "40P01"}, true},
+		"serialization":    {&pgconn.PgError{Code: "40001"}, true},
+		"wrapped deadlock": {fmt.Errorf("load job: %w", &pgconn.PgError{Code: "40P01"}), true},
+		"unique violation": {&pgconn.PgError{Code: "23505"}, false},
+		"check violation":  {&pgconn.PgError{Code: "23514"}, false},
+		"plain error":      {errors.New("boom"), false},
+		"nil":              {nil, false},
+	}
+	for name, c := range cases {
+		if got := Retryable(c.err); got != c.want {
+			t.Errorf("%s: Retryable = %v, want %v", name, got, c.want)
+		}
+	}
+}
+
+func TestBackoffGrows(t *testing.T) {
+	for attempt := 1; attempt < MaxAttempts; attempt++ {
+		lo := backoff(attempt)
+		if base := 10 << (attempt - 1); lo.Milliseconds() < int64(base) || lo.Milliseconds() >= int64(2*base) {
+			t.Errorf("attempt %d: backoff %v outside [%dms, %dms)", attempt, lo, base, 2*base)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package problem renders API errors as RFC 7807 problem details
+// (application/problem+json). Each problem carries a stable, machine-readable
+// code, which is also what settlement records in conversion_jobs.metadata.error
+// when a job fails, so one list of codes covers both.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/logging"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// Codes. Clients match on these, so they never change meaning once released.
+const (
+	InvalidRequest      = "INVALID_REQUEST"      // malformed or failing validation; see errors
+	Unauthorized        = "UNAUTHORIZED"         // no tenant, bad key or missing operator token
+	Forbidden           = "FORBIDDEN"            // authenticated but not allowed
+	NotFound            = "NOT_FOUND"            // unknown route or resource
+	Conflict            = "CONFLICT"             // the resource is not in a state that allows this
+	IdempotencyConflict = "IDEMPOTENCY_CONFLICT" // idempotency key reused for a different request
+	InsufficientFunds   = "INSUFFICIENT_FUNDS"
+	UnsupportedPair     = "UNSUPPORTED_PAIR"     // no rate for the currency pair
+	RateUnavailable     = "RATE_UNAVAILABLE"     // a rate the request needs cannot be had
+	CurrencyNotEnabled  = "CURRENCY_NOT_ENABLED" // not in the tenant's currencies
+	AmountTooSmall      = "AMOUNT_TOO_SMALL"     // nothing left after fees
+	UserFrozen          = "USER_FROZEN"
+	UserClosed          = "USER_CLOSED"
+	AccountFrozen       = "ACCOUNT_FROZEN"
+	AccountClosed       = "ACCOUNT_CLOSED"
+	LimitExpired        = "LIMIT_EXPIRED"    // limit order cancelled at expires_at
+	BatchLegFailed      = "BATCH_LEG_FAILED" // another leg of an all-or-nothing batch failed
+	Internal            = "INTERNAL"
+)
+
+var titles = map[string]string{
+	InvalidRequest:      "The request is invalid",
+	Unauthorized:        "Authentication is required",
+	Forbidden:           "The request is not allowed",
+	NotFound:            "Not found",
+	Conflict:            "The request conflicts with the current state",
+	IdempotencyConflict: "The idempotency key was used for a different request",
+	InsufficientFunds:   "Insufficient funds",
+	UnsupportedPair:     "The currency pair is not supported",
+	RateUnavailable:     "A rate is unavailable",
+	CurrencyNotEnabled:  "The currency is not enabled for the tenant",
+	AmountTooSmall:      "The amount does not cover the fee",
+	UserFrozen:          "The user is frozen",
+	UserClosed:          "The user is closed",
+	AccountFrozen:       "The account is frozen",
+	AccountClosed:       "The account is closed",
+	LimitExpired:        "The limit order expired",
+	BatchLegFailed:      "Another leg of the batch failed",
+	Internal:            "Internal error",
+}
+
+// Problem is an RFC 7807 problem details object. Code, RequestID and Errors
+// are extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is one invalid part of a request: a body field as a JSON path
+// such as jobs[1].source_amount, or a path or query parameter name.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// New returns the problem for code.
+func New(status int, code, detail string) *Problem {
+	title := titles[code]
+	if title == "" {
+		title = http.StatusText(status)
+	}
+	return &Problem{Type: Type(code), Title: title, Status: status, Detail: detail, Code: code}
+}
+
+// Type is the problem type URI of code.
+func Type(code string) string {
+	return "urn:problem:" + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+}
+
+// Invalid is a 400 INVALID_REQUEST listing the invalid fields.
+func Invalid(detail string, errs ...FieldError) *Problem {
+	p := New(http.StatusBadRequest, InvalidRequest, detail)
+	p.Errors = errs
+	return p
+}
+
+// Response renders p with the request id of ctx.
+func (p *Problem) Response(ctx context.Context) events.APIGatewayProxyResponse {
+	if p.RequestID == "" {
+		p.RequestID = logging.CorrelationID(ctx)
+	}
+	b, _ := json.Marshal(p)
+	headers := map[string]string{"Content-Type": ContentType}
+	if p.RequestID != "" {
+		headers[logging.Header] = p.RequestID
+	}
+	return events.APIGatewayProxyResponse{StatusCode: p.Status, Body: string(b), Headers: headers}
+}
+
+// Write writes p to w, for handlers served by net/http rather than API
+// Gateway.
+func (p *Problem) Write(ctx context.Context, w http.ResponseWriter) {
+	resp := p.Response(ctx)
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write([]byte(resp.Body))
+}
+
+// Respond is New(status, code, detail).Response(ctx), shaped for returning
+// from a handler.
+func Respond(ctx context.Context, status int, code, detail string) (events.APIGatewayProxyResponse, error) {
+	return New(status, code, detail).Response(ctx), nil
+}
+
+// ServerError logs err and answers 500 INTERNAL without revealing it.
+func ServerError(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	slog.ErrorContext(ctx, "request failed", "error", err)
+	return Respond(ctx, http.StatusInternalServerError, Internal, "")
+}
+
+// RespondInvalid answers 400 INVALID_REQUEST for one invalid field.
+func RespondInvalid(ctx context.Context, field, detail string) (events.APIGatewayProxyResponse, error) {
+	return Invalid(detail, FieldError{Field: field, Detail: detail}).Response(ctx), nil
+}
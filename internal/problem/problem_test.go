@@ -0,0 +1,50 @@
+package problem_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+)
+
+func TestResponseCarriesCodeAndRequestID(t *testing.T) {
+	ctx := logging.WithCorrelationID(context.Background(), "corr-1")
+	resp, _ := problem.Respond(ctx, http.StatusUnprocessableEntity, problem.InsufficientFunds, "insufficient available funds")
+	if resp.StatusCode != http.StatusUnprocessableEntity || resp.Headers["Content-Type"] != problem.ContentType || resp.Headers[logging.Header] != "corr-1" {
+		t.Errorf("response = %+v", resp)
+	}
+	var p problem.Problem
+	if err := json.Unmarshal([]byte(resp.Body), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := problem.Problem{Type: "urn:problem:insufficient-funds", Title: "Insufficient funds", Status: 422, Detail: "insufficient available funds", Code: "INSUFFICIENT_FUNDS", RequestID: "corr-1"}
+	if p.Type != want.Type || p.Title != want.Title || p.Status != want.Status || p.Detail != want.Detail || p.Code != want.Code || p.RequestID != want.RequestID {
+		t.Errorf("problem = %+v, want %+v", p, want)
+	}
+}
+
+func TestInvalidListsFields(t *testing.T) {
+	w := httptest.NewRecorder()
+	problem.Invalid("invalid batch items", problem.FieldError{Field: "jobs[1].source_amount", Detail: "source_amount must be positive"}).Write(context.Background(), w)
+	var p problem.Problem
+	_ = json.Unmarshal(w.Body.Bytes(), &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.InvalidRequest || len(p.Errors) != 1 || p.Errors[0].Field != "jobs[1].source_amount" {
+		t.Errorf("status %d, body %s", w.Code, w.Body)
+	}
+	if p.RequestID != "" || w.Header().Get(logging.Header) != "" {
+		t.Errorf("request id %q without a correlation id", p.RequestID)
+	}
+}
+
+func TestServerErrorHidesCause(t *testing.T) {
+	resp, _ := problem.ServerError(context.Background(), context.DeadlineExceeded)
+	var p problem.Problem
+	_ = json.Unmarshal([]byte(resp.Body), &p)
+	if resp.StatusCode != http.StatusInternalServerError || p.Code != problem.Internal || p.Detail != "" {
+		t.Errorf("response = %+v", resp)
+	}
+}
@@ -0,0 +1,33 @@
+// Package queue publishes job messages to the conversion queue.
+package queue
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SQSPublisher sends messages to one SQS queue with the trace context
+// injected into the message attributes.
+type SQSPublisher struct {
+	Client   *sqs.Client
+	QueueURL string
+}
+
+// Publish sends body with attrs as string message attributes.
+func (p SQSPublisher) Publish(ctx context.Context, body []byte, attrs map[string]string) error {
+	ctx, span := tracing.Start(ctx, "sqs.send", trace.WithSpanKind(trace.SpanKindProducer))
+	msgAttrs := make(map[string]sqstypes.MessageAttributeValue, len(attrs))
+	for k, v := range attrs {
+		msgAttrs[k] = sqstypes.MessageAttributeValue{DataType: ptr("String"), StringValue: ptr(v)}
+	}
+	tracing.InjectSQS(ctx, msgAttrs)
+	_, err := p.Client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: &p.QueueURL, MessageBody: ptr(string(body)), MessageAttributes: msgAttrs})
+	tracing.End(span, err)
+	return err
+}
+
+func ptr[T any](v T) *T { return &v }
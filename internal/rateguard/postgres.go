@@ -0,0 +1,92 @@
+package rateguard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/pgtx"
+)
+
+// PGStore is the Postgres Store, on rate_breakers and rate_rejections. Both
+// are shared by every tenant, so any connection can use them.
+type PGStore struct{ DB *sql.DB }
+
+// BreakerColumns select a Breaker from rate_breakers b LEFT JOIN
+// rate_rejections r ON r.rejection_id = b.rejection_id, for ScanBreaker.
+const BreakerColumns = `b.source_currency, b.target_currency, b.status, b.reference_rate, b.reference_at, COALESCE(b.reset_by, ''), b.reset_at,
+	r.rejection_id, r.rate, r.provider, r.quoted_at, r.reason, r.reference_rate, r.deviation, r.rejected_at`
+
+// RejectionColumns select a Rejection from rate_rejections, for ScanRejection.
+const RejectionColumns = `rejection_id, source_currency, target_currency, rate, provider, quoted_at, reason, reference_rate, deviation, rejected_at`
+
+// Scanner is a *sql.Row or *sql.Rows.
+type Scanner interface{ Scan(dest ...any) error }
+
+// ScanBreaker scans BreakerColumns.
+func ScanBreaker(row Scanner) (Breaker, error) {
+	var b Breaker
+	var id sql.NullInt64
+	var rate sql.NullFloat64
+	var provider, reason sql.NullString
+	var rejectedAt sql.NullTime
+	var r Rejection
+	err := row.Scan(&b.Source, &b.Target, &b.Status, &b.ReferenceRate, &b.ReferenceAt, &b.ResetBy, &b.ResetAt,
+		&id, &rate, &provider, &r.QuotedAt, &reason, &r.ReferenceRate, &r.Deviation, &rejectedAt)
+	if err != nil {
+		return b, err
+	}
+	if id.Valid {
+		r.ID, r.Source, r.Target, r.Rate, r.Provider, r.Reason, r.RejectedAt = id.Int64, b.Source, b.Target, rate.Float64, provider.String, reason.String, rejectedAt.Time.UTC()
+		b.Rejection = &r
+	}
+	return b, nil
+}
+
+// ScanRejection scans RejectionColumns.
+func ScanRejection(row Scanner) (Rejection, error) {
+	var r Rejection
+	err := row.Scan(&r.ID, &r.Source, &r.Target, &r.Rate, &r.Provider, &r.QuotedAt, &r.Reason, &r.ReferenceRate, &r.Deviation, &r.RejectedAt)
+	r.RejectedAt = r.RejectedAt.UTC()
+	return r, err
+}
+
+func (s PGStore) Breaker(ctx context.Context, source, target string) (Breaker, error) {
+	b, err := ScanBreaker(s.DB.QueryRowContext(ctx, `SELECT `+BreakerColumns+`
+		FROM rate_breakers b LEFT JOIN rate_rejections r ON r.rejection_id = b.rejection_id
+		WHERE b.source_currency=$1 AND b.target_currency=$2`, source, target))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Breaker{Source: source, Target: target, Status: BreakerClosed}, nil
+	}
+	return b, err
+}
+
+// Accept only moves the reference forward in time, and leaves an open
+// breaker's reference for the operator to review.
+func (s PGStore) Accept(ctx context.Context, source, target string, rate float64, at time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO rate_breakers (source_currency, target_currency, reference_rate, reference_at)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (source_currency, target_currency) DO UPDATE SET reference_rate=EXCLUDED.reference_rate, reference_at=EXCLUDED.reference_at, updated_at=now()
+		WHERE rate_breakers.status = 'closed' AND (rate_breakers.reference_at IS NULL OR rate_breakers.reference_at <= EXCLUDED.reference_at)`,
+		source, target, rate, at)
+	return err
+}
+
+func (s PGStore) Reject(ctx context.Context, r Rejection) (Rejection, error) {
+	err := pgtx.Run(ctx, s.DB, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, `INSERT INTO rate_rejections (source_currency, target_currency, rate, provider, quoted_at, reason, reference_rate, deviation)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING rejection_id, rejected_at`,
+			r.Source, r.Target, r.Rate, r.Provider, r.QuotedAt, r.Reason, r.ReferenceRate, r.Deviation).Scan(&r.ID, &r.RejectedAt); err != nil {
+			return err
+		}
+		// A breaker that is already open keeps the rejection that opened it
+		_, err := tx.ExecContext(ctx, `INSERT INTO rate_breakers (source_currency, target_currency, status, rejection_id)
+			VALUES ($1,$2,'open',$3)
+			ON CONFLICT (source_currency, target_currency) DO UPDATE SET status='open', rejection_id=EXCLUDED.rejection_id, updated_at=now()
+			WHERE rate_breakers.status = 'closed'`, r.Source, r.Target, r.ID)
+		return err
+	})
+	r.RejectedAt = r.RejectedAt.UTC()
+	return r, err
+}
@@ -0,0 +1,235 @@
+// Package rateguard checks rate service quotes before they price a
+// conversion. A quote is rejected when it is not a positive number, is older
+// than Limits.MaxAge, or is too far from the pair's last accepted rate or
+// from the inverse of the reverse pair's rate. A rejection is recorded with
+// the offending quote and opens the pair's breaker: until an operator resets
+// it (cmd/admin), the pair is not quoted at all, so nothing executes on it
+// and its jobs stay queued.
+package rateguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/rates"
+)
+
+// Rejection reasons.
+const (
+	ReasonInvalid          = "invalid"           // not a positive, finite number
+	ReasonStale            = "stale"             // older than MaxAge, or undated
+	ReasonDeviation        = "deviation"         // too far from the last accepted rate
+	ReasonInverseDeviation = "inverse_deviation" // too far from 1 / the reverse pair's rate
+)
+
+// Breaker statuses.
+const (
+	BreakerClosed = "closed"
+	BreakerOpen   = "open"
+)
+
+// ErrBreakerOpen is returned for a pair whose breaker is open, including by
+// the lookup whose quote opened it (a *RejectedError).
+var ErrBreakerOpen = errors.New("rate breaker open")
+
+// Limits bound the quotes a Guard accepts. A zero limit is not checked.
+type Limits struct {
+	MaxAge time.Duration
+	// MaxDeviation is the largest relative change from the pair's last
+	// accepted rate, e.g. 0.1 for 10%.
+	MaxDeviation float64
+	// MaxInverseDeviation is the largest relative difference between the
+	// rate and 1 / the reverse pair's rate. Checking it quotes the reverse
+	// pair too, so it costs a second lookup.
+	MaxInverseDeviation float64
+}
+
+// DefaultLimits allow a 10% move from the last accepted rate. The inverse
+// check is off: the rate service quotes both directions of a pair from one
+// book, so their inverses agree by construction. Turn it on for a provider
+// that quotes each direction on its own.
+var DefaultLimits = Limits{MaxAge: 30 * time.Second, MaxDeviation: 0.1}
+
+// LimitsFromEnv overrides DefaultLimits with RATE_MAX_AGE (a duration),
+// RATE_MAX_DEVIATION and RATE_MAX_INVERSE_DEVIATION (fractions); 0 disables
+// a check.
+func LimitsFromEnv() (Limits, error) {
+	l := DefaultLimits
+	if v := os.Getenv("RATE_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return l, fmt.Errorf("RATE_MAX_AGE: %q is not a duration", v)
+		}
+		l.MaxAge = d
+	}
+	for name, f := range map[string]*float64{"RATE_MAX_DEVIATION": &l.MaxDeviation, "RATE_MAX_INVERSE_DEVIATION": &l.MaxInverseDeviation} {
+		if v := os.Getenv(name); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x < 0 || math.IsInf(x, 0) {
+				return l, fmt.Errorf("%s: %q is not a fraction", name, v)
+			}
+			*f = x
+		}
+	}
+	return l, nil
+}
+
+// Breaker is a pair's circuit breaker. Rejection is the rejection that last
+// opened it; the last accepted rate is the reference deviations are
+// measured from.
+type Breaker struct {
+	Source        string     `json:"source"`
+	Target        string     `json:"target"`
+	Status        string     `json:"status"`
+	Rejection     *Rejection `json:"rejection,omitempty"`
+	ReferenceRate *float64   `json:"reference_rate,omitempty"`
+	ReferenceAt   *time.Time `json:"reference_at,omitempty"`
+	ResetBy       string     `json:"reset_by,omitempty"`
+	ResetAt       *time.Time `json:"reset_at,omitempty"`
+}
+
+// Rejection is a refused quote. ReferenceRate and Deviation are set for the
+// deviation reasons; QuotedAt is nil for an undated quote.
+type Rejection struct {
+	ID            int64      `json:"rejection_id"`
+	Source        string     `json:"source"`
+	Target        string     `json:"target"`
+	Rate          float64    `json:"rate"`
+	Provider      string     `json:"provider"`
+	QuotedAt      *time.Time `json:"quoted_at,omitempty"`
+	Reason        string     `json:"reason"`
+	ReferenceRate *float64   `json:"reference_rate,omitempty"`
+	Deviation     *float64   `json:"deviation,omitempty"`
+	RejectedAt    time.Time  `json:"rejected_at"`
+}
+
+// RejectedError is the lookup whose quote was rejected; it opened the
+// breaker, so it matches ErrBreakerOpen.
+type RejectedError struct{ Rejection Rejection }
+
+func (e *RejectedError) Error() string {
+	r := e.Rejection
+	msg := fmt.Sprintf("rate %s:%s %v from %s rejected: %s", r.Source, r.Target, r.Rate, r.Provider, r.Reason)
+	if r.ReferenceRate != nil && r.Deviation != nil {
+		msg += fmt.Sprintf(" (%.2f%% from %v)", *r.Deviation*100, *r.ReferenceRate)
+	}
+	return msg
+}
+
+func (e *RejectedError) Unwrap() error { return ErrBreakerOpen }
+
+// Store keeps the breakers and rejections.
+type Store interface {
+	// Breaker returns the pair's breaker, closed without a reference if the
+	// pair has none yet.
+	Breaker(ctx context.Context, source, target string) (Breaker, error)
+	// Accept makes rate, quoted at, the pair's reference.
+	Accept(ctx context.Context, source, target string, rate float64, at time.Time) error
+	// Reject records r and opens the pair's breaker, unless it is already
+	// open. It returns r with its ID and RejectedAt.
+	Reject(ctx context.Context, r Rejection) (Rejection, error)
+}
+
+// Client fetches the rate of a pair; rates.Lambda is one, and so is Guard.
+type Client interface {
+	Rate(ctx context.Context, source, target string) (rates.Response, error)
+}
+
+// Guard is a Client that only returns quotes within Limits, from pairs whose
+// breaker is closed. Lookup failures are returned as they are: they do not
+// quote a bad rate, so they do not open the breaker.
+type Guard struct {
+	Rates  Client
+	Store  Store
+	Limits Limits
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func (g Guard) Rate(ctx context.Context, source, target string) (rates.Response, error) {
+	b, err := g.Store.Breaker(ctx, source, target)
+	if err != nil {
+		return rates.Response{}, fmt.Errorf("rate breaker %s:%s: %w", source, target, err)
+	}
+	if b.Status == BreakerOpen {
+		metrics.Count(metrics.RateBreakerHalts, 1, metrics.Pair(source, target))
+		return rates.Response{}, fmt.Errorf("%s:%s: %w", source, target, ErrBreakerOpen)
+	}
+	q, err := g.Rates.Rate(ctx, source, target)
+	if err != nil {
+		return q, err
+	}
+	if r, ok := g.check(ctx, source, target, q, b.ReferenceRate); !ok {
+		r, err := g.Store.Reject(ctx, r)
+		if err != nil {
+			return rates.Response{}, fmt.Errorf("record rate rejection: %w", err)
+		}
+		err = &RejectedError{r}
+		metrics.Count(metrics.RateRejections, 1, metrics.Pair(source, target), metrics.D("reason", r.Reason))
+		slog.ErrorContext(ctx, "rate rejected, breaker open", "pair", source+":"+target, "rejection_id", r.ID, "error", err)
+		return rates.Response{}, err
+	}
+	// The quote is good whether or not it becomes the reference
+	at := q.AsOf
+	if at.IsZero() {
+		at = g.now()
+	}
+	if err := g.Store.Accept(ctx, source, target, q.Rate, at); err != nil {
+		slog.WarnContext(ctx, "rate reference not updated", "pair", source+":"+target, "error", err)
+	}
+	return q, nil
+}
+
+// check returns the rejection of q, if any. The reverse pair is only quoted
+// once q passes the other checks, and a failure to quote it skips the check.
+func (g Guard) check(ctx context.Context, source, target string, q rates.Response, reference *float64) (Rejection, bool) {
+	r := Rejection{Source: source, Target: target, Rate: q.Rate, Provider: q.Provider}
+	if !q.AsOf.IsZero() {
+		r.QuotedAt = &q.AsOf
+	}
+	switch {
+	case !(q.Rate > 0) || math.IsInf(q.Rate, 0):
+		r.Reason = ReasonInvalid
+		return r, false
+	case g.Limits.MaxAge > 0 && (q.AsOf.IsZero() || g.now().Sub(q.AsOf) > g.Limits.MaxAge):
+		r.Reason = ReasonStale
+		return r, false
+	case g.Limits.MaxDeviation > 0 && reference != nil:
+		if dev := deviation(q.Rate, *reference); dev > g.Limits.MaxDeviation {
+			r.Reason, r.ReferenceRate, r.Deviation = ReasonDeviation, reference, &dev
+			return r, false
+		}
+	}
+	if g.Limits.MaxInverseDeviation > 0 {
+		inv, err := g.Rates.Rate(ctx, target, source)
+		if err != nil || !(inv.Rate > 0) {
+			slog.DebugContext(ctx, "inverse rate check skipped", "pair", target+":"+source, "rate", inv.Rate, "error", err)
+			return r, true
+		}
+		implied := 1 / inv.Rate
+		if dev := deviation(q.Rate, implied); dev > g.Limits.MaxInverseDeviation {
+			r.Reason, r.ReferenceRate, r.Deviation = ReasonInverseDeviation, &implied, &dev
+			return r, false
+		}
+	}
+	return r, true
+}
+
+func (g Guard) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
+
+// deviation is the relative difference of rate from reference.
+func deviation(rate, reference float64) float64 {
+	return math.Abs(rate/reference - 1)
+}
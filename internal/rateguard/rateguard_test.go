@@ -0,0 +1,171 @@
+package rateguard_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/rateguard"
+	"github.com/irajwani/microservice-go/internal/rates"
+)
+
+var now = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+// memStore keeps breakers by "SRC:TGT".
+type memStore struct {
+	breakers   map[string]rateguard.Breaker
+	rejections []rateguard.Rejection
+}
+
+func (m *memStore) Breaker(_ context.Context, source, target string) (rateguard.Breaker, error) {
+	if b, ok := m.breakers[source+":"+target]; ok {
+		return b, nil
+	}
+	return rateguard.Breaker{Source: source, Target: target, Status: rateguard.BreakerClosed}, nil
+}
+
+func (m *memStore) Accept(_ context.Context, source, target string, rate float64, at time.Time) error {
+	b, _ := m.Breaker(context.Background(), source, target)
+	b.ReferenceRate, b.ReferenceAt = &rate, &at
+	m.breakers[source+":"+target] = b
+	return nil
+}
+
+func (m *memStore) Reject(_ context.Context, r rateguard.Rejection) (rateguard.Rejection, error) {
+	r.ID, r.RejectedAt = int64(len(m.rejections)+1), now
+	m.rejections = append(m.rejections, r)
+	b, _ := m.Breaker(context.Background(), r.Source, r.Target)
+	if b.Status != rateguard.BreakerOpen {
+		b.Status, b.Rejection = rateguard.BreakerOpen, &r
+	}
+	m.breakers[r.Source+":"+r.Target] = b
+	return r, nil
+}
+
+// quotes serves a table of quotes and counts lookups.
+type quotes struct {
+	table   map[string]rates.Response
+	lookups int
+}
+
+func (q *quotes) Rate(_ context.Context, source, target string) (rates.Response, error) {
+	q.lookups++
+	if r, ok := q.table[source+":"+target]; ok {
+		return r, nil
+	}
+	return rates.Response{}, errors.New("rate lambda status 404")
+}
+
+func quote(source, target string, rate float64, age time.Duration) rates.Response {
+	return rates.Response{Source: source, Target: target, Rate: rate, Provider: "feed", AsOf: now.Add(-age)}
+}
+
+func newGuard(table ...rates.Response) (rateguard.Guard, *memStore, *quotes) {
+	store := &memStore{breakers: map[string]rateguard.Breaker{}}
+	q := &quotes{table: map[string]rates.Response{}}
+	for _, r := range table {
+		q.table[r.Source+":"+r.Target] = r
+	}
+	return rateguard.Guard{Rates: q, Store: store, Limits: rateguard.DefaultLimits, Now: func() time.Time { return now }}, store, q
+}
+
+func TestGuardAcceptsGoodQuotes(t *testing.T) {
+	g, store, _ := newGuard(quote("USD", "EUR", 0.90, time.Second), quote("EUR", "USD", 1.16, 0))
+	r, err := g.Rate(context.Background(), "USD", "EUR")
+	if err != nil || r.Rate != 0.90 {
+		t.Fatalf("rate = %+v, %v", r, err)
+	}
+	if b := store.breakers["USD:EUR"]; b.Status != rateguard.BreakerClosed || *b.ReferenceRate != 0.90 || !b.ReferenceAt.Equal(now.Add(-time.Second)) {
+		t.Errorf("breaker = %+v, want closed with the quote as reference", b)
+	}
+	if len(store.rejections) != 0 {
+		t.Errorf("rejections = %+v", store.rejections)
+	}
+}
+
+func TestGuardRejections(t *testing.T) {
+	reference := 0.90
+	for _, tc := range []struct {
+		name      string
+		quote     rates.Response
+		inverse   float64
+		reference *float64
+		reason    string
+	}{
+		{name: "zero", quote: quote("USD", "EUR", 0, 0), inverse: 1.16, reason: rateguard.ReasonInvalid},
+		{name: "stale", quote: quote("USD", "EUR", 0.90, time.Minute), inverse: 1.16, reason: rateguard.ReasonStale},
+		{name: "undated", quote: rates.Response{Source: "USD", Target: "EUR", Rate: 0.90, Provider: "feed"}, inverse: 1.16, reason: rateguard.ReasonStale},
+		{name: "off reference", quote: quote("USD", "EUR", 0.0009, 0), inverse: 1.16, reference: &reference, reason: rateguard.ReasonDeviation},
+		{name: "off inverse", quote: quote("USD", "EUR", 0.0009, 0), inverse: 1.16, reason: rateguard.ReasonInverseDeviation},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g, store, q := newGuard(tc.quote, quote("EUR", "USD", tc.inverse, 0))
+			g.Limits.MaxInverseDeviation = 0.1
+			if tc.reference != nil {
+				store.breakers["USD:EUR"] = rateguard.Breaker{Source: "USD", Target: "EUR", Status: rateguard.BreakerClosed, ReferenceRate: tc.reference}
+			}
+			_, err := g.Rate(context.Background(), "USD", "EUR")
+			var rejected *rateguard.RejectedError
+			if !errors.As(err, &rejected) || !errors.Is(err, rateguard.ErrBreakerOpen) || rejected.Rejection.Reason != tc.reason {
+				t.Fatalf("err = %v, want a %s rejection", err, tc.reason)
+			}
+			if len(store.rejections) != 1 || store.rejections[0].Rate != tc.quote.Rate || store.rejections[0].Provider != "feed" {
+				t.Errorf("rejections = %+v, want the offending quote", store.rejections)
+			}
+			if b := store.breakers["USD:EUR"]; b.Status != rateguard.BreakerOpen || b.Rejection.ID != 1 {
+				t.Errorf("breaker = %+v, want open by the rejection", b)
+			}
+
+			// An open breaker halts the pair without quoting it
+			lookups := q.lookups
+			if _, err := g.Rate(context.Background(), "USD", "EUR"); !errors.Is(err, rateguard.ErrBreakerOpen) || q.lookups != lookups {
+				t.Errorf("open breaker: err %v after %d lookups", err, q.lookups-lookups)
+			}
+			if len(store.rejections) != 1 {
+				t.Errorf("halted lookups were recorded as rejections: %+v", store.rejections)
+			}
+		})
+	}
+}
+
+func TestGuardChecksOff(t *testing.T) {
+	// Lookup failures are not rejections
+	g, store, _ := newGuard()
+	if _, err := g.Rate(context.Background(), "USD", "EUR"); err == nil || errors.Is(err, rateguard.ErrBreakerOpen) {
+		t.Errorf("lookup failure: err %v", err)
+	}
+	// Without the reverse pair the inverse check is skipped
+	g, _, _ = newGuard(quote("USD", "JPY", 150, 0))
+	g.Limits.MaxInverseDeviation = 0.1
+	if r, err := g.Rate(context.Background(), "USD", "JPY"); err != nil || r.Rate != 150 {
+		t.Errorf("no inverse: %+v, %v", r, err)
+	}
+	// By default the reverse pair is not quoted at all
+	g, _, q := newGuard(quote("USD", "EUR", 0.90, 0), quote("EUR", "USD", 5, 0))
+	if _, err := g.Rate(context.Background(), "USD", "EUR"); err != nil || q.lookups != 1 {
+		t.Errorf("default limits: err %v after %d lookups, want one", err, q.lookups)
+	}
+	// Zero limits are not checked
+	g, _, _ = newGuard(quote("USD", "EUR", 0.90, time.Hour), quote("EUR", "USD", 5, 0))
+	g.Limits = rateguard.Limits{}
+	if _, err := g.Rate(context.Background(), "USD", "EUR"); err != nil {
+		t.Errorf("no limits: %v", err)
+	}
+	if len(store.rejections) != 0 {
+		t.Errorf("rejections = %+v", store.rejections)
+	}
+}
+
+func TestLimitsFromEnv(t *testing.T) {
+	t.Setenv("RATE_MAX_AGE", "2m")
+	t.Setenv("RATE_MAX_INVERSE_DEVIATION", "0")
+	l, err := rateguard.LimitsFromEnv()
+	if err != nil || l.MaxAge != 2*time.Minute || l.MaxDeviation != rateguard.DefaultLimits.MaxDeviation || l.MaxInverseDeviation != 0 {
+		t.Errorf("limits = %+v, %v", l, err)
+	}
+	t.Setenv("RATE_MAX_DEVIATION", "-1")
+	if _, err := rateguard.LimitsFromEnv(); err == nil {
+		t.Error("negative deviation accepted")
+	}
+}
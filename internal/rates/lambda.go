@@ -0,0 +1,99 @@
+// Package rates is the client of the rate Lambda (cmd/rate).
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/spreads"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Response is the rate Lambda's answer for one pair. Rate is the mid; Bid
+// and Ask are SpreadBps apart around it. AsOf is when the rate was quoted; it
+// is zero from a rate service that does not say.
+type Response struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	Rate      float64   `json:"rate"`
+	Bid       float64   `json:"bid"`
+	Ask       float64   `json:"ask"`
+	SpreadBps int       `json:"spread_bps"`
+	Provider  string    `json:"provider"`
+	AsOf      time.Time `json:"as_of"`
+}
+
+// Book is the pair's book, to price a conversion from.
+func (r Response) Book() spreads.Book {
+	return spreads.Book{Mid: r.Rate, SpreadBps: r.SpreadBps}
+}
+
+// Lambda fetches rates by invoking the rate Lambda named Function.
+type Lambda struct {
+	Client   *awslambda.Client
+	Function string
+}
+
+// Rate invokes the rate lambda with an API Gateway proxy style request because
+// it expects events.APIGatewayProxyRequest; the trace context travels in its headers.
+func (l Lambda) Rate(ctx context.Context, source, target string) (Response, error) {
+	ctx, span := tracing.Start(ctx, "rate.invoke", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("faas.invoked_name", l.Function)))
+	rateResp, err := l.invoke(ctx, source, target)
+	tracing.End(span, err)
+	return rateResp, err
+}
+
+func (l Lambda) invoke(ctx context.Context, source, target string) (Response, error) {
+	var rateResp Response
+	req := events.APIGatewayProxyRequest{
+		Resource:              "/rate",
+		Path:                  "/rate",
+		HTTPMethod:            "GET",
+		QueryStringParameters: map[string]string{"source": source, "target": target},
+		Headers:               map[string]string{logging.Header: logging.CorrelationID(ctx)},
+	}
+	tracing.InjectHeaders(ctx, req.Headers)
+	payloadReq, err := json.Marshal(req)
+	if err != nil {
+		return rateResp, err
+	}
+	invOut, err := l.Client.Invoke(ctx, &awslambda.InvokeInput{FunctionName: aws.String(l.Function), Payload: payloadReq})
+	if err != nil {
+		return rateResp, fmt.Errorf("invoke rate: %w", err)
+	}
+	if invOut.FunctionError != nil {
+		return rateResp, fmt.Errorf("rate lambda error: %s", *invOut.FunctionError)
+	}
+
+	// Try API Gateway proxy response envelope first
+	type apigwResp struct {
+		StatusCode      int               `json:"statusCode"`
+		Body            string            `json:"body"`
+		IsBase64Encoded bool              `json:"isBase64Encoded"`
+		Headers         map[string]string `json:"headers"`
+	}
+	var gw apigwResp
+	if err := json.Unmarshal(invOut.Payload, &gw); err == nil && gw.Body != "" { // looks like proxy envelope
+		if gw.StatusCode != 200 {
+			return rateResp, fmt.Errorf("rate lambda status %d", gw.StatusCode)
+		}
+		if err := json.Unmarshal([]byte(gw.Body), &rateResp); err != nil {
+			return rateResp, fmt.Errorf("decode rate body: %w", err)
+		}
+	} else {
+		// Fall back: attempt direct decode into Response
+		if err2 := json.Unmarshal(invOut.Payload, &rateResp); err2 != nil {
+			return rateResp, fmt.Errorf("decode rate: %v (envelope err: %v)", err2, err)
+		}
+	}
+	return rateResp, nil
+}
@@ -0,0 +1,38 @@
+package rates
+
+import (
+	"context"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+// Client fetches the rate of a pair; Lambda is one.
+type Client interface {
+	Rate(ctx context.Context, source, target string) (Response, error)
+}
+
+// Lookup fetches the pair's rate from c and records the lookup's latency and
+// errors.
+func Lookup(ctx context.Context, c Client, source, target string) (Response, error) {
+	start := time.Now()
+	resp, err := c.Rate(ctx, source, target)
+	metrics.Since(metrics.RateLookupLatency, start, metrics.Pair(source, target))
+	if err != nil {
+		metrics.Count(metrics.RateLookupErrors, 1, metrics.Pair(source, target))
+	}
+	return resp, err
+}
+
+// Books prices from c: it looks the pair up and returns its book, for a
+// settlement.RateFunc.
+func Books(c Client) func(ctx context.Context, source, target string) (spreads.Book, error) {
+	return func(ctx context.Context, source, target string) (spreads.Book, error) {
+		resp, err := Lookup(ctx, c, source, target)
+		if err != nil {
+			return spreads.Book{}, err
+		}
+		return resp.Book(), nil
+	}
+}
@@ -0,0 +1,12 @@
+package rates
+
+import "github.com/irajwani/microservice-go/internal/spreads"
+
+// Mock are the books of the mock provider, quoted by the rate service and
+// priced from directly by POST /exchange. Each pair has one book; the reverse
+// direction is its inverse.
+var Mock = spreads.Books{
+	"USD:EUR": {Mid: 0.90, SpreadBps: 10},
+	"GBP:USD": {Mid: 0.79, SpreadBps: 10},
+	"GBP:EUR": {Mid: 0.90, SpreadBps: 15},
+}
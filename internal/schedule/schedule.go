@@ -0,0 +1,252 @@
+// Package schedule parses the recurrence of scheduled conversions and
+// computes their occurrences. A recurrence is either a 5-field cron
+// expression ("0 9 * * MON", "@daily") or an iCalendar RRULE subset
+// ("FREQ=WEEKLY;BYDAY=MO;BYHOUR=9"), evaluated in an IANA time zone.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is a parsed recurrence. Each field is a bitset of the values that
+// match, as in cron.
+type Spec struct {
+	minute, hour, dom, month, dow uint64
+	// Cron matches a day when either day-of-month or day-of-week matches,
+	// unless one of them is unrestricted.
+	domAny, dowAny bool
+	loc            *time.Location
+}
+
+// horizon bounds the search for the next occurrence.
+const horizon = 5 * 366 * 24 * time.Hour
+
+// ErrNever is returned for recurrences with no occurrence (e.g. "0 0 30 2 *").
+var ErrNever = errors.New("recurrence never fires")
+
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var (
+	monthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+	dayNames   = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+	rruleDays  = map[string]int{"SU": 0, "MO": 1, "TU": 2, "WE": 3, "TH": 4, "FR": 5, "SA": 6}
+)
+
+// Parse parses a cron expression or RRULE evaluated in time zone tz (UTC
+// when empty).
+func Parse(expr, tz string) (*Spec, error) {
+	loc := time.UTC
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", tz)
+		}
+	}
+	expr = strings.TrimSpace(expr)
+	var s *Spec
+	var err error
+	if upper := strings.ToUpper(expr); strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=") {
+		s, err = parseRRule(strings.TrimPrefix(upper, "RRULE:"))
+	} else {
+		s, err = parseCron(expr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.loc = loc
+	if s.Next(time.Now()).IsZero() {
+		return nil, ErrNever
+	}
+	return s, nil
+}
+
+// Next returns the first occurrence strictly after t, at minute precision,
+// or the zero time if there is none within five years.
+func (s *Spec) Next(after time.Time) time.Time {
+	t := after.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(horizon)
+	for t.Before(limit) {
+		y, mo, d := t.Date()
+		switch {
+		case !has(s.month, int(mo)):
+			t = time.Date(y, mo+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(y, mo, d+1, 0, 0, 0, 0, s.loc)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(y, mo, d, t.Hour()+1, 0, 0, 0, s.loc)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Spec) dayMatches(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(set uint64, v int) bool { return set&(1<<uint(v)) != 0 }
+
+func span(lo, hi int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v++ {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+func parseCron(expr string) (*Spec, error) {
+	if m, ok := macros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	f := strings.Fields(expr)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	s := &Spec{domAny: strings.HasPrefix(f[2], "*"), dowAny: strings.HasPrefix(f[4], "*")}
+	var err error
+	if s.minute, err = field(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = field(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = field(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = field(f[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = field(f[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if has(s.dow, 7) { // 7 is Sunday too
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+// field parses one cron field: "*", "5", "1-5", "*/15", "10-50/20", "MON,FRI".
+func field(expr string, lo, hi int, names map[string]int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		rng, stepStr, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = value(a, lo, hi, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(b, lo, hi, names); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				to = hi
+			}
+			if to < from {
+				return 0, fmt.Errorf("bad range %q", rng)
+			}
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func value(s string, lo, hi int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("value %q out of range %d-%d", s, lo, hi)
+	}
+	return v, nil
+}
+
+// parseRRule supports FREQ=HOURLY|DAILY|WEEKLY|MONTHLY with BYMONTH,
+// BYMONTHDAY, BYDAY (plain weekdays), BYHOUR and BYMINUTE. There is no
+// DTSTART, so unset BYHOUR and BYMINUTE default to 0 (midnight; on the hour
+// for HOURLY). INTERVAL other than 1, COUNT and UNTIL are not supported.
+func parseRRule(rule string) (*Spec, error) {
+	parts := map[string]string{}
+	for _, p := range strings.Split(strings.TrimSuffix(rule, ";"), ";") {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("bad RRULE part %q", p)
+		}
+		parts[k] = v
+	}
+	s := &Spec{minute: 1, hour: 1, dom: span(1, 31), month: span(1, 12), dow: span(0, 6), domAny: true, dowAny: true}
+	switch parts["FREQ"] {
+	case "HOURLY":
+		s.hour = span(0, 23)
+	case "DAILY":
+	case "WEEKLY":
+		if parts["BYDAY"] == "" {
+			return nil, errors.New("RRULE FREQ=WEEKLY needs BYDAY")
+		}
+	case "MONTHLY":
+		if parts["BYMONTHDAY"] == "" && parts["BYDAY"] == "" {
+			return nil, errors.New("RRULE FREQ=MONTHLY needs BYMONTHDAY or BYDAY")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported RRULE FREQ %q", parts["FREQ"])
+	}
+	var err error
+	for k, v := range parts {
+		switch k {
+		case "FREQ":
+		case "INTERVAL":
+			if v != "1" {
+				return nil, errors.New("RRULE INTERVAL other than 1 is not supported")
+			}
+		case "BYMINUTE":
+			s.minute, err = field(v, 0, 59, nil)
+		case "BYHOUR":
+			s.hour, err = field(v, 0, 23, nil)
+		case "BYMONTHDAY":
+			s.dom, err = field(v, 1, 31, nil)
+		case "BYMONTH":
+			s.month, err = field(v, 1, 12, nil)
+		case "BYDAY":
+			s.dow, err = field(v, 0, 6, rruleDays)
+		default:
+			return nil, fmt.Errorf("RRULE %s is not supported", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RRULE %s: %w", k, err)
+		}
+	}
+	return s, nil
+}
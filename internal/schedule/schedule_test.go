@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// Wednesday 2025-01-15 10:30 UTC
+	from := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		expr, tz string
+		want     time.Time
+	}{
+		{"0 9 * * MON", "", time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC)},
+		{"*/20 * * * *", "", time.Date(2025, 1, 15, 10, 40, 0, 0, time.UTC)},
+		{"30 10 * * *", "", time.Date(2025, 1, 16, 10, 30, 0, 0, time.UTC)}, // strictly after
+		{"@monthly", "", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", "", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)}, // day-of-month OR day-of-week
+		{"0 12 * * 7", "", time.Date(2025, 1, 19, 12, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", "America/New_York", time.Date(2025, 1, 15, 14, 0, 0, 0, time.UTC)}, // 05:30 local
+		{"FREQ=WEEKLY;BYDAY=MO,FR;BYHOUR=9", "", time.Date(2025, 1, 17, 9, 0, 0, 0, time.UTC)},
+		{"RRULE:FREQ=DAILY;BYHOUR=8;BYMINUTE=15", "Europe/Berlin", time.Date(2025, 1, 16, 7, 15, 0, 0, time.UTC)},
+		{"FREQ=HOURLY;BYMINUTE=5", "", time.Date(2025, 1, 15, 11, 5, 0, 0, time.UTC)},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", "", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := Parse(tc.expr, tc.tz)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tc.want) {
+			t.Errorf("%q in %q: Next = %v, want %v", tc.expr, tc.tz, got.UTC(), tc.want)
+		}
+	}
+}
+
+func TestNextAcrossDST(t *testing.T) {
+	// Europe/Berlin springs forward on 2025-03-30; 09:00 local moves from 08:00 to 07:00 UTC
+	s, err := Parse("0 9 * * *", "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := s.Next(time.Date(2025, 3, 29, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2025, 3, 30, 7, 0, 0, 0, time.UTC); !first.Equal(want) {
+		t.Errorf("Next = %v, want %v", first.UTC(), want)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, expr := range []string{
+		"", "* * * *", "60 * * * *", "0 24 * * *", "5-1 * * * *", "*/0 * * * *", "0 0 * FOO *",
+		"FREQ=YEARLY", "FREQ=WEEKLY", "FREQ=DAILY;INTERVAL=2", "FREQ=DAILY;COUNT=3", "FREQ=DAILY;BYHOUR",
+	} {
+		if _, err := Parse(expr, ""); err == nil {
+			t.Errorf("Parse(%q) succeeded", expr)
+		}
+	}
+	if _, err := Parse("0 0 30 2 *", ""); !errors.Is(err, ErrNever) {
+		t.Errorf("Feb 30: err = %v, want ErrNever", err)
+	}
+	if _, err := Parse("@daily", "Mars/Olympus"); err == nil {
+		t.Error("unknown zone accepted")
+	}
+}
@@ -0,0 +1,134 @@
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/problem"
+)
+
+// ReasonBatchLegFailed is recorded on the other legs of an all-or-nothing
+// batch when one leg cannot settle.
+const ReasonBatchLegFailed = problem.BatchLegFailed
+
+// batchFailed rolls back an all-or-nothing batch; it carries the leg that
+// could not settle and why.
+type batchFailed struct {
+	jobID, reason string
+}
+
+func (e *batchFailed) Error() string {
+	return fmt.Sprintf("batch leg %s failed: %s", e.jobID, e.reason)
+}
+
+// SettleBatch settles every queued leg of an all-or-nothing batch in one
+// transaction: the books of the legs' pairs are fetched and then all accounts
+// the batch touches are locked up front in account_id order, then the legs settle in batch order so later legs see the
+// balances earlier legs left. If any leg fails (funds, validation, amount too
+// small, a frozen or closed account) nothing is moved and every still-queued
+// leg is committed as failed, the culprit with its own reason and the rest
+// with BATCH_LEG_FAILED. Legs
+// already settled are skipped, so any leg's message may trigger the batch and
+// redeliveries are harmless. An error leaves every leg queued.
+func (s *Settler) SettleBatch(ctx context.Context, batchID string) (map[string]Result, error) {
+	ctx = logging.With(ctx, "batch_id", batchID)
+	var legs []Job
+	results := map[string]Result{}
+	err := s.Store.InTx(ctx, func(tx Tx) error {
+		var err error
+		if legs, err = batchLegs(ctx, tx, batchID); err != nil {
+			return err
+		}
+		// Fetch every pair's book before the accounts are locked; a failed
+		// fetch surfaces when its leg settles
+		bs := books{}
+		for _, job := range legs {
+			if Validate(job) == nil {
+				s.book(ctx, bs, job)
+			}
+		}
+		var accounts []string
+		for _, job := range legs {
+			for _, cur := range []string{job.SourceCurrency, job.TargetCurrency} {
+				id, err := tx.EnsureAccount(ctx, job.ClientID, cur)
+				if errors.Is(err, ErrUserClosed) {
+					return &batchFailed{jobID: job.ID, reason: ReasonUserClosed}
+				}
+				if err != nil {
+					return err
+				}
+				accounts = append(accounts, id)
+			}
+		}
+		if _, err := lockBalances(ctx, tx, accounts...); err != nil {
+			return err
+		}
+		clear(results)
+		for _, job := range legs {
+			res, err := s.settle(ctx, tx, job, bs)
+			if err != nil {
+				return err
+			}
+			if !res.Skipped && res.Status == StatusFailed {
+				return &batchFailed{jobID: job.ID, reason: res.Reason}
+			}
+			results[job.ID] = res
+		}
+		return nil
+	})
+	var failed *batchFailed
+	if errors.As(err, &failed) {
+		slog.WarnContext(ctx, "batch failed", "job_id", failed.jobID, "reason", failed.reason)
+		err = s.Store.InTx(ctx, func(tx Tx) error {
+			if legs, err = batchLegs(ctx, tx, batchID); err != nil {
+				return err
+			}
+			clear(results)
+			for _, job := range legs {
+				status, held, err := tx.LockJob(ctx, job.ID)
+				if err != nil {
+					return fmt.Errorf("load job: %w", err)
+				}
+				if status != StatusQueued {
+					results[job.ID] = Result{Status: status, Skipped: true}
+					continue
+				}
+				job.held = held
+				reason := ReasonBatchLegFailed
+				if job.ID == failed.jobID {
+					reason = failed.reason
+				}
+				if results[job.ID], err = fail(ctx, tx, job, reason, "batch_id", batchID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range legs {
+		record(logging.With(ctx, "job_id", job.ID), job, results[job.ID])
+	}
+	return results, nil
+}
+
+// batchLegs locks the batch's jobs and stamps them with the caller's
+// correlation id, which every leg of a batch shares.
+func batchLegs(ctx context.Context, tx Tx, batchID string) ([]Job, error) {
+	legs, err := tx.BatchJobs(ctx, batchID)
+	if err != nil {
+		return nil, fmt.Errorf("load batch: %w", err)
+	}
+	if len(legs) == 0 {
+		return nil, fmt.Errorf("batch %s has no jobs", batchID)
+	}
+	for i := range legs {
+		legs[i].CorrelationID = logging.CorrelationID(ctx)
+	}
+	return legs, nil
+}
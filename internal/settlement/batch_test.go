@@ -0,0 +1,90 @@
+package settlement_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+func leg(id string, amount float64) settlement.Job {
+	j := job("USD", "EUR", amount)
+	j.ID = id
+	return j
+}
+
+func TestSettleBatchSettlesEveryLeg(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 150})
+	store.AddBatch("b1", leg("j1", 100), leg("j2", 50))
+	res, err := s.SettleBatch(context.Background(), "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["j1"].Status != settlement.StatusCompleted || res["j2"].Status != settlement.StatusCompleted {
+		t.Fatalf("results = %+v, want both completed", res)
+	}
+	if store.Accounts["u1/USD"].Balance != 0 || len(store.Ledger) != 4 {
+		t.Errorf("USD %v, %d ledger entries; want 0 and 4", store.Accounts["u1/USD"].Balance, len(store.Ledger))
+	}
+	// accounts are locked once for the whole batch, in id order, before any leg
+	if len(store.Locked) < 2 || store.Locked[0] != "u1/EUR" || store.Locked[1] != "u1/USD" {
+		t.Errorf("lock order = %v, want u1/EUR then u1/USD first", store.Locked)
+	}
+
+	// a redelivered leg message finds nothing left to do
+	res, err = s.SettleBatch(context.Background(), "b1")
+	if err != nil || !res["j1"].Skipped || !res["j2"].Skipped || len(store.Ledger) != 4 {
+		t.Errorf("redelivery: %+v %v, %d ledger entries", res, err, len(store.Ledger))
+	}
+}
+
+func TestSettleBatchFailsEveryLegWhenOneCannotSettle(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 120})
+	store.AddBatch("b1", leg("j1", 100), leg("j2", 50), leg("j3", 10))
+	res, err := s.SettleBatch(context.Background(), "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"j1": settlement.ReasonBatchLegFailed, "j2": settlement.ReasonInsufficientFunds, "j3": settlement.ReasonBatchLegFailed}
+	for id, reason := range want {
+		if res[id].Status != settlement.StatusFailed || store.Reasons[id] != reason {
+			t.Errorf("%s: result %+v, reason %q; want failed (%s)", id, res[id], store.Reasons[id], reason)
+		}
+	}
+	if store.Accounts["u1/USD"].Balance != 120 || len(store.Ledger) != 0 {
+		t.Errorf("funds moved: USD %v, %d ledger entries", store.Accounts["u1/USD"].Balance, len(store.Ledger))
+	}
+	if len(store.Outbox) != 3 {
+		t.Errorf("outbox = %v, want one conversion.failed event per leg", store.Outbox)
+	}
+}
+
+func TestSettleBatchLeavesLegsQueuedOnError(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100, "GBP": 100})
+	gbp := job("GBP", "JPY", 10) // no rate
+	gbp.ID = "j2"
+	store.AddBatch("b1", leg("j1", 10), gbp)
+	if _, err := s.SettleBatch(context.Background(), "b1"); err == nil {
+		t.Fatal("want rate error")
+	}
+	if store.Jobs["j1"] != settlement.StatusQueued || store.Jobs["j2"] != settlement.StatusQueued || len(store.Ledger) != 0 {
+		t.Errorf("jobs %v, %d ledger entries; want both queued and nothing moved", store.Jobs, len(store.Ledger))
+	}
+}
+
+func TestSettleBatchFetchesEachPairOnceBeforeLocking(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 150})
+	store.AddBatch("b1", leg("j1", 100), leg("j2", 50))
+	var lockedAtFetch []int
+	s.Rates = settlement.RateFunc(func(ctx context.Context, source, target string) (spreads.Book, error) {
+		lockedAtFetch = append(lockedAtFetch, len(store.Locked))
+		return usdEUR(ctx, source, target)
+	})
+	if _, err := s.SettleBatch(context.Background(), "b1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(lockedAtFetch) != 1 || lockedAtFetch[0] != 0 {
+		t.Errorf("accounts locked at each rate fetch = %v, want [0]", lockedAtFetch)
+	}
+}
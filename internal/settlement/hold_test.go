@@ -0,0 +1,89 @@
+package settlement_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+func TestSettleCapturesQueuedJobHold(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddHeldJob(job("USD", "EUR", 100))
+
+	// The whole balance is held, but by this job
+	res, err := s.Settle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusCompleted {
+		t.Fatalf("result = %+v, want completed", res)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 0 || usd.Held != 0 {
+		t.Errorf("USD = %+v, want the held 100 debited", usd)
+	}
+	if _, held := store.Holds["j1"]; held {
+		t.Errorf("completed job still holds %v", store.Holds["j1"])
+	}
+}
+
+func TestSettleIgnoresOtherJobsHolds(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddHeldJob(job("USD", "EUR", 100))
+	legacy := job("USD", "EUR", 50)
+	legacy.ID = "j2"
+	store.Jobs["j2"] = settlement.StatusQueued // no hold of its own
+
+	res, err := s.Settle(context.Background(), legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || res.Reason != settlement.ReasonInsufficientFunds {
+		t.Errorf("result = %+v, want INSUFFICIENT_FUNDS with nothing available", res)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 100 || usd.Held != 100 {
+		t.Errorf("USD = %+v, want j1's hold untouched", usd)
+	}
+}
+
+func TestFailureReleasesHold(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	bad := job("USD", "USD", 100)
+	store.AddHeldJob(bad)
+
+	res, err := s.Settle(context.Background(), bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || res.Reason != settlement.ReasonInvalidRequest {
+		t.Fatalf("result = %+v, want INVALID_REQUEST", res)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 100 || usd.Held != 0 {
+		t.Errorf("USD = %+v, want the hold released", usd)
+	}
+	if _, held := store.Holds["j1"]; held {
+		t.Errorf("job still holds %v", store.Holds["j1"])
+	}
+}
+
+func TestCancelQueuedJobReleasesHold(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddHeldJob(job("USD", "EUR", 40))
+
+	res, err := s.Cancel(context.Background(), job("USD", "EUR", 40), "user_cancelled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusCancelled {
+		t.Fatalf("result = %+v, want cancelled", res)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 100 || usd.Held != 0 {
+		t.Errorf("USD = %+v, want the hold released", usd)
+	}
+	if _, held := store.Holds["j1"]; held {
+		t.Errorf("job still holds %v", store.Holds["j1"])
+	}
+	if res, _ := s.Settle(context.Background(), job("USD", "EUR", 40)); !res.Skipped {
+		t.Errorf("settle after cancel = %+v, want skipped", res)
+	}
+}
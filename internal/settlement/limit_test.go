@@ -0,0 +1,85 @@
+package settlement_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+func limitOrder(limit float64) settlement.Job {
+	j := job("USD", "EUR", 100)
+	j.LimitRate = limit
+	return j
+}
+
+func TestSettleLimitOrderWaitsForRate(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddLimitOrder(limitOrder(0.95))
+
+	res, err := s.Settle(context.Background(), limitOrder(0.95))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Skipped || res.Status != settlement.StatusPending || res.Rate != 0.9 {
+		t.Fatalf("result = %+v, want pending at 0.9", res)
+	}
+	if store.Jobs["j1"] != settlement.StatusPending || store.Accounts["u1/USD"].Held != 100 || len(store.Outbox) != 0 {
+		t.Errorf("job %s, held %v, outbox %v; want it untouched", store.Jobs["j1"], store.Accounts["u1/USD"].Held, store.Outbox)
+	}
+}
+
+func TestSettleLimitOrderCapturesHold(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddLimitOrder(limitOrder(0.9))
+
+	res, err := s.Settle(context.Background(), limitOrder(0.9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusCompleted {
+		t.Fatalf("result = %+v, want completed", res)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 0 || usd.Held != 0 {
+		t.Errorf("USD = %+v, want the held 100 debited", usd)
+	}
+}
+
+func TestHoldsReduceAvailableBalance(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 150})
+	store.AddLimitOrder(limitOrder(2))
+
+	other := job("USD", "EUR", 100)
+	other.ID = "j2"
+	res, err := s.CreateAndSettle(context.Background(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || res.Reason != settlement.ReasonInsufficientFunds {
+		t.Errorf("result = %+v, want INSUFFICIENT_FUNDS with 50 available", res)
+	}
+}
+
+func TestCancelReleasesHold(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddLimitOrder(limitOrder(2))
+
+	res, err := s.Cancel(context.Background(), limitOrder(2), settlement.ReasonLimitExpired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusCancelled || store.Reasons["j1"] != settlement.ReasonLimitExpired {
+		t.Fatalf("result = %+v, reason %q; want cancelled (LIMIT_EXPIRED)", res, store.Reasons["j1"])
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 100 || usd.Held != 0 || len(store.Outbox) != 1 {
+		t.Errorf("USD = %+v, outbox %v; want the hold released and one event", usd, store.Outbox)
+	}
+
+	// Cancelling again, or settling, finds the job no longer pending
+	if res, _ := s.Cancel(context.Background(), limitOrder(2), settlement.ReasonLimitExpired); !res.Skipped {
+		t.Errorf("second cancel = %+v, want skipped", res)
+	}
+	if res, _ := s.Settle(context.Background(), limitOrder(0.5)); !res.Skipped || res.Status != settlement.StatusCancelled {
+		t.Errorf("settle after cancel = %+v, want skipped", res)
+	}
+}
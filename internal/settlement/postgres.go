@@ -0,0 +1,201 @@
+package settlement
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/admin"
+	"github.com/irajwani/microservice-go/internal/fees"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/pgtx"
+	"github.com/irajwani/microservice-go/internal/spreads"
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+// PGStore is the Postgres Store.
+type PGStore struct{ DB *sql.DB }
+
+// InTx retries fn on deadlocks and serialization failures (see pgtx.Run).
+func (s PGStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	return pgtx.Run(ctx, s.DB, func(tx *sql.Tx) error { return fn(pgTx{tx}) })
+}
+
+type pgTx struct{ tx *sql.Tx }
+
+func (t pgTx) InsertJob(ctx context.Context, job Job) error {
+	createdAt := job.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	_, err := t.tx.ExecContext(ctx, `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, status, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,'queued',$6,$6)`, job.ID, job.ClientID, job.SourceCurrency, job.TargetCurrency, job.SourceAmount, createdAt)
+	return err
+}
+
+// LockJob scopes the rest of the transaction to the job's tenant, so a worker
+// reading every tenant writes the job's accounts, ledger and events in its own.
+func (t pgTx) LockJob(ctx context.Context, jobID string) (string, float64, error) {
+	var status, tenantID string
+	var held float64
+	err := t.tx.QueryRowContext(ctx, `SELECT status, held_amount, tenant_id FROM conversion_jobs WHERE job_id=$1 FOR UPDATE`, jobID).Scan(&status, &held, &tenantID)
+	if err != nil {
+		return "", 0, err
+	}
+	return status, held, tenant.Scope(ctx, t.tx, tenantID)
+}
+
+func (t pgTx) EnsureAccount(ctx context.Context, user, currency string) (string, error) {
+	var id string
+	err := t.tx.QueryRowContext(ctx, `SELECT account_id FROM accounts WHERE user_id=$1 AND currency=$2`, user, currency).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	// Closed users get no new accounts. A concurrent first conversion into the
+	// same currency may win the insert; then read its row once it has committed.
+	err = t.tx.QueryRowContext(ctx, `INSERT INTO accounts (user_id, currency, balance)
+		SELECT $1, $2, 0 WHERE NOT EXISTS (SELECT 1 FROM users WHERE user_id=$1 AND status='closed')
+		ON CONFLICT (tenant_id, user_id, currency) DO NOTHING RETURNING account_id`, user, currency).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = t.tx.QueryRowContext(ctx, `SELECT account_id FROM accounts WHERE user_id=$1 AND currency=$2`, user, currency).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrUserClosed
+		}
+	}
+	return id, err
+}
+
+func (t pgTx) AccountStatus(ctx context.Context, accountID string) (user, account string, err error) {
+	err = t.tx.QueryRowContext(ctx, `SELECT COALESCE(u.status,'active'), a.status FROM accounts a LEFT JOIN users u ON u.tenant_id = a.tenant_id AND u.user_id = a.user_id WHERE a.account_id=$1`, accountID).Scan(&user, &account)
+	return user, account, err
+}
+
+func (t pgTx) Currencies(ctx context.Context) ([]string, error) {
+	var list sql.NullString
+	err := t.tx.QueryRowContext(ctx, `SELECT array_to_json(currencies) FROM tenants WHERE tenant_id = current_setting('app.tenant_id', true)`).Scan(&list)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !list.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var currencies []string
+	err = json.Unmarshal([]byte(list.String), &currencies)
+	return currencies, err
+}
+
+func (t pgTx) LockBalance(ctx context.Context, accountID string) (float64, error) {
+	var balance float64
+	err := t.tx.QueryRowContext(ctx, `SELECT balance - held FROM accounts WHERE account_id=$1 FOR UPDATE`, accountID).Scan(&balance)
+	return balance, err
+}
+
+func (t pgTx) FeeSchedule(ctx context.Context, clientID, source, target string, at time.Time) (fees.Schedule, error) {
+	return fees.Lookup(ctx, t.tx, clientID, source, target, at)
+}
+
+func (t pgTx) Spread(ctx context.Context, clientID, source, target string) (spreads.Schedule, error) {
+	return spreads.Lookup(ctx, t.tx, clientID, source, target)
+}
+
+func (t pgTx) AddBalance(ctx context.Context, accountID string, delta float64) error {
+	_, err := t.tx.ExecContext(ctx, `UPDATE accounts SET balance = balance + $1 WHERE account_id=$2`, delta, accountID)
+	return err
+}
+
+func (t pgTx) AddHold(ctx context.Context, accountID string, delta float64) error {
+	_, err := t.tx.ExecContext(ctx, `UPDATE accounts SET held = held + $1 WHERE account_id=$2`, delta, accountID)
+	return err
+}
+
+func (t pgTx) PostLedger(ctx context.Context, jobID, accountID, entryType string, amount float64, currency string) error {
+	_, err := t.tx.ExecContext(ctx, `INSERT INTO ledger_entries (job_id, account_id, entry_type, amount, currency) VALUES ($1,$2,$3,$4,$5)`, jobID, accountID, entryType, amount, currency)
+	return err
+}
+
+func (t pgTx) FailJob(ctx context.Context, jobID, reason string) error {
+	_, err := t.tx.ExecContext(ctx, `UPDATE conversion_jobs SET status='failed', held_amount=0, updated_at=now(), metadata = jsonb_set(metadata,'{"error"}', to_jsonb($2::text)) WHERE job_id=$1`, jobID, reason)
+	return err
+}
+
+func (t pgTx) CancelJob(ctx context.Context, jobID, reason string) error {
+	_, err := t.tx.ExecContext(ctx, `UPDATE conversion_jobs SET status='cancelled', held_amount=0, updated_at=now(), metadata = jsonb_set(metadata,'{"error"}', to_jsonb($2::text)) WHERE job_id=$1`, jobID, reason)
+	return err
+}
+
+func (t pgTx) CompleteJob(ctx context.Context, jobID string, r Result) error {
+	_, err := t.tx.ExecContext(ctx, `UPDATE conversion_jobs SET status='completed', held_amount=0, target_amount=$2, rate=$3, fee=$4, fee_bps=$5, fee_schedule_id=NULLIF($6,'')::uuid, fee_schedule_version=NULLIF($7,0),
+		mid_rate=$8, spread_bps=$9, spread=$10, spread_id=NULLIF($11,'')::uuid, client_tier=$12, completed_at=now(), updated_at=now() WHERE job_id=$1`,
+		jobID, r.TargetAmount, r.Rate, r.Quote.Fee, r.Quote.FeeBps, r.Quote.ScheduleID, r.Quote.ScheduleVersion,
+		r.Spread.MidRate, r.Spread.SpreadBps, r.Spread.Spread, r.Spread.SpreadID, r.Spread.Tier)
+	return err
+}
+
+func (t pgTx) AppendOutbox(ctx context.Context, aggregateID, topic string, payload []byte) error {
+	_, err := t.tx.ExecContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ('conversion_job',$1,$2,$3)`, aggregateID, topic, payload)
+	return err
+}
+
+func (t pgTx) BatchJobs(ctx context.Context, batchID string) ([]Job, error) {
+	rows, err := t.tx.QueryContext(ctx, `SELECT job_id, client_id, source_currency, target_currency, source_amount, created_at, tenant_id
+		FROM conversion_jobs WHERE batch_id=$1 ORDER BY batch_index FOR UPDATE`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []Job
+	var tenantID string
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.ClientID, &j.SourceCurrency, &j.TargetCurrency, &j.SourceAmount, &j.CreatedAt, &tenantID); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil || len(jobs) == 0 {
+		return jobs, err
+	}
+	// Every leg belongs to the batch's tenant
+	return jobs, tenant.Scope(ctx, t.tx, tenantID)
+}
+
+// LockConversion sums the reversals after the lock is granted, so a reversal
+// committed while it waited is counted. Like LockJob it scopes the
+// transaction to the job's tenant.
+func (t pgTx) LockConversion(ctx context.Context, jobID string) (Conversion, error) {
+	var c Conversion
+	var tenantID string
+	err := t.tx.QueryRowContext(ctx, `SELECT job_id, client_id, source_currency, target_currency, source_amount, status, COALESCE(target_amount, 0),
+			COALESCE(reversal_of::text, ''), created_at, tenant_id FROM conversion_jobs WHERE job_id=$1 FOR UPDATE`, jobID).
+		Scan(&c.ID, &c.ClientID, &c.SourceCurrency, &c.TargetCurrency, &c.SourceAmount, &c.Status, &c.TargetAmount, &c.ReversalOf, &c.CreatedAt, &tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Conversion{}, ErrJobNotFound
+	}
+	if err != nil {
+		return Conversion{}, err
+	}
+	if err := tenant.Scope(ctx, t.tx, tenantID); err != nil {
+		return Conversion{}, err
+	}
+	err = t.tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(reversed_amount), 0) FROM conversion_jobs WHERE reversal_of=$1`, jobID).Scan(&c.Reversed)
+	return c, err
+}
+
+func (t pgTx) InsertReversal(ctx context.Context, c Conversion, r Reversal, res ReversalResult) error {
+	_, err := t.tx.ExecContext(ctx, `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, status, target_amount, rate,
+			reversal_of, reversed_amount, metadata, completed_at)
+		VALUES ($1,$2,$3,$4,$5,'completed',$6,$7,$8,$9,jsonb_build_object('reason',$10::text,'rate_basis',$11::text,'operator',$12::text,'correlation_id',$13::text),now())`,
+		r.ID, c.ClientID, c.TargetCurrency, c.SourceCurrency, res.Debit, res.Credit, res.Rate, c.ID, res.Amount, r.Reason, r.Basis, r.Operator, logging.CorrelationID(ctx))
+	if err != nil {
+		return err
+	}
+	return admin.Record(ctx, t.tx, admin.Action{
+		Operator: r.Operator, Action: "job.reversed", TargetType: "job", TargetID: c.ID, Reason: r.Reason,
+		Details: map[string]any{"reversal_job_id": r.ID, "reversed_amount": res.Amount, "debit": res.Debit, "credit": res.Credit, "rate_basis": r.Basis},
+	})
+}
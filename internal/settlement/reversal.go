@@ -0,0 +1,183 @@
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+)
+
+// Rate bases of a reversal.
+const (
+	// BasisOriginal undoes the conversion at its own terms: the reversed
+	// share of the source amount is credited back in full, fee included.
+	BasisOriginal = "original"
+	// BasisCurrent converts the reversed target amount back at the current
+	// mid rate, free of fee and spread.
+	BasisCurrent = "current"
+)
+
+// Reversal errors; the reversal writes nothing.
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrNotReversible  = errors.New("only completed conversions can be reversed")
+	ErrFullyReversed  = errors.New("conversion is already fully reversed")
+	ErrReversalAmount = errors.New("amount must be > 0 and at most the unreversed source amount")
+	ErrReversalBasis  = errors.New("rate_basis must be original or current")
+	ErrReversalFunds  = errors.New("insufficient available funds to reverse")
+)
+
+// precision is the smallest amount NUMERIC(20,8) stores.
+const precision = 1e-8
+
+// Conversion is a job as a reversal sees it.
+type Conversion struct {
+	Job
+	Status       string
+	TargetAmount float64
+	// ReversalOf is set when the job is itself a reversal.
+	ReversalOf string
+	// Reversed is the part of SourceAmount earlier reversals cover.
+	Reversed float64
+}
+
+// Reversal asks for part or all of a completed conversion to be reversed.
+type Reversal struct {
+	ID    string // reversal job to create
+	JobID string // conversion to reverse
+	// Amount is the part of the conversion's source amount to reverse; zero
+	// reverses whatever is left.
+	Amount   float64
+	Basis    string
+	Reason   string
+	Operator string
+}
+
+// ReversalResult is a completed reversal: its job converted Debit of the
+// conversion's target currency back into Credit of its source currency.
+type ReversalResult struct {
+	Amount    float64
+	Debit     float64
+	Credit    float64
+	Rate      float64 // Credit per unit of Debit
+	Remaining float64 // source amount still reversible
+}
+
+// Reverse reverses r.Amount of a completed conversion in one transaction: it
+// inserts a completed reversal job linked to the conversion, moves both
+// balances back, posts compensating ledger entries under the reversal job and
+// emits conversion.reversed for the conversion. The conversion's own rows are
+// only locked, never updated; what remains reversible is derived from its
+// reversals. Business refusals are the Err* values above.
+func (s *Settler) Reverse(ctx context.Context, r Reversal) (ReversalResult, error) {
+	if r.Basis != BasisOriginal && r.Basis != BasisCurrent {
+		return ReversalResult{}, ErrReversalBasis
+	}
+	if r.Amount < 0 {
+		return ReversalResult{}, ErrReversalAmount
+	}
+	ctx = logging.With(ctx, "job_id", r.JobID, "reversal_job_id", r.ID)
+	var c Conversion
+	var res ReversalResult
+	err := s.Store.InTx(ctx, func(tx Tx) error {
+		var err error
+		if c, err = tx.LockConversion(ctx, r.JobID); err != nil {
+			return err
+		}
+		if res, err = s.reverse(ctx, tx, c, r); err != nil {
+			return err
+		}
+		c.CorrelationID = logging.CorrelationID(ctx)
+		return appendEvent(ctx, tx, c.Job, "conversion.reversed", map[string]any{
+			"reversal_job_id": r.ID, "reversed_amount": res.Amount, "debit": res.Debit, "credit": res.Credit,
+			"rate": res.Rate, "rate_basis": r.Basis, "remaining": res.Remaining, "reason": r.Reason,
+		})
+	})
+	if err != nil {
+		return ReversalResult{}, err
+	}
+	slog.InfoContext(ctx, "conversion reversed", "amount", res.Amount, "debit", res.Debit, "credit", res.Credit,
+		"rate_basis", r.Basis, "remaining", res.Remaining, "operator", r.Operator)
+	metrics.Count(metrics.JobsReversed, 1, metrics.Pair(c.SourceCurrency, c.TargetCurrency), metrics.D("rate_basis", r.Basis))
+	return res, nil
+}
+
+func (s *Settler) reverse(ctx context.Context, tx Tx, c Conversion, r Reversal) (ReversalResult, error) {
+	if c.Status != StatusCompleted || c.ReversalOf != "" || c.TargetAmount <= 0 {
+		return ReversalResult{}, ErrNotReversible
+	}
+	remaining := c.SourceAmount - c.Reversed
+	if remaining < precision {
+		return ReversalResult{}, ErrFullyReversed
+	}
+	res := ReversalResult{Amount: r.Amount}
+	if res.Amount == 0 || math.Abs(res.Amount-remaining) < precision {
+		res.Amount = remaining
+	}
+	if res.Amount > remaining {
+		return ReversalResult{}, ErrReversalAmount
+	}
+	res.Remaining = remaining - res.Amount
+
+	// The reversed share of what the conversion credited, converted back
+	res.Debit = round8(c.TargetAmount * res.Amount / c.SourceAmount)
+	switch r.Basis {
+	case BasisOriginal:
+		res.Credit = res.Amount
+	case BasisCurrent:
+		book, err := s.Rates.Rate(ctx, c.SourceCurrency, c.TargetCurrency)
+		if err == nil && book.Mid <= 0 {
+			err = fmt.Errorf("invalid rate %v for %s:%s", book.Mid, c.SourceCurrency, c.TargetCurrency)
+		}
+		if err != nil {
+			return ReversalResult{}, err
+		}
+		res.Credit = round8(res.Debit / book.Mid)
+	}
+	if res.Debit <= 0 || res.Credit <= 0 {
+		return ReversalResult{}, ErrReversalAmount
+	}
+	res.Rate = res.Credit / res.Debit
+
+	srcAcct, err := tx.EnsureAccount(ctx, c.ClientID, c.SourceCurrency)
+	if err != nil {
+		return ReversalResult{}, err
+	}
+	tgtAcct, err := tx.EnsureAccount(ctx, c.ClientID, c.TargetCurrency)
+	if err != nil {
+		return ReversalResult{}, err
+	}
+	balances, err := lockBalances(ctx, tx, srcAcct, tgtAcct)
+	if err != nil {
+		return ReversalResult{}, err
+	}
+	if balances[tgtAcct] < res.Debit {
+		return ReversalResult{}, ErrReversalFunds
+	}
+
+	if err := tx.InsertReversal(ctx, c, r, res); err != nil {
+		return ReversalResult{}, fmt.Errorf("insert reversal: %w", err)
+	}
+	if err := tx.AddBalance(ctx, tgtAcct, -res.Debit); err != nil {
+		return ReversalResult{}, err
+	}
+	if err := tx.AddBalance(ctx, srcAcct, res.Credit); err != nil {
+		return ReversalResult{}, err
+	}
+	if err := tx.PostLedger(ctx, r.ID, tgtAcct, "debit", res.Debit, c.TargetCurrency); err != nil {
+		return ReversalResult{}, err
+	}
+	if err := tx.PostLedger(ctx, r.ID, srcAcct, "credit", res.Credit, c.SourceCurrency); err != nil {
+		return ReversalResult{}, err
+	}
+	return res, nil
+}
+
+// round8 rounds to the 8 decimal places amounts are stored with.
+func round8(v float64) float64 {
+	return math.Round(v*1e8) / 1e8
+}
@@ -0,0 +1,129 @@
+package settlement_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/settlement/settlementtest"
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+// converted sets up j1 as 100 USD converted into 89.7 EUR (fee taken).
+func converted() (*settlement.Settler, *settlementtest.MemStore) {
+	s, store := newSettler(map[string]float64{"USD": 0, "EUR": 89.7})
+	store.AddCompleted(job("USD", "EUR", 100), 89.7)
+	return s, store
+}
+
+func reversal(id string, amount float64, basis string) settlement.Reversal {
+	return settlement.Reversal{ID: id, JobID: "j1", Amount: amount, Basis: basis, Reason: "customer refund", Operator: "ops"}
+}
+
+func TestReverseAtOriginalRateRefundsFee(t *testing.T) {
+	s, store := converted()
+
+	res, err := s.Reverse(context.Background(), reversal("r1", 0, settlement.BasisOriginal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Amount != 100 || res.Debit != 89.7 || res.Credit != 100 || res.Remaining != 0 {
+		t.Fatalf("result = %+v, want 89.7 EUR back into 100 USD", res)
+	}
+	if usd, eur := store.Accounts["u1/USD"], store.Accounts["u1/EUR"]; usd.Balance != 100 || eur.Balance != 0 {
+		t.Errorf("USD %v, EUR %v; want 100 and 0", usd.Balance, eur.Balance)
+	}
+	if len(store.Ledger) != 2 || store.Ledger[0].JobID != "r1" || store.Ledger[0].EntryType != "debit" || store.Ledger[1].EntryType != "credit" {
+		t.Errorf("ledger = %+v, want a debit and a credit under r1", store.Ledger)
+	}
+	if rev := store.Conversions["r1"]; rev.ReversalOf != "j1" || rev.SourceCurrency != "EUR" || store.Jobs["r1"] != settlement.StatusCompleted {
+		t.Errorf("reversal job = %+v (%s), want a completed EUR->USD job linked to j1", rev, store.Jobs["r1"])
+	}
+	if len(store.Outbox) != 1 {
+		t.Errorf("outbox = %v, want one conversion.reversed event", store.Outbox)
+	}
+
+	if _, err := s.Reverse(context.Background(), reversal("r2", 0, settlement.BasisOriginal)); !errors.Is(err, settlement.ErrFullyReversed) {
+		t.Errorf("second full reversal err = %v, want ErrFullyReversed", err)
+	}
+}
+
+func TestReversePartially(t *testing.T) {
+	s, store := converted()
+
+	if _, err := s.Reverse(context.Background(), reversal("r1", 40, settlement.BasisOriginal)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reverse(context.Background(), reversal("r2", 70, settlement.BasisOriginal)); !errors.Is(err, settlement.ErrReversalAmount) {
+		t.Errorf("over-reversal err = %v, want ErrReversalAmount", err)
+	}
+	res, err := s.Reverse(context.Background(), reversal("r3", 0, settlement.BasisOriginal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Amount != 60 || res.Remaining != 0 {
+		t.Errorf("rest = %+v, want the remaining 60", res)
+	}
+	if usd, eur := store.Accounts["u1/USD"], store.Accounts["u1/EUR"]; usd.Balance != 100 || eur.Balance > 1e-8 {
+		t.Errorf("USD %v, EUR %v; want 100 and 0", usd.Balance, eur.Balance)
+	}
+}
+
+func TestReverseAtCurrentRate(t *testing.T) {
+	s, store := converted()
+	s.Rates = settlement.RateFunc(func(context.Context, string, string) (spreads.Book, error) {
+		return spreads.Book{Mid: 0.78, SpreadBps: 20}, nil
+	})
+
+	res, err := s.Reverse(context.Background(), reversal("r1", 50, settlement.BasisCurrent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half of 89.7 EUR at the 0.78 EUR per USD mid; reversals pay no spread
+	if res.Debit != 44.85 || res.Credit != 57.5 {
+		t.Fatalf("result = %+v, want 44.85 EUR into 57.5 USD", res)
+	}
+	if usd := store.Accounts["u1/USD"]; usd.Balance != 57.5 {
+		t.Errorf("USD = %v, want 57.5", usd.Balance)
+	}
+}
+
+func TestReverseRefusals(t *testing.T) {
+	cases := map[string]struct {
+		setup func(*settlementtest.MemStore)
+		rev   settlement.Reversal
+		want  error
+	}{
+		"unknown job":  {nil, settlement.Reversal{ID: "r1", JobID: "nope", Basis: settlement.BasisOriginal}, settlement.ErrJobNotFound},
+		"bad basis":    {nil, reversal("r1", 0, "yesterday"), settlement.ErrReversalBasis},
+		"not complete": {func(m *settlementtest.MemStore) { m.Jobs["j1"] = settlement.StatusFailed }, reversal("r1", 0, settlement.BasisOriginal), settlement.ErrNotReversible},
+		"funds spent": {func(m *settlementtest.MemStore) { m.Fund("u1", map[string]float64{"EUR": 10}) },
+			reversal("r1", 0, settlement.BasisOriginal), settlement.ErrReversalFunds},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, store := converted()
+			if c.setup != nil {
+				c.setup(store)
+			}
+			if _, err := s.Reverse(context.Background(), c.rev); !errors.Is(err, c.want) {
+				t.Errorf("err = %v, want %v", err, c.want)
+			}
+			if len(store.Ledger) != 0 || len(store.Outbox) != 0 {
+				t.Errorf("ledger %v, outbox %v; want nothing written", store.Ledger, store.Outbox)
+			}
+		})
+	}
+}
+
+func TestReversalCannotBeReversed(t *testing.T) {
+	s, _ := converted()
+	if _, err := s.Reverse(context.Background(), reversal("r1", 10, settlement.BasisOriginal)); err != nil {
+		t.Fatal(err)
+	}
+	rev := settlement.Reversal{ID: "r2", JobID: "r1", Basis: settlement.BasisOriginal}
+	if _, err := s.Reverse(context.Background(), rev); !errors.Is(err, settlement.ErrNotReversible) {
+		t.Errorf("err = %v, want ErrNotReversible", err)
+	}
+}
@@ -0,0 +1,546 @@
+// Package settlement is the single settlement sequence behind both the
+// asynchronous job pipeline (cmd/consumer) and the synchronous POST /exchange:
+// validate, fetch the rate, lock accounts, check funds, price, move balances,
+// post the double-entry ledger, finish the job row and write the outbox event,
+// all in one transaction.
+package settlement
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/fees"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/spreads"
+	"github.com/irajwani/microservice-go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Job statuses.
+const (
+	StatusPending   = "pending" // limit order waiting for its rate
+	StatusQueued    = "queued"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Failure reasons recorded in conversion_jobs.metadata.error. They are the
+// API's error codes, so a failed job and a refused request read the same.
+const (
+	ReasonInsufficientFunds  = problem.InsufficientFunds
+	ReasonInvalidRequest     = problem.InvalidRequest
+	ReasonAmountTooSmall     = problem.AmountTooSmall
+	ReasonLimitExpired       = problem.LimitExpired
+	ReasonUserFrozen         = problem.UserFrozen
+	ReasonUserClosed         = problem.UserClosed
+	ReasonAccountFrozen      = problem.AccountFrozen
+	ReasonAccountClosed      = problem.AccountClosed
+	ReasonCurrencyNotEnabled = problem.CurrencyNotEnabled
+)
+
+// Statuses of users and accounts. Only active accounts of active users are
+// debited or credited; a user without a users row is active.
+const (
+	AccountActive = "active"
+	AccountFrozen = "frozen"
+	AccountClosed = "closed"
+)
+
+// Topic is the outbox topic of conversion.completed / conversion.failed events.
+const Topic = "conversion-events"
+
+// Validation errors, shared by every entry point.
+var (
+	ErrCurrency     = errors.New("currencies must be 3-letter codes")
+	ErrSameCurrency = errors.New("source and target currencies must differ")
+	ErrAmount       = errors.New("source_amount must be > 0")
+	ErrLimitRate    = errors.New("limit_rate must be > 0")
+)
+
+// InvalidField is a validation error about one field of a conversion, named
+// as in the API's requests.
+type InvalidField struct {
+	Field string
+	Err   error
+}
+
+func (e *InvalidField) Error() string { return e.Err.Error() }
+func (e *InvalidField) Unwrap() error { return e.Err }
+
+// ErrUserClosed is returned by EnsureAccount instead of opening an account
+// for a closed user.
+var ErrUserClosed = errors.New("user is closed")
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Job is a conversion to settle.
+type Job struct {
+	ID             string
+	ClientID       string
+	SourceCurrency string
+	TargetCurrency string
+	SourceAmount   float64
+	CorrelationID  string
+	CreatedAt      time.Time
+	// LimitRate makes the job a limit order: it is pending, its source amount
+	// is held, and it only settles at a rate of at least LimitRate.
+	LimitRate float64
+
+	// held is the amount held for the job, as LockJob returned it.
+	held float64
+}
+
+// Result is the outcome of Settle.
+type Result struct {
+	// Status is completed or failed; when Skipped it is the status the job
+	// already had.
+	Status string
+	// Skipped is set when the job was no longer queued (e.g. SQS redelivery),
+	// or was a limit order whose limit the rate did not meet; then Status is
+	// pending.
+	Skipped      bool
+	Reason       string  // failure reason
+	Rate         float64 // the bid the job executed at, after the spread
+	TargetAmount float64
+	Quote        fees.Quote
+	Spread       spreads.Quote
+}
+
+// Store runs settlements transactionally.
+type Store interface {
+	// InTx runs fn in one transaction, committing when fn returns nil.
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+// Tx is the statements a settlement runs inside its transaction.
+type Tx interface {
+	// InsertJob inserts job as queued.
+	InsertJob(ctx context.Context, job Job) error
+	// LockJob locks the job row and returns its status and the amount held
+	// for it.
+	LockJob(ctx context.Context, jobID string) (status string, held float64, err error)
+	// EnsureAccount returns the user's account in currency, creating it empty
+	// unless the user is closed (ErrUserClosed).
+	EnsureAccount(ctx context.Context, user, currency string) (accountID string, err error)
+	// Currencies returns the currencies the tenant may convert between, or
+	// nil for any.
+	Currencies(ctx context.Context) ([]string, error)
+	// AccountStatus returns the status of the account and of its user.
+	AccountStatus(ctx context.Context, accountID string) (user, account string, err error)
+	// LockBalance locks the account row and returns its available balance
+	// (balance less holds).
+	LockBalance(ctx context.Context, accountID string) (float64, error)
+	FeeSchedule(ctx context.Context, clientID, source, target string, at time.Time) (fees.Schedule, error)
+	// Spread returns the client's tier and its spread override on the pair.
+	Spread(ctx context.Context, clientID, source, target string) (spreads.Schedule, error)
+	AddBalance(ctx context.Context, accountID string, delta float64) error
+	// AddHold changes the amount held on the account.
+	AddHold(ctx context.Context, accountID string, delta float64) error
+	PostLedger(ctx context.Context, jobID, accountID, entryType string, amount float64, currency string) error
+	FailJob(ctx context.Context, jobID, reason string) error
+	CancelJob(ctx context.Context, jobID, reason string) error
+	CompleteJob(ctx context.Context, jobID string, r Result) error
+	AppendOutbox(ctx context.Context, aggregateID, topic string, payload []byte) error
+	// BatchJobs locks the jobs of a batch and returns them in batch order.
+	BatchJobs(ctx context.Context, batchID string) ([]Job, error)
+	// LockConversion locks the job row and returns it with what its
+	// reversals already cover, or ErrJobNotFound.
+	LockConversion(ctx context.Context, jobID string) (Conversion, error)
+	// InsertReversal inserts r.ID as a completed reversal of c and records
+	// it in the admin audit log.
+	InsertReversal(ctx context.Context, c Conversion, r Reversal, res ReversalResult) error
+}
+
+// RateSource returns the book of a pair: its mid rate and spread.
+type RateSource interface {
+	Rate(ctx context.Context, source, target string) (spreads.Book, error)
+}
+
+// RateFunc adapts a function to RateSource.
+type RateFunc func(ctx context.Context, source, target string) (spreads.Book, error)
+
+// Rate implements RateSource.
+func (f RateFunc) Rate(ctx context.Context, source, target string) (spreads.Book, error) {
+	return f(ctx, source, target)
+}
+
+// Settler settles jobs against a Store, pricing them with Rates, the spread
+// overrides and the fee schedule.
+type Settler struct {
+	Store Store
+	Rates RateSource
+}
+
+// Validate checks the rules every conversion must satisfy, whichever entry
+// point it came through. Errors are an *InvalidField wrapping one of the Err
+// values above.
+func Validate(job Job) error {
+	switch {
+	case !currencyCode.MatchString(job.SourceCurrency):
+		return &InvalidField{"source_currency", ErrCurrency}
+	case !currencyCode.MatchString(job.TargetCurrency):
+		return &InvalidField{"target_currency", ErrCurrency}
+	case job.SourceCurrency == job.TargetCurrency:
+		return &InvalidField{"target_currency", ErrSameCurrency}
+	case job.SourceAmount <= 0:
+		return &InvalidField{"source_amount", ErrAmount}
+	case job.LimitRate < 0:
+		return &InvalidField{"limit_rate", ErrLimitRate}
+	}
+	return nil
+}
+
+// Settle settles an existing queued job, or a pending limit order whose limit
+// the rate meets. Jobs that are no longer queued (pending) are skipped, so
+// redelivered messages are harmless. A job that cannot be settled
+// for business reasons (funds, validation) is committed as failed and
+// reported in the Result; an error means the transaction was rolled back and
+// the job is still queued.
+func (s *Settler) Settle(ctx context.Context, job Job) (Result, error) {
+	return s.run(ctx, job, false)
+}
+
+// CreateAndSettle inserts job and settles it in the same transaction, for
+// synchronous callers. On error nothing is written.
+func (s *Settler) CreateAndSettle(ctx context.Context, job Job) (Result, error) {
+	return s.run(ctx, job, true)
+}
+
+func (s *Settler) run(ctx context.Context, job Job, create bool) (Result, error) {
+	var res Result
+	err := s.Store.InTx(ctx, func(tx Tx) error {
+		if create {
+			if err := tx.InsertJob(ctx, job); err != nil {
+				return fmt.Errorf("insert job: %w", err)
+			}
+		}
+		var err error
+		res, err = s.settle(ctx, tx, job, books{})
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	record(ctx, job, res)
+	return res, nil
+}
+
+// settle settles job inside tx. The pair's book comes from bs, fetched into it
+// first if missing: either way before any account is locked, so no rate call
+// runs while balances are held.
+func (s *Settler) settle(ctx context.Context, tx Tx, job Job, bs books) (Result, error) {
+	// Lock job row (must exist & be queued). If status already completed/failed, skip (idempotent)
+	status, held, err := tx.LockJob(ctx, job.ID)
+	if err != nil {
+		return Result{}, fmt.Errorf("load job: %w", err)
+	}
+	job.held = held
+	if want := job.status(); status != want {
+		return Result{Status: status, Skipped: true}, nil
+	}
+	if err := Validate(job); err != nil {
+		return fail(ctx, tx, job, ReasonInvalidRequest, "error", err)
+	}
+	currencies, err := tx.Currencies(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("tenant currencies: %w", err)
+	}
+	if currencies != nil && (!slices.Contains(currencies, job.SourceCurrency) || !slices.Contains(currencies, job.TargetCurrency)) {
+		return fail(ctx, tx, job, ReasonCurrencyNotEnabled, "currencies", currencies)
+	}
+	book, err := s.book(ctx, bs, job)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Ensure accounts exist, then lock both balances in account_id order
+	srcAcct, err := tx.EnsureAccount(ctx, job.ClientID, job.SourceCurrency)
+	if errors.Is(err, ErrUserClosed) {
+		return fail(ctx, tx, job, ReasonUserClosed)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	tgtAcct, err := tx.EnsureAccount(ctx, job.ClientID, job.TargetCurrency)
+	if errors.Is(err, ErrUserClosed) {
+		return fail(ctx, tx, job, ReasonUserClosed)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	balances, err := lockBalances(ctx, tx, srcAcct, tgtAcct)
+	if err != nil {
+		return Result{}, err
+	}
+	// Frozen or closed users and accounts are neither debited nor credited
+	if reason, err := blocked(ctx, tx, srcAcct, tgtAcct); err != nil || reason != "" {
+		if err != nil {
+			return Result{}, err
+		}
+		return fail(ctx, tx, job, reason)
+	}
+	// The job's own hold is available to it
+	if balances[srcAcct]+job.held < job.SourceAmount {
+		return fail(ctx, tx, job, ReasonInsufficientFunds, "available", balances[srcAcct], "held", job.held)
+	}
+
+	// Pricing: the job executes at the bid of the pair's book, with the spread
+	// of the client's tier, and the fee schedule prices the fee on top
+	pricingCtx, pricingSpan := tracing.Start(ctx, "job.pricing")
+	override, err := tx.Spread(pricingCtx, job.ClientID, job.SourceCurrency, job.TargetCurrency)
+	if err != nil {
+		tracing.End(pricingSpan, err)
+		return Result{}, err
+	}
+	spread := override.Quote(book, job.SourceAmount)
+	rate := spread.Rate
+	if rate < job.LimitRate {
+		pricingSpan.End()
+		return Result{Status: StatusPending, Skipped: true, Rate: rate, Spread: spread}, nil
+	}
+	schedule, err := tx.FeeSchedule(pricingCtx, job.ClientID, job.SourceCurrency, job.TargetCurrency, time.Now())
+	if err != nil {
+		tracing.End(pricingSpan, err)
+		return Result{}, err
+	}
+	gross := job.SourceAmount * rate
+	quote := schedule.Quote(job.SourceAmount, gross)
+	res := Result{Status: StatusCompleted, Rate: rate, TargetAmount: gross - quote.Fee, Quote: quote, Spread: spread}
+	pricingSpan.SetAttributes(attribute.Float64("fx.rate", rate), attribute.Float64("fx.mid_rate", book.Mid),
+		attribute.Int("fx.spread_bps", spread.SpreadBps), attribute.Int("fee.bps", quote.FeeBps))
+	pricingSpan.End()
+	if res.TargetAmount <= 0 {
+		return fail(ctx, tx, job, ReasonAmountTooSmall, "rate", rate, "fee", quote.Fee)
+	}
+
+	ctx, settleSpan := tracing.Start(ctx, "job.settling")
+	defer settleSpan.End()
+
+	// Balances (capturing the job's hold), double-entry ledger, job row and outbox event
+	if job.held > 0 {
+		if err := tx.AddHold(ctx, srcAcct, -job.held); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := tx.AddBalance(ctx, srcAcct, -job.SourceAmount); err != nil {
+		return Result{}, err
+	}
+	if err := tx.AddBalance(ctx, tgtAcct, res.TargetAmount); err != nil {
+		return Result{}, err
+	}
+	if err := tx.PostLedger(ctx, job.ID, srcAcct, "debit", job.SourceAmount, job.SourceCurrency); err != nil {
+		return Result{}, err
+	}
+	if err := tx.PostLedger(ctx, job.ID, tgtAcct, "credit", res.TargetAmount, job.TargetCurrency); err != nil {
+		return Result{}, err
+	}
+	if err := tx.CompleteJob(ctx, job.ID, res); err != nil {
+		return Result{}, err
+	}
+	if err := appendEvent(ctx, tx, job, "conversion.completed", map[string]any{
+		"target_amount": res.TargetAmount, "rate": rate, "fee": quote.Fee,
+		"fee_bps": quote.FeeBps, "fee_schedule_id": quote.ScheduleID, "fee_schedule_version": quote.ScheduleVersion,
+		"mid_rate": spread.MidRate, "spread_bps": spread.SpreadBps, "spread": spread.Spread, "client_tier": spread.Tier,
+	}); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// books holds the pairs' books a settlement has fetched, keyed
+// "SOURCE:TARGET", with the error of a fetch that failed.
+type books map[string]fetched
+
+type fetched struct {
+	book spreads.Book
+	err  error
+}
+
+// book returns the book of job's pair from bs, fetching it from Rates into
+// bs when missing.
+func (s *Settler) book(ctx context.Context, bs books, job Job) (spreads.Book, error) {
+	key := job.SourceCurrency + ":" + job.TargetCurrency
+	f, ok := bs[key]
+	if !ok {
+		rateCtx, span := tracing.Start(ctx, "job.rate")
+		f.book, f.err = s.Rates.Rate(rateCtx, job.SourceCurrency, job.TargetCurrency)
+		if f.err == nil && (f.book.Mid <= 0 || f.book.SpreadBps < 0) {
+			f.err = fmt.Errorf("invalid book %+v for %s", f.book, key)
+		}
+		tracing.End(span, f.err)
+		bs[key] = f
+	}
+	return f.book, f.err
+}
+
+// status is the status a job must have to settle.
+func (j Job) status() string {
+	if j.LimitRate > 0 {
+		return StatusPending
+	}
+	return StatusQueued
+}
+
+// Cancel cancels a queued job or pending limit order with reason, releasing
+// its hold, and emits conversion.cancelled. Jobs that have already settled
+// are skipped.
+func (s *Settler) Cancel(ctx context.Context, job Job, reason string) (Result, error) {
+	var res Result
+	err := s.Store.InTx(ctx, func(tx Tx) error {
+		status, held, err := tx.LockJob(ctx, job.ID)
+		if err != nil {
+			return fmt.Errorf("load job: %w", err)
+		}
+		if status != StatusPending && status != StatusQueued {
+			res = Result{Status: status, Skipped: true}
+			return nil
+		}
+		job.held = held
+		if err := releaseHold(ctx, tx, job); err != nil {
+			return err
+		}
+		if err := tx.CancelJob(ctx, job.ID, reason); err != nil {
+			return fmt.Errorf("cancel job (%s): %w", reason, err)
+		}
+		res = Result{Status: StatusCancelled, Reason: reason}
+		return appendEvent(ctx, tx, job, "conversion.cancelled", map[string]any{"reason": reason})
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	record(ctx, job, res)
+	return res, nil
+}
+
+// Blocked returns the failure reason for a job touching an account with
+// these statuses, or "" when both are active. The user's status wins.
+func Blocked(userStatus, accountStatus string) string {
+	switch userStatus {
+	case AccountFrozen:
+		return ReasonUserFrozen
+	case AccountClosed:
+		return ReasonUserClosed
+	}
+	switch accountStatus {
+	case AccountFrozen:
+		return ReasonAccountFrozen
+	case AccountClosed:
+		return ReasonAccountClosed
+	}
+	return ""
+}
+
+// blocked returns the reason the first of the locked accounts that is not
+// active blocks the job, or "".
+func blocked(ctx context.Context, tx Tx, accountIDs ...string) (string, error) {
+	for _, id := range accountIDs {
+		user, account, err := tx.AccountStatus(ctx, id)
+		if err != nil {
+			return "", fmt.Errorf("account status %s: %w", id, err)
+		}
+		if reason := Blocked(user, account); reason != "" {
+			return reason, nil
+		}
+	}
+	return "", nil
+}
+
+// releaseHold returns the job's held amount to the available balance.
+func releaseHold(ctx context.Context, tx Tx, job Job) error {
+	if job.held == 0 {
+		return nil
+	}
+	acct, err := tx.EnsureAccount(ctx, job.ClientID, job.SourceCurrency)
+	if err != nil {
+		return err
+	}
+	if err := tx.AddHold(ctx, acct, -job.held); err != nil {
+		return fmt.Errorf("release hold: %w", err)
+	}
+	return nil
+}
+
+// fail commits the job as failed with reason, releasing its hold,
+// and emits conversion.failed.
+func fail(ctx context.Context, tx Tx, job Job, reason string, logArgs ...any) (Result, error) {
+	if err := releaseHold(ctx, tx, job); err != nil {
+		return Result{}, err
+	}
+	if err := tx.FailJob(ctx, job.ID, reason); err != nil {
+		return Result{}, fmt.Errorf("fail job (%s): %w", reason, err)
+	}
+	if err := appendEvent(ctx, tx, job, "conversion.failed", map[string]any{"reason": reason}); err != nil {
+		return Result{}, err
+	}
+	slog.WarnContext(ctx, "job failed", append([]any{"reason", reason, "source_amount", job.SourceAmount}, logArgs...)...)
+	return Result{Status: StatusFailed, Reason: reason}, nil
+}
+
+func appendEvent(ctx context.Context, tx Tx, job Job, event string, fields map[string]any) error {
+	payload := map[string]any{
+		"event": event, "job_id": job.ID, "user_id": job.ClientID, "source_currency": job.SourceCurrency,
+		"target_currency": job.TargetCurrency, "source_amount": job.SourceAmount, logging.Attribute: job.CorrelationID,
+	}
+	for k, v := range fields {
+		payload[k] = v
+	}
+	b, _ := json.Marshal(payload)
+	return tx.AppendOutbox(ctx, job.ID, Topic, b)
+}
+
+// lockBalances locks the given accounts in account_id order and returns their
+// balances. Every settlement locks in this one global order, so two
+// transactions over the same accounts (e.g. USD->EUR and EUR->USD for one
+// user) queue behind each other instead of deadlocking.
+func lockBalances(ctx context.Context, tx Tx, accountIDs ...string) (map[string]float64, error) {
+	ids := slices.Clone(accountIDs)
+	slices.Sort(ids)
+	balances := make(map[string]float64, len(ids))
+	for _, id := range slices.Compact(ids) {
+		b, err := tx.LockBalance(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("lock account %s: %w", id, err)
+		}
+		balances[id] = b
+	}
+	return balances, nil
+}
+
+// record logs the committed outcome and emits the job metrics.
+func record(ctx context.Context, job Job, res Result) {
+	switch {
+	case res.Skipped && res.Status == StatusPending:
+		slog.DebugContext(ctx, "limit not met", "rate", res.Rate, "limit_rate", job.LimitRate)
+		return
+	case res.Skipped:
+		slog.InfoContext(ctx, "job already processed", "status", res.Status)
+		return
+	}
+	pair := metrics.Pair(job.SourceCurrency, job.TargetCurrency)
+	switch res.Status {
+	case StatusFailed:
+		metrics.Count(metrics.JobsFailed, 1, pair, metrics.D("reason", res.Reason))
+		return
+	case StatusCancelled:
+		slog.InfoContext(ctx, "job cancelled", "reason", res.Reason)
+		metrics.Count(metrics.JobsCancelled, 1, pair, metrics.D("reason", res.Reason))
+		return
+	}
+	slog.InfoContext(ctx, "job completed", "rate", res.Rate, "mid_rate", res.Spread.MidRate, "fee", res.Quote.Fee, "spread", res.Spread.Spread, "target_amount", res.TargetAmount)
+	metrics.Count(metrics.JobsCompleted, 1, pair)
+	metrics.Observe(metrics.NotionalVolume, metrics.UnitNone, job.SourceAmount, pair)
+	metrics.Observe(metrics.FeeRevenue, metrics.UnitNone, res.Quote.Fee, pair)
+	metrics.Observe(metrics.SpreadRevenue, metrics.UnitNone, res.Spread.Spread, pair)
+	if !job.CreatedAt.IsZero() {
+		metrics.Since(metrics.JobLatency, job.CreatedAt, pair)
+	}
+}
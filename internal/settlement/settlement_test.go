@@ -0,0 +1,185 @@
+package settlement_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/fees"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/settlement/settlementtest"
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+// usdEUR quotes USD:EUR at 0.9 without a spread.
+var usdEUR = settlement.RateFunc(func(_ context.Context, source, target string) (spreads.Book, error) {
+	if source+":"+target != "USD:EUR" {
+		return spreads.Book{}, errors.New("rate not found")
+	}
+	return spreads.Book{Mid: 0.9}, nil
+})
+
+func newSettler(balances map[string]float64) (*settlement.Settler, *settlementtest.MemStore) {
+	store := settlementtest.New()
+	store.Fund("u1", balances)
+	return &settlement.Settler{Store: store, Rates: usdEUR}, store
+}
+
+func job(source, target string, amount float64) settlement.Job {
+	return settlement.Job{ID: "j1", ClientID: "u1", SourceCurrency: source, TargetCurrency: target, SourceAmount: amount}
+}
+
+func TestCreateAndSettle(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	res, err := s.CreateAndSettle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 100 * 0.9 * (1 - 0.003)
+	if res.Status != settlement.StatusCompleted || math.Abs(res.TargetAmount-want) > 1e-9 || res.Quote.FeeBps != 30 {
+		t.Fatalf("result = %+v, want completed with %.4f at 30 bps", res, want)
+	}
+	if store.Jobs["j1"] != settlement.StatusCompleted || store.Accounts["u1/USD"].Balance != 900 {
+		t.Errorf("job %s, USD %v; want completed and 900", store.Jobs["j1"], store.Accounts["u1/USD"].Balance)
+	}
+	if len(store.Ledger) != 2 || len(store.Outbox) != 1 || store.Outbox[0] != settlement.Topic {
+		t.Errorf("ledger %d entries, outbox %v; want 2 and one %s event", len(store.Ledger), store.Outbox, settlement.Topic)
+	}
+}
+
+func TestCreateAndSettleRecordsInsufficientFunds(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 10})
+	res, err := s.CreateAndSettle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || res.Reason != settlement.ReasonInsufficientFunds {
+		t.Fatalf("result = %+v, want failed (INSUFFICIENT_FUNDS)", res)
+	}
+	if store.Jobs["j1"] != settlement.StatusFailed || len(store.Ledger) != 0 || store.Accounts["u1/USD"].Balance != 10 {
+		t.Errorf("want a failed job and no funds moved, got %+v", store)
+	}
+	if len(store.Outbox) != 1 {
+		t.Errorf("outbox = %v, want one conversion.failed event", store.Outbox)
+	}
+}
+
+func TestCreateAndSettleRecordsAmountTooSmall(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	store.Fees = &fees.Schedule{ID: "s1", Version: 1, Tiers: []fees.Tier{{FeeBps: 30}}, MinFee: 5}
+	res, err := s.CreateAndSettle(context.Background(), job("USD", "EUR", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || res.Reason != settlement.ReasonAmountTooSmall {
+		t.Fatalf("result = %+v, want failed (AMOUNT_TOO_SMALL)", res)
+	}
+	if len(store.Ledger) != 0 || store.Accounts["u1/USD"].Balance != 1000 {
+		t.Errorf("want no funds moved, got %+v", store)
+	}
+}
+
+func TestCreateAndSettleWritesNothingOnError(t *testing.T) {
+	s, store := newSettler(map[string]float64{"GBP": 100})
+	if _, err := s.CreateAndSettle(context.Background(), job("GBP", "JPY", 10)); err == nil {
+		t.Fatal("want rate error")
+	}
+	if len(store.Jobs) != 0 || len(store.Accounts) != 1 {
+		t.Errorf("job or accounts left behind: %+v", store)
+	}
+}
+
+func TestSettleFailsInvalidJobs(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	store.Jobs["j1"] = settlement.StatusQueued
+	res, err := s.Settle(context.Background(), job("USD", "USD", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || store.Reasons["j1"] != settlement.ReasonInvalidRequest {
+		t.Errorf("result = %+v, reason %q; want failed (INVALID_REQUEST)", res, store.Reasons["j1"])
+	}
+}
+
+func TestSettleFailsCurrenciesTheTenantHasNotEnabled(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	store.Enabled = []string{"USD", "GBP"}
+	store.Jobs["j1"] = settlement.StatusQueued
+	res, err := s.Settle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || store.Reasons["j1"] != settlement.ReasonCurrencyNotEnabled {
+		t.Errorf("result = %+v, reason %q; want failed (CURRENCY_NOT_ENABLED)", res, store.Reasons["j1"])
+	}
+
+	store.Enabled = append(store.Enabled, "EUR")
+	store.Jobs["j1"] = settlement.StatusQueued
+	if res, err := s.Settle(context.Background(), job("USD", "EUR", 100)); err != nil || res.Status != settlement.StatusCompleted {
+		t.Errorf("result = %+v, %v; want completed once EUR is enabled", res, err)
+	}
+}
+
+func TestSettleSkipsProcessedJobs(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	store.Jobs["j1"] = settlement.StatusCompleted
+	res, err := s.Settle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Skipped || len(store.Ledger) != 0 {
+		t.Errorf("completed job was settled again: %+v", res)
+	}
+}
+
+func TestSettleLocksAccountsInIDOrder(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000, "EUR": 0})
+	store.Jobs["j1"] = settlement.StatusQueued
+	if _, err := s.Settle(context.Background(), job("USD", "EUR", 100)); err != nil {
+		t.Fatal(err)
+	}
+	// source u1/USD sorts after target u1/EUR, so the target is locked first
+	if len(store.Locked) != 2 || store.Locked[0] != "u1/EUR" || store.Locked[1] != "u1/USD" {
+		t.Errorf("lock order = %v, want [u1/EUR u1/USD]", store.Locked)
+	}
+}
+
+func TestSettleFetchesTheRateBeforeLockingAccounts(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000, "EUR": 0})
+	store.Jobs["j1"] = settlement.StatusQueued
+	var lockedAtFetch []int
+	s.Rates = settlement.RateFunc(func(ctx context.Context, source, target string) (spreads.Book, error) {
+		lockedAtFetch = append(lockedAtFetch, len(store.Locked))
+		return usdEUR(ctx, source, target)
+	})
+	if _, err := s.Settle(context.Background(), job("USD", "EUR", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if len(lockedAtFetch) != 1 || lockedAtFetch[0] != 0 {
+		t.Errorf("accounts locked at each rate fetch = %v, want [0]", lockedAtFetch)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		job   settlement.Job
+		want  error
+		field string
+	}{
+		"ok":               {job("USD", "EUR", 1), nil, ""},
+		"lowercase":        {job("usd", "EUR", 1), settlement.ErrCurrency, "source_currency"},
+		"lowercase target": {job("USD", "eur", 1), settlement.ErrCurrency, "target_currency"},
+		"same currency":    {job("USD", "USD", 1), settlement.ErrSameCurrency, "target_currency"},
+		"non-positive":     {job("USD", "EUR", 0), settlement.ErrAmount, "source_amount"},
+	} {
+		err := settlement.Validate(tc.job)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: Validate = %v, want %v", name, err, tc.want)
+		}
+		var invalid *settlement.InvalidField
+		if errors.As(err, &invalid) && invalid.Field != tc.field {
+			t.Errorf("%s: field = %q, want %q", name, invalid.Field, tc.field)
+		}
+	}
+}
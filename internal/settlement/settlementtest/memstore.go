@@ -0,0 +1,242 @@
+// Package settlementtest provides an in-memory settlement.Store for unit tests.
+package settlementtest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/fees"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+// Account is one in-memory account.
+type Account struct {
+	User, Currency string
+	Balance, Held  float64
+	Status         string // "" is active
+}
+
+// LedgerEntry is one in-memory ledger row.
+type LedgerEntry struct {
+	JobID, AccountID, EntryType, Currency string
+	Amount                                float64
+}
+
+// MemStore is an in-memory settlement.Store; InTx discards every change when
+// fn fails. Account ids are "user/currency".
+type MemStore struct {
+	Jobs     map[string]string  // job id -> status
+	Holds    map[string]float64 // job id -> amount held for it
+	Reasons  map[string]string  // job id -> failure or cancellation reason
+	Results  map[string]settlement.Result
+	Accounts map[string]Account
+	Ledger   []LedgerEntry
+	Outbox   []string                    // topics
+	Batches  map[string][]settlement.Job // batch id -> legs in batch order
+	Users    map[string]string           // user -> status; absent is active
+	Tiers    map[string]string           // user -> tier; absent is standard
+	// Fees prices every conversion; nil uses fees.Default.
+	Fees *fees.Schedule
+	// Spreads override the book's spread by tier, on every pair.
+	Spreads map[string]int
+	// Enabled are the tenant's currencies; nil allows any.
+	Enabled []string
+	// Conversions are the jobs LockConversion knows, including reversals.
+	Conversions map[string]settlement.Conversion
+	// Locked records LockBalance calls, in order, across committed transactions.
+	Locked []string
+}
+
+// New returns an empty MemStore.
+func New() *MemStore {
+	return &MemStore{Jobs: map[string]string{}, Holds: map[string]float64{}, Reasons: map[string]string{}, Results: map[string]settlement.Result{}, Accounts: map[string]Account{}, Batches: map[string][]settlement.Job{}, Users: map[string]string{}, Tiers: map[string]string{}, Spreads: map[string]int{}, Conversions: map[string]settlement.Conversion{}}
+}
+
+// Fund creates the user's accounts with the given balances.
+func (s *MemStore) Fund(user string, balances map[string]float64) {
+	for cur, bal := range balances {
+		s.Accounts[user+"/"+cur] = Account{User: user, Currency: cur, Balance: bal}
+	}
+}
+
+// AddBatch inserts jobs as the queued legs of batchID.
+func (s *MemStore) AddBatch(batchID string, jobs ...settlement.Job) {
+	for _, j := range jobs {
+		s.Jobs[j.ID] = settlement.StatusQueued
+	}
+	s.Batches[batchID] = append(s.Batches[batchID], jobs...)
+}
+
+// AddHeldJob inserts job as queued and holds its amount, as POST /jobs does.
+func (s *MemStore) AddHeldJob(job settlement.Job) {
+	s.addHeld(job, settlement.StatusQueued)
+}
+
+// AddLimitOrder inserts job as a pending limit order and holds its amount.
+func (s *MemStore) AddLimitOrder(job settlement.Job) {
+	s.addHeld(job, settlement.StatusPending)
+}
+
+func (s *MemStore) addHeld(job settlement.Job, status string) {
+	s.Jobs[job.ID], s.Holds[job.ID] = status, job.SourceAmount
+	id := job.ClientID + "/" + job.SourceCurrency
+	a := s.Accounts[id]
+	a.User, a.Currency, a.Held = job.ClientID, job.SourceCurrency, a.Held+job.SourceAmount
+	s.Accounts[id] = a
+}
+
+// AddCompleted records job as a completed conversion of targetAmount, without
+// moving any balance.
+func (s *MemStore) AddCompleted(job settlement.Job, targetAmount float64) {
+	s.Jobs[job.ID] = settlement.StatusCompleted
+	s.Conversions[job.ID] = settlement.Conversion{Job: job, Status: settlement.StatusCompleted, TargetAmount: targetAmount}
+}
+
+func (s *MemStore) InTx(_ context.Context, fn func(settlement.Tx) error) error {
+	work := &MemStore{Jobs: maps.Clone(s.Jobs), Holds: maps.Clone(s.Holds), Reasons: maps.Clone(s.Reasons), Results: maps.Clone(s.Results), Accounts: maps.Clone(s.Accounts),
+		Ledger: slices.Clone(s.Ledger), Outbox: slices.Clone(s.Outbox), Batches: s.Batches, Users: s.Users, Tiers: s.Tiers, Fees: s.Fees, Spreads: s.Spreads, Enabled: s.Enabled, Conversions: maps.Clone(s.Conversions), Locked: slices.Clone(s.Locked)}
+	if err := fn(work); err != nil {
+		return err
+	}
+	*s = *work
+	return nil
+}
+
+func (s *MemStore) InsertJob(_ context.Context, job settlement.Job) error {
+	if _, ok := s.Jobs[job.ID]; ok {
+		return errors.New("duplicate job")
+	}
+	s.Jobs[job.ID] = settlement.StatusQueued
+	return nil
+}
+
+func (s *MemStore) LockJob(_ context.Context, jobID string) (string, float64, error) {
+	status, ok := s.Jobs[jobID]
+	if !ok {
+		return "", 0, errors.New("no such job")
+	}
+	return status, s.Holds[jobID], nil
+}
+
+func (s *MemStore) EnsureAccount(_ context.Context, user, currency string) (string, error) {
+	id := user + "/" + currency
+	if _, ok := s.Accounts[id]; !ok {
+		if s.Users[user] == settlement.AccountClosed {
+			return "", settlement.ErrUserClosed
+		}
+		s.Accounts[id] = Account{User: user, Currency: currency}
+	}
+	return id, nil
+}
+
+func (s *MemStore) AccountStatus(_ context.Context, accountID string) (string, string, error) {
+	a := s.Accounts[accountID]
+	return cmp.Or(s.Users[a.User], settlement.AccountActive), cmp.Or(a.Status, settlement.AccountActive), nil
+}
+
+func (s *MemStore) Currencies(context.Context) ([]string, error) {
+	return s.Enabled, nil
+}
+
+func (s *MemStore) LockBalance(_ context.Context, accountID string) (float64, error) {
+	s.Locked = append(s.Locked, accountID)
+	a := s.Accounts[accountID]
+	return a.Balance - a.Held, nil
+}
+
+func (s *MemStore) FeeSchedule(context.Context, string, string, string, time.Time) (fees.Schedule, error) {
+	if s.Fees != nil {
+		return *s.Fees, nil
+	}
+	return fees.Default, nil
+}
+
+func (s *MemStore) Spread(_ context.Context, clientID, _, _ string) (spreads.Schedule, error) {
+	tier := cmp.Or(s.Tiers[clientID], spreads.StandardTier)
+	if bps, ok := s.Spreads[tier]; ok {
+		return spreads.Schedule{ID: "spread-" + tier, Tier: tier, SpreadBps: bps}, nil
+	}
+	return spreads.Schedule{Tier: tier}, nil
+}
+
+func (s *MemStore) AddBalance(_ context.Context, accountID string, delta float64) error {
+	a := s.Accounts[accountID]
+	a.Balance += delta
+	s.Accounts[accountID] = a
+	return nil
+}
+
+func (s *MemStore) AddHold(_ context.Context, accountID string, delta float64) error {
+	a := s.Accounts[accountID]
+	if a.Held+delta < 0 {
+		return errors.New("hold would go negative")
+	}
+	a.Held += delta
+	s.Accounts[accountID] = a
+	return nil
+}
+
+func (s *MemStore) PostLedger(_ context.Context, jobID, accountID, entryType string, amount float64, currency string) error {
+	s.Ledger = append(s.Ledger, LedgerEntry{jobID, accountID, entryType, currency, amount})
+	return nil
+}
+
+func (s *MemStore) FailJob(_ context.Context, jobID, reason string) error {
+	s.Jobs[jobID], s.Reasons[jobID] = settlement.StatusFailed, reason
+	delete(s.Holds, jobID)
+	return nil
+}
+
+func (s *MemStore) CancelJob(_ context.Context, jobID, reason string) error {
+	s.Jobs[jobID], s.Reasons[jobID] = settlement.StatusCancelled, reason
+	delete(s.Holds, jobID)
+	return nil
+}
+
+func (s *MemStore) CompleteJob(_ context.Context, jobID string, r settlement.Result) error {
+	s.Jobs[jobID], s.Results[jobID] = settlement.StatusCompleted, r
+	delete(s.Holds, jobID)
+	return nil
+}
+
+func (s *MemStore) AppendOutbox(_ context.Context, _, topic string, _ []byte) error {
+	s.Outbox = append(s.Outbox, topic)
+	return nil
+}
+
+func (s *MemStore) BatchJobs(_ context.Context, batchID string) ([]settlement.Job, error) {
+	return slices.Clone(s.Batches[batchID]), nil
+}
+
+func (s *MemStore) LockConversion(_ context.Context, jobID string) (settlement.Conversion, error) {
+	c, ok := s.Conversions[jobID]
+	if !ok {
+		return settlement.Conversion{}, settlement.ErrJobNotFound
+	}
+	c.Status, c.Reversed = s.Jobs[jobID], 0
+	for _, r := range s.Conversions {
+		if r.ReversalOf == jobID {
+			c.Reversed += r.Reversed
+		}
+	}
+	return c, nil
+}
+
+// InsertReversal records the reversal as a conversion whose Reversed is the
+// amount it reverses.
+func (s *MemStore) InsertReversal(_ context.Context, c settlement.Conversion, r settlement.Reversal, res settlement.ReversalResult) error {
+	if _, ok := s.Jobs[r.ID]; ok {
+		return errors.New("duplicate job")
+	}
+	s.Jobs[r.ID], s.Reasons[r.ID] = settlement.StatusCompleted, r.Reason
+	s.Conversions[r.ID] = settlement.Conversion{
+		Job:    settlement.Job{ID: r.ID, ClientID: c.ClientID, SourceCurrency: c.TargetCurrency, TargetCurrency: c.SourceCurrency, SourceAmount: res.Debit},
+		Status: settlement.StatusCompleted, TargetAmount: res.Credit, ReversalOf: c.ID, Reversed: res.Amount,
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package settlement_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+// book quotes every pair at 0.9 with a 20 bps spread.
+var book = settlement.RateFunc(func(context.Context, string, string) (spreads.Book, error) {
+	return spreads.Book{Mid: 0.9, SpreadBps: 20}, nil
+})
+
+func TestSettleExecutesAtTheBid(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	s.Rates = book
+	res, err := s.CreateAndSettle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bid := spreads.Book{Mid: 0.9, SpreadBps: 20}.Bid() // 0.9 / 1.001
+	if res.Rate != bid || res.Spread.MidRate != 0.9 || res.Spread.SpreadBps != 20 || res.Spread.Tier != spreads.StandardTier || res.Spread.SpreadID != "" {
+		t.Fatalf("result = %+v, want the book's bid %v", res, bid)
+	}
+	// The spread and the fee are separate revenues
+	if want := 100 * (0.9 - bid); math.Abs(res.Spread.Spread-want) > 1e-9 {
+		t.Errorf("spread = %v, want %v", res.Spread.Spread, want)
+	}
+	if want := 100 * bid * (1 - 0.003); math.Abs(res.TargetAmount-want) > 1e-9 || res.Quote.FeeBps != 30 {
+		t.Errorf("target %v at %d bps, want %v at 30", res.TargetAmount, res.Quote.FeeBps, want)
+	}
+	if got := store.Results["j1"].Spread; got != res.Spread {
+		t.Errorf("job spread = %+v, want %+v", got, res.Spread)
+	}
+}
+
+func TestSettleSpreadByTier(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 1000})
+	s.Rates = book
+	store.Tiers["u1"], store.Spreads["premium"] = "premium", 0
+	res, err := s.CreateAndSettle(context.Background(), job("USD", "EUR", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rate != 0.9 || res.Spread.Spread != 0 || res.Spread.Tier != "premium" || res.Spread.SpreadID == "" {
+		t.Errorf("result = %+v, want the mid for the premium tier", res)
+	}
+}
+
+func TestSettleLimitOrderChecksTheBid(t *testing.T) {
+	// The mid meets the limit, but the bid the order would execute at does not
+	s, store := newSettler(map[string]float64{"USD": 100})
+	s.Rates = book
+	store.AddLimitOrder(limitOrder(0.9))
+	res, err := s.Settle(context.Background(), limitOrder(0.9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Skipped || res.Status != settlement.StatusPending || res.Rate >= 0.9 {
+		t.Errorf("result = %+v, want pending below the limit", res)
+	}
+}
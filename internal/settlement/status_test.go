@@ -0,0 +1,90 @@
+package settlement_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/settlement"
+)
+
+func TestSettleFailsBlockedAccounts(t *testing.T) {
+	for name, tc := range map[string]struct {
+		user, source, target, want string
+	}{
+		"frozen source":    {source: settlement.AccountFrozen, want: settlement.ReasonAccountFrozen},
+		"closed target":    {target: settlement.AccountClosed, want: settlement.ReasonAccountClosed},
+		"frozen user":      {user: settlement.AccountFrozen, want: settlement.ReasonUserFrozen},
+		"closed user wins": {user: settlement.AccountClosed, source: settlement.AccountFrozen, want: settlement.ReasonUserClosed},
+		"active settles":   {user: settlement.AccountActive, source: settlement.AccountActive},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, store := newSettler(map[string]float64{"USD": 100, "EUR": 0})
+			store.AddHeldJob(job("USD", "EUR", 40))
+			if tc.user != "" {
+				store.Users["u1"] = tc.user
+			}
+			for cur, status := range map[string]string{"USD": tc.source, "EUR": tc.target} {
+				a := store.Accounts["u1/"+cur]
+				a.Status = status
+				store.Accounts["u1/"+cur] = a
+			}
+
+			res, err := s.Settle(context.Background(), job("USD", "EUR", 40))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == "" {
+				if res.Status != settlement.StatusCompleted {
+					t.Errorf("result = %+v, want completed", res)
+				}
+				return
+			}
+			if res.Status != settlement.StatusFailed || store.Reasons["j1"] != tc.want {
+				t.Errorf("result = %+v, reason %q; want failed (%s)", res, store.Reasons["j1"], tc.want)
+			}
+			if usd := store.Accounts["u1/USD"]; usd.Balance != 100 || usd.Held != 0 || len(store.Ledger) != 0 {
+				t.Errorf("USD = %+v with %d ledger entries, want untouched and the hold released", usd, len(store.Ledger))
+			}
+		})
+	}
+}
+
+func TestSettleDoesNotOpenAccountsForClosedUsers(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddHeldJob(job("USD", "EUR", 40))
+	store.Users["u1"] = settlement.AccountClosed
+
+	res, err := s.Settle(context.Background(), job("USD", "EUR", 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != settlement.StatusFailed || res.Reason != settlement.ReasonUserClosed {
+		t.Errorf("result = %+v, want USER_CLOSED", res)
+	}
+	if _, ok := store.Accounts["u1/EUR"]; ok {
+		t.Error("EUR account opened for a closed user")
+	}
+	if store.Accounts["u1/USD"].Held != 0 {
+		t.Errorf("USD = %+v, want the hold released", store.Accounts["u1/USD"])
+	}
+}
+
+func TestSettleBatchFailsForClosedUser(t *testing.T) {
+	s, store := newSettler(map[string]float64{"USD": 100})
+	store.AddBatch("b1", leg("j1", 10), leg("j2", 20))
+	store.Users["u1"] = settlement.AccountClosed
+
+	res, err := s.SettleBatch(context.Background(), "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"j1": settlement.ReasonUserClosed, "j2": settlement.ReasonBatchLegFailed}
+	for id, reason := range want {
+		if res[id].Status != settlement.StatusFailed || store.Reasons[id] != reason {
+			t.Errorf("%s: result %+v, reason %q; want failed (%s)", id, res[id], store.Reasons[id], reason)
+		}
+	}
+	if _, ok := store.Accounts["u1/EUR"]; ok {
+		t.Error("EUR account opened for a closed user")
+	}
+}
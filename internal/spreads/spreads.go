@@ -0,0 +1,119 @@
+// Package spreads prices the bid/ask spread of a conversion around the mid
+// rate, from the pair's book and the overrides stored in Postgres (see
+// db/migrations/0017_spreads.sql).
+//
+// Bid and ask sit half the spread either side of the mid, geometrically:
+// bid = mid / (1 + h) and ask = mid * (1 + h), with h = SpreadBps / 20000. So
+// the mid is the geometric mean of bid and ask, and the reverse pair's book
+// (Inverse) has bid 1/ask, mid 1/mid and ask 1/bid: both directions of a pair
+// are quoted from one book. A conversion sells the source currency at the bid.
+package spreads
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// StandardTier is the tier of a client without a users row.
+const StandardTier = "standard"
+
+// Book is a pair's mid rate (target units per source unit) and the spread
+// quoted around it.
+type Book struct {
+	Mid       float64
+	SpreadBps int
+}
+
+func (b Book) half() float64 { return 1 + float64(b.SpreadBps)/20000.0 }
+
+// Bid is the rate a conversion of the pair executes at.
+func (b Book) Bid() float64 { return b.Mid / b.half() }
+
+// Ask is the rate the reverse conversion executes at, as target units per
+// source unit of this pair.
+func (b Book) Ask() float64 { return b.Mid * b.half() }
+
+// Inverse is the book of the reverse pair.
+func (b Book) Inverse() Book { return Book{Mid: 1 / b.Mid, SpreadBps: b.SpreadBps} }
+
+// Books holds one book per pair, keyed "SOURCE:TARGET" in the direction it
+// is quoted.
+type Books map[string]Book
+
+// Book returns the book of source->target: the pair's own, or the inverse of
+// the reverse pair's.
+func (bs Books) Book(source, target string) (Book, bool) {
+	if b, ok := bs[source+":"+target]; ok {
+		return b, true
+	}
+	if b, ok := bs[target+":"+source]; ok {
+		return b.Inverse(), true
+	}
+	return Book{}, false
+}
+
+// Schedule is the spread override that applies to a client on a pair. Without
+// an ID no fx_spreads row matched, and the book's spread applies.
+type Schedule struct {
+	ID        string
+	Tier      string
+	SpreadBps int
+}
+
+// Quote is the spread priced into one conversion. Spread is the revenue it
+// earns, in target currency units; it is reported apart from the fee.
+type Quote struct {
+	SpreadID  string  `json:"spread_id,omitempty"`
+	Tier      string  `json:"client_tier"`
+	MidRate   float64 `json:"mid_rate"`
+	SpreadBps int     `json:"spread_bps"`
+	Spread    float64 `json:"spread"`
+	// Rate is the bid the conversion executes at.
+	Rate float64 `json:"-"`
+}
+
+// Quote prices a conversion of notional source units on b.
+func (s Schedule) Quote(b Book, notional float64) Quote {
+	if s.ID != "" {
+		b.SpreadBps = s.SpreadBps
+	}
+	bid := b.Bid()
+	return Quote{SpreadID: s.ID, Tier: s.Tier, MidRate: b.Mid, SpreadBps: b.SpreadBps, Spread: notional * (b.Mid - bid), Rate: bid}
+}
+
+// Pair is the fx_spreads key of a pair: both codes in alphabetical order,
+// e.g. EUR-USD for USD->EUR and EUR->USD.
+func Pair(source, target string) string {
+	if target < source {
+		source, target = target, source
+	}
+	return source + "-" + target
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Lookup returns the client's tier and the most specific fx_spreads row for
+// it on the pair: tier+pair, tier, pair, then global. Without a match the
+// Schedule has no ID.
+func Lookup(ctx context.Context, q Querier, clientID, source, target string) (Schedule, error) {
+	var s Schedule
+	var id sql.NullString
+	var bps sql.NullInt64
+	err := q.QueryRowContext(ctx, `SELECT u.tier, s.spread_id, s.spread_bps
+		FROM (SELECT COALESCE((SELECT tier FROM users WHERE user_id = $1), $3) AS tier) u
+		LEFT JOIN LATERAL (
+		  SELECT spread_id, spread_bps FROM fx_spreads
+		  WHERE (tier IS NULL OR tier = u.tier) AND (pair IS NULL OR pair = $2)
+		  ORDER BY (tier IS NOT NULL) DESC, (pair IS NOT NULL) DESC
+		  LIMIT 1
+		) s ON true`, clientID, Pair(source, target), StandardTier).Scan(&s.Tier, &id, &bps)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("lookup spread: %w", err)
+	}
+	s.ID, s.SpreadBps = id.String, int(bps.Int64)
+	return s, nil
+}
@@ -0,0 +1,55 @@
+package spreads_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/irajwani/microservice-go/internal/spreads"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-12 }
+
+func TestBookInverse(t *testing.T) {
+	b := spreads.Book{Mid: 0.90, SpreadBps: 20}
+	inv := b.Inverse()
+	if !near(inv.Bid(), 1/b.Ask()) || !near(inv.Ask(), 1/b.Bid()) || !near(inv.Mid, 1/b.Mid) {
+		t.Errorf("inverse of %+v = %+v, bid %v ask %v", b, inv, inv.Bid(), inv.Ask())
+	}
+	if !near(math.Sqrt(b.Bid()*b.Ask()), b.Mid) || b.Bid() >= b.Mid || b.Ask() <= b.Mid {
+		t.Errorf("bid %v ask %v around mid %v", b.Bid(), b.Ask(), b.Mid)
+	}
+	if spread := (b.Ask() - b.Bid()) / b.Mid * 10000; math.Abs(spread-20) > 0.01 {
+		t.Errorf("spread = %v bps, want 20", spread)
+	}
+}
+
+func TestScheduleQuote(t *testing.T) {
+	b := spreads.Book{Mid: 0.90, SpreadBps: 20}
+	q := spreads.Schedule{Tier: spreads.StandardTier}.Quote(b, 100)
+	if q.SpreadBps != 20 || q.SpreadID != "" || !near(q.Rate, b.Bid()) || !near(q.Spread, 100*(0.90-b.Bid())) {
+		t.Errorf("book spread: %+v", q)
+	}
+	q = spreads.Schedule{ID: "s1", Tier: "premium", SpreadBps: 0}.Quote(b, 100)
+	if q.SpreadBps != 0 || q.Rate != 0.90 || q.Spread != 0 || q.MidRate != 0.90 || q.Tier != "premium" {
+		t.Errorf("override: %+v", q)
+	}
+}
+
+func TestPair(t *testing.T) {
+	if spreads.Pair("USD", "EUR") != "EUR-USD" || spreads.Pair("EUR", "USD") != "EUR-USD" {
+		t.Errorf("pairs %s %s", spreads.Pair("USD", "EUR"), spreads.Pair("EUR", "USD"))
+	}
+}
+
+func TestBooks(t *testing.T) {
+	books := spreads.Books{"USD:EUR": {Mid: 0.90, SpreadBps: 10}}
+	if b, ok := books.Book("USD", "EUR"); !ok || b.Mid != 0.90 {
+		t.Errorf("USD:EUR = %+v, %v", b, ok)
+	}
+	if b, ok := books.Book("EUR", "USD"); !ok || !near(b.Mid, 1/0.90) || b.SpreadBps != 10 {
+		t.Errorf("EUR:USD = %+v, %v; want the inverse book", b, ok)
+	}
+	if _, ok := books.Book("USD", "GBP"); ok {
+		t.Error("USD:GBP quoted without a book")
+	}
+}
@@ -0,0 +1,243 @@
+// Package tenant isolates the business units sharing this service. Every
+// request is resolved to a tenant, the tenant travels in the context, and the
+// database pool sets it on each connection as app.tenant_id, which the
+// row-level security policies of db/migrations/0013_tenants.sql filter on.
+package tenant
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Env is the variable Parse reads API keys from.
+const Env = "TENANT_KEYS"
+
+// Default is the tenant that owned every row before tenants existed.
+const Default = "default"
+
+// All lets a background worker read every tenant's rows, e.g. to claim the
+// outbox or find due schedules. Rows it writes must belong to one tenant:
+// settlement scopes its transaction to the job's tenant. Handlers never
+// resolve to All.
+const All = "*"
+
+// Header narrows a back-office request to one tenant.
+const Header = "X-Tenant-ID"
+
+// ErrUnauthorized rejects a request that names no known tenant.
+var ErrUnauthorized = errors.New("tenant API key required")
+
+// ErrBypassesRLS is returned by CheckRole for a database role that row-level
+// security does not bind.
+var ErrBypassesRLS = errors.New("database role bypasses row-level security")
+
+var tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type ctxKey struct{}
+
+// Keys maps API keys to tenant ids.
+type Keys map[string]string
+
+// Parse reads a comma-separated list of tenant:key pairs. An empty list is
+// valid; requests must then carry the tenant in their authorizer context.
+func Parse(s string) (Keys, error) {
+	keys := Keys{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(pair, ":")
+		if !ok || !Valid(id) || len(key) < 16 {
+			return nil, fmt.Errorf("%s: want tenant:key with a lower-case tenant id and a key of at least 16 characters, got %q", Env, id)
+		}
+		if _, dup := keys[key]; dup {
+			return nil, fmt.Errorf("%s: key of %s is not unique", Env, id)
+		}
+		keys[key] = id
+	}
+	return keys, nil
+}
+
+// Resolve returns the request's tenant: the tenant_id an API Gateway
+// authorizer put in the request context, or else the tenant whose key the
+// "Authorization: Bearer" header carries. Every key is compared in constant
+// time.
+func (k Keys) Resolve(evt events.APIGatewayProxyRequest) (string, error) {
+	if id, ok := evt.RequestContext.Authorizer["tenant_id"].(string); ok {
+		if !Valid(id) {
+			return "", ErrUnauthorized
+		}
+		return id, nil
+	}
+	var header string
+	for h, v := range evt.Headers {
+		if strings.EqualFold(h, "Authorization") {
+			header = v
+		}
+	}
+	return k.Bearer(header)
+}
+
+// Bearer returns the tenant whose key an Authorization header value carries.
+func (k Keys) Bearer(authorization string) (string, error) {
+	key, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || key == "" {
+		return "", ErrUnauthorized
+	}
+	id := ""
+	for known, tenant := range k {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(key)) == 1 {
+			id = tenant
+		}
+	}
+	if id == "" {
+		return "", ErrUnauthorized
+	}
+	return id, nil
+}
+
+// Token returns a token that authenticates as the tenant owning key for
+// userID's requests until exp, for clients that cannot send the key itself:
+// a browser's EventSource sets no headers. It reads
+// <tenant>.<unix expiry>.<hex HMAC-SHA256 keyed with key>.
+func (k Keys) Token(key, userID string, exp time.Time) (string, error) {
+	id, err := k.Bearer("Bearer " + key)
+	if err != nil {
+		return "", err
+	}
+	payload := id + "." + strconv.FormatInt(exp.Unix(), 10)
+	return payload + "." + tokenMAC(key, payload, userID), nil
+}
+
+// VerifyToken returns the tenant of a token Token issued for userID, unless
+// it expired before now. Any of the tenant's keys may have signed it.
+func (k Keys) VerifyToken(token, userID string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !Valid(parts[0]) {
+		return "", ErrUnauthorized
+	}
+	id, payload, sig := parts[0], parts[0]+"."+parts[1], parts[2]
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > exp {
+		return "", ErrUnauthorized
+	}
+	valid := false
+	for key, tenant := range k {
+		if tenant == id && hmac.Equal([]byte(tokenMAC(key, payload, userID)), []byte(sig)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return "", ErrUnauthorized
+	}
+	return id, nil
+}
+
+func tokenMAC(key, payload, userID string) string {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte(payload + "." + userID))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Operator returns the tenant an operator's request names in Header, or All
+// when it names none.
+func Operator(evt events.APIGatewayProxyRequest) (string, error) {
+	for h, v := range evt.Headers {
+		if strings.EqualFold(h, Header) && v != "" {
+			if !Valid(v) {
+				return "", fmt.Errorf("%s: %q is not a tenant id", Header, v)
+			}
+			return v, nil
+		}
+	}
+	return All, nil
+}
+
+// With returns ctx scoped to the tenant, which is also added to the log
+// attributes.
+func With(ctx context.Context, id string) context.Context {
+	return logging.With(context.WithValue(ctx, ctxKey{}, id), "tenant_id", id)
+}
+
+// FromContext returns the tenant of ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Options make a stdlib pool set app.tenant_id to the tenant of the
+// context each connection is taken out with. Outside a tenant the setting
+// is reset to the connection default, which matches no rows unless the DSN
+// sets one. database/sql only resets connections that have been used, so a
+// connection it opens in the background for a waiting request keeps that
+// default: it sees nothing rather than another tenant's rows.
+func Options() []stdlib.OptionOpenDB {
+	return []stdlib.OptionOpenDB{stdlib.OptionAfterConnect(apply), stdlib.OptionResetSession(apply)}
+}
+
+func apply(ctx context.Context, conn *pgx.Conn) error {
+	id := FromContext(ctx)
+	if id == "" {
+		_, err := conn.Exec(ctx, `RESET app.tenant_id`)
+		return err
+	}
+	_, err := conn.Exec(ctx, `SELECT set_config('app.tenant_id', $1, false)`, id)
+	return err
+}
+
+// Execer is the part of *sql.Tx that Scope needs.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Scope narrows the rest of the transaction to the tenant, whatever the
+// connection is set to. Workers running as All call it once they have locked
+// the row they act on, so that what they write belongs to its tenant.
+func Scope(ctx context.Context, tx Execer, id string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, true)`, id); err != nil {
+		return fmt.Errorf("scope to tenant %s: %w", id, err)
+	}
+	return nil
+}
+
+// Querier is satisfied by *sql.DB and *sql.Conn.
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// CheckRole fails when the connection's role is a superuser or has
+// BYPASSRLS: every tenant's rows would be visible to it. Binaries call it at
+// start up and refuse to serve as such a role (connect as jobs_app, see
+// db/migrations/0018_app_role.sql).
+func CheckRole(ctx context.Context, q Querier) error {
+	var role string
+	var bypass bool
+	err := q.QueryRowContext(ctx, `SELECT rolname, rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&role, &bypass)
+	if err != nil {
+		return fmt.Errorf("check database role: %w", err)
+	}
+	if bypass {
+		return fmt.Errorf("%w: %s", ErrBypassesRLS, role)
+	}
+	return nil
+}
+
+// Valid reports whether id is a well-formed tenant id.
+func Valid(id string) bool {
+	return tenantID.MatchString(id)
+}
@@ -0,0 +1,102 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParse(t *testing.T) {
+	keys, err := Parse(" acme:aaaaaaaaaaaaaaaa, globex:bbbbbbbbbbbbbbbb ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys["aaaaaaaaaaaaaaaa"] != "acme" || keys["bbbbbbbbbbbbbbbb"] != "globex" {
+		t.Errorf("keys = %v", keys)
+	}
+	for _, bad := range []string{"acme", "acme:short", ":aaaaaaaaaaaaaaaa", "Acme:aaaaaaaaaaaaaaaa", "*:aaaaaaaaaaaaaaaa", "a:aaaaaaaaaaaaaaaa,b:aaaaaaaaaaaaaaaa"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	keys := Keys{"aaaaaaaaaaaaaaaa": "acme"}
+	req := func(header string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"authorization": header}}
+	}
+	if id, err := keys.Resolve(req("Bearer aaaaaaaaaaaaaaaa")); err != nil || id != "acme" {
+		t.Errorf("tenant = %q, %v; want acme", id, err)
+	}
+	for _, header := range []string{"", "Bearer ", "Bearer bbbbbbbbbbbbbbbb", "aaaaaaaaaaaaaaaa"} {
+		if _, err := keys.Resolve(req(header)); err != ErrUnauthorized {
+			t.Errorf("%q: err = %v, want ErrUnauthorized", header, err)
+		}
+	}
+
+	// The authorizer's tenant wins over any key
+	authorized := req("Bearer aaaaaaaaaaaaaaaa")
+	authorized.RequestContext.Authorizer = map[string]any{"tenant_id": "globex"}
+	if id, err := keys.Resolve(authorized); err != nil || id != "globex" {
+		t.Errorf("authorizer tenant = %q, %v; want globex", id, err)
+	}
+	authorized.RequestContext.Authorizer = map[string]any{"tenant_id": All}
+	if _, err := keys.Resolve(authorized); err != ErrUnauthorized {
+		t.Errorf("authorizer tenant %q: err = %v, want ErrUnauthorized", All, err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	keys := Keys{"aaaaaaaaaaaaaaaa": "acme", "cccccccccccccccc": "acme", "bbbbbbbbbbbbbbbb": "globex"}
+	now := time.Now()
+	token, err := keys.Token("aaaaaaaaaaaaaaaa", "u1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := keys.VerifyToken(token, "u1", now); err != nil || id != "acme" {
+		t.Errorf("tenant = %q, %v; want acme", id, err)
+	}
+	// The tenant's other key does not change what its tokens prove
+	if id, err := (Keys{"aaaaaaaaaaaaaaaa": "acme"}).VerifyToken(token, "u1", now); err != nil || id != "acme" {
+		t.Errorf("after rotating out the other key: %q, %v", id, err)
+	}
+	for name, tc := range map[string]struct {
+		token, user string
+		at          time.Time
+	}{
+		"other user":   {token, "u2", now},
+		"expired":      {token, "u1", now.Add(2 * time.Minute)},
+		"other tenant": {strings.Replace(token, "acme", "globex", 1), "u1", now},
+		"later expiry": {strings.Replace(token, ".", ".9", 1), "u1", now},
+		"no signature": {strings.Join(strings.Split(token, ".")[:2], "."), "u1", now},
+		"empty":        {"", "u1", now},
+	} {
+		if _, err := keys.VerifyToken(tc.token, tc.user, tc.at); err != ErrUnauthorized {
+			t.Errorf("%s: err = %v, want ErrUnauthorized", name, err)
+		}
+	}
+	if _, err := (Keys{"cccccccccccccccc": "acme"}).VerifyToken(token, "u1", now); err != ErrUnauthorized {
+		t.Errorf("revoked signing key: err = %v, want ErrUnauthorized", err)
+	}
+	if _, err := keys.Token("dddddddddddddddd", "u1", now); err != ErrUnauthorized {
+		t.Errorf("unknown key: err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestOperator(t *testing.T) {
+	req := func(headers map[string]string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: headers}
+	}
+	if id, err := Operator(req(nil)); err != nil || id != All {
+		t.Errorf("without header: %q, %v; want All", id, err)
+	}
+	if id, err := Operator(req(map[string]string{"x-tenant-id": "acme"})); err != nil || id != "acme" {
+		t.Errorf("with header: %q, %v; want acme", id, err)
+	}
+	if _, err := Operator(req(map[string]string{Header: All})); err == nil {
+		t.Error("header naming All succeeded")
+	}
+}
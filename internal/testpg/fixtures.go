@@ -0,0 +1,294 @@
+package testpg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/internal/tenant"
+)
+
+// Funds maps currency to opening balance, e.g. Funds{"USD": 5000, "EUR": 0}.
+type Funds map[string]float64
+
+// FundedUser creates a new user with one account per currency in funds and
+// returns the user id.
+func FundedUser(t testing.TB, db *sql.DB, funds Funds) string {
+	t.Helper()
+	user := "u-" + uuid.NewString()[:8]
+	for cur, bal := range funds {
+		if _, err := db.ExecContext(context.Background(), `INSERT INTO accounts (user_id, currency, balance) VALUES ($1,$2,$3)`, user, cur, bal); err != nil {
+			t.Fatalf("fund %s %s: %v", user, cur, err)
+		}
+	}
+	return user
+}
+
+// Balance returns the user's balance in currency (0 when the account is missing).
+func Balance(t testing.TB, db *sql.DB, user, currency string) float64 {
+	t.Helper()
+	var b float64
+	err := db.QueryRowContext(context.Background(), `SELECT COALESCE((SELECT balance FROM accounts WHERE user_id=$1 AND currency=$2), 0)`, user, currency).Scan(&b)
+	if err != nil {
+		t.Fatalf("balance %s %s: %v", user, currency, err)
+	}
+	return b
+}
+
+// AssertBalance fails t unless the balance equals want to 8 decimal places.
+func AssertBalance(t testing.TB, db *sql.DB, user, currency string, want float64) {
+	t.Helper()
+	if got := Balance(t, db, user, currency); math.Abs(got-want) > 1e-8 {
+		t.Errorf("%s %s balance = %.8f, want %.8f", user, currency, got, want)
+	}
+}
+
+// AssertHeld fails t unless the amount held on the account equals want.
+func AssertHeld(t testing.TB, db *sql.DB, user, currency string, want float64) {
+	t.Helper()
+	var got float64
+	if err := db.QueryRowContext(context.Background(), `SELECT COALESCE((SELECT held FROM accounts WHERE user_id=$1 AND currency=$2), 0)`, user, currency).Scan(&got); err != nil {
+		t.Fatalf("held %s %s: %v", user, currency, err)
+	}
+	if math.Abs(got-want) > 1e-8 {
+		t.Errorf("%s %s held = %.8f, want %.8f", user, currency, got, want)
+	}
+}
+
+// LedgerEntry is one row of ledger_entries.
+type LedgerEntry struct {
+	EntryType string
+	Currency  string
+	Amount    float64
+}
+
+// Ledger returns the ledger entries posted for a job, debits first.
+func Ledger(t testing.TB, db *sql.DB, jobID string) []LedgerEntry {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), `SELECT entry_type, currency, amount FROM ledger_entries WHERE job_id=$1 ORDER BY entry_type, created_at`, jobID)
+	if err != nil {
+		t.Fatalf("ledger %s: %v", jobID, err)
+	}
+	defer rows.Close()
+	var out []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.EntryType, &e.Currency, &e.Amount); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// AssertLedgerMatchesBalances fails t unless, for every account of user,
+// credits minus debits equal the balance minus opening.
+func AssertLedgerMatchesBalances(t testing.TB, db *sql.DB, user string, opening Funds) {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), `SELECT a.currency, a.balance,
+			COALESCE(SUM(CASE l.entry_type WHEN 'credit' THEN l.amount ELSE -l.amount END), 0)
+		FROM accounts a LEFT JOIN ledger_entries l ON l.account_id = a.account_id
+		WHERE a.user_id=$1 GROUP BY a.currency, a.balance`, user)
+	if err != nil {
+		t.Fatalf("ledger sums: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cur string
+		var bal, net float64
+		if err := rows.Scan(&cur, &bal, &net); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(opening[cur]+net-bal) > 1e-6 {
+			t.Errorf("%s %s: opening %.8f + ledger %.8f != balance %.8f", user, cur, opening[cur], net, bal)
+		}
+	}
+}
+
+// Job is the subset of conversion_jobs tests assert on.
+type Job struct {
+	Status       string
+	TargetAmount sql.NullFloat64
+	Rate         sql.NullFloat64
+	Fee          sql.NullFloat64
+	FeeVersion   sql.NullInt64
+	HeldAmount   float64
+	Metadata     map[string]any
+}
+
+// LoadJob returns the job row or fails t.
+func LoadJob(t testing.TB, db *sql.DB, jobID string) Job {
+	t.Helper()
+	var j Job
+	var meta []byte
+	err := db.QueryRowContext(context.Background(), `SELECT status, target_amount, rate, fee, fee_schedule_version, held_amount, metadata FROM conversion_jobs WHERE job_id=$1`, jobID).
+		Scan(&j.Status, &j.TargetAmount, &j.Rate, &j.Fee, &j.FeeVersion, &j.HeldAmount, &meta)
+	if err != nil {
+		t.Fatalf("load job %s: %v", jobID, err)
+	}
+	_ = json.Unmarshal(meta, &j.Metadata)
+	return j
+}
+
+// QueuedJob inserts a queued job with no hold, as jobs from before holds
+// were, and returns its id.
+func QueuedJob(t testing.TB, db *sql.DB, user, source, target string, amount float64) string {
+	t.Helper()
+	id := uuid.NewString()
+	if _, err := db.ExecContext(context.Background(), `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, status) VALUES ($1,$2,$3,$4,$5,'queued')`,
+		id, user, source, target, amount); err != nil {
+		t.Fatalf("insert job: %v", err)
+	}
+	return id
+}
+
+// HeldJob inserts a queued job holding its amount, the way POST /jobs does,
+// and returns its id.
+func HeldJob(t testing.TB, db *sql.DB, user, source, target string, amount float64) string {
+	t.Helper()
+	id := QueuedJob(t, db, user, source, target, amount)
+	hold(t, db, id, user, source, amount)
+	return id
+}
+
+// LimitOrder inserts a pending limit order the way POST /jobs does, holding
+// its amount, and returns its id. A zero expiresAt never expires.
+func LimitOrder(t testing.TB, db *sql.DB, user, source, target string, amount, limitRate float64, expiresAt time.Time) string {
+	t.Helper()
+	id := uuid.NewString()
+	var expires any
+	if !expiresAt.IsZero() {
+		expires = expiresAt
+	}
+	if _, err := db.ExecContext(context.Background(), `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, status, limit_rate, expires_at) VALUES ($1,$2,$3,$4,$5,'pending',$6,$7)`,
+		id, user, source, target, amount, limitRate, expires); err != nil {
+		t.Fatalf("insert limit order: %v", err)
+	}
+	hold(t, db, id, user, source, amount)
+	return id
+}
+
+func hold(t testing.TB, db *sql.DB, jobID, user, currency string, amount float64) {
+	t.Helper()
+	if _, err := db.ExecContext(context.Background(), `UPDATE accounts SET held = held + $3 WHERE user_id=$1 AND currency=$2`, user, currency, amount); err != nil {
+		t.Fatalf("hold funds: %v", err)
+	}
+	if _, err := db.ExecContext(context.Background(), `UPDATE conversion_jobs SET held_amount=$2 WHERE job_id=$1`, jobID, amount); err != nil {
+		t.Fatalf("hold funds: %v", err)
+	}
+}
+
+// Batch groups the queued jobs into a new batch, in order, the way
+// POST /jobs/batch does and returns the batch id.
+func Batch(t testing.TB, db *sql.DB, user string, allOrNothing bool, jobIDs ...string) string {
+	t.Helper()
+	id := uuid.NewString()
+	if _, err := db.ExecContext(context.Background(), `INSERT INTO conversion_batches (batch_id, client_id, all_or_nothing, size) VALUES ($1,$2,$3,$4)`,
+		id, user, allOrNothing, len(jobIDs)); err != nil {
+		t.Fatalf("insert batch: %v", err)
+	}
+	for i, jobID := range jobIDs {
+		if _, err := db.ExecContext(context.Background(), `UPDATE conversion_jobs SET batch_id=$1, batch_index=$2 WHERE job_id=$3`, id, i, jobID); err != nil {
+			t.Fatalf("add job to batch: %v", err)
+		}
+	}
+	return id
+}
+
+// OutboxEvent is one outbox row.
+type OutboxEvent struct {
+	Topic   string
+	Payload map[string]any
+}
+
+// Outbox returns the outbox rows for an aggregate id, oldest first.
+func Outbox(t testing.TB, db *sql.DB, aggregateID string) []OutboxEvent {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), `SELECT topic, payload FROM outbox WHERE aggregate_id=$1 ORDER BY created_at`, aggregateID)
+	if err != nil {
+		t.Fatalf("outbox %s: %v", aggregateID, err)
+	}
+	defer rows.Close()
+	var out []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&e.Topic, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(payload, &e.Payload); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// AppendOutbox inserts an unprocessed outbox row the way the settlement core
+// does and returns its outbox_id.
+func AppendOutbox(t testing.TB, db *sql.DB, aggregateID, topic string, payload map[string]any) string {
+	t.Helper()
+	raw, _ := json.Marshal(payload)
+	var id string
+	if err := db.QueryRowContext(context.Background(), `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ('conversion_job',$1,$2,$3) RETURNING outbox_id`,
+		aggregateID, topic, raw).Scan(&id); err != nil {
+		t.Fatalf("append outbox: %v", err)
+	}
+	return id
+}
+
+// Tenant creates a tenant that converts between currencies (any when none
+// are given) and returns its id. Use tenant.With to act as it.
+func Tenant(t testing.TB, db *sql.DB, currencies ...string) string {
+	t.Helper()
+	id := "t-" + uuid.NewString()[:8]
+	var list any
+	if len(currencies) > 0 {
+		list = "{" + strings.Join(currencies, ",") + "}"
+	}
+	if _, err := db.ExecContext(context.Background(), `INSERT INTO tenants (tenant_id, name, currencies) VALUES ($1,$1,$2)`, id, list); err != nil {
+		t.Fatalf("create tenant: %v", err)
+	}
+	return id
+}
+
+// APIRequest builds an API Gateway proxy request. A "?a=b&c=d" suffix on path
+// becomes query string parameters; body is JSON-encoded unless it is a string.
+// The authorizer context names tenant.Default.
+func APIRequest(method, path string, body any) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{HTTPMethod: method, Path: path, Headers: map[string]string{}, QueryStringParameters: map[string]string{}}
+	req.RequestContext.Authorizer = map[string]any{"tenant_id": tenant.Default}
+	if p, q, ok := strings.Cut(path, "?"); ok {
+		req.Path = p
+		for _, kv := range strings.Split(q, "&") {
+			k, v, _ := strings.Cut(kv, "=")
+			req.QueryStringParameters[k] = v
+		}
+	}
+	switch b := body.(type) {
+	case nil:
+	case string:
+		req.Body = b
+	default:
+		raw, _ := json.Marshal(b)
+		req.Body = string(raw)
+	}
+	return req
+}
+
+// SQSEvent wraps JSON-encoded bodies as SQS records.
+func SQSEvent(bodies ...any) events.SQSEvent {
+	var evt events.SQSEvent
+	for i, b := range bodies {
+		raw, _ := json.Marshal(b)
+		evt.Records = append(evt.Records, events.SQSMessage{MessageId: fmt.Sprintf("msg-%d-%d", time.Now().UnixNano(), i), Body: string(raw)})
+	}
+	return evt
+}
@@ -0,0 +1,102 @@
+package testpg
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Conversion is one operation of a stress workload.
+type Conversion struct {
+	User           string
+	Source, Target string
+	Amount         float64
+}
+
+// StressOps returns the number of operations a stress test should fire:
+// STRESS_OPS if set, else 2000. Stress tests are skipped with -short.
+func StressOps(t testing.TB) int {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("stress test skipped in -short mode")
+	}
+	if n, err := strconv.Atoi(os.Getenv("STRESS_OPS")); err == nil && n > 0 {
+		return n
+	}
+	return 2000
+}
+
+// Workload returns n conversions between random pairs of currencies for
+// random users. It is generated from seed, so every run fires the same
+// operations; only their interleaving varies.
+func Workload(seed uint64, n int, users []string, currencies ...string) []Conversion {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	out := make([]Conversion, n)
+	for i := range out {
+		src := rng.IntN(len(currencies))
+		tgt := (src + 1 + rng.IntN(len(currencies)-1)) % len(currencies)
+		out[i] = Conversion{
+			User:   users[rng.IntN(len(users))],
+			Source: currencies[src],
+			Target: currencies[tgt],
+			Amount: float64(1+rng.IntN(20000)) / 100, // 0.01 .. 200.00
+		}
+	}
+	return out
+}
+
+// Parallel runs fn for every operation on workers goroutines.
+func Parallel[T any](workers int, ops []T, fn func(T)) {
+	ch := make(chan T)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range ch {
+				fn(op)
+			}
+		}()
+	}
+	for _, op := range ops {
+		ch <- op
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// AssertNoNegativeBalances fails t if any account balance is below zero.
+func AssertNoNegativeBalances(t testing.TB, db *sql.DB) {
+	t.Helper()
+	var n int
+	if err := db.QueryRowContext(context.Background(), `SELECT count(*) FROM accounts WHERE balance < 0`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n > 0 {
+		t.Errorf("%d accounts have a negative balance", n)
+	}
+}
+
+// JobStatuses counts the jobs of users by status.
+func JobStatuses(t testing.TB, db *sql.DB, users []string) map[string]int {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), `SELECT status, count(*) FROM conversion_jobs WHERE client_id = ANY($1) GROUP BY status`, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			t.Fatal(err)
+		}
+		out[status] = n
+	}
+	return out
+}
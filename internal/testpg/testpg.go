@@ -0,0 +1,229 @@
+// Package testpg provides a migrated Postgres database for integration tests.
+//
+// The server is taken from TEST_DATABASE_URL when set; otherwise a throwaway
+// cluster is started with the initdb/pg_ctl binaries found in PG_BIN or PATH.
+// Each test binary gets its own freshly created database with db/migrations
+// applied. When neither is available, tests calling DB are skipped.
+//
+// Tests log in as a role of their own rather than the superuser that ran the
+// migrations, and it assumes jobs_app (db/migrations/0018_app_role.sql) on
+// login: they run with the service's privileges, and row-level security
+// applies to them as it does to the service. Connections default to tenant.Default. Empty hands out blank
+// databases, as the superuser, for tests of the migrations themselves.
+package testpg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/irajwani/microservice-go/internal/migrate"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var (
+	shared     *sql.DB
+	adminDSN   string
+	skipReason string
+)
+
+// Run prepares the package database, hands it to setup (typically to install
+// it in the handler's package-level db variable), runs the tests and tears the
+// database down. Use it from TestMain:
+//
+//	func TestMain(m *testing.M) { os.Exit(testpg.Run(m, func(d *sql.DB) { db = d })) }
+func Run(m *testing.M, setup func(*sql.DB)) int {
+	dsn, stop, err := server()
+	if err != nil {
+		skipReason = err.Error()
+		return m.Run()
+	}
+	defer stop()
+	adminDSN = dsn
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	name := fmt.Sprintf("test_%d_%d", os.Getpid(), rand.IntN(1_000_000))
+	admin, err := sql.Open("pgx", adminDSN)
+	if err != nil {
+		return fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.ExecContext(ctx, `CREATE DATABASE `+name); err != nil {
+		return fatal(fmt.Errorf("create database: %w", err))
+	}
+	// One teardown: roles are per cluster, so the role goes once the database
+	// (and its grants) is gone
+	defer func() {
+		_, _ = admin.ExecContext(context.Background(), `DROP DATABASE IF EXISTS `+name+` WITH (FORCE)`)
+		_, _ = admin.ExecContext(context.Background(), `DROP ROLE IF EXISTS `+name)
+	}()
+
+	u, _ := url.Parse(adminDSN)
+	u.Path = "/" + name
+	if err := migrateDB(ctx, u.String()); err != nil {
+		return fatal(err)
+	}
+	pass := strconv.FormatUint(rand.Uint64(), 36)
+	if _, err := admin.ExecContext(ctx, `CREATE ROLE `+name+` LOGIN PASSWORD '`+pass+`'`); err != nil {
+		return fatal(fmt.Errorf("create role: %w", err))
+	}
+	if err := assumeAppRole(ctx, admin, name); err != nil {
+		return fatal(err)
+	}
+
+	u.User = url.UserPassword(name, pass)
+	q := u.Query()
+	q.Set("app.tenant_id", tenant.Default)
+	u.RawQuery = q.Encode()
+	cfg, err := pgx.ParseConfig(u.String())
+	if err != nil {
+		return fatal(err)
+	}
+	db := stdlib.OpenDB(*cfg, tenant.Options()...)
+	defer db.Close()
+	shared = db
+	if setup != nil {
+		setup(db)
+	}
+	return m.Run()
+}
+
+func migrateDB(ctx context.Context, dsn string) error {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Up(ctx); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	return nil
+}
+
+// assumeAppRole makes role switch to jobs_app whenever it connects to the
+// database of the same name.
+func assumeAppRole(ctx context.Context, admin *sql.DB, role string) error {
+	for _, q := range []string{
+		`GRANT jobs_app TO ` + role,
+		`ALTER ROLE ` + role + ` IN DATABASE ` + role + ` SET role = jobs_app`,
+	} {
+		if _, err := admin.ExecContext(ctx, q); err != nil {
+			return fmt.Errorf("assume jobs_app: %w", err)
+		}
+	}
+	return nil
+}
+
+// DB returns the package database, skipping t when no Postgres is available.
+func DB(t testing.TB) *sql.DB {
+	t.Helper()
+	if shared == nil {
+		t.Skipf("integration test needs Postgres: %s", skipReason)
+	}
+	return shared
+}
+
+// Empty creates a database with no migrations applied and returns a
+// superuser connection to it. The database is dropped when t ends.
+func Empty(t testing.TB) *sql.DB {
+	t.Helper()
+	DB(t)
+	ctx := context.Background()
+	admin, err := sql.Open("pgx", adminDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("test_%d_%d", os.Getpid(), rand.IntN(1_000_000))
+	if _, err := admin.ExecContext(ctx, `CREATE DATABASE `+name); err != nil {
+		admin.Close()
+		t.Fatalf("create database: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.ExecContext(ctx, `DROP DATABASE IF EXISTS `+name+` WITH (FORCE)`)
+		admin.Close()
+	})
+	u, _ := url.Parse(adminDSN)
+	u.Path = "/" + name
+	db, err := sql.Open("pgx", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func fatal(err error) int {
+	fmt.Fprintln(os.Stderr, "testpg:", err)
+	return 1
+}
+
+// server returns an admin DSN for a server that can create databases.
+func server() (dsn string, stop func(), err error) {
+	if v := os.Getenv("TEST_DATABASE_URL"); v != "" {
+		return v, func() {}, nil
+	}
+	initdb, err := lookPG("initdb")
+	if err != nil {
+		return "", nil, fmt.Errorf("set TEST_DATABASE_URL or put initdb/pg_ctl on PATH (or PG_BIN)")
+	}
+	pgctl, err := lookPG("pg_ctl")
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "testpg")
+	if err != nil {
+		return "", nil, err
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -F", port, dir)
+	if out, err := exec.Command(pgctl, "-D", data, "-o", opts, "-l", filepath.Join(dir, "log"), "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	stop = func() {
+		_ = exec.Command(pgctl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return "postgres://postgres@127.0.0.1:" + strconv.Itoa(port) + "/postgres?sslmode=disable", stop, nil
+}
+
+func lookPG(name string) (string, error) {
+	if bin := os.Getenv("PG_BIN"); bin != "" {
+		return exec.LookPath(filepath.Join(bin, name))
+	}
+	return exec.LookPath(name)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
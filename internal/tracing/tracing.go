@@ -0,0 +1,212 @@
+// Package tracing wires OpenTelemetry for the Lambdas: tracer provider and
+// exporter setup, W3C context propagation over SQS message attributes and
+// API Gateway style headers, and pgx query spans.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/irajwani/microservice-go"
+
+var provider *sdktrace.TracerProvider
+
+// Init installs the global tracer provider for service. The exporter is chosen
+// by OTEL_TRACES_EXPORTER: "otlp" (default when OTEL_EXPORTER_OTLP_ENDPOINT is
+// set; configured through the standard OTEL_EXPORTER_OTLP_* variables),
+// "stdout", or "none" (default otherwise, spans are still created so trace ids
+// propagate).
+func Init(ctx context.Context, service string) error {
+	exporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	if exporter == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		exporter = "otlp"
+	}
+	var opts []sdktrace.TracerProviderOption
+	switch exporter {
+	case "otlp":
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return fmt.Errorf("otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "stdout":
+		exp, err := stdouttrace.New()
+		if err != nil {
+			return fmt.Errorf("stdout exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithSyncer(exp))
+	}
+	install(service, opts...)
+	return nil
+}
+
+// InitWithExporter installs a provider that exports synchronously to exp, e.g.
+// tracetest.NewInMemoryExporter() in tests.
+func InitWithExporter(service string, exp sdktrace.SpanExporter) {
+	install(service, sdktrace.WithSyncer(exp))
+}
+
+func install(service string, opts ...sdktrace.TracerProviderOption) {
+	res := resource.NewSchemaless(attribute.String("service.name", service))
+	provider = sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Flush exports buffered spans. Lambdas call it before returning because the
+// execution environment may be frozen between invocations.
+func Flush(ctx context.Context) {
+	if provider != nil {
+		_ = provider.ForceFlush(ctx)
+	}
+}
+
+// Tracer returns the service tracer.
+func Tracer() trace.Tracer { return otel.Tracer(instrumentation) }
+
+// Start is shorthand for Tracer().Start.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End records err (if any) on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartHandler starts the server span for an API Gateway request, continuing
+// any trace context carried in its headers.
+func StartHandler(ctx context.Context, evt events.APIGatewayProxyRequest) (context.Context, trace.Span) {
+	ctx = ExtractHeaders(ctx, evt.Headers)
+	route := evt.Resource
+	if route == "" {
+		route = evt.Path
+	}
+	return Start(ctx, evt.HTTPMethod+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", evt.HTTPMethod),
+			attribute.String("url.path", evt.Path),
+			attribute.String("aws.request_id", evt.RequestContext.RequestID),
+		))
+}
+
+// SetStatus records the HTTP response status on the handler span.
+func SetStatus(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+	}
+}
+
+// InjectHeaders writes the trace context of ctx into headers.
+func InjectHeaders(ctx context.Context, headers map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// ExtractHeaders returns ctx continuing the trace context found in headers
+// (header names are matched case-insensitively).
+func ExtractHeaders(ctx context.Context, headers map[string]string) context.Context {
+	lower := make(map[string]string, len(headers))
+	for k, v := range headers {
+		lower[strings.ToLower(k)] = v
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(lower))
+}
+
+// InjectSQS adds the trace context of ctx to outgoing SQS message attributes.
+func InjectSQS(ctx context.Context, attrs map[string]sqstypes.MessageAttributeValue) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for k, v := range carrier {
+		attrs[k] = sqstypes.MessageAttributeValue{DataType: strPtr("String"), StringValue: strPtr(v)}
+	}
+}
+
+// ExtractSQS returns ctx continuing the trace context carried by an SQS record.
+func ExtractSQS(ctx context.Context, r events.SQSMessage) context.Context {
+	carrier := propagation.MapCarrier{}
+	for k, v := range r.MessageAttributes {
+		if v.StringValue != nil {
+			carrier[k] = *v.StringValue
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// OpenDB opens a database/sql pool over pgx with query spans enabled.
+func OpenDB(dsn string, opts ...stdlib.OptionOpenDB) (*sql.DB, error) {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Tracer = QueryTracer{}
+	return stdlib.OpenDB(*cfg, opts...), nil
+}
+
+// QueryTracer is a pgx.QueryTracer emitting one client span per statement.
+type QueryTracer struct{}
+
+type querySpanKey struct{}
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx // keep pool pings and background queries out of traces
+	}
+	ctx, span := Start(ctx, "db.query", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.query.text", statement(data.SQL)),
+		))
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	End(span, data.Err)
+}
+
+// JobPhase records a span for a job phase that has already happened, such as
+// the time a job spent queued between created_at and pickup.
+func JobPhase(ctx context.Context, name string, start, end time.Time) {
+	_, span := Start(ctx, name, trace.WithTimestamp(start))
+	span.End(trace.WithTimestamp(end))
+}
+
+func statement(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > 512 {
+		sql = sql[:512]
+	}
+	return sql
+}
+
+func strPtr(s string) *string { return &s }
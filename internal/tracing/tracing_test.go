@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSQSPropagationAndJobPhases(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	InitWithExporter("test", exp)
+
+	ctx, producer := Start(context.Background(), "POST /jobs")
+	attrs := map[string]sqstypes.MessageAttributeValue{}
+	InjectSQS(ctx, attrs)
+	producer.End()
+
+	rec := events.SQSMessage{MessageAttributes: map[string]events.SQSMessageAttribute{}}
+	for k, v := range attrs {
+		rec.MessageAttributes[k] = events.SQSMessageAttribute{DataType: *v.DataType, StringValue: v.StringValue}
+	}
+	consumerCtx, consumer := Start(ExtractSQS(context.Background(), rec), "process job")
+	created := time.Now().Add(-3 * time.Second)
+	JobPhase(consumerCtx, "job.queued", created, created.Add(2*time.Second))
+	consumer.End()
+
+	spans := exp.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	traceID := spans[0].SpanContext.TraceID()
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != traceID {
+			t.Fatalf("span %q not in producer trace", s.Name)
+		}
+	}
+	queued := spans[1]
+	if queued.Name != "job.queued" || queued.EndTime.Sub(queued.StartTime) != 2*time.Second {
+		t.Fatalf("unexpected queued span %q duration %s", queued.Name, queued.EndTime.Sub(queued.StartTime))
+	}
+}
+
+func TestExtractHeadersCaseInsensitive(t *testing.T) {
+	InitWithExporter("test", tracetest.NewInMemoryExporter())
+	ctx, span := Start(context.Background(), "client")
+	defer span.End()
+	headers := map[string]string{}
+	InjectHeaders(ctx, headers)
+	upper := map[string]string{"Traceparent": headers["traceparent"]}
+	got := ExtractHeaders(context.Background(), upper)
+	if _, s := Start(got, "server"); s.SpanContext().TraceID() != span.SpanContext().TraceID() {
+		t.Fatal("trace context not continued from Traceparent header")
+	}
+}
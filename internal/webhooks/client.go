@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned for an endpoint on a loopback, private,
+// link-local or unspecified address: deliveries must not reach the service's
+// own network or the instance metadata endpoint.
+var ErrBlockedAddress = errors.New("address not allowed for webhooks")
+
+// ValidateURL checks an endpoint at subscription time: an absolute https URL
+// whose host, when it is an IP literal or localhost, is not blocked. Hostnames
+// are checked again on every delivery, once resolved (see NewClient).
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("url must be an absolute https URL")
+	}
+	if u.Hostname() == "localhost" {
+		return fmt.Errorf("%w: localhost", ErrBlockedAddress)
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && blocked(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with. Every connection is
+// checked after DNS resolution, so a hostname that resolves (or is rebound) to
+// a blocked address fails with ErrBlockedAddress. Redirects are not followed:
+// a 3xx is the endpoint's response, and is retried like any other non-2xx.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed in place of the endpoint, bypassing the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// control runs once the address to connect to is resolved.
+func control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if blocked(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+// blockedPrefixes are the IPv4 ranges net/netip has no predicate for.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and the broadcast address
+}
+
+func blocked(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
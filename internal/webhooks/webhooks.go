@@ -0,0 +1,90 @@
+// Package webhooks holds what the webhook API, the dispatcher and client
+// receivers share: the event types clients can subscribe to, the request
+// signature, the retry schedule and the endpoints deliveries may reach.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events clients can subscribe to; they are the "event" field of the
+// conversion-events outbox payloads.
+var Events = []string{"conversion.completed", "conversion.failed", "conversion.cancelled", "conversion.reversed"}
+
+// Request headers set on every delivery.
+const (
+	SignatureHeader = "Webhook-Signature" // t=<unix seconds>,v1=<hex HMAC-SHA256>
+	IDHeader        = "Webhook-Id"        // delivery id, stable across retries
+	EventHeader     = "Webhook-Event"
+)
+
+// MaxAttempts is how often a delivery is tried before it is marked failed.
+const MaxAttempts = 8
+
+// Backoff returns the delay before retrying after the given (1-based) failed
+// attempt: 30s, 1m, 2m, ... capped at 1h.
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 8 {
+		return time.Hour
+	}
+	return min(30*time.Second<<(attempt-1), time.Hour)
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign returns the SignatureHeader value for body sent at t. The MAC covers
+// "<unix seconds>.<body>" so a captured request cannot be replayed later with
+// a fresh timestamp.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+// Verify checks a SignatureHeader value against body, rejecting signatures
+// older than tolerance (zero disables the age check).
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("malformed signature header")
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, body))) {
+		return errors.New("signature mismatch")
+	}
+	if age := time.Since(time.Unix(unix, 0)); tolerance > 0 && age > tolerance {
+		return fmt.Errorf("signature too old (%s)", age.Round(time.Second))
+	}
+	return nil
+}
+
+func mac(secret, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"event":"conversion.completed"}`)
+	header := Sign("s3cret", time.Now(), body)
+	if err := Verify("s3cret", header, body, 5*time.Minute); err != nil {
+		t.Fatalf("Verify(valid) = %v", err)
+	}
+	if err := Verify("other", header, body, 5*time.Minute); err == nil {
+		t.Error("Verify accepted the wrong secret")
+	}
+	if err := Verify("s3cret", header, []byte(`{"event":"conversion.failed"}`), 5*time.Minute); err == nil {
+		t.Error("Verify accepted a tampered body")
+	}
+	if err := Verify("s3cret", Sign("s3cret", time.Now().Add(-time.Hour), body), body, 5*time.Minute); err == nil {
+		t.Error("Verify accepted a stale signature")
+	}
+	if err := Verify("s3cret", "v1=abc", body, 0); err == nil {
+		t.Error("Verify accepted a header without timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}
+	for i, w := range want {
+		if got := Backoff(i + 1); got != w {
+			t.Errorf("Backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://client.example/hooks":   true,
+		"https://203.0.113.7/hooks":      true,
+		"http://client.example/hooks":    false,
+		"/hooks":                         false,
+		"https://":                       false,
+		"https://localhost/hooks":        false,
+		"https://127.0.0.1/hooks":        false,
+		"https://10.0.3.4/hooks":         false,
+		"https://192.168.1.1/hooks":      false,
+		"https://169.254.169.254/latest": false,
+		"https://0.0.0.0/hooks":          false,
+		"https://[::1]/hooks":            false,
+		"https://[::ffff:10.0.0.1]/x":    false,
+		"https://[fe80::1]/hooks":        false,
+		"https://[fd00::1]/hooks":        false,
+		"https://0.1.2.3/hooks":          false,
+		"https://100.64.0.1/hooks":       false,
+		"https://100.127.255.254/hooks":  false,
+		"https://100.128.0.1/hooks":      true,
+		"https://255.255.255.255/hooks":  false,
+		"https://240.0.0.1/hooks":        false,
+		"https://224.0.0.251/hooks":      false,
+		"https://239.1.2.3/hooks":        false,
+		"https://[ff02::1]/hooks":        false,
+		"https://[ff0e::1]/hooks":        false,
+		"https://[::ffff:100.64.0.1]/x":  false,
+	} {
+		if err := ValidateURL(raw); (err == nil) != ok {
+			t.Errorf("ValidateURL(%q) = %v, want ok %v", raw, err, ok)
+		}
+	}
+}
+
+func TestClientRefusesBlockedAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+	client := NewClient(time.Second)
+	// The loopback literal and a hostname resolving to it are both refused
+	for _, u := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		resp, err := client.Get(u)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("GET %s = %v, want ErrBlockedAddress", u, err)
+		}
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+	client := NewClient(time.Second)
+	client.Transport = srv.Client().Transport // reach the loopback test server
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status = %d, want the 302 itself", resp.StatusCode)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// svc is backed by the test database; SQS publishing is left to the outbox.
+var svc *Service
+
+func TestMain(m *testing.M) {
+	os.Exit(testpg.Run(m, func(d *sql.DB) { svc = &Service{Store: pgStore{db: d}} }))
+}
+
+func TestCreateJobWritesJobAndOutbox(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 500})
+
+	req := testpg.APIRequest(http.MethodPost, "/jobs", JobRequest{ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100})
+	req.Headers["X-Correlation-ID"] = "corr-1"
+	resp, err := svc.Handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d err %v body %s", resp.StatusCode, err, resp.Body)
+	}
+	var job JobResponse
+	if err := json.Unmarshal([]byte(resp.Body), &job); err != nil {
+		t.Fatal(err)
+	}
+	if got := testpg.LoadJob(t, pg, job.JobID).Status; got != "queued" {
+		t.Errorf("status = %q, want queued", got)
+	}
+	events := testpg.Outbox(t, pg, job.JobID)
+	if len(events) != 1 || events[0].Topic != "conversion-jobs" {
+		t.Fatalf("outbox = %+v, want one conversion-jobs row", events)
+	}
+	if events[0].Payload["correlation_id"] != "corr-1" {
+		t.Errorf("outbox correlation_id = %v", events[0].Payload["correlation_id"])
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 500) // nothing settles until the consumer runs
+	testpg.AssertHeld(t, pg, user, "USD", 100)
+}
+
+func TestCreateJobHoldsAvailableFunds(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100})
+	body := JobRequest{ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100}
+
+	if resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first job: status %d body %s", resp.StatusCode, resp.Body)
+	}
+	// The first job holds the whole balance, so neither a second job nor a batch can queue
+	if resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body)); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("second job: status %d, want 422", resp.StatusCode)
+	}
+	batch := BatchRequest{ClientID: user, Jobs: []JobRequest{{SourceCurrency: "USD", TargetCurrency: "GBP", SourceAmount: 1}}}
+	if resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs/batch", batch)); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("batch: status %d, want 422", resp.StatusCode)
+	}
+	var jobs int
+	if err := pg.QueryRow(`SELECT count(*) FROM conversion_jobs WHERE client_id=$1`, user).Scan(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if jobs != 1 {
+		t.Errorf("jobs = %d, want only the first", jobs)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 100)
+	testpg.AssertHeld(t, pg, user, "USD", 100)
+}
+
+func TestCreateJobRefusesBlockedAccounts(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 100, "EUR": 0})
+	body := JobRequest{ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 10}
+
+	if _, err := pg.Exec(`UPDATE accounts SET status='frozen' WHERE user_id=$1 AND currency='EUR'`, user); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body)); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("frozen target: status %d, want 403 (%s)", resp.StatusCode, resp.Body)
+	}
+	if _, err := pg.Exec(`UPDATE accounts SET status='active' WHERE user_id=$1`, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pg.Exec(`INSERT INTO users (user_id, status) VALUES ($1,'closed')`, user); err != nil {
+		t.Fatal(err)
+	}
+	batch := BatchRequest{ClientID: user, Jobs: []JobRequest{{SourceCurrency: "USD", TargetCurrency: "GBP", SourceAmount: 1}}}
+	if resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs/batch", batch)); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("closed user batch: status %d, want 403 (%s)", resp.StatusCode, resp.Body)
+	}
+	testpg.AssertHeld(t, pg, user, "USD", 0)
+}
+
+func TestCreateJobRefusesCurrenciesTheTenantHasNotEnabled(t *testing.T) {
+	pg := testpg.DB(t)
+	req := testpg.APIRequest(http.MethodPost, "/jobs", JobRequest{ClientID: "u-currency", SourceCurrency: "USD", TargetCurrency: "GBP", SourceAmount: 10})
+	req.RequestContext.Authorizer["tenant_id"] = testpg.Tenant(t, pg, "USD", "EUR")
+	if resp, _ := svc.Handler(context.Background(), req); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d, want 400 (%s)", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestCreateJobIdempotencyKey(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 500})
+	key := "idem-" + user
+	body := JobRequest{ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 10, IdempotencyKey: &key}
+
+	first, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body))
+	second, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body))
+	if first.StatusCode != http.StatusCreated || second.StatusCode != http.StatusOK {
+		t.Fatalf("statuses %d, %d; want 201, 200", first.StatusCode, second.StatusCode)
+	}
+	var a, b JobResponse
+	_ = json.Unmarshal([]byte(first.Body), &a)
+	_ = json.Unmarshal([]byte(second.Body), &b)
+	if a.JobID != b.JobID {
+		t.Errorf("replay returned job %s, want %s", b.JobID, a.JobID)
+	}
+	if n := len(testpg.Outbox(t, pg, a.JobID)); n != 1 {
+		t.Errorf("outbox rows = %d, want 1", n)
+	}
+}
+
+func TestConcurrentRequestsWithOneIdempotencyKeyCreateOneJob(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 500})
+	key := "idem-race-" + user
+	body := JobRequest{ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 10, IdempotencyKey: &key}
+
+	resps := make(chan events.APIGatewayProxyResponse, 8)
+	testpg.Parallel(8, make([]JobRequest, 8), func(JobRequest) {
+		resp, err := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body))
+		if err != nil {
+			t.Error(err)
+		}
+		resps <- resp
+	})
+	close(resps)
+	created, jobIDs := 0, map[string]bool{}
+	for resp := range resps {
+		switch resp.StatusCode {
+		case http.StatusCreated:
+			created++
+		case http.StatusOK:
+		default:
+			t.Fatalf("status %d (%s), want 201 or a 200 replay", resp.StatusCode, resp.Body)
+		}
+		var job JobResponse
+		_ = json.Unmarshal([]byte(resp.Body), &job)
+		jobIDs[job.JobID] = true
+	}
+	if created != 1 || len(jobIDs) != 1 {
+		t.Errorf("%d created, %d distinct jobs; want one of each", created, len(jobIDs))
+	}
+	testpg.AssertHeld(t, pg, user, "USD", 10)
+}
+
+func TestCreateLimitOrderHoldsFunds(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 150})
+	limit := 0.95
+	body := JobRequest{ClientID: user, SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 100, LimitRate: &limit}
+
+	resp, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d body %s", resp.StatusCode, resp.Body)
+	}
+	var job JobResponse
+	_ = json.Unmarshal([]byte(resp.Body), &job)
+	if got := testpg.LoadJob(t, pg, job.JobID).Status; got != "pending" {
+		t.Errorf("status = %q, want pending", got)
+	}
+	if n := len(testpg.Outbox(t, pg, job.JobID)); n != 0 {
+		t.Errorf("outbox rows = %d, want none until the limit is met", n)
+	}
+	testpg.AssertBalance(t, pg, user, "USD", 150)
+	testpg.AssertHeld(t, pg, user, "USD", 100)
+
+	// Only 50 USD is left available for a second order
+	resp, _ = svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs", body))
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("second order: status %d, want 422", resp.StatusCode)
+	}
+	testpg.AssertHeld(t, pg, user, "USD", 100)
+}
+
+func TestCreateJobRejectsInvalidRequests(t *testing.T) {
+	testpg.DB(t)
+	cases := map[string]struct {
+		method, path, body string
+		want               int
+	}{
+		"bad json":       {http.MethodPost, "/jobs", `{`, http.StatusBadRequest},
+		"missing client": {http.MethodPost, "/jobs", `{"source_currency":"USD","target_currency":"EUR","source_amount":1}`, http.StatusBadRequest},
+		"bad amount":     {http.MethodPost, "/jobs", `{"client_id":"c","source_currency":"USD","target_currency":"EUR","source_amount":0}`, http.StatusBadRequest},
+		"unknown route":  {http.MethodGet, "/jobs", ``, http.StatusNotFound},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resp, _ := svc.Handler(context.Background(), testpg.APIRequest(c.method, c.path, c.body))
+			if resp.StatusCode != c.want {
+				t.Errorf("status = %d, want %d (%s)", resp.StatusCode, c.want, resp.Body)
+			}
+		})
+	}
+}
+
+func TestCreateBatchWritesLegsInOneBatch(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 500})
+	key := "batch-" + user
+	body := BatchRequest{ClientID: user, AllOrNothing: true, Jobs: []JobRequest{
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 10, IdempotencyKey: &key},
+		{SourceCurrency: "USD", TargetCurrency: "GBP", SourceAmount: 20},
+	}}
+
+	resp, err := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs/batch", body))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d err %v body %s", resp.StatusCode, err, resp.Body)
+	}
+	var batch BatchResponse
+	if err := json.Unmarshal([]byte(resp.Body), &batch); err != nil {
+		t.Fatal(err)
+	}
+	var size, legs int
+	if err := pg.QueryRow(`SELECT size, (SELECT count(*) FROM conversion_jobs WHERE batch_id = b.batch_id) FROM conversion_batches b WHERE batch_id=$1`, batch.BatchID).Scan(&size, &legs); err != nil {
+		t.Fatal(err)
+	}
+	if size != 2 || legs != 2 {
+		t.Errorf("batch size %d with %d legs, want 2 and 2", size, legs)
+	}
+	testpg.AssertHeld(t, pg, user, "USD", 30)
+	for _, item := range batch.Items {
+		events := testpg.Outbox(t, pg, item.Job.JobID)
+		if len(events) != 1 || events[0].Payload["batch_id"] != batch.BatchID || events[0].Payload["all_or_nothing"] != true {
+			t.Errorf("leg %d outbox = %+v", item.Index, events)
+		}
+	}
+
+	// the keyed item replays into its original batch
+	replay, _ := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs/batch", BatchRequest{ClientID: user, Jobs: body.Jobs[:1]}))
+	var again BatchResponse
+	_ = json.Unmarshal([]byte(replay.Body), &again)
+	if replay.StatusCode != http.StatusOK || again.BatchID != batch.BatchID || again.Items[0].Job.JobID != batch.Items[0].Job.JobID {
+		t.Errorf("replay = %d %s", replay.StatusCode, replay.Body)
+	}
+}
+
+func TestConcurrentBatchesHoldWithoutDeadlock(t *testing.T) {
+	pg := testpg.DB(t)
+	user := testpg.FundedUser(t, pg, testpg.Funds{"USD": 1000, "EUR": 1000})
+	// Half the batches list USD first and half EUR first; each holds 1 in both
+	usdFirst := BatchRequest{ClientID: user, Jobs: []JobRequest{
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 0.5},
+		{SourceCurrency: "EUR", TargetCurrency: "USD", SourceAmount: 1},
+		{SourceCurrency: "USD", TargetCurrency: "GBP", SourceAmount: 0.5},
+	}}
+	eurFirst := BatchRequest{ClientID: user, Jobs: []JobRequest{usdFirst.Jobs[1], usdFirst.Jobs[0], usdFirst.Jobs[2]}}
+	ops := make([]BatchRequest, 100)
+	for i := range ops {
+		ops[i] = usdFirst
+		if i%2 == 1 {
+			ops[i] = eurFirst
+		}
+	}
+
+	statuses := make(chan int, len(ops))
+	testpg.Parallel(16, ops, func(body BatchRequest) {
+		resp, err := svc.Handler(context.Background(), testpg.APIRequest(http.MethodPost, "/jobs/batch", body))
+		if err != nil {
+			t.Error(err)
+		}
+		statuses <- resp.StatusCode
+	})
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusCreated {
+			t.Errorf("batch status %d, want 201", status)
+		}
+	}
+	testpg.AssertHeld(t, pg, user, "USD", 100)
+	testpg.AssertHeld(t, pg, user, "EUR", 100)
+}
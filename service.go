@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/logging"
+	"github.com/irajwani/microservice-go/internal/metrics"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/tenant"
+	"github.com/irajwani/microservice-go/internal/tracing"
+)
+
+// JobRequest represents an incoming job creation payload. With LimitRate the
+// job is a limit order: it stays pending, with its amount held, until the
+// rate reaches LimitRate, and is cancelled at ExpiresAt if it has not.
+type JobRequest struct {
+	ClientID       string     `json:"client_id"`
+	SourceCurrency string     `json:"source_currency"`
+	TargetCurrency string     `json:"target_currency"`
+	SourceAmount   float64    `json:"source_amount"`
+	IdempotencyKey *string    `json:"idempotency_key,omitempty"`
+	LimitRate      *float64   `json:"limit_rate,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// JobResponse represents the response returned to the caller
+type JobResponse struct {
+	JobID          string     `json:"job_id"`
+	Status         string     `json:"status"`
+	ClientID       string     `json:"client_id"`
+	SourceCurrency string     `json:"source_currency"`
+	TargetCurrency string     `json:"target_currency"`
+	SourceAmount   float64    `json:"source_amount"`
+	IdempotencyKey *string    `json:"idempotency_key,omitempty"`
+	CorrelationID  string     `json:"correlation_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	BatchID        string     `json:"batch_id,omitempty"`
+	AllOrNothing   bool       `json:"all_or_nothing,omitempty"`
+	LimitRate      *float64   `json:"limit_rate,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// Store persists jobs and their outbox rows.
+type Store interface {
+	// JobByIdempotencyKey returns the job created with key, or sql.ErrNoRows.
+	JobByIdempotencyKey(ctx context.Context, key string) (JobResponse, error)
+	// CreateJob inserts the queued job, a hold on its amount and its outbox
+	// row in one transaction and returns the outbox id. A limit order is
+	// inserted pending instead, and has no outbox row. Without enough
+	// available funds it returns errInsufficientFunds, for a frozen or
+	// closed user or account an accountBlockedError, for a currency the
+	// tenant has not enabled errCurrencyNotEnabled, and when another job
+	// already has its idempotency key errIdempotencyKeyTaken.
+	CreateJob(ctx context.Context, job JobResponse, payload []byte) (outboxID string, err error)
+	// CreateBatch inserts the batch, its legs with their holds and one outbox
+	// row per leg in one transaction and returns the outbox ids in leg order.
+	// It returns errInsufficientFunds, an accountBlockedError or
+	// errCurrencyNotEnabled, and writes nothing, when any leg's amount cannot
+	// be held, and errIdempotencyKeyTaken when a concurrent request took a
+	// leg's idempotency key.
+	CreateBatch(ctx context.Context, batch BatchResponse, legs []BatchItem, payloads [][]byte) (outboxIDs []string, err error)
+	// MarkPublished records that the outbox row has been delivered.
+	MarkPublished(ctx context.Context, outboxID string) error
+}
+
+// Publisher delivers job messages to the conversion queue.
+type Publisher interface {
+	Publish(ctx context.Context, body []byte, attrs map[string]string) error
+}
+
+// errInsufficientFunds rejects a job whose amount cannot be held.
+var errInsufficientFunds = errors.New("insufficient available funds")
+
+// errIdempotencyKeyTaken rejects a job whose idempotency key a concurrent
+// request committed first.
+var errIdempotencyKeyTaken = errors.New("idempotency_key was used by a concurrent request")
+
+// errCurrencyNotEnabled rejects a job in a currency the tenant does not
+// convert.
+var errCurrencyNotEnabled = errors.New("currency not enabled for tenant")
+
+// accountBlockedError rejects a job for a frozen or closed user or account;
+// reason is the settlement failure reason, e.g. ACCOUNT_FROZEN.
+type accountBlockedError struct{ reason string }
+
+func (e accountBlockedError) Error() string { return "account blocked: " + e.reason }
+
+// Service handles POST /jobs and POST /jobs/batch. A nil Publisher leaves delivery to the outbox.
+// Tenants maps API keys to tenants for callers the authorizer has not resolved.
+type Service struct {
+	Store     Store
+	Publisher Publisher
+	Tenants   tenant.Keys
+}
+
+// validate checks req, returning a *settlement.InvalidField naming the
+// offending field.
+func validate(req JobRequest) error {
+	if req.ClientID == "" {
+		return &settlement.InvalidField{Field: "client_id", Err: errors.New("client_id is required")}
+	}
+	if req.LimitRate != nil && *req.LimitRate <= 0 {
+		return &settlement.InvalidField{Field: "limit_rate", Err: settlement.ErrLimitRate}
+	}
+	if req.ExpiresAt != nil {
+		if req.LimitRate == nil {
+			return &settlement.InvalidField{Field: "expires_at", Err: errors.New("expires_at requires limit_rate")}
+		}
+		if !req.ExpiresAt.After(time.Now()) {
+			return &settlement.InvalidField{Field: "expires_at", Err: errors.New("expires_at must be in the future")}
+		}
+	}
+	// Same rules the settlement core applies when the job is processed
+	return settlement.Validate(settlement.Job{SourceCurrency: req.SourceCurrency, TargetCurrency: req.TargetCurrency, SourceAmount: req.SourceAmount})
+}
+
+// fieldError reports a validation error of the job at prefix in the request
+// body, e.g. "jobs[2].", under the field it names.
+func fieldError(prefix string, err error) problem.FieldError {
+	field := strings.TrimSuffix(prefix, ".")
+	var invalid *settlement.InvalidField
+	if errors.As(err, &invalid) {
+		field = prefix + invalid.Field
+	}
+	return problem.FieldError{Field: field, Detail: err.Error()}
+}
+
+// replays reports whether jr asks for the job existing was created as, so that
+// reusing its idempotency key returns it rather than conflicting. Amounts and
+// rates are compared to the precision they are stored at.
+func replays(existing JobResponse, jr JobRequest) bool {
+	same := func(a, b float64) bool { return math.Abs(a-b) < 5e-9 }
+	if (existing.LimitRate == nil) != (jr.LimitRate == nil) || existing.LimitRate != nil && !same(*existing.LimitRate, *jr.LimitRate) {
+		return false
+	}
+	return existing.ClientID == jr.ClientID && existing.SourceCurrency == jr.SourceCurrency &&
+		existing.TargetCurrency == jr.TargetCurrency && same(existing.SourceAmount, jr.SourceAmount)
+}
+
+// rejected answers a store error: the problem for a job that cannot be held,
+// or a server error.
+func rejected(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	var blocked accountBlockedError
+	switch {
+	case errors.Is(err, errInsufficientFunds):
+		return problem.Respond(ctx, http.StatusUnprocessableEntity, problem.InsufficientFunds, err.Error())
+	case errors.As(err, &blocked):
+		return problem.Respond(ctx, http.StatusForbidden, blocked.reason, err.Error())
+	case errors.Is(err, errCurrencyNotEnabled):
+		return problem.Respond(ctx, http.StatusBadRequest, problem.CurrencyNotEnabled, err.Error())
+	case errors.Is(err, errIdempotencyKeyTaken):
+		return problem.Respond(ctx, http.StatusConflict, problem.IdempotencyConflict, err.Error())
+	}
+	return problem.ServerError(ctx, err)
+}
+
+// replayed answers a request whose idempotency key created existing: with
+// the job when the request replays it, else with a conflict.
+func replayed(ctx context.Context, existing JobResponse, jr JobRequest) (events.APIGatewayProxyResponse, error) {
+	if !replays(existing, jr) {
+		return problem.Respond(ctx, http.StatusConflict, problem.IdempotencyConflict, fmt.Sprintf("idempotency_key was used for job %s", existing.JobID))
+	}
+	slog.InfoContext(ctx, "idempotent replay", "job_id", existing.JobID)
+	b, _ := json.Marshal(existing)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(b)}, nil
+}
+
+// Handler supports API Gateway REST proxy POST /jobs and POST /jobs/batch
+func (s *Service) Handler(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, span := tracing.StartHandler(ctx, evt)
+	defer tracing.Flush(ctx)
+	resp, err := api.Spec.Wrap(s.handle, "CreateJob", "CreateBatch")(ctx, evt)
+	tracing.SetStatus(span, resp.StatusCode)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *Service) handle(ctx context.Context, evt events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	correlationID := logging.FromRequest(evt)
+	ctx = logging.WithCorrelationID(ctx, correlationID)
+	tenantID, err := s.Tenants.Resolve(evt)
+	if err != nil {
+		return problem.Respond(ctx, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+	}
+	ctx = tenant.With(ctx, tenantID)
+
+	// Basic routing: only care about POST /jobs and POST /jobs/batch
+	if evt.HTTPMethod == http.MethodPost && evt.Path == "/jobs/batch" {
+		return s.createBatch(ctx, evt, correlationID)
+	}
+	if evt.HTTPMethod != http.MethodPost || evt.Path != "/jobs" {
+		return problem.Respond(ctx, http.StatusNotFound, problem.NotFound, "")
+	}
+
+	var jr JobRequest
+	if err := json.Unmarshal([]byte(evt.Body), &jr); err != nil {
+		return problem.Invalid(fmt.Sprintf("invalid json: %v", err)).Response(ctx), nil
+	}
+	if err := validate(jr); err != nil {
+		return problem.Invalid(err.Error(), fieldError("", err)).Response(ctx), nil
+	}
+	ctx = logging.With(ctx, "user_id", jr.ClientID)
+
+	// Context with timeout for DB ops
+	opCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Idempotency: if key present, return existing job if found
+	if jr.IdempotencyKey != nil {
+		existing, err := s.Store.JobByIdempotencyKey(opCtx, *jr.IdempotencyKey)
+		switch {
+		case err == nil:
+			return replayed(ctx, existing, jr)
+		case !errors.Is(err, sql.ErrNoRows):
+			return problem.ServerError(ctx, fmt.Errorf("idempotency lookup: %w", err))
+		}
+	}
+
+	resp := JobResponse{
+		JobID:          uuid.NewString(),
+		Status:         "queued",
+		ClientID:       jr.ClientID,
+		SourceCurrency: jr.SourceCurrency,
+		TargetCurrency: jr.TargetCurrency,
+		SourceAmount:   jr.SourceAmount,
+		IdempotencyKey: jr.IdempotencyKey,
+		CorrelationID:  correlationID,
+		CreatedAt:      time.Now().UTC(),
+		LimitRate:      jr.LimitRate,
+		ExpiresAt:      jr.ExpiresAt,
+	}
+	if jr.LimitRate != nil {
+		resp.Status = settlement.StatusPending
+	}
+	ctx = logging.With(ctx, "job_id", resp.JobID)
+	payload, _ := json.Marshal(resp)
+
+	outboxID, err := s.Store.CreateJob(opCtx, resp, payload)
+	if errors.Is(err, errIdempotencyKeyTaken) {
+		// A concurrent request with the same key committed its job first
+		existing, err := s.Store.JobByIdempotencyKey(opCtx, *jr.IdempotencyKey)
+		if err != nil {
+			return problem.ServerError(ctx, fmt.Errorf("idempotency lookup: %w", err))
+		}
+		return replayed(ctx, existing, jr)
+	}
+	if err != nil {
+		return rejected(ctx, err)
+	}
+	metrics.Count(metrics.JobsCreated, 1, metrics.Pair(jr.SourceCurrency, jr.TargetCurrency))
+
+	// Publish to SQS (best effort). Failure does not roll back DB commit.
+	// Limit orders are settled by cmd/limits instead.
+	if s.Publisher != nil && outboxID != "" {
+		publishCtx, cancelPub := context.WithTimeout(ctx, 2*time.Second)
+		defer cancelPub()
+		if err := s.Publisher.Publish(publishCtx, payload, map[string]string{logging.Attribute: correlationID}); err != nil {
+			slog.WarnContext(ctx, "failed to publish SQS message", "error", err)
+		} else if err := s.Store.MarkPublished(publishCtx, outboxID); err != nil {
+			slog.WarnContext(ctx, "failed to mark outbox row processed", "outbox_id", outboxID, "error", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "job created", "status", resp.Status, "source_currency", jr.SourceCurrency, "target_currency", jr.TargetCurrency, "source_amount", jr.SourceAmount)
+	b, _ := json.Marshal(resp)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusCreated, Headers: map[string]string{"Content-Type": "application/json", logging.Header: correlationID}, Body: string(b)}, nil
+}
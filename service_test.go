@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/irajwani/microservice-go/api"
+	"github.com/irajwani/microservice-go/internal/problem"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/irajwani/microservice-go/internal/testpg"
+)
+
+// memStore is an in-memory Store.
+type memStore struct {
+	jobs      map[string]JobResponse // by idempotency key
+	outbox    map[string][]byte
+	published []string
+	failWith  error
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: map[string]JobResponse{}, outbox: map[string][]byte{}}
+}
+
+func (s *memStore) JobByIdempotencyKey(_ context.Context, key string) (JobResponse, error) {
+	if j, ok := s.jobs[key]; ok {
+		return j, nil
+	}
+	return JobResponse{}, sql.ErrNoRows
+}
+
+func (s *memStore) CreateJob(_ context.Context, job JobResponse, payload []byte) (string, error) {
+	if s.failWith != nil {
+		return "", s.failWith
+	}
+	if job.IdempotencyKey != nil {
+		s.jobs[*job.IdempotencyKey] = job
+	}
+	if job.LimitRate != nil {
+		return "", nil
+	}
+	id := "outbox-" + job.JobID
+	s.outbox[id] = payload
+	return id, nil
+}
+
+func (s *memStore) CreateBatch(ctx context.Context, _ BatchResponse, legs []BatchItem, payloads [][]byte) ([]string, error) {
+	if s.failWith != nil {
+		return nil, s.failWith
+	}
+	ids := make([]string, len(legs))
+	for i, leg := range legs {
+		ids[i], _ = s.CreateJob(ctx, leg.Job, payloads[i])
+	}
+	return ids, nil
+}
+
+func (s *memStore) MarkPublished(_ context.Context, outboxID string) error {
+	s.published = append(s.published, outboxID)
+	return nil
+}
+
+// memPublisher records published messages.
+type memPublisher struct {
+	bodies   [][]byte
+	attrs    []map[string]string
+	failWith error
+}
+
+func (p *memPublisher) Publish(_ context.Context, body []byte, attrs map[string]string) error {
+	if p.failWith != nil {
+		return p.failWith
+	}
+	p.bodies = append(p.bodies, body)
+	p.attrs = append(p.attrs, attrs)
+	return nil
+}
+
+func createJob(t *testing.T, s *Service, body JobRequest) (int, JobResponse) {
+	t.Helper()
+	req := testpg.APIRequest(http.MethodPost, "/jobs", body)
+	req.Headers["X-Correlation-ID"] = "corr-unit"
+	resp, err := s.Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	var out JobResponse
+	_ = json.Unmarshal([]byte(resp.Body), &out)
+	return resp.StatusCode, out
+}
+
+func TestServicePublishesAndMarksOutbox(t *testing.T) {
+	store, pub := newMemStore(), &memPublisher{}
+	status, job := createJob(t, &Service{Store: store, Publisher: pub}, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5})
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d", status)
+	}
+	if len(pub.bodies) != 1 || pub.attrs[0]["correlation_id"] != "corr-unit" {
+		t.Fatalf("published %d messages, attrs %v", len(pub.bodies), pub.attrs)
+	}
+	var msg JobResponse
+	if err := json.Unmarshal(pub.bodies[0], &msg); err != nil || msg.JobID != job.JobID {
+		t.Errorf("message = %s", pub.bodies[0])
+	}
+	if len(store.published) != 1 || store.published[0] != "outbox-"+job.JobID {
+		t.Errorf("marked = %v", store.published)
+	}
+}
+
+func TestServiceLeavesOutboxPendingWhenPublishFails(t *testing.T) {
+	store := newMemStore()
+	s := &Service{Store: store, Publisher: &memPublisher{failWith: errors.New("queue down")}}
+	if status, _ := createJob(t, s, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5}); status != http.StatusCreated {
+		t.Fatalf("status = %d, want 201 (publishing is best effort)", status)
+	}
+	if len(store.outbox) != 1 || len(store.published) != 0 {
+		t.Errorf("outbox %d rows, %d marked; want 1 pending", len(store.outbox), len(store.published))
+	}
+}
+
+func TestServiceIdempotentReplaySkipsStore(t *testing.T) {
+	store := newMemStore()
+	s := &Service{Store: store}
+	key := "k1"
+	req := JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key}
+	_, first := createJob(t, s, req)
+	store.failWith = errors.New("must not insert again")
+	status, second := createJob(t, s, req)
+	if status != http.StatusOK || second.JobID != first.JobID {
+		t.Errorf("replay = %d %s, want 200 %s", status, second.JobID, first.JobID)
+	}
+}
+
+// racingStore commits winner between the handler's idempotency lookup and its
+// insert, as a concurrent request with the same key would.
+type racingStore struct {
+	*memStore
+	winner JobResponse
+}
+
+func (s racingStore) CreateJob(context.Context, JobResponse, []byte) (string, error) {
+	s.jobs[*s.winner.IdempotencyKey] = s.winner
+	return "", errIdempotencyKeyTaken
+}
+
+func TestServiceReplaysJobOfConcurrentRequest(t *testing.T) {
+	key := "k1"
+	req := JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key}
+	winner := JobResponse{JobID: uuid.NewString(), Status: "queued", CreatedAt: time.Now().UTC(), ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key}
+	status, job := createJob(t, &Service{Store: racingStore{newMemStore(), winner}}, req)
+	if status != http.StatusOK || job.JobID != winner.JobID {
+		t.Errorf("create = %d %s, want 200 %s", status, job.JobID, winner.JobID)
+	}
+
+	req.SourceAmount = 6
+	if status, _ := createJob(t, &Service{Store: racingStore{newMemStore(), winner}}, req); status != http.StatusConflict {
+		t.Errorf("different request: status = %d, want 409", status)
+	}
+}
+
+func TestServiceLimitOrderIsNotPublished(t *testing.T) {
+	store, pub := newMemStore(), &memPublisher{}
+	limit, expires := 0.95, time.Now().Add(time.Hour)
+	status, job := createJob(t, &Service{Store: store, Publisher: pub}, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, LimitRate: &limit, ExpiresAt: &expires})
+	if status != http.StatusCreated || job.Status != "pending" || job.LimitRate == nil || *job.LimitRate != limit {
+		t.Fatalf("create = %d %+v, want a pending limit order", status, job)
+	}
+	if len(pub.bodies) != 0 || len(store.outbox) != 0 {
+		t.Errorf("limit order published (%d) or given an outbox row (%d)", len(pub.bodies), len(store.outbox))
+	}
+}
+
+func TestServiceRejectsBadLimitOrders(t *testing.T) {
+	zero, limit, past := 0.0, 1.0, time.Now().Add(-time.Minute)
+	for name, req := range map[string]JobRequest{
+		"zero limit":          {LimitRate: &zero},
+		"expiry without rate": {ExpiresAt: &past},
+		"past expiry":         {LimitRate: &limit, ExpiresAt: &past},
+	} {
+		req.ClientID, req.SourceCurrency, req.TargetCurrency, req.SourceAmount = "c1", "USD", "EUR", 5
+		if status, _ := createJob(t, &Service{Store: newMemStore()}, req); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, status)
+		}
+	}
+}
+
+func TestServiceStoreFailureIs500(t *testing.T) {
+	store := newMemStore()
+	store.failWith = errors.New("connection refused")
+	if status, _ := createJob(t, &Service{Store: store}, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5}); status != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", status)
+	}
+}
+
+func TestServiceInsufficientFundsIs422(t *testing.T) {
+	store := newMemStore()
+	store.failWith = errInsufficientFunds
+	svc := &Service{Store: store}
+	if status, _ := createJob(t, svc, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5}); status != http.StatusUnprocessableEntity {
+		t.Errorf("job status = %d, want 422", status)
+	}
+	batch := BatchRequest{ClientID: "c1", Jobs: []JobRequest{{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5}}}
+	if status, _, body := createBatch(t, svc, batch); status != http.StatusUnprocessableEntity {
+		t.Errorf("batch status = %d, want 422 (%s)", status, body)
+	}
+}
+
+func TestServiceBlockedAccountIs403(t *testing.T) {
+	store := newMemStore()
+	store.failWith = accountBlockedError{reason: settlement.ReasonAccountFrozen}
+	svc := &Service{Store: store}
+	if status, _ := createJob(t, svc, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5}); status != http.StatusForbidden {
+		t.Errorf("job status = %d, want 403", status)
+	}
+	batch := BatchRequest{ClientID: "c1", Jobs: []JobRequest{{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5}}}
+	if status, _, body := createBatch(t, svc, batch); status != http.StatusForbidden || !strings.Contains(body, settlement.ReasonAccountFrozen) {
+		t.Errorf("batch status = %d, want 403 (%s)", status, body)
+	}
+}
+
+func createBatch(t *testing.T, s *Service, body any) (int, BatchResponse, string) {
+	t.Helper()
+	req := testpg.APIRequest(http.MethodPost, "/jobs/batch", body)
+	resp, err := s.Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+	var out BatchResponse
+	_ = json.Unmarshal([]byte(resp.Body), &out)
+	return resp.StatusCode, out, resp.Body
+}
+
+func TestServiceBatchPublishesEveryLeg(t *testing.T) {
+	store, pub := newMemStore(), &memPublisher{}
+	s := &Service{Store: store, Publisher: pub}
+	key := "k1"
+	_, first := createJob(t, s, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key})
+
+	status, batch, body := createBatch(t, s, BatchRequest{ClientID: "c1", AllOrNothing: true, Jobs: []JobRequest{
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 1},
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key},
+		{SourceCurrency: "EUR", TargetCurrency: "GBP", SourceAmount: 2},
+	}})
+	if status != http.StatusCreated || len(batch.Items) != 3 {
+		t.Fatalf("status = %d, body %s", status, body)
+	}
+	if batch.Items[1].Status != "replayed" || batch.Items[1].Job.JobID != first.JobID {
+		t.Errorf("item 1 = %+v, want replay of %s", batch.Items[1], first.JobID)
+	}
+	if len(pub.bodies) != 3 { // the single job plus two new legs
+		t.Fatalf("published %d messages, want 3", len(pub.bodies))
+	}
+	var msg JobResponse
+	if err := json.Unmarshal(pub.bodies[2], &msg); err != nil || msg.BatchID != batch.BatchID || !msg.AllOrNothing || msg.ClientID != "c1" {
+		t.Errorf("leg message = %s", pub.bodies[2])
+	}
+	if len(store.published) != 3 {
+		t.Errorf("marked = %v", store.published)
+	}
+
+	// replaying only keyed items reports them without writing
+	store.failWith = errors.New("must not insert again")
+	status, replay, _ := createBatch(t, s, BatchRequest{ClientID: "c1", Jobs: []JobRequest{{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key}}})
+	if status != http.StatusOK || replay.Items[0].Job.JobID != first.JobID {
+		t.Errorf("replay = %d %+v", status, replay)
+	}
+}
+
+func TestServiceBatchReportsItemErrors(t *testing.T) {
+	key := "k"
+	status, _, body := createBatch(t, &Service{Store: newMemStore()}, BatchRequest{ClientID: "c1", Jobs: []JobRequest{
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 1, IdempotencyKey: &key},
+		{SourceCurrency: "USD", TargetCurrency: "USD", SourceAmount: 1},
+		{ClientID: "c2", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 1},
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 1, IdempotencyKey: &key},
+	}})
+	var out problem.Problem
+	_ = json.Unmarshal([]byte(body), &out)
+	if status != http.StatusBadRequest || out.Code != problem.InvalidRequest || len(out.Errors) != 3 {
+		t.Fatalf("status = %d, body %s; want 400 for items 1, 2 and 3", status, body)
+	}
+	for i, want := range []string{"jobs[1].target_currency", "jobs[2].client_id", "jobs[3].idempotency_key"} {
+		if out.Errors[i].Field != want {
+			t.Errorf("errors[%d].field = %q, want %q", i, out.Errors[i].Field, want)
+		}
+	}
+	if status, _, _ := createBatch(t, &Service{Store: newMemStore()}, BatchRequest{ClientID: "c1"}); status != http.StatusBadRequest {
+		t.Errorf("empty batch: status = %d, want 400", status)
+	}
+}
+
+func TestServiceIdempotencyKeyReusedForAnotherJobConflicts(t *testing.T) {
+	s := &Service{Store: newMemStore()}
+	key := "k1"
+	if status, _ := createJob(t, s, JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 5, IdempotencyKey: &key}); status != http.StatusCreated {
+		t.Fatalf("status = %d", status)
+	}
+
+	req := testpg.APIRequest(http.MethodPost, "/jobs", JobRequest{ClientID: "c1", SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 6, IdempotencyKey: &key})
+	req.Headers["X-Correlation-ID"] = "corr-conflict"
+	resp, _ := s.Handler(context.Background(), req)
+	var p problem.Problem
+	_ = json.Unmarshal([]byte(resp.Body), &p)
+	if resp.StatusCode != http.StatusConflict || p.Code != problem.IdempotencyConflict || p.RequestID != "corr-conflict" || resp.Headers["Content-Type"] != problem.ContentType {
+		t.Errorf("status = %d, headers %v, body %s; want 409 IDEMPOTENCY_CONFLICT", resp.StatusCode, resp.Headers, resp.Body)
+	}
+	if err := api.Spec.CheckResponse(req, resp); err != nil {
+		t.Error(err)
+	}
+
+	status, _, body := createBatch(t, s, BatchRequest{ClientID: "c1", Jobs: []JobRequest{
+		{SourceCurrency: "USD", TargetCurrency: "EUR", SourceAmount: 1},
+		{SourceCurrency: "USD", TargetCurrency: "GBP", SourceAmount: 5, IdempotencyKey: &key},
+	}})
+	_ = json.Unmarshal([]byte(body), &p)
+	if status != http.StatusConflict || len(p.Errors) != 1 || p.Errors[0].Field != "jobs[1].idempotency_key" {
+		t.Errorf("batch status = %d, body %s; want 409 for jobs[1]", status, body)
+	}
+}
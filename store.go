@@ -0,0 +1,190 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/irajwani/microservice-go/internal/pgtx"
+	"github.com/irajwani/microservice-go/internal/settlement"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// keyTaken reports whether err is a violation of the unique index on
+// (tenant_id, idempotency_key): a concurrent request with the same key
+// inserted its job first.
+func keyTaken(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "ux_conversion_jobs_tenant_idempotency_key"
+}
+
+// pgStore is the Postgres Store.
+type pgStore struct{ db *sql.DB }
+
+func (s pgStore) JobByIdempotencyKey(ctx context.Context, key string) (JobResponse, error) {
+	var j JobResponse
+	err := s.db.QueryRowContext(ctx, `SELECT j.job_id, j.status, j.client_id, j.source_currency, j.target_currency, j.source_amount, j.idempotency_key, j.created_at,
+			COALESCE(j.batch_id::text, ''), COALESCE(b.all_or_nothing, false), j.limit_rate, j.expires_at
+		FROM conversion_jobs j LEFT JOIN conversion_batches b ON b.batch_id = j.batch_id WHERE j.idempotency_key = $1`, key).
+		Scan(&j.JobID, &j.Status, &j.ClientID, &j.SourceCurrency, &j.TargetCurrency, &j.SourceAmount, &j.IdempotencyKey, &j.CreatedAt, &j.BatchID, &j.AllOrNothing, &j.LimitRate, &j.ExpiresAt)
+	return j, err
+}
+
+func (s pgStore) CreateJob(ctx context.Context, job JobResponse, payload []byte) (string, error) {
+	var outboxID string
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		outboxID = ""
+		// Insert job, holding its whole amount
+		// The correlation id is kept on the job for limit orders, which are settled long after the request
+		_, err := tx.ExecContext(ctx, `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, held_amount, status, idempotency_key, limit_rate, expires_at, metadata, created_at, updated_at)
+			VALUES ($1,$2,$3,$4,$5,$5,$6,$7,$8,$9,jsonb_build_object('correlation_id',$10::text),$11,$11)`,
+			job.JobID, job.ClientID, job.SourceCurrency, job.TargetCurrency, job.SourceAmount, job.Status, job.IdempotencyKey, job.LimitRate, job.ExpiresAt, job.CorrelationID, job.CreatedAt)
+		if keyTaken(err) {
+			return errIdempotencyKeyTaken
+		}
+		if err != nil {
+			return fmt.Errorf("insert job: %w", err)
+		}
+
+		// Hold the amount out of the available balance until the job settles
+		if err := checkJob(ctx, tx, job); err != nil {
+			return err
+		}
+		if err := holdFunds(ctx, tx, job); err != nil {
+			return err
+		}
+
+		// Insert outbox row (queued jobs only; cmd/limits settles limit orders)
+		if job.LimitRate == nil {
+			err = tx.QueryRowContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ($1,$2,$3,$4) RETURNING outbox_id`,
+				"conversion_job", job.JobID, "conversion-jobs", payload).Scan(&outboxID)
+			if err != nil {
+				return fmt.Errorf("insert outbox: %w", err)
+			}
+		}
+		return nil
+	})
+	return outboxID, err
+}
+
+func (s pgStore) CreateBatch(ctx context.Context, batch BatchResponse, legs []BatchItem, payloads [][]byte) ([]string, error) {
+	var outboxIDs []string
+	err := pgtx.Run(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO conversion_batches (batch_id, client_id, all_or_nothing, size, created_at) VALUES ($1,$2,$3,$4,$5)`,
+			batch.BatchID, batch.ClientID, batch.AllOrNothing, len(legs), batch.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("insert batch: %w", err)
+		}
+
+		// Legs keep their index in the request, so replayed items leave gaps
+		outboxIDs = make([]string, len(legs))
+		jobs := make([]JobResponse, len(legs))
+		for i, leg := range legs {
+			job := leg.Job
+			jobs[i] = job
+			_, err = tx.ExecContext(ctx, `INSERT INTO conversion_jobs (job_id, client_id, source_currency, target_currency, source_amount, held_amount, status, idempotency_key, batch_id, batch_index, created_at, updated_at)
+				VALUES ($1,$2,$3,$4,$5,$5,'queued',$6,$7,$8,$9,$9)`, job.JobID, job.ClientID, job.SourceCurrency, job.TargetCurrency, job.SourceAmount, job.IdempotencyKey, batch.BatchID, leg.Index, job.CreatedAt)
+			if keyTaken(err) {
+				return fmt.Errorf("item %d: %w", leg.Index, errIdempotencyKeyTaken)
+			}
+			if err != nil {
+				return fmt.Errorf("insert job %d: %w", leg.Index, err)
+			}
+			if err := checkJob(ctx, tx, job); err != nil {
+				return fmt.Errorf("item %d: %w", leg.Index, err)
+			}
+			err = tx.QueryRowContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, topic, payload) VALUES ($1,$2,$3,$4) RETURNING outbox_id`,
+				"conversion_job", job.JobID, "conversion-jobs", payloads[i]).Scan(&outboxIDs[i])
+			if err != nil {
+				return fmt.Errorf("insert outbox %d: %w", leg.Index, err)
+			}
+		}
+		// One hold per currency, whatever the order of the legs
+		return holdFunds(ctx, tx, jobs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outboxIDs, nil
+}
+
+// checkJob returns errCurrencyNotEnabled when the tenant does not convert
+// between the job's currencies, and an accountBlockedError when the user or
+// either account is not active.
+func checkJob(ctx context.Context, tx *sql.Tx, job JobResponse) error {
+	// Missing users rows and accounts are active; settlement opens accounts.
+	// A tenant without a currency list converts any currency.
+	var enabled bool
+	var user, source, target string
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE((SELECT currencies IS NULL OR ($2 = ANY(currencies) AND $3 = ANY(currencies))
+			FROM tenants WHERE tenant_id = current_setting('app.tenant_id', true)), true),
+		COALESCE((SELECT status FROM users WHERE user_id=$1),'active'),
+		COALESCE((SELECT status FROM accounts WHERE user_id=$1 AND currency=$2),'active'),
+		COALESCE((SELECT status FROM accounts WHERE user_id=$1 AND currency=$3),'active')`,
+		job.ClientID, job.SourceCurrency, job.TargetCurrency).Scan(&enabled, &user, &source, &target)
+	if err != nil {
+		return fmt.Errorf("account status: %w", err)
+	}
+	if !enabled {
+		return errCurrencyNotEnabled
+	}
+	if reason := cmp.Or(settlement.Blocked(user, source), settlement.Blocked(user, target)); reason != "" {
+		return accountBlockedError{reason: reason}
+	}
+	return nil
+}
+
+// holdFunds adds the inserted jobs' amounts, summed per source account, to
+// the accounts' held amounts. Like settlement, it locks the accounts in
+// account_id order, so that requests holding in several currencies queue
+// instead of deadlocking. It returns errInsufficientFunds when an available
+// balance cannot cover the total held in it.
+func holdFunds(ctx context.Context, tx *sql.Tx, jobs ...JobResponse) error {
+	ids := make([]string, len(jobs))
+	need := map[string]bool{}
+	for i, j := range jobs {
+		ids[i] = j.JobID
+		need[j.ClientID+"/"+j.SourceCurrency] = true
+	}
+	const holds = `WITH h AS (SELECT client_id, source_currency, sum(held_amount) AS amount FROM conversion_jobs WHERE job_id = ANY($1::uuid[]) GROUP BY client_id, source_currency)`
+	rows, err := tx.QueryContext(ctx, holds+`
+		SELECT a.user_id, a.currency, a.balance - a.held >= h.amount FROM accounts a JOIN h ON a.user_id = h.client_id AND a.currency = h.source_currency
+		ORDER BY a.account_id FOR UPDATE OF a`, ids)
+	if err != nil {
+		return fmt.Errorf("lock accounts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user, currency string
+		var covered bool
+		if err := rows.Scan(&user, &currency, &covered); err != nil {
+			return err
+		}
+		if covered {
+			delete(need, user+"/"+currency)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// Left over: no account, or not enough available in it
+	if len(need) > 0 {
+		return fmt.Errorf("%s: %w", slices.Sorted(maps.Keys(need))[0], errInsufficientFunds)
+	}
+
+	_, err = tx.ExecContext(ctx, holds+`
+		UPDATE accounts a SET held = a.held + h.amount FROM h WHERE a.user_id = h.client_id AND a.currency = h.source_currency`, ids)
+	if err != nil {
+		return fmt.Errorf("hold funds: %w", err)
+	}
+	return nil
+}
+
+func (s pgStore) MarkPublished(ctx context.Context, outboxID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outbox SET processed_at=now() WHERE outbox_id=$1`, outboxID)
+	return err
+}
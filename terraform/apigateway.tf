@@ -28,6 +28,84 @@ resource "aws_api_gateway_resource" "job_item" {
   path_part   = "{job_id}"
 }
 
+resource "aws_api_gateway_resource" "job_reverse" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.job_item.id
+  path_part   = "reverse"
+}
+
+resource "aws_api_gateway_resource" "jobs_batch" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.jobs.id
+  path_part   = "batch"
+}
+
+resource "aws_api_gateway_resource" "batches" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_rest_api.jobs_api.root_resource_id
+  path_part   = "batches"
+}
+
+resource "aws_api_gateway_resource" "batch_item" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.batches.id
+  path_part   = "{batch_id}"
+}
+
+resource "aws_api_gateway_resource" "webhooks" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_rest_api.jobs_api.root_resource_id
+  path_part   = "webhooks"
+}
+
+resource "aws_api_gateway_resource" "webhooks_proxy" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.webhooks.id
+  path_part   = "{proxy+}"
+}
+
+resource "aws_api_gateway_resource" "schedules" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_rest_api.jobs_api.root_resource_id
+  path_part   = "schedules"
+}
+
+resource "aws_api_gateway_resource" "schedules_proxy" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.schedules.id
+  path_part   = "{proxy+}"
+}
+
+resource "aws_api_gateway_resource" "admin" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_rest_api.jobs_api.root_resource_id
+  path_part   = "admin"
+}
+
+resource "aws_api_gateway_resource" "admin_proxy" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.admin.id
+  path_part   = "{proxy+}"
+}
+
+resource "aws_api_gateway_resource" "rates" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_rest_api.jobs_api.root_resource_id
+  path_part   = "rates"
+}
+
+resource "aws_api_gateway_resource" "rate_pair" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.rates.id
+  path_part   = "{pair}"
+}
+
+resource "aws_api_gateway_resource" "rate_history" {
+  rest_api_id = aws_api_gateway_rest_api.jobs_api.id
+  parent_id   = aws_api_gateway_resource.rate_pair.id
+  path_part   = "history"
+}
+
 resource "aws_api_gateway_method" "jobs_post" {
   rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
   resource_id   = aws_api_gateway_resource.jobs.id
@@ -56,6 +134,13 @@ resource "aws_api_gateway_method" "jobdetail_get" {
   authorization = "NONE"
 }
 
+resource "aws_api_gateway_method" "job_reverse_post" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.job_reverse.id
+  http_method   = "POST"
+  authorization = "NONE" # admin bearer token checked by the Lambda
+}
+
 resource "aws_api_gateway_method" "jobs_list_get" {
   rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
   resource_id   = aws_api_gateway_resource.jobs.id
@@ -63,6 +148,69 @@ resource "aws_api_gateway_method" "jobs_list_get" {
   authorization = "NONE"
 }
 
+resource "aws_api_gateway_method" "jobs_batch_post" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.jobs_batch.id
+  http_method   = "POST"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "batch_get" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.batch_item.id
+  http_method   = "GET"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "webhooks_any" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.webhooks.id
+  http_method   = "ANY"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "webhooks_proxy_any" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.webhooks_proxy.id
+  http_method   = "ANY"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "schedules_any" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.schedules.id
+  http_method   = "ANY"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "schedules_proxy_any" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.schedules_proxy.id
+  http_method   = "ANY"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "admin_proxy_any" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.admin_proxy.id
+  http_method   = "ANY"
+  authorization = "NONE" # admin bearer token checked by the Lambda
+}
+
+resource "aws_api_gateway_method" "rate_pair_get" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.rate_pair.id
+  http_method   = "GET"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_method" "rate_history_get" {
+  rest_api_id   = aws_api_gateway_rest_api.jobs_api.id
+  resource_id   = aws_api_gateway_resource.rate_history.id
+  http_method   = "GET"
+  authorization = "NONE"
+}
+
 resource "aws_api_gateway_integration" "jobs_post_integration" {
   rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
   resource_id             = aws_api_gateway_resource.jobs.id
@@ -99,6 +247,24 @@ resource "aws_api_gateway_integration" "jobdetail_get_integration" {
   uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.jobdetail_lambda.arn}/invocations"
 }
 
+resource "aws_api_gateway_integration" "admin_proxy_any_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.admin_proxy.id
+  http_method             = aws_api_gateway_method.admin_proxy_any.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.admin_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "job_reverse_post_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.job_reverse.id
+  http_method             = aws_api_gateway_method.job_reverse_post.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.reversals_lambda.arn}/invocations"
+}
+
 resource "aws_api_gateway_integration" "jobs_list_get_integration" {
   rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
   resource_id             = aws_api_gateway_resource.jobs.id
@@ -108,6 +274,78 @@ resource "aws_api_gateway_integration" "jobs_list_get_integration" {
   uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.jobdetail_lambda.arn}/invocations"
 }
 
+resource "aws_api_gateway_integration" "jobs_batch_post_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.jobs_batch.id
+  http_method             = aws_api_gateway_method.jobs_batch_post.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.create_job_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "batch_get_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.batch_item.id
+  http_method             = aws_api_gateway_method.batch_get.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.jobdetail_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "webhooks_any_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.webhooks.id
+  http_method             = aws_api_gateway_method.webhooks_any.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.webhooks_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "webhooks_proxy_any_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.webhooks_proxy.id
+  http_method             = aws_api_gateway_method.webhooks_proxy_any.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.webhooks_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "schedules_any_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.schedules.id
+  http_method             = aws_api_gateway_method.schedules_any.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.schedules_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "schedules_proxy_any_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.schedules_proxy.id
+  http_method             = aws_api_gateway_method.schedules_proxy_any.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.schedules_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "rate_pair_get_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.rate_pair.id
+  http_method             = aws_api_gateway_method.rate_pair_get.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.rate_lambda.arn}/invocations"
+}
+
+resource "aws_api_gateway_integration" "rate_history_get_integration" {
+  rest_api_id             = aws_api_gateway_rest_api.jobs_api.id
+  resource_id             = aws_api_gateway_resource.rate_history.id
+  http_method             = aws_api_gateway_method.rate_history_get.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = "arn:aws:apigateway:${var.aws_region}:lambda:path/2015-03-31/functions/${aws_lambda_function.rate_lambda.arn}/invocations"
+}
+
 resource "aws_lambda_permission" "apigw_rest_invoke_go" {
   statement_id  = "AllowAPIGatewayRestInvokeGo"
   action        = "lambda:InvokeFunction"
@@ -140,6 +378,22 @@ resource "aws_lambda_permission" "apigw_rest_invoke_jobdetail" {
   source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/GET/jobs/*"
 }
 
+resource "aws_lambda_permission" "apigw_rest_invoke_admin" {
+  statement_id  = "AllowAPIGatewayRestInvokeAdmin"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.admin_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/*/admin/*"
+}
+
+resource "aws_lambda_permission" "apigw_rest_invoke_reversals" {
+  statement_id  = "AllowAPIGatewayRestInvokeReversals"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.reversals_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/POST/jobs/*/reverse"
+}
+
 resource "aws_lambda_permission" "apigw_rest_invoke_jobs_list" {
   statement_id  = "AllowAPIGatewayRestInvokeJobsList"
   action        = "lambda:InvokeFunction"
@@ -148,6 +402,46 @@ resource "aws_lambda_permission" "apigw_rest_invoke_jobs_list" {
   source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/GET/jobs"
 }
 
+resource "aws_lambda_permission" "apigw_rest_invoke_jobs_batch" {
+  statement_id  = "AllowAPIGatewayRestInvokeJobsBatch"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.create_job_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/POST/jobs/batch"
+}
+
+resource "aws_lambda_permission" "apigw_rest_invoke_batch" {
+  statement_id  = "AllowAPIGatewayRestInvokeBatch"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.jobdetail_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/GET/batches/*"
+}
+
+resource "aws_lambda_permission" "apigw_rest_invoke_webhooks" {
+  statement_id  = "AllowAPIGatewayRestInvokeWebhooks"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.webhooks_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/*/webhooks*"
+}
+
+resource "aws_lambda_permission" "apigw_rest_invoke_schedules" {
+  statement_id  = "AllowAPIGatewayRestInvokeSchedules"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.schedules_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/*/schedules*"
+}
+
+resource "aws_lambda_permission" "apigw_rest_invoke_rate" {
+  statement_id  = "AllowAPIGatewayRestInvokeRate"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.rate_lambda.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "arn:aws:execute-api:${var.aws_region}:000000000000:${aws_api_gateway_rest_api.jobs_api.id}/*/GET/rates/*"
+}
+
 resource "aws_api_gateway_deployment" "jobs_deployment" {
   rest_api_id = aws_api_gateway_rest_api.jobs_api.id
   depends_on  = [
@@ -155,7 +449,17 @@ resource "aws_api_gateway_deployment" "jobs_deployment" {
     aws_api_gateway_integration.exchange_post_integration,
   aws_api_gateway_integration.balances_get_integration,
   aws_api_gateway_integration.jobdetail_get_integration,
-  aws_api_gateway_integration.jobs_list_get_integration
+  aws_api_gateway_integration.jobs_list_get_integration,
+    aws_api_gateway_integration.webhooks_any_integration,
+    aws_api_gateway_integration.webhooks_proxy_any_integration,
+    aws_api_gateway_integration.jobs_batch_post_integration,
+    aws_api_gateway_integration.batch_get_integration,
+    aws_api_gateway_integration.schedules_any_integration,
+    aws_api_gateway_integration.schedules_proxy_any_integration,
+    aws_api_gateway_integration.job_reverse_post_integration,
+    aws_api_gateway_integration.admin_proxy_any_integration,
+    aws_api_gateway_integration.rate_pair_get_integration,
+    aws_api_gateway_integration.rate_history_get_integration,
   ]
   stage_name  = var.rest_api_stage
   triggers = {
@@ -174,6 +478,31 @@ resource "aws_api_gateway_deployment" "jobs_deployment" {
       aws_lambda_function.exchange_lambda.source_code_hash,
       aws_lambda_function.balances_lambda.source_code_hash,
   aws_lambda_function.jobdetail_lambda.source_code_hash,
+      aws_api_gateway_method.webhooks_any.id,
+      aws_api_gateway_integration.webhooks_any_integration.id,
+      aws_api_gateway_method.webhooks_proxy_any.id,
+      aws_api_gateway_integration.webhooks_proxy_any_integration.id,
+      aws_lambda_function.webhooks_lambda.source_code_hash,
+      aws_api_gateway_method.jobs_batch_post.id,
+      aws_api_gateway_integration.jobs_batch_post_integration.id,
+      aws_api_gateway_method.batch_get.id,
+      aws_api_gateway_integration.batch_get_integration.id,
+      aws_api_gateway_method.schedules_any.id,
+      aws_api_gateway_integration.schedules_any_integration.id,
+      aws_api_gateway_method.schedules_proxy_any.id,
+      aws_api_gateway_integration.schedules_proxy_any_integration.id,
+      aws_lambda_function.schedules_lambda.source_code_hash,
+      aws_api_gateway_method.job_reverse_post.id,
+      aws_api_gateway_integration.job_reverse_post_integration.id,
+      aws_lambda_function.reversals_lambda.source_code_hash,
+      aws_api_gateway_method.admin_proxy_any.id,
+      aws_api_gateway_integration.admin_proxy_any_integration.id,
+      aws_lambda_function.admin_lambda.source_code_hash,
+      aws_api_gateway_method.rate_pair_get.id,
+      aws_api_gateway_integration.rate_pair_get_integration.id,
+      aws_api_gateway_method.rate_history_get.id,
+      aws_api_gateway_integration.rate_history_get_integration.id,
+      aws_lambda_function.rate_lambda.source_code_hash,
     ]))
   }
 }
@@ -1,19 +1,29 @@
 ## Go Lambda build & functions
 
+# Rebuild a binary when its package or any shared package changes
+locals {
+  shared_go_hash = sha256(join("", [for f in sort(fileset("${path.module}/..", "{internal,db}/**")) : filesha256("${path.module}/../${f}")]))
+
+  go_hash = {
+    for pkg in [".", "cmd/rate", "cmd/consumer", "cmd/exchange", "cmd/jobdetail", "cmd/balances", "cmd/webhooks", "cmd/dispatcher", "cmd/schedules", "cmd/scheduler", "cmd/limits", "cmd/reversals", "cmd/admin"] :
+    pkg => sha256(join("", concat([local.shared_go_hash], [for f in sort(fileset("${path.module}/../${pkg}", "*.go")) : filesha256("${path.module}/../${pkg}/${f}")])))
+  }
+}
+
 # Go lambda build
 resource "null_resource" "build_go_lambda" {
-  triggers = { source_hash = filesha256("${path.module}/../main.go") }
+  triggers = { source_hash = local.go_hash["."] }
   provisioner "local-exec" {
-    command     = "GOOS=linux GOARCH=amd64 go build -o hello ../main.go"
+    command     = "GOOS=linux GOARCH=amd64 go build -o hello .."
     working_dir = path.module
   }
 }
 
 # Build rate lambda
 resource "null_resource" "build_rate_lambda" {
-  triggers = { source_hash = filesha256("${path.module}/../cmd/rate/main.go") }
+  triggers = { source_hash = local.go_hash["cmd/rate"] }
   provisioner "local-exec" {
-    command     = "GOOS=linux GOARCH=amd64 go build -o rate ../cmd/rate/main.go"
+    command     = "GOOS=linux GOARCH=amd64 go build -o rate ../cmd/rate"
     working_dir = path.module
   }
 }
@@ -33,7 +43,16 @@ resource "aws_lambda_function" "rate_lambda" {
   filename         = data.archive_file.rate_lambda_zip.output_path
   source_code_hash = data.archive_file.rate_lambda_zip.output_base64sha256
   timeout          = 3
-  environment { variables = { STATIC_RATE = "1.10", STATIC_FEE_BPS = "25" } }
+  environment {
+    variables = {
+      STATIC_RATE = "1.10"
+      DB_HOST     = var.db_host
+      DB_PORT     = tostring(var.db_port)
+      DB_USER     = var.db_username
+      DB_PASSWORD = var.db_password
+      DB_NAME     = var.db_name
+    }
+  }
 }
 
 resource "aws_cloudwatch_log_group" "RateLambdaLogGroup" {
@@ -43,18 +62,18 @@ resource "aws_cloudwatch_log_group" "RateLambdaLogGroup" {
 
 # Build consumer lambda
 resource "null_resource" "build_consumer_lambda" {
-  triggers = { source_hash = filesha256("${path.module}/../cmd/consumer/main.go") }
+  triggers = { source_hash = local.go_hash["cmd/consumer"] }
   provisioner "local-exec" {
-    command     = "GOOS=linux GOARCH=amd64 go build -o consumer ../cmd/consumer/main.go"
+    command     = "GOOS=linux GOARCH=amd64 go build -o consumer ../cmd/consumer"
     working_dir = path.module
   }
 }
 
 # Build exchange lambda
 resource "null_resource" "build_exchange_lambda" {
-  triggers = { source_hash = filesha256("${path.module}/../cmd/exchange/main.go") }
+  triggers = { source_hash = local.go_hash["cmd/exchange"] }
   provisioner "local-exec" {
-    command     = "GOOS=linux GOARCH=amd64 go build -o exchange ../cmd/exchange/main.go"
+    command     = "GOOS=linux GOARCH=amd64 go build -o exchange ../cmd/exchange"
     working_dir = path.module
   }
 }
@@ -68,9 +87,9 @@ data "archive_file" "exchange_lambda_zip" {
 
 # Build jobdetail lambda
 resource "null_resource" "build_jobdetail_lambda" {
-  triggers = { source_hash = filesha256("${path.module}/../cmd/jobdetail/main.go") }
+  triggers = { source_hash = local.go_hash["cmd/jobdetail"] }
   provisioner "local-exec" {
-    command     = "GOOS=linux GOARCH=amd64 go build -o jobdetail ../cmd/jobdetail/main.go"
+    command     = "GOOS=linux GOARCH=amd64 go build -o jobdetail ../cmd/jobdetail"
     working_dir = path.module
   }
 }
@@ -97,6 +116,7 @@ resource "aws_lambda_function" "jobdetail_lambda" {
       DB_USER     = var.db_username
       DB_PASSWORD = var.db_password
       DB_NAME     = var.db_name
+      TENANT_KEYS = var.tenant_keys
     }
   }
 }
@@ -121,14 +141,19 @@ resource "aws_lambda_function" "exchange_lambda" {
       DB_USER     = var.db_username
       DB_PASSWORD = var.db_password
       DB_NAME     = var.db_name
+      TENANT_KEYS = var.tenant_keys
+
+      RATE_MAX_AGE               = var.rate_max_age
+      RATE_MAX_DEVIATION         = tostring(var.rate_max_deviation)
+      RATE_MAX_INVERSE_DEVIATION = tostring(var.rate_max_inverse_deviation)
     }
   }
 }
 
 resource "null_resource" "build_balances_lambda" {
-  triggers = { source_hash = filesha256("${path.module}/../cmd/balances/main.go") }
+  triggers = { source_hash = local.go_hash["cmd/balances"] }
   provisioner "local-exec" {
-    command     = "GOOS=linux GOARCH=amd64 go build -o balances ../cmd/balances/main.go"
+    command     = "GOOS=linux GOARCH=amd64 go build -o balances ../cmd/balances"
     working_dir = path.module
   }
 }
@@ -150,11 +175,13 @@ resource "aws_lambda_function" "balances_lambda" {
   timeout          = 5
   environment {
     variables = {
-      DB_HOST     = var.db_host
-      DB_PORT     = tostring(var.db_port)
-      DB_USER     = var.db_username
-      DB_PASSWORD = var.db_password
-      DB_NAME     = var.db_name
+      DB_HOST          = var.db_host
+      DB_PORT          = tostring(var.db_port)
+      DB_USER          = var.db_username
+      DB_PASSWORD      = var.db_password
+      DB_NAME          = var.db_name
+      TENANT_KEYS      = var.tenant_keys
+      RATE_LAMBDA_NAME = var.rate_lambda_name
     }
   }
 }
@@ -182,6 +209,12 @@ resource "aws_lambda_function" "consumer_lambda" {
       DB_PASSWORD      = var.db_password
       DB_NAME          = var.db_name
       RATE_LAMBDA_NAME = var.rate_lambda_name
+
+      RATE_MAX_AGE               = var.rate_max_age
+      RATE_MAX_DEVIATION         = tostring(var.rate_max_deviation)
+      RATE_MAX_INVERSE_DEVIATION = tostring(var.rate_max_inverse_deviation)
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
     }
   }
 }
@@ -215,7 +248,10 @@ resource "aws_lambda_function" "create_job_lambda" {
       DB_USER     = var.db_username
       DB_PASSWORD = var.db_password
       DB_NAME     = var.db_name
+      TENANT_KEYS = var.tenant_keys
       QUEUE_URL   = aws_sqs_queue.outbox.id
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
     }
   }
 }
@@ -224,3 +260,363 @@ resource "aws_cloudwatch_log_group" "GoHelloLambdaLogGroup" {
   name              = "/aws/lambda/${aws_lambda_function.create_job_lambda.function_name}"
   retention_in_days = 1
 }
+
+# Build webhooks (subscription API) lambda
+resource "null_resource" "build_webhooks_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/webhooks"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o webhooks ../cmd/webhooks"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "webhooks_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/webhooks"
+  output_path = "${path.module}/webhooks-lambda.zip"
+  depends_on  = [null_resource.build_webhooks_lambda]
+}
+
+resource "aws_lambda_function" "webhooks_lambda" {
+  function_name = "webhooks_lambda"
+  handler       = "webhooks"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.webhooks_lambda_zip.output_path
+  source_code_hash = data.archive_file.webhooks_lambda_zip.output_base64sha256
+  timeout          = 5
+  environment {
+    variables = {
+      DB_HOST     = var.db_host
+      DB_PORT     = tostring(var.db_port)
+      DB_USER     = var.db_username
+      DB_PASSWORD = var.db_password
+      DB_NAME     = var.db_name
+      TENANT_KEYS = var.tenant_keys
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "WebhooksLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.webhooks_lambda.function_name}"
+  retention_in_days = 1
+}
+
+# Build webhook dispatcher lambda (runs on a schedule, see below)
+resource "null_resource" "build_dispatcher_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/dispatcher"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o dispatcher ../cmd/dispatcher"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "dispatcher_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/dispatcher"
+  output_path = "${path.module}/dispatcher-lambda.zip"
+  depends_on  = [null_resource.build_dispatcher_lambda]
+}
+
+resource "aws_lambda_function" "dispatcher_lambda" {
+  function_name = "webhook_dispatcher_lambda"
+  handler       = "dispatcher"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.dispatcher_lambda_zip.output_path
+  source_code_hash = data.archive_file.dispatcher_lambda_zip.output_base64sha256
+  timeout          = 60
+  environment {
+    variables = {
+      DB_HOST     = var.db_host
+      DB_PORT     = tostring(var.db_port)
+      DB_USER     = var.db_username
+      DB_PASSWORD = var.db_password
+      DB_NAME     = var.db_name
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "DispatcherLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.dispatcher_lambda.function_name}"
+  retention_in_days = 1
+}
+
+resource "aws_cloudwatch_event_rule" "webhook_dispatch" {
+  name                = "webhook-dispatch"
+  schedule_expression = "rate(1 minute)"
+}
+
+resource "aws_cloudwatch_event_target" "webhook_dispatch" {
+  rule = aws_cloudwatch_event_rule.webhook_dispatch.name
+  arn  = aws_lambda_function.dispatcher_lambda.arn
+}
+
+resource "aws_lambda_permission" "events_invoke_dispatcher" {
+  statement_id  = "AllowEventBridgeInvokeDispatcher"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.dispatcher_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.webhook_dispatch.arn
+}
+
+# Build schedules (scheduled conversions API) lambda
+resource "null_resource" "build_schedules_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/schedules"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o schedules ../cmd/schedules"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "schedules_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/schedules"
+  output_path = "${path.module}/schedules-lambda.zip"
+  depends_on  = [null_resource.build_schedules_lambda]
+}
+
+resource "aws_lambda_function" "schedules_lambda" {
+  function_name = "schedules_lambda"
+  handler       = "schedules"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.schedules_lambda_zip.output_path
+  source_code_hash = data.archive_file.schedules_lambda_zip.output_base64sha256
+  timeout          = 5
+  environment {
+    variables = {
+      DB_HOST     = var.db_host
+      DB_PORT     = tostring(var.db_port)
+      DB_USER     = var.db_username
+      DB_PASSWORD = var.db_password
+      DB_NAME     = var.db_name
+      TENANT_KEYS = var.tenant_keys
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "SchedulesLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.schedules_lambda.function_name}"
+  retention_in_days = 1
+}
+
+# Build scheduler lambda (books due scheduled conversions every minute)
+resource "null_resource" "build_scheduler_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/scheduler"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o scheduler ../cmd/scheduler"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "scheduler_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/scheduler"
+  output_path = "${path.module}/scheduler-lambda.zip"
+  depends_on  = [null_resource.build_scheduler_lambda]
+}
+
+resource "aws_lambda_function" "scheduler_lambda" {
+  function_name = "scheduler_lambda"
+  handler       = "scheduler"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.scheduler_lambda_zip.output_path
+  source_code_hash = data.archive_file.scheduler_lambda_zip.output_base64sha256
+  timeout          = 60
+  environment {
+    variables = {
+      DB_HOST     = var.db_host
+      DB_PORT     = tostring(var.db_port)
+      DB_USER     = var.db_username
+      DB_PASSWORD = var.db_password
+      DB_NAME     = var.db_name
+      QUEUE_URL   = aws_sqs_queue.outbox.id
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "SchedulerLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.scheduler_lambda.function_name}"
+  retention_in_days = 1
+}
+
+resource "aws_cloudwatch_event_rule" "scheduled_conversions" {
+  name                = "scheduled-conversions"
+  schedule_expression = "rate(1 minute)"
+}
+
+resource "aws_cloudwatch_event_target" "scheduled_conversions" {
+  rule = aws_cloudwatch_event_rule.scheduled_conversions.name
+  arn  = aws_lambda_function.scheduler_lambda.arn
+}
+
+resource "aws_lambda_permission" "events_invoke_scheduler" {
+  statement_id  = "AllowEventBridgeInvokeScheduler"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.scheduler_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.scheduled_conversions.arn
+}
+
+# Build limit order watcher lambda (re-evaluates pending limit orders every minute)
+resource "null_resource" "build_limits_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/limits"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o limits ../cmd/limits"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "limits_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/limits"
+  output_path = "${path.module}/limits-lambda.zip"
+  depends_on  = [null_resource.build_limits_lambda]
+}
+
+resource "aws_lambda_function" "limits_lambda" {
+  function_name = "limit_orders_lambda"
+  handler       = "limits"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.limits_lambda_zip.output_path
+  source_code_hash = data.archive_file.limits_lambda_zip.output_base64sha256
+  timeout          = 60
+  environment {
+    variables = {
+      DB_HOST          = var.db_host
+      DB_PORT          = tostring(var.db_port)
+      DB_USER          = var.db_username
+      DB_PASSWORD      = var.db_password
+      DB_NAME          = var.db_name
+      RATE_LAMBDA_NAME = var.rate_lambda_name
+
+      RATE_MAX_AGE               = var.rate_max_age
+      RATE_MAX_DEVIATION         = tostring(var.rate_max_deviation)
+      RATE_MAX_INVERSE_DEVIATION = tostring(var.rate_max_inverse_deviation)
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "LimitsLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.limits_lambda.function_name}"
+  retention_in_days = 1
+}
+
+resource "aws_cloudwatch_event_rule" "limit_orders" {
+  name                = "limit-orders"
+  schedule_expression = "rate(1 minute)"
+}
+
+resource "aws_cloudwatch_event_target" "limit_orders" {
+  rule = aws_cloudwatch_event_rule.limit_orders.name
+  arn  = aws_lambda_function.limits_lambda.arn
+}
+
+resource "aws_lambda_permission" "events_invoke_limits" {
+  statement_id  = "AllowEventBridgeInvokeLimits"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.limits_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.limit_orders.arn
+}
+
+# Build reversals lambda (admin-only POST /jobs/{job_id}/reverse)
+resource "null_resource" "build_reversals_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/reversals"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o reversals ../cmd/reversals"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "reversals_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/reversals"
+  output_path = "${path.module}/reversals-lambda.zip"
+  depends_on  = [null_resource.build_reversals_lambda]
+}
+
+resource "aws_lambda_function" "reversals_lambda" {
+  function_name = "reversals_lambda"
+  handler       = "reversals"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.reversals_lambda_zip.output_path
+  source_code_hash = data.archive_file.reversals_lambda_zip.output_base64sha256
+  timeout          = 10
+  environment {
+    variables = {
+      DB_HOST          = var.db_host
+      DB_PORT          = tostring(var.db_port)
+      DB_USER          = var.db_username
+      DB_PASSWORD      = var.db_password
+      DB_NAME          = var.db_name
+      RATE_LAMBDA_NAME = var.rate_lambda_name
+      ADMIN_TOKENS     = var.admin_tokens
+
+      RATE_MAX_AGE               = var.rate_max_age
+      RATE_MAX_DEVIATION         = tostring(var.rate_max_deviation)
+      RATE_MAX_INVERSE_DEVIATION = tostring(var.rate_max_inverse_deviation)
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "ReversalsLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.reversals_lambda.function_name}"
+  retention_in_days = 1
+}
+
+# Build admin (back-office API) lambda
+resource "null_resource" "build_admin_lambda" {
+  triggers = { source_hash = local.go_hash["cmd/admin"] }
+  provisioner "local-exec" {
+    command     = "GOOS=linux GOARCH=amd64 go build -o admin ../cmd/admin"
+    working_dir = path.module
+  }
+}
+
+data "archive_file" "admin_lambda_zip" {
+  type        = "zip"
+  source_file = "${path.module}/admin"
+  output_path = "${path.module}/admin-lambda.zip"
+  depends_on  = [null_resource.build_admin_lambda]
+}
+
+resource "aws_lambda_function" "admin_lambda" {
+  function_name = "admin_lambda"
+  handler       = "admin"
+  runtime       = "go1.x"
+  role          = aws_iam_role.lambda_execution_role.arn
+  filename         = data.archive_file.admin_lambda_zip.output_path
+  source_code_hash = data.archive_file.admin_lambda_zip.output_base64sha256
+  timeout          = 10
+  environment {
+    variables = {
+      DB_HOST      = var.db_host
+      DB_PORT      = tostring(var.db_port)
+      DB_USER      = var.db_username
+      DB_PASSWORD  = var.db_password
+      DB_NAME      = var.db_name
+      QUEUE_URL    = aws_sqs_queue.outbox.id
+      ADMIN_TOKENS = var.admin_tokens
+
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "AdminLambdaLogGroup" {
+  name              = "/aws/lambda/${aws_lambda_function.admin_lambda.function_name}"
+  retention_in_days = 1
+}
@@ -10,6 +10,14 @@ output "jobdetail_api_invoke_url" {
   value = "http://localhost:4566/restapis/${aws_api_gateway_rest_api.jobs_api.id}/${var.rest_api_stage}/_user_request_/jobs/{job_id}"
 }
 
+output "webhooks_api_invoke_url" {
+  value = "http://localhost:4566/restapis/${aws_api_gateway_rest_api.jobs_api.id}/${var.rest_api_stage}/_user_request_/webhooks"
+}
+
+output "schedules_api_invoke_url" {
+  value = "http://localhost:4566/restapis/${aws_api_gateway_rest_api.jobs_api.id}/${var.rest_api_stage}/_user_request_/schedules"
+}
+
 output "outbox_queue_url" {
   value = aws_sqs_queue.outbox.id
 }
@@ -31,10 +31,12 @@ resource "aws_iam_role_policy_attachment" "lambda_attach_sqs_send" {
   policy_arn = aws_iam_policy.lambda_sqs_send.arn
 }
 
-# Event source mapping: SQS -> consumer lambda
+# Event source mapping: SQS -> consumer lambda. The consumer reports the messages of jobs it could not settle
+# (ReportBatchItemFailures); only those are redelivered.
 resource "aws_lambda_event_source_mapping" "outbox_consumer" {
-  event_source_arn = aws_sqs_queue.outbox.arn
-  function_name    = aws_lambda_function.consumer_lambda.arn
-  batch_size       = 5
-  enabled          = true
+  event_source_arn        = aws_sqs_queue.outbox.arn
+  function_name           = aws_lambda_function.consumer_lambda.arn
+  batch_size              = 5
+  enabled                 = true
+  function_response_types = ["ReportBatchItemFailures"]
 }
@@ -29,15 +29,15 @@ variable "rest_api_stage" {
 }
 
 variable "db_username" {
-  description = "RDS master username"
+  description = "Role the Lambdas connect as: jobs_app from db/migrations/0018_app_role.sql, never the RDS master user (a role that bypasses row-level security is refused at start up)"
   type        = string
-  default     = "postgres"
+  default     = "jobs_app"
 }
 
 variable "db_password" {
-  description = "RDS master password (do NOT use default in real env)"
+  description = "Password of db_username, set by `migrate up` from APP_DB_PASSWORD (do NOT use default in real env)"
   type        = string
-  default     = "postgrespw"
+  default     = "jobsapppw"
   sensitive   = true
 }
 
@@ -76,3 +76,41 @@ variable "consumer_lambda_name" {
   type        = string
   default     = "jobs_consumer_lambda"
 }
+
+variable "otel_exporter_otlp_endpoint" {
+  description = "OTLP/HTTP endpoint for trace export (empty disables export)"
+  type        = string
+  default     = ""
+}
+
+variable "admin_tokens" {
+  description = "Back-office operators for the admin and reversal endpoints, as comma-separated name:token pairs (empty disables them)"
+  type        = string
+  default     = ""
+  sensitive   = true
+}
+
+variable "tenant_keys" {
+  description = "API keys of the tenants calling the public endpoints, as comma-separated tenant:key pairs; callers send theirs as Authorization: Bearer <key> (the web client as TENANT_API_KEY). Empty means every request needs an authorizer that sets tenant_id. The default is a dev key for the default tenant: do NOT use it in a real env"
+  type        = string
+  default     = "default:dev-default-key-0123456789"
+  sensitive   = true
+}
+
+variable "rate_max_age" {
+  description = "Oldest rate quote that may price a conversion, as a Go duration (0 disables the check)"
+  type        = string
+  default     = "30s"
+}
+
+variable "rate_max_deviation" {
+  description = "Largest change from a pair's last accepted rate, as a fraction, before its rate breaker opens (0 disables the check)"
+  type        = number
+  default     = 0.1
+}
+
+variable "rate_max_inverse_deviation" {
+  description = "Largest difference from 1 / the reverse pair's rate, as a fraction, before a pair's rate breaker opens. 0 disables the check: the rate service quotes both directions from one book. Costs a second rate lookup per quote"
+  type        = number
+  default     = 0
+}